@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"litetask/internal/backup"
+	"litetask/internal/config"
+	"litetask/internal/store"
+)
+
+const usage = `usage:
+  litetask                  run the server
+  litetask migrate status   show applied and pending schema migrations
+  litetask migrate up       apply pending schema migrations
+  litetask backup [file]    write an online snapshot of the database
+                            (default: BACKUP_DIR or the current directory)
+  litetask restore <file>   replace the database with a snapshot; stop the server first
+`
+
+// runCommand handles the command-line subcommands and returns the exit code.
+func runCommand(args []string) int {
+	switch args[0] {
+	case "migrate":
+		return runMigrate(args[1:])
+	case "backup":
+		return runBackup(args[1:])
+	case "restore":
+		return runRestore(args[1:])
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", args[0], usage)
+		return 2
+	}
+}
+
+func runMigrate(args []string) int {
+	if len(args) != 1 || (args[0] != "status" && args[0] != "up") {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+	dbPath := config.EnvOrDefault("DB_PATH", store.DefaultDBPath)
+	st, err := store.OpenUnmigrated(dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open database: %v\n", err)
+		return 1
+	}
+	defer st.Close()
+
+	if args[0] == "up" {
+		applied, err := st.Migrate()
+		for _, m := range applied {
+			fmt.Printf("applied %d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "migration failed: %v\n", err)
+			return 1
+		}
+		if len(applied) == 0 {
+			fmt.Println("schema is up to date")
+		}
+		return 0
+	}
+
+	states, err := st.MigrationStatus()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read migrations: %v\n", err)
+		return 1
+	}
+	version, err := st.SchemaVersion()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read schema version: %v\n", err)
+		return 1
+	}
+	fmt.Printf("database: %s\nschema version: %d (build: %d)\n\n", dbPath, version, store.LatestSchemaVersion())
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tNAME\tSTATE\tAPPLIED AT")
+	pending := 0
+	for _, m := range states {
+		state := "pending"
+		appliedAt := "-"
+		switch {
+		case m.Unknown:
+			state = "unknown (newer build)"
+		case m.Modified:
+			state = "modified"
+		case m.Applied:
+			state = "applied"
+		default:
+			pending++
+		}
+		if m.AppliedAt != nil {
+			appliedAt = m.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", m.Version, m.Name, state, appliedAt)
+	}
+	tw.Flush()
+	if pending > 0 {
+		fmt.Printf("\n%d pending; run `litetask migrate up` to apply\n", pending)
+	}
+	return 0
+}
+
+func runBackup(args []string) int {
+	if len(args) > 1 {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+	dbPath := config.EnvOrDefault("DB_PATH", store.DefaultDBPath)
+	dest := ""
+	if len(args) == 1 {
+		dest = args[0]
+	} else {
+		dest = filepath.Join(config.EnvOrDefault("BACKUP_DIR", "."), backup.FileName(time.Now()))
+	}
+	if err := store.CopyDatabase(context.Background(), dbPath, dest); err != nil {
+		fmt.Fprintf(os.Stderr, "backup failed: %v\n", err)
+		return 1
+	}
+	fmt.Printf("wrote %s\n", dest)
+	return 0
+}
+
+func runRestore(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+	dbPath := config.EnvOrDefault("DB_PATH", store.DefaultDBPath)
+	previous, version, err := backup.Restore(context.Background(), args[0], dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "restore failed: %v\n", err)
+		return 1
+	}
+	if previous != "" {
+		fmt.Printf("previous database saved as %s\n", previous)
+	}
+	fmt.Printf("restored %s into %s (schema version %d", args[0], dbPath, version)
+	if version < store.LatestSchemaVersion() {
+		fmt.Printf(", migrations up to %d will run on next start", store.LatestSchemaVersion())
+	}
+	fmt.Println(")")
+	return 0
+}
@@ -4,20 +4,36 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/base64"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"time"
+	// Digest time zones must load on hosts without a zoneinfo database.
+	_ "time/tzdata"
 
+	"litetask/internal/backup"
 	"litetask/internal/config"
+	"litetask/internal/digest"
+	"litetask/internal/events"
 	"litetask/internal/httpapi"
+	"litetask/internal/inbound"
+	"litetask/internal/mailer"
+	"litetask/internal/oidc"
 	"litetask/internal/store"
 	"litetask/internal/tgbot"
+	"litetask/internal/webhooks"
 )
 
 const defaultAddr = ":8080"
 
 func main() {
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
 	dbPath := config.EnvOrDefault("DB_PATH", store.DefaultDBPath)
 	st, err := store.Open(dbPath)
 	if err != nil {
@@ -35,9 +51,108 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	go tgbot.Start(ctx, st, strings.TrimSpace(os.Getenv("BOT_TOKEN")), strings.TrimSpace(os.Getenv("BOT_CHAT_ID")))
+	bus := events.NewBus()
+
+	go webhooks.NewDispatcher(st, bus).Run(ctx)
+	botOpts := tgbot.Options{
+		Token:     strings.TrimSpace(os.Getenv("BOT_TOKEN")),
+		ChatID:    strings.TrimSpace(os.Getenv("BOT_CHAT_ID")),
+		PublicURL: strings.TrimSpace(os.Getenv("PUBLIC_URL")),
+	}
+	var botWebhook http.Handler
+	var digestTelegram digest.Telegram
+	if hookURL := strings.TrimSpace(os.Getenv("BOT_WEBHOOK_URL")); hookURL != "" && botOpts.Token != "" {
+		hook, err := tgbot.NewWebhook(hookURL, strings.TrimSpace(os.Getenv("BOT_WEBHOOK_SECRET")))
+		if err != nil {
+			log.Printf("telegram webhook disabled, the bot will poll: %v", err)
+		} else {
+			botOpts.Webhook = hook
+			botWebhook = hook
+		}
+	}
+	if botOpts.Token != "" {
+		poster := tgbot.NewPoster()
+		botOpts.Poster = poster
+		digestTelegram = poster
+	}
+	go tgbot.Start(ctx, st, bus, botOpts)
+
+	var mailbox *mailer.Mailbox
+	var inboundEmail http.Handler
+	if address := strings.TrimSpace(os.Getenv("INBOUND_EMAIL_ADDRESS")); address != "" {
+		mailbox, err = mailer.ParseMailbox(address)
+		if err != nil {
+			log.Fatalf("invalid INBOUND_EMAIL_ADDRESS: %v", err)
+		}
+		secret := strings.TrimSpace(os.Getenv("INBOUND_EMAIL_SECRET"))
+		if len(secret) < 16 {
+			log.Fatalf("INBOUND_EMAIL_SECRET must be at least 16 characters")
+		}
+		inboundEmail = inbound.NewReceiver(st, bus, mailbox, secret)
+	}
+
+	var digestMail digest.Mailer
+	var accountMail httpapi.Mailer
+	var outbox *mailer.Outbox
+	mailConfig, mailEnabled, err := mailer.ConfigFromEnv()
+	if err != nil {
+		log.Fatalf("invalid SMTP settings: %v", err)
+	}
+	if mailEnabled {
+		outbox = mailer.NewOutbox(st, mailer.New(mailConfig))
+		digestMail = outbox
+		accountMail = outbox
+		go outbox.Run(ctx)
+		go mailer.NewNotifier(st, bus, outbox, botOpts.PublicURL, mailbox).Run(ctx)
+	}
+	go digest.NewScheduler(st, digestTelegram, digestMail).Run(ctx)
 
-	server := httpapi.New(st, secret, allowRegistration, "web/dist")
+	requireVerification := config.EnvOrDefault("REQUIRE_EMAIL_VERIFICATION", "false") == "true"
+	if requireVerification && (!mailEnabled || botOpts.PublicURL == "") {
+		log.Fatalf("REQUIRE_EMAIL_VERIFICATION needs SMTP_HOST and PUBLIC_URL")
+	}
+
+	var sso *oidc.Provider
+	ssoConfig, ssoEnabled, err := oidc.ConfigFromEnv(botOpts.PublicURL)
+	if err != nil {
+		log.Fatalf("invalid OIDC settings: %v", err)
+	}
+	if ssoEnabled {
+		if sso, err = oidc.New(ssoConfig); err != nil {
+			log.Fatalf("invalid OIDC settings: %v", err)
+		}
+		log.Printf("single sign-on with %s", ssoConfig.Issuer)
+	}
+	disablePasswordLogin := config.EnvOrDefault("OIDC_DISABLE_PASSWORD_LOGIN", "false") == "true"
+	if disablePasswordLogin && sso == nil {
+		log.Fatalf("OIDC_DISABLE_PASSWORD_LOGIN needs OIDC_ISSUER")
+	}
+
+	backupDir := strings.TrimSpace(os.Getenv("BACKUP_DIR"))
+	if backupDir != "" {
+		interval, keep, err := backupSchedule()
+		if err != nil {
+			log.Fatalf("invalid backup settings: %v", err)
+		}
+		go backup.NewScheduler(st, backupDir, interval, keep).Run(ctx)
+	}
+
+	server := httpapi.New(st, bus, httpapi.Options{
+		AuthSecret:        secret,
+		AllowRegistration: allowRegistration,
+		StaticDir:         "web/dist",
+		BackupDir:         backupDir,
+		TelegramWebhook:   botWebhook,
+		Outbox:            outbox,
+		Mailbox:           mailbox,
+		InboundEmail:      inboundEmail,
+		Mailer:            accountMail,
+		PublicURL:         botOpts.PublicURL,
+
+		RequireEmailVerification: requireVerification,
+		OIDC:                     sso,
+		DisablePasswordLogin:     disablePasswordLogin,
+	})
 
 	log.Printf("listening on %s", defaultAddr)
 	if err := http.ListenAndServe(defaultAddr, server.Routes()); err != nil {
@@ -62,3 +177,15 @@ func loadSecret() ([]byte, error) {
 	log.Printf("generated random auth secret; set AUTH_SECRET to persist sessions")
 	return secret, nil
 }
+
+func backupSchedule() (time.Duration, int, error) {
+	interval, err := time.ParseDuration(config.EnvOrDefault("BACKUP_INTERVAL", "24h"))
+	if err != nil || interval < time.Minute {
+		return 0, 0, fmt.Errorf("BACKUP_INTERVAL must be a duration of at least 1m")
+	}
+	keep, err := strconv.Atoi(config.EnvOrDefault("BACKUP_KEEP", "7"))
+	if err != nil || keep < 1 {
+		return 0, 0, fmt.Errorf("BACKUP_KEEP must be a positive number")
+	}
+	return interval, keep, nil
+}
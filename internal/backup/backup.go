@@ -0,0 +1,171 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"litetask/internal/store"
+)
+
+const (
+	filePrefix = "litetask-"
+	fileSuffix = ".db"
+	timeLayout = "20060102-150405"
+)
+
+// Snapshot is a backup file in the backup directory.
+type Snapshot struct {
+	Name      string    `json:"name"`
+	Path      string    `json:"-"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// FileName returns the name of a snapshot taken at t.
+func FileName(t time.Time) string {
+	return filePrefix + t.UTC().Format(timeLayout) + fileSuffix
+}
+
+// Create writes a new snapshot of the live database into dir.
+func Create(ctx context.Context, st *store.Store, dir string) (Snapshot, error) {
+	now := time.Now().UTC()
+	path := filepath.Join(dir, FileName(now))
+	if err := st.Backup(ctx, path); err != nil {
+		return Snapshot{}, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	return Snapshot{Name: info.Name(), Path: path, Size: info.Size(), CreatedAt: now}, nil
+}
+
+// List returns the snapshots in dir, newest first.
+func List(dir string) ([]Snapshot, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return []Snapshot{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	snapshots := make([]Snapshot, 0)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, filePrefix) || !strings.HasSuffix(name, fileSuffix) {
+			continue
+		}
+		at, err := time.Parse(timeLayout, strings.TrimSuffix(strings.TrimPrefix(name, filePrefix), fileSuffix))
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, Snapshot{Name: name, Path: filepath.Join(dir, name), Size: info.Size(), CreatedAt: at})
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].CreatedAt.After(snapshots[j].CreatedAt) })
+	return snapshots, nil
+}
+
+// Prune deletes all but the newest keep snapshots in dir.
+func Prune(dir string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	snapshots, err := List(dir)
+	if err != nil {
+		return err
+	}
+	for _, snap := range snapshots[min(keep, len(snapshots)):] {
+		if err := os.Remove(snap.Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Restore replaces the database at dbPath with snapshot after validating it.
+// The current database is first copied next to it as
+// "<dbPath>.pre-restore-<time>", whose path is returned. The server must not
+// be running.
+func Restore(ctx context.Context, snapshot, dbPath string) (string, int, error) {
+	version, err := store.ValidateSnapshot(snapshot)
+	if err != nil {
+		return "", 0, err
+	}
+
+	previous := ""
+	if _, err := os.Stat(dbPath); err == nil {
+		previous = dbPath + ".pre-restore-" + time.Now().UTC().Format(timeLayout)
+		if err := store.CopyDatabase(ctx, dbPath, previous); err != nil {
+			return "", 0, fmt.Errorf("failed to save current database: %w", err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return "", 0, err
+	}
+
+	// A leftover rollback journal would be replayed into the restored file.
+	for _, suffix := range []string{"-journal", "-wal", "-shm"} {
+		if err := os.Remove(dbPath + suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return previous, 0, err
+		}
+	}
+	if err := store.CopyDatabase(ctx, snapshot, dbPath); err != nil {
+		return previous, 0, err
+	}
+	return previous, version, nil
+}
+
+// Scheduler takes a snapshot every interval and keeps the newest few.
+type Scheduler struct {
+	store    *store.Store
+	dir      string
+	interval time.Duration
+	keep     int
+}
+
+func NewScheduler(st *store.Store, dir string, interval time.Duration, keep int) *Scheduler {
+	return &Scheduler{store: st, dir: dir, interval: interval, keep: keep}
+}
+
+// Run blocks until ctx is cancelled. The first snapshot is taken once the
+// newest existing one is older than the interval, so restarts do not reset
+// the schedule.
+func (s *Scheduler) Run(ctx context.Context) {
+	wait := time.Duration(0)
+	if snapshots, err := List(s.dir); err != nil {
+		log.Printf("backup: failed to list %s: %v", s.dir, err)
+	} else if len(snapshots) > 0 {
+		wait = max(0, s.interval-time.Since(snapshots[0].CreatedAt))
+	}
+	log.Printf("backup: every %s into %s, keeping %d", s.interval, s.dir, s.keep)
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		snap, err := Create(ctx, s.store, s.dir)
+		if err != nil {
+			log.Printf("backup: snapshot failed: %v", err)
+		} else {
+			log.Printf("backup: wrote %s (%d bytes)", snap.Name, snap.Size)
+			if err := Prune(s.dir, s.keep); err != nil {
+				log.Printf("backup: failed to prune old snapshots: %v", err)
+			}
+		}
+		timer.Reset(s.interval)
+	}
+}
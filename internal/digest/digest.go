@@ -0,0 +1,270 @@
+package digest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"litetask/internal/mailer"
+	"litetask/internal/store"
+)
+
+const (
+	checkInterval = time.Minute
+	// sectionLimit caps the tasks listed in each section.
+	sectionLimit = 20
+)
+
+// Telegram posts to the chats of a project; *tgbot.Poster implements it.
+type Telegram interface {
+	PostToProject(projectID int64, text string) error
+}
+
+// Mailer sends email; *mailer.Mailer and *mailer.Outbox implement it.
+type Mailer interface {
+	Send(msg mailer.Message) error
+}
+
+// Report is the content of a project's digest.
+type Report struct {
+	ProjectID   int64
+	ProjectName string
+	Frequency   string
+	StaleDays   int
+	// New holds the tasks created in the digest's period, Stuck those in
+	// progress for longer than StaleDays and Done those finished since the
+	// start of the week. A task is in progress in any status of the
+	// workflow but the first and the final ones.
+	New   []store.Task
+	Stuck []StuckTask
+	Done  []store.Task
+	// statusTitles maps the project's status keys to their titles.
+	statusTitles map[string]string
+	location     *time.Location
+}
+
+// StuckTask is a task in progress with the time it got there.
+type StuckTask struct {
+	store.Task
+	Since time.Time
+}
+
+// Build collects the digest of a project at now.
+func Build(st *store.Store, d store.DigestSettings, now time.Time) (Report, error) {
+	wf, err := st.GetWorkflow(d.ProjectID)
+	if err != nil {
+		return Report{}, err
+	}
+	tasks, err := st.FetchTasks(store.TaskFilter{ProjectID: d.ProjectID})
+	if err != nil {
+		return Report{}, err
+	}
+	changes, err := st.ListProjectStatusChanges(d.ProjectID)
+	if err != nil {
+		return Report{}, err
+	}
+	// Events are oldest first, so the last one wins.
+	lastChange := make(map[int64]time.Time, len(changes))
+	for _, e := range changes {
+		lastChange[e.TaskID] = e.CreatedAt
+	}
+	final := make(map[string]bool)
+	inProgress := make(map[string]bool)
+	r := Report{
+		ProjectID:    d.ProjectID,
+		ProjectName:  st.LookupProjectName(d.ProjectID),
+		Frequency:    d.Frequency,
+		StaleDays:    d.StaleDays,
+		New:          []store.Task{},
+		Stuck:        []StuckTask{},
+		Done:         []store.Task{},
+		statusTitles: make(map[string]string),
+		location:     d.Location(),
+	}
+	for i, s := range wf.Statuses {
+		final[s.Key] = s.Final
+		inProgress[s.Key] = i > 0 && !s.Final
+		r.statusTitles[s.Key] = s.Title
+	}
+
+	newSince := now.Add(-d.Period())
+	staleBefore := now.AddDate(0, 0, -d.StaleDays)
+	weekStart := WeekStart(now.In(r.location))
+	for _, t := range tasks {
+		changed, ok := lastChange[t.ID]
+		if !ok {
+			changed = t.CreatedAt
+		}
+		if !t.CreatedAt.Before(newSince) {
+			r.New = append(r.New, t)
+		}
+		if inProgress[t.Status] && changed.Before(staleBefore) {
+			r.Stuck = append(r.Stuck, StuckTask{Task: t, Since: changed})
+		}
+		if final[t.Status] && ok && !changed.Before(weekStart) {
+			r.Done = append(r.Done, t)
+		}
+	}
+	// Tasks come newest first; the longest stuck go first.
+	sort.SliceStable(r.Stuck, func(i, j int) bool { return r.Stuck[i].Since.Before(r.Stuck[j].Since) })
+	return r, nil
+}
+
+// WeekStart returns midnight of the Monday of t's week in t's location.
+func WeekStart(t time.Time) time.Time {
+	days := (int(t.Weekday()) + 6) % 7
+	return time.Date(t.Year(), t.Month(), t.Day()-days, 0, 0, 0, 0, t.Location())
+}
+
+// Empty reports whether there is nothing to tell.
+func (r Report) Empty() bool {
+	return len(r.New) == 0 && len(r.Stuck) == 0 && len(r.Done) == 0
+}
+
+func (r Report) Subject() string {
+	return fmt.Sprintf("LiteTask: сводка по проекту %s", r.ProjectName)
+}
+
+// Text renders the digest as plain text for Telegram and email.
+func (r Report) Text() string {
+	var builder strings.Builder
+	period := "день"
+	if r.Frequency == store.DigestWeekly {
+		period = "неделю"
+	}
+	fmt.Fprintf(&builder, "Сводка по проекту %s\n", r.ProjectName)
+	if r.Empty() {
+		builder.WriteString("\nНичего нового.\n")
+		return builder.String()
+	}
+
+	if len(r.New) > 0 {
+		fmt.Fprintf(&builder, "\nНовые задачи за %s (%d):\n", period, len(r.New))
+		for _, t := range r.New[:min(len(r.New), sectionLimit)] {
+			fmt.Fprintf(&builder, "#%d [%s] %s\n", t.ID, r.statusTitle(t.Status), t.Title)
+		}
+		writeMore(&builder, len(r.New))
+	}
+	if len(r.Stuck) > 0 {
+		fmt.Fprintf(&builder, "\nВ работе дольше %d дн. (%d):\n", r.StaleDays, len(r.Stuck))
+		for _, t := range r.Stuck[:min(len(r.Stuck), sectionLimit)] {
+			fmt.Fprintf(&builder, "#%d [%s] %s — с %s%s\n", t.ID, r.statusTitle(t.Status), t.Title, t.Since.In(r.location).Format(store.DateLayout), assignees(t.Task))
+		}
+		writeMore(&builder, len(r.Stuck))
+	}
+	if len(r.Done) > 0 {
+		fmt.Fprintf(&builder, "\nЗавершено на этой неделе (%d):\n", len(r.Done))
+		for _, t := range r.Done[:min(len(r.Done), sectionLimit)] {
+			fmt.Fprintf(&builder, "#%d %s%s\n", t.ID, t.Title, assignees(t))
+		}
+		writeMore(&builder, len(r.Done))
+	}
+	return builder.String()
+}
+
+func (r Report) statusTitle(key string) string {
+	if title, ok := r.statusTitles[key]; ok {
+		return title
+	}
+	return key
+}
+
+func writeMore(builder *strings.Builder, total int) {
+	if total > sectionLimit {
+		fmt.Fprintf(builder, "…и ещё %d\n", total-sectionLimit)
+	}
+}
+
+func assignees(t store.Task) string {
+	if len(t.Assignees) == 0 {
+		return ""
+	}
+	names := make([]string, len(t.Assignees))
+	for i, a := range t.Assignees {
+		names[i] = strings.TrimSpace(a.FirstName + " " + a.LastName)
+		if names[i] == "" {
+			names[i] = a.Email
+		}
+	}
+	return " (" + strings.Join(names, ", ") + ")"
+}
+
+// Scheduler sends the project digests when they are due. Either channel may
+// be nil when it is not configured.
+type Scheduler struct {
+	store    *store.Store
+	telegram Telegram
+	mail     Mailer
+}
+
+func NewScheduler(st *store.Store, telegram Telegram, mail Mailer) *Scheduler {
+	return &Scheduler{store: st, telegram: telegram, mail: mail}
+}
+
+// Run blocks until ctx is cancelled, checking the schedules every minute.
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		s.sendDue(time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Scheduler) sendDue(now time.Time) {
+	digests, err := s.store.ListActiveDigests()
+	if err != nil {
+		log.Printf("digest: failed to load schedules: %v", err)
+		return
+	}
+	for _, d := range digests {
+		if !d.Due(now) {
+			continue
+		}
+		// A failed delivery is not retried, so that a broken channel does
+		// not repeat the digest on the others every minute.
+		if err := s.Send(d, now); err != nil {
+			log.Printf("digest: project %d: %v", d.ProjectID, err)
+		}
+		if err := s.store.MarkDigestSent(d.ProjectID, now); err != nil {
+			log.Printf("digest: failed to record delivery: %v", err)
+		}
+	}
+}
+
+// Send builds the digest and delivers it to the configured channels. An
+// empty digest is not sent.
+func (s *Scheduler) Send(d store.DigestSettings, now time.Time) error {
+	r, err := Build(s.store, d, now)
+	if err != nil {
+		return err
+	}
+	if r.Empty() {
+		return nil
+	}
+	text := r.Text()
+	var errs []error
+	if d.Telegram {
+		if s.telegram == nil {
+			errs = append(errs, errors.New("telegram bot is not configured"))
+		} else if err := s.telegram.PostToProject(d.ProjectID, text); err != nil {
+			errs = append(errs, fmt.Errorf("telegram: %w", err))
+		}
+	}
+	if len(d.Emails) > 0 {
+		if s.mail == nil {
+			errs = append(errs, errors.New("email is not configured"))
+		} else if err := s.mail.Send(mailer.Message{To: d.Emails, Subject: r.Subject(), Text: text}); err != nil {
+			errs = append(errs, fmt.Errorf("email: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
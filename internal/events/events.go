@@ -0,0 +1,97 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Event types published on the bus.
+const (
+	TaskCreated    = "task.created"
+	TaskUpdated    = "task.updated"
+	TaskDeleted    = "task.deleted"
+	CommentCreated = "comment.created"
+	CommentDeleted = "comment.deleted"
+	ProjectCreated = "project.created"
+	ProjectUpdated = "project.updated"
+	ProjectDeleted = "project.deleted"
+)
+
+// Where a mutation originated.
+const (
+	SourceWeb      = "web"
+	SourceTelegram = "telegram"
+	SourceEmail    = "email"
+)
+
+const subscriberQueue = 64
+
+// Event describes a mutation of a task, comment or project. Data carries the
+// affected entity as it looks after the change.
+type Event struct {
+	ID            uint64    `json:"id"`
+	Type          string    `json:"type"`
+	ProjectID     int64     `json:"projectId"`
+	PrevProjectID int64     `json:"prevProjectId,omitempty"`
+	TaskID        int64     `json:"taskId,omitempty"`
+	ActorID       int64     `json:"actorId,omitempty"`
+	Source        string    `json:"source"`
+	Data          any       `json:"data,omitempty"`
+	At            time.Time `json:"at"`
+}
+
+// Bus fans events out to subscribers. A nil *Bus is valid and drops
+// everything, so publishers do not need to check whether one is configured.
+type Bus struct {
+	mu     sync.RWMutex
+	nextID uint64
+	subs   map[chan Event]struct{}
+}
+
+func NewBus() *Bus {
+	return &Bus{subs: make(map[chan Event]struct{})}
+}
+
+// Publish delivers e to every subscriber without blocking. Subscribers that
+// fall behind lose events rather than stall the publisher.
+func (b *Bus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	b.nextID++
+	e.ID = b.nextID
+	if e.At.IsZero() {
+		e.At = time.Now().UTC()
+	}
+	for ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+	b.mu.Unlock()
+}
+
+// Subscribe returns a channel receiving all future events and a function
+// that unsubscribes and closes the channel.
+func (b *Bus) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, subscriberQueue)
+	if b == nil {
+		close(ch)
+		return ch, func() {}
+	}
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, ch)
+			b.mu.Unlock()
+			close(ch)
+		})
+	}
+}
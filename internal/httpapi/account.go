@@ -0,0 +1,187 @@
+package httpapi
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"litetask/internal/mailer"
+	"litetask/internal/store"
+)
+
+// Mailer sends the emails of the account flows; *mailer.Outbox implements
+// it.
+type Mailer interface {
+	Send(msg mailer.Message) error
+}
+
+// accountEmailsEnabled reports whether password reset and verification
+// emails can be sent: they need a mailer and the public address for links.
+func (s *Server) accountEmailsEnabled() bool {
+	return s.mailer != nil && s.publicURL != ""
+}
+
+// sendAccountEmail issues a token for purpose and emails the link with it.
+// ErrTokenCooldown is returned when one was sent moments ago.
+func (s *Server) sendAccountEmail(u store.User, purpose string) error {
+	ttl, param, template, subject := store.PasswordResetTTL, "reset", "reset", "LiteTask: сброс пароля"
+	if purpose == store.TokenEmailVerify {
+		ttl, param, template, subject = store.EmailVerifyTTL, "verify", "verify", "LiteTask: подтверждение адреса"
+	}
+	token, _, err := s.store.CreateAuthToken(u.ID, purpose, ttl)
+	if err != nil {
+		return err
+	}
+	msg, err := mailer.Render(template, subject, mailer.AccountNotice{
+		Email: u.Email,
+		Link:  fmt.Sprintf("%s/?%s=%s", s.publicURL, param, url.QueryEscape(token)),
+		Valid: formatHours(ttl),
+	})
+	if err != nil {
+		return err
+	}
+	msg.To = []string{u.Email}
+	// The link is useless after the token expires, and the outbox must not
+	// keep it around once sent.
+	msg.ExpiresAt = time.Now().Add(ttl)
+	return s.mailer.Send(msg)
+}
+
+// formatHours renders a whole number of hours in Russian: "1 час",
+// "2 часа", "48 часов".
+func formatHours(d time.Duration) string {
+	n := int(d / time.Hour)
+	switch {
+	case n%10 == 1 && n%100 != 11:
+		return fmt.Sprintf("%d час", n)
+	case n%10 >= 2 && n%10 <= 4 && (n%100 < 12 || n%100 > 14):
+		return fmt.Sprintf("%d часа", n)
+	}
+	return fmt.Sprintf("%d часов", n)
+}
+
+// accountByLogin reads {"login"}, an email or username, and looks the user
+// up. The user is nil when there is none; on error the response is already
+// written.
+func (s *Server) accountByLogin(w http.ResponseWriter, r *http.Request) (*store.User, error) {
+	var payload struct {
+		Login string `json:"login"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return nil, err
+	}
+	if strings.TrimSpace(payload.Login) == "" {
+		http.Error(w, "email/юзернейм обязателен", http.StatusBadRequest)
+		return nil, errors.New("login required")
+	}
+	u, err := s.store.GetUserByEmailOrUsername(payload.Login)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return nil, err
+	}
+	return &u, nil
+}
+
+// handleForgot emails a password reset link. It answers the same whether or
+// not the address belongs to anyone, so it cannot be used to probe for
+// accounts.
+func (s *Server) handleForgot(w http.ResponseWriter, r *http.Request) {
+	if s.disablePasswordLogin {
+		http.Error(w, "password login disabled", http.StatusForbidden)
+		return
+	}
+	if !s.accountEmailsEnabled() {
+		http.Error(w, "password reset is not configured", http.StatusServiceUnavailable)
+		return
+	}
+	u, err := s.accountByLogin(w, r)
+	if err != nil {
+		return
+	}
+	if u != nil && u.Role != "blocked" {
+		if err := s.sendAccountEmail(*u, store.TokenPasswordReset); err != nil && !errors.Is(err, store.ErrTokenCooldown) {
+			log.Printf("failed to send password reset email: %v", err)
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleReset sets a new password with a token from the reset email. All
+// sessions end, so the user signs in again with the new password.
+func (s *Server) handleReset(w http.ResponseWriter, r *http.Request) {
+	if s.disablePasswordLogin {
+		http.Error(w, "password login disabled", http.StatusForbidden)
+		return
+	}
+	var payload struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(payload.Password) < 6 {
+		http.Error(w, "password too short", http.StatusBadRequest)
+		return
+	}
+	if _, err := s.store.ResetPassword(strings.TrimSpace(payload.Token), payload.Password); err != nil {
+		if errors.Is(err, store.ErrInvalidToken) {
+			http.Error(w, "ссылка недействительна или устарела", http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleVerify confirms an address with the token from the verification
+// email.
+func (s *Server) handleVerify(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if _, err := s.store.VerifyEmail(strings.TrimSpace(payload.Token)); err != nil {
+		if errors.Is(err, store.ErrInvalidToken) {
+			http.Error(w, "ссылка недействительна или устарела", http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleResendVerification sends the verification email again. Like
+// handleForgot it does not tell whether the address is registered.
+func (s *Server) handleResendVerification(w http.ResponseWriter, r *http.Request) {
+	if !s.requireVerification {
+		http.Error(w, "email verification is off", http.StatusNotFound)
+		return
+	}
+	u, err := s.accountByLogin(w, r)
+	if err != nil {
+		return
+	}
+	if u != nil && !u.EmailVerified && u.Role != "blocked" {
+		if err := s.sendAccountEmail(*u, store.TokenEmailVerify); err != nil && !errors.Is(err, store.ErrTokenCooldown) {
+			log.Printf("failed to send verification email: %v", err)
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
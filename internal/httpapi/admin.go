@@ -0,0 +1,50 @@
+package httpapi
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"litetask/internal/backup"
+)
+
+// handleBackup takes an online snapshot of the database. With a backup
+// directory configured the snapshot is kept there and described in the
+// response; ?download=true (or no directory) streams the file instead.
+func (s *Server) handleBackup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	download := r.URL.Query().Get("download") == "true" || s.backupDir == ""
+
+	if !download {
+		snap, err := backup.Create(r.Context(), s.store, s.backupDir)
+		if err != nil {
+			log.Printf("backup failed: %v", err)
+			http.Error(w, "backup failed", http.StatusInternalServerError)
+			return
+		}
+		writeJSONStatus(w, http.StatusCreated, snap)
+		return
+	}
+
+	tmpDir, err := os.MkdirTemp("", "litetask-backup-")
+	if err != nil {
+		http.Error(w, "backup failed", http.StatusInternalServerError)
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+	name := backup.FileName(time.Now())
+	path := filepath.Join(tmpDir, name)
+	if err := s.store.Backup(r.Context(), path); err != nil {
+		log.Printf("backup failed: %v", err)
+		http.Error(w, "backup failed", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/vnd.sqlite3")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	http.ServeFile(w, r, path)
+}
@@ -0,0 +1,95 @@
+package httpapi
+
+import (
+	"database/sql"
+	"errors"
+	"mime"
+	"net/http"
+	"strconv"
+)
+
+// handleTaskAttachments serves /api/tasks/{id}/attachments: the list, and a
+// file's content at .../attachments/{attachmentId}.
+func (s *Server) handleTaskAttachments(w http.ResponseWriter, r *http.Request, taskID int64, rest []string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	auth := getAuth(r)
+	t, err := s.store.GetTask(taskID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "task not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to load task", http.StatusInternalServerError)
+		return
+	}
+	if auth.isRestricted && !auth.canAccess(t.ProjectID) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	switch len(rest) {
+	case 0:
+		attachments, err := s.store.ListTaskAttachments(taskID)
+		if err != nil {
+			http.Error(w, "failed to load attachments", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, attachments)
+	case 1:
+		id, err := strconv.ParseInt(rest[0], 10, 64)
+		if err != nil {
+			http.Error(w, "invalid attachment id", http.StatusBadRequest)
+			return
+		}
+		a, err := s.store.GetTaskAttachment(id)
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && a.TaskID != taskID) {
+			http.Error(w, "attachment not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "failed to load attachment", http.StatusInternalServerError)
+			return
+		}
+		// Files come from email senders, so they are always downloaded
+		// rather than rendered in the app's origin.
+		w.Header().Set("Content-Type", a.ContentType)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.FileName}))
+		w.Header().Set("Content-Length", strconv.Itoa(len(a.Data)))
+		if _, err := w.Write(a.Data); err != nil {
+			return
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// getProjectMailbox returns the address that creates tasks in a project by
+// email.
+func (s *Server) getProjectMailbox(w http.ResponseWriter, r *http.Request, projectID int64) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !getAuth(r).canAccess(projectID) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if s.mailbox == nil {
+		http.Error(w, "inbound email is not configured", http.StatusNotFound)
+		return
+	}
+	if ok, err := s.store.ProjectExists(projectID); err != nil {
+		http.Error(w, "failed to load project", http.StatusInternalServerError)
+		return
+	} else if !ok {
+		http.Error(w, "project not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, struct {
+		Address string `json:"address"`
+	}{Address: s.mailbox.ProjectAddress(projectID)})
+}
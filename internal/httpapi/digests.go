@@ -0,0 +1,98 @@
+package httpapi
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"litetask/internal/digest"
+	"litetask/internal/store"
+)
+
+// handleProjectDigest serves /api/projects/{id}/digest: anyone with access
+// reads the settings and the preview, maintainers change them.
+func (s *Server) handleProjectDigest(w http.ResponseWriter, r *http.Request, projectID int64, rest []string) {
+	auth := getAuth(r)
+	if !auth.canAccess(projectID) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if len(rest) == 1 && rest[0] == "preview" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.previewDigest(w, projectID)
+		return
+	}
+	if len(rest) != 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	d, err := s.store.GetDigestSettings(projectID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "project not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to load digest settings", http.StatusInternalServerError)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, d)
+	case http.MethodPut:
+		if !auth.canMaintain(projectID) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		// Fields left out of the body keep their current values.
+		if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		d.ProjectID = projectID
+		updated, err := s.store.SetDigestSettings(d)
+		if errors.Is(err, store.ErrInvalidDigest) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "project not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "failed to update digest settings", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, updated)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// previewDigest renders the digest as it would be sent now.
+func (s *Server) previewDigest(w http.ResponseWriter, projectID int64) {
+	d, err := s.store.GetDigestSettings(projectID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "project not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to load digest settings", http.StatusInternalServerError)
+		return
+	}
+	report, err := digest.Build(s.store, d, time.Now())
+	if err != nil {
+		http.Error(w, "failed to build digest", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, struct {
+		Subject string `json:"subject"`
+		Text    string `json:"text"`
+		Empty   bool   `json:"empty"`
+	}{Subject: report.Subject(), Text: report.Text(), Empty: report.Empty()})
+}
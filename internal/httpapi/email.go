@@ -0,0 +1,85 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+
+	"litetask/internal/mailer"
+	"litetask/internal/store"
+)
+
+// handleEmailOutbox lists the newest queued and sent emails, optionally only
+// those with ?status=pending|delivered|failed.
+func (s *Server) handleEmailOutbox(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", store.DeliveryPending, store.DeliveryDelivered, store.DeliveryFailed:
+	default:
+		http.Error(w, "invalid status", http.StatusBadRequest)
+		return
+	}
+	limit := defaultDeliveryLimit
+	if val := r.URL.Query().Get("limit"); val != "" {
+		n, err := strconv.Atoi(val)
+		if err != nil || n <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxDeliveryLimit)
+	}
+	emails, err := s.store.ListOutbox(status, limit)
+	if err != nil {
+		http.Error(w, "failed to load emails", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, emails)
+}
+
+// handleTestEmail sends a test email right away so that admins can check the
+// SMTP settings; the SMTP error is returned as is.
+func (s *Server) handleTestEmail(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.outbox == nil {
+		http.Error(w, "email is not configured", http.StatusServiceUnavailable)
+		return
+	}
+	var payload struct {
+		To string `json:"to"`
+	}
+	// Without a body the email goes to the admin.
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	to := getAuth(r).user.Email
+	if strings.TrimSpace(payload.To) != "" {
+		addr, err := mail.ParseAddress(strings.TrimSpace(payload.To))
+		if err != nil {
+			http.Error(w, "invalid email", http.StatusBadRequest)
+			return
+		}
+		to = addr.Address
+	}
+	err := s.outbox.SendNow(mailer.Message{
+		To:      []string{to},
+		Subject: "LiteTask: проверка почты",
+		Text:    "Если ты читаешь это письмо, LiteTask умеет отправлять почту.\n",
+	})
+	if err != nil {
+		http.Error(w, "failed to send: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
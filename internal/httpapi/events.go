@@ -0,0 +1,153 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"litetask/internal/events"
+	"litetask/internal/store"
+)
+
+// sseHeartbeat is how often a stream is kept alive and its credentials are
+// checked again.
+const sseHeartbeat = 25 * time.Second
+
+// handleEvents streams bus events as Server-Sent Events. Only events of
+// projects the caller can access are sent; projectId narrows the stream to
+// a single board.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	auth := getAuth(r)
+	projectID := int64(0)
+	if pid := r.URL.Query().Get("projectId"); pid != "" {
+		val, err := strconv.ParseInt(pid, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid projectId", http.StatusBadRequest)
+			return
+		}
+		projectID = val
+	}
+	if projectID != 0 && !auth.canAccess(projectID) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	sub, unsubscribe := s.events.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 3000\n\n")
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			// The session may have been revoked, the user blocked or their
+			// project roles changed since the stream opened.
+			if auth, ok = s.reauthenticate(r); !ok || (projectID != 0 && !auth.canAccess(projectID)) {
+				return
+			}
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case e, ok := <-sub:
+			if !ok {
+				return
+			}
+			e, ok = visibleEvent(auth, projectID, e)
+			if !ok {
+				continue
+			}
+			data, err := json.Marshal(e)
+			if err != nil {
+				log.Printf("failed to encode event: %v", err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// reauthenticate checks the credentials of a long-lived request again.
+func (s *Server) reauthenticate(r *http.Request) (authUser, bool) {
+	auth, err := s.authenticate(r)
+	if err != nil {
+		return authUser{}, false
+	}
+	if err := s.loadProjectRoles(&auth); err != nil {
+		log.Printf("failed to load project roles: %v", err)
+		return authUser{}, false
+	}
+	return auth, true
+}
+
+// movedTask is all that subscribers who only see the project a task moved
+// out of learn about it: that it left their board.
+type movedTask struct {
+	ID        int64 `json:"id"`
+	ProjectID int64 `json:"projectId"`
+	Moved     bool  `json:"moved"`
+}
+
+// visibleEvent returns e as the subscriber may see it, or false when it is
+// about projects they cannot access. A task moved out of a project they can
+// see, into one they cannot, is reported without its contents.
+func visibleEvent(auth authUser, projectID int64, e events.Event) (events.Event, bool) {
+	matches := func(pid int64) bool {
+		if pid == 0 {
+			return false
+		}
+		if projectID != 0 && pid != projectID {
+			return false
+		}
+		return auth.canAccess(pid)
+	}
+	if matches(e.ProjectID) {
+		return e, true
+	}
+	if !matches(e.PrevProjectID) {
+		return events.Event{}, false
+	}
+	e.ProjectID, e.PrevProjectID = e.PrevProjectID, 0
+	e.Data = movedTask{ID: e.TaskID, ProjectID: e.ProjectID, Moved: true}
+	return e, true
+}
+
+func (s *Server) publish(r *http.Request, e events.Event) {
+	e.ActorID = getAuth(r).user.ID
+	e.Source = events.SourceWeb
+	s.events.Publish(e)
+}
+
+func (s *Server) publishTask(r *http.Request, eventType string, prevProjectID int64, t store.Task) {
+	e := events.Event{Type: eventType, ProjectID: t.ProjectID, TaskID: t.ID, Data: t}
+	if prevProjectID != t.ProjectID {
+		e.PrevProjectID = prevProjectID
+	}
+	s.publish(r, e)
+}
@@ -0,0 +1,98 @@
+package httpapi
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"litetask/internal/store"
+)
+
+// handleProjectMembers serves /api/projects/{id}/members. Anyone with access
+// to the project can list its members; maintainers add them or change their
+// role with PUT /members/{userId} and remove them with DELETE.
+func (s *Server) handleProjectMembers(w http.ResponseWriter, r *http.Request, projectID int64, rest []string) {
+	auth := getAuth(r)
+	if !auth.canAccess(projectID) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if len(rest) == 0 {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		members, err := s.store.ListProjectMembers(projectID)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "project not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "failed to load members", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, members)
+		return
+	}
+	if len(rest) != 1 {
+		http.NotFound(w, r)
+		return
+	}
+	userID, err := strconv.ParseInt(rest[0], 10, 64)
+	if err != nil {
+		http.Error(w, "invalid user id", http.StatusBadRequest)
+		return
+	}
+	if !auth.canMaintain(projectID) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		var payload struct {
+			Role string `json:"role"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		role := strings.TrimSpace(strings.ToLower(payload.Role))
+		if role == "" {
+			role = store.ProjectRoleMember
+		}
+		member, err := s.store.SetProjectMember(projectID, userID, role)
+		if errors.Is(err, store.ErrInvalidProjectRole) {
+			http.Error(w, "invalid role", http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			if strings.Contains(err.Error(), "project not found") {
+				http.Error(w, "project not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "failed to update member", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, member)
+	case http.MethodDelete:
+		if err := s.store.RemoveProjectMember(projectID, userID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, "member not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "failed to remove member", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
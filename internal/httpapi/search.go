@@ -0,0 +1,62 @@
+package httpapi
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"litetask/internal/store"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// handleSearch runs a full-text query over the tasks and comments of the
+// projects the caller can access. projectId narrows it to one board.
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	auth := getAuth(r)
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		http.Error(w, "q is required", http.StatusBadRequest)
+		return
+	}
+	filter := store.TaskFilter{Allowed: auth.allowed}
+	if pid := r.URL.Query().Get("projectId"); pid != "" {
+		val, err := strconv.ParseInt(pid, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid projectId", http.StatusBadRequest)
+			return
+		}
+		if !auth.canAccess(val) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		filter.ProjectID = val
+	}
+	limit := defaultSearchLimit
+	if l := r.URL.Query().Get("limit"); l != "" {
+		val, err := strconv.Atoi(l)
+		if err != nil || val <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(val, maxSearchLimit)
+	}
+	if auth.isRestricted && len(auth.allowed) == 0 {
+		writeJSON(w, []store.SearchResult{})
+		return
+	}
+
+	results, err := s.store.Search(query, filter, limit)
+	if err != nil {
+		http.Error(w, "search failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, results)
+}
@@ -8,15 +8,19 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	"litetask/internal/events"
+	"litetask/internal/mailer"
+	"litetask/internal/oidc"
 	"litetask/internal/store"
 
 	"golang.org/x/crypto/bcrypt"
@@ -32,35 +36,113 @@ type authUser struct {
 	user         store.User
 	allowed      map[int64]struct{}
 	isRestricted bool
+	// roles holds the project roles of a restricted user.
+	roles map[int64]string
+	// token is set when the request authenticated with a personal API token,
+	// session when it came with the auth cookie.
+	token   *store.APIToken
+	session *store.Session
 }
 
 type Server struct {
 	store             *store.Store
+	events            *events.Bus
 	authSecret        []byte
 	allowRegistration bool
 	staticDir         string
+	backupDir         string
+	telegramWebhook   http.Handler
+	outbox            *mailer.Outbox
+	mailbox           *mailer.Mailbox
+	inboundEmail      http.Handler
+	mailer            Mailer
+	publicURL         string
+	// requireVerification keeps self-registered users out until they
+	// confirm their email address.
+	requireVerification bool
+	oidc                *oidc.Provider
+	// disablePasswordLogin leaves single sign-on as the only way in.
+	disablePasswordLogin bool
+}
+
+// Options configures a Server.
+type Options struct {
+	AuthSecret        []byte
+	AllowRegistration bool
+	StaticDir         string
+	// BackupDir is where POST /api/admin/backup stores snapshots. When empty
+	// the snapshot is only offered as a download.
+	BackupDir string
+	// TelegramWebhook receives bot updates at /api/telegram/webhook when the
+	// bot runs in webhook mode.
+	TelegramWebhook http.Handler
+	// Outbox sends email; nil when SMTP is not configured.
+	Outbox *mailer.Outbox
+	// Mailbox is the inbound email address; InboundEmail receives the
+	// messages sent to it at /api/inbound/email. Both are nil when inbound
+	// email is off.
+	Mailbox      *mailer.Mailbox
+	InboundEmail http.Handler
+	// Mailer sends password reset and verification emails, with links to
+	// PublicURL. Without both there is no password reset.
+	Mailer    Mailer
+	PublicURL string
+	// RequireEmailVerification makes new registrations confirm their email
+	// address before they can sign in; it needs Mailer and PublicURL.
+	RequireEmailVerification bool
+	// OIDC signs users in with an OpenID Connect provider; nil when single
+	// sign-on is off.
+	OIDC *oidc.Provider
+	// DisablePasswordLogin turns off login, registration and password reset
+	// with local passwords, so users sign in through OIDC only.
+	DisablePasswordLogin bool
 }
 
 type taskResponse struct {
-	ID          int64               `json:"id"`
-	Title       string              `json:"title"`
-	Status      string              `json:"status"`
-	Description string              `json:"description"`
-	ProjectID   int64               `json:"projectId"`
-	CreatedAt   time.Time           `json:"createdAt"`
-	CreatedBy   int64               `json:"createdBy"`
-	AuthorEmail string              `json:"authorEmail"`
-	AuthorFirst string              `json:"authorFirstName,omitempty"`
-	AuthorLast  string              `json:"authorLastName,omitempty"`
-	Comments    []store.TaskComment `json:"comments"`
-}
-
-func New(s *store.Store, secret []byte, allowRegistration bool, staticDir string) *Server {
+	ID          int64                `json:"id"`
+	Title       string               `json:"title"`
+	Status      string               `json:"status"`
+	Description string               `json:"description"`
+	ProjectID   int64                `json:"projectId"`
+	CreatedAt   time.Time            `json:"createdAt"`
+	CreatedBy   int64                `json:"createdBy"`
+	AuthorEmail string               `json:"authorEmail"`
+	AuthorFirst string               `json:"authorFirstName,omitempty"`
+	AuthorLast  string               `json:"authorLastName,omitempty"`
+	StartDate   *time.Time           `json:"startDate,omitempty"`
+	DueDate     *time.Time           `json:"dueDate,omitempty"`
+	Overdue     bool                 `json:"overdue"`
+	Assignees   []store.TaskAssignee `json:"assignees"`
+	Comments    []store.TaskComment  `json:"comments"`
+}
+
+// projectResponse carries the caller's role so clients can hide actions the
+// role does not allow.
+type projectResponse struct {
+	store.Project
+	Role string `json:"role"`
+}
+
+func New(s *store.Store, bus *events.Bus, opts Options) *Server {
 	return &Server{
-		store:             s,
-		authSecret:        secret,
-		allowRegistration: allowRegistration,
-		staticDir:         staticDir,
+		// The history records which changes came from the web, as the
+		// webhooks report them.
+		store:             s.WithSource(events.SourceWeb),
+		events:            bus,
+		authSecret:        opts.AuthSecret,
+		allowRegistration: opts.AllowRegistration,
+		staticDir:         opts.StaticDir,
+		backupDir:         opts.BackupDir,
+		telegramWebhook:   opts.TelegramWebhook,
+		outbox:            opts.Outbox,
+		mailbox:           opts.Mailbox,
+		inboundEmail:      opts.InboundEmail,
+		mailer:            opts.Mailer,
+		publicURL:         strings.TrimRight(opts.PublicURL, "/"),
+
+		requireVerification:  opts.RequireEmailVerification,
+		oidc:                 opts.OIDC,
+		disablePasswordLogin: opts.DisablePasswordLogin,
 	}
 }
 
@@ -74,6 +156,30 @@ func (s *Server) Routes() http.Handler {
 	mux.Handle("/api/users", s.cors(s.requireAdmin(http.HandlerFunc(s.handleUsers))))
 	mux.Handle("/api/users/", s.cors(s.requireAdmin(http.HandlerFunc(s.handleUserActions))))
 	mux.Handle("/api/profile", s.cors(s.requireUser(http.HandlerFunc(s.handleProfile))))
+	mux.Handle("/api/profile/2fa", s.cors(s.requireUser(http.HandlerFunc(s.handleTwoFactor))))
+	mux.Handle("/api/profile/2fa/recovery", s.cors(s.requireUser(http.HandlerFunc(s.handleRecoveryCodes))))
+	mux.Handle("/api/profile/tokens", s.cors(s.requireUser(http.HandlerFunc(s.handleTokens))))
+	mux.Handle("/api/profile/tokens/", s.cors(s.requireUser(http.HandlerFunc(s.handleTokenActions))))
+	mux.Handle("/api/profile/sessions", s.cors(s.requireUser(http.HandlerFunc(s.handleSessions))))
+	mux.Handle("/api/profile/sessions/", s.cors(s.requireUser(http.HandlerFunc(s.handleSessionActions))))
+	mux.Handle("/api/profile/telegram", s.cors(s.requireUser(http.HandlerFunc(s.handleTelegram))))
+	mux.Handle("/api/profile/telegram/link", s.cors(s.requireUser(http.HandlerFunc(s.handleTelegramLink))))
+	if s.telegramWebhook != nil {
+		mux.Handle("/api/telegram/webhook", s.telegramWebhook)
+	}
+	if s.inboundEmail != nil {
+		mux.Handle("/api/inbound/email", s.inboundEmail)
+	}
+	mux.Handle("/api/telegram/chats", s.cors(s.requireAdmin(http.HandlerFunc(s.handleTelegramChats))))
+	mux.Handle("/api/telegram/chats/", s.cors(s.requireAdmin(http.HandlerFunc(s.handleTelegramChatActions))))
+	mux.Handle("/api/events", s.cors(s.requireUser(http.HandlerFunc(s.handleEvents))))
+	mux.Handle("/api/search", s.cors(s.requireUser(http.HandlerFunc(s.handleSearch))))
+	mux.Handle("/api/webhooks", s.cors(s.requireAdmin(http.HandlerFunc(s.handleWebhooks))))
+	mux.Handle("/api/webhooks/", s.cors(s.requireAdmin(http.HandlerFunc(s.handleWebhookActions))))
+	mux.Handle("/api/admin/backup", s.cors(s.requireAdmin(http.HandlerFunc(s.handleBackup))))
+	mux.Handle("/api/admin/email", s.cors(s.requireAdmin(http.HandlerFunc(s.handleEmailOutbox))))
+	mux.Handle("/api/admin/email/test", s.cors(s.requireAdmin(http.HandlerFunc(s.handleTestEmail))))
+	mux.Handle("/api/admin/security", s.cors(s.requireAdmin(http.HandlerFunc(s.handleSecurity))))
 	mux.Handle("/", s.staticHandler())
 	return mux
 }
@@ -81,7 +187,7 @@ func (s *Server) Routes() http.Handler {
 func (s *Server) cors(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET,POST,PATCH,DELETE,OPTIONS")
+		w.Header().Set("Access-Control-Allow-Methods", "GET,POST,PUT,PATCH,DELETE,OPTIONS")
 		w.Header().Set("Access-Control-Allow-Headers", "Content-Type,Authorization")
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusNoContent)
@@ -93,45 +199,64 @@ func (s *Server) cors(next http.Handler) http.Handler {
 
 func (s *Server) requireUser(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		u, err := s.authenticate(r)
+		auth, err := s.authenticate(r)
 		if err != nil {
 			http.Error(w, "unauthorized", http.StatusUnauthorized)
 			return
 		}
+		u := auth.user
 		if u.Role == "blocked" {
 			http.Error(w, "account blocked", http.StatusForbidden)
 			return
 		}
-		auth := authUser{user: u}
-		if u.Role != "admin" {
-			allowed, err := s.store.GetUserProjects(u.ID)
-			if err != nil {
-				http.Error(w, "server error", http.StatusInternalServerError)
-				return
-			}
-			auth.isRestricted = true
-			auth.allowed = make(map[int64]struct{}, len(allowed))
-			for _, pid := range allowed {
-				auth.allowed[pid] = struct{}{}
-			}
+		if !tokenAllows(auth.token, r) {
+			http.Error(w, "read-only token", http.StatusForbidden)
+			return
+		}
+		if err := s.loadProjectRoles(&auth); err != nil {
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
 		}
 		ctx := context.WithValue(r.Context(), ctxUser, auth)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// loadProjectRoles restricts a user who is not an admin to the projects
+// they are a member of.
+func (s *Server) loadProjectRoles(auth *authUser) error {
+	if auth.user.Role == "admin" {
+		return nil
+	}
+	roles, err := s.store.GetUserProjectRoles(auth.user.ID)
+	if err != nil {
+		return err
+	}
+	auth.isRestricted = true
+	auth.roles = roles
+	auth.allowed = make(map[int64]struct{}, len(roles))
+	for pid := range roles {
+		auth.allowed[pid] = struct{}{}
+	}
+	return nil
+}
+
 func (s *Server) requireAdmin(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		u, err := s.authenticate(r)
+		auth, err := s.authenticate(r)
 		if err != nil {
 			http.Error(w, "unauthorized", http.StatusUnauthorized)
 			return
 		}
-		if u.Role != "admin" {
+		if auth.user.Role != "admin" {
 			http.Error(w, "forbidden", http.StatusForbidden)
 			return
 		}
-		ctx := context.WithValue(r.Context(), ctxUser, authUser{user: u})
+		if !tokenAllows(auth.token, r) {
+			http.Error(w, "read-only token", http.StatusForbidden)
+			return
+		}
+		ctx := context.WithValue(r.Context(), ctxUser, auth)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
@@ -182,6 +307,39 @@ func (s *Server) handleTaskActions(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if len(parts) >= 2 && parts[1] == "attachments" {
+		s.handleTaskAttachments(w, r, id, parts[2:])
+		return
+	}
+
+	if len(parts) == 2 && parts[1] == "activity" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.listTaskActivity(w, r, id)
+		return
+	}
+
+	if len(parts) == 2 && parts[1] == "assignees" {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.assignTask(w, r, id)
+		return
+	}
+
+	if len(parts) == 3 && parts[1] == "assignees" && r.Method == http.MethodDelete {
+		userID, err := strconv.ParseInt(parts[2], 10, 64)
+		if err != nil {
+			http.Error(w, "invalid user id", http.StatusBadRequest)
+			return
+		}
+		s.unassignTask(w, r, id, userID)
+		return
+	}
+
 	if len(parts) == 3 && parts[1] == "comments" && r.Method == http.MethodDelete {
 		commentID, err := strconv.ParseInt(parts[2], 10, 64)
 		if err != nil {
@@ -193,7 +351,7 @@ func (s *Server) handleTaskActions(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if len(parts) == 1 && r.Method == http.MethodPatch {
-		s.updateDescription(w, r, id)
+		s.updateTask(w, r, id)
 		return
 	}
 
@@ -225,8 +383,26 @@ func (s *Server) handleAuthRoutes(w http.ResponseWriter, r *http.Request) {
 		s.handleRegister(w, r)
 	case strings.HasPrefix(path, "/me") && r.Method == http.MethodGet:
 		s.handleMe(w, r)
+	case strings.HasPrefix(path, "/config") && r.Method == http.MethodGet:
+		s.handleAuthConfig(w, r)
+	case strings.HasPrefix(path, "/oidc/login") && r.Method == http.MethodGet:
+		s.handleSSOLogin(w, r)
+	case strings.HasPrefix(path, "/oidc/callback") && r.Method == http.MethodGet:
+		s.handleSSOCallback(w, r)
 	case strings.HasPrefix(path, "/logout") && r.Method == http.MethodPost:
 		s.handleLogout(w, r)
+	case strings.HasPrefix(path, "/2fa/enroll") && r.Method == http.MethodPost:
+		s.handleLoginEnroll(w, r)
+	case strings.HasPrefix(path, "/2fa") && r.Method == http.MethodPost:
+		s.handleLoginTwoFactor(w, r)
+	case strings.HasPrefix(path, "/forgot") && r.Method == http.MethodPost:
+		s.handleForgot(w, r)
+	case strings.HasPrefix(path, "/reset") && r.Method == http.MethodPost:
+		s.handleReset(w, r)
+	case strings.HasPrefix(path, "/verify/resend") && r.Method == http.MethodPost:
+		s.handleResendVerification(w, r)
+	case strings.HasPrefix(path, "/verify") && r.Method == http.MethodPost:
+		s.handleVerify(w, r)
 	default:
 		http.Error(w, "not found", http.StatusNotFound)
 	}
@@ -248,6 +424,7 @@ func (s *Server) handleUsers(w http.ResponseWriter, r *http.Request) {
 			FirstName  string  `json:"firstName"`
 			LastName   string  `json:"lastName"`
 			ProjectIDs []int64 `json:"projectIds"`
+			TwoFactor  bool    `json:"twoFactorEnabled"`
 		}, len(users))
 		for i, u := range users {
 			projects, _ := s.store.GetUserProjects(u.ID)
@@ -259,7 +436,8 @@ func (s *Server) handleUsers(w http.ResponseWriter, r *http.Request) {
 				FirstName  string  `json:"firstName"`
 				LastName   string  `json:"lastName"`
 				ProjectIDs []int64 `json:"projectIds"`
-			}{ID: u.ID, Email: u.Email, Username: u.Username, Role: u.Role, FirstName: u.FirstName, LastName: u.LastName, ProjectIDs: projects}
+				TwoFactor  bool    `json:"twoFactorEnabled"`
+			}{ID: u.ID, Email: u.Email, Username: u.Username, Role: u.Role, FirstName: u.FirstName, LastName: u.LastName, ProjectIDs: projects, TwoFactor: u.TwoFactorEnabled}
 		}
 		writeJSON(w, trimmed)
 	case http.MethodPost:
@@ -329,6 +507,7 @@ func (s *Server) handleUsers(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) handleUserActions(w http.ResponseWriter, r *http.Request) {
 	idStr := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/users/"), "/")
+	idStr, twoFactor := strings.CutSuffix(idStr, "/2fa")
 	if idStr == "" {
 		http.NotFound(w, r)
 		return
@@ -338,6 +517,10 @@ func (s *Server) handleUserActions(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "invalid user id", http.StatusBadRequest)
 		return
 	}
+	if twoFactor {
+		s.resetUserTwoFactor(w, r, id)
+		return
+	}
 	if r.Method != http.MethodPatch {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
@@ -390,6 +573,12 @@ func (s *Server) handleUserActions(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "failed to update password", http.StatusBadRequest)
 			return
 		}
+		// Resetting one's own password revokes the current session too.
+		if auth := getAuth(r); auth.user.ID == id && auth.session != nil {
+			if err := s.startSession(w, r, id); err != nil {
+				log.Printf("failed to reissue session: %v", err)
+			}
+		}
 	}
 	if payload.ProjectIDs != nil {
 		if err := s.store.SetUserProjects(id, payload.ProjectIDs); err != nil {
@@ -402,7 +591,7 @@ func (s *Server) handleUserActions(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 	if payload.FirstName != nil || payload.LastName != nil {
-		updated, err = s.store.UpdateUserProfile(id, nil, nil, payload.FirstName, payload.LastName)
+		updated, err = s.store.UpdateUserProfile(id, nil, nil, payload.FirstName, payload.LastName, nil)
 		if errors.Is(err, sql.ErrNoRows) {
 			http.Error(w, "user not found", http.StatusNotFound)
 			return
@@ -437,17 +626,49 @@ func (s *Server) handleUserActions(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) handleProjectActions(w http.ResponseWriter, r *http.Request) {
 	trimmed := strings.TrimPrefix(r.URL.Path, "/api/projects/")
-	idStr := strings.Trim(strings.TrimSuffix(trimmed, "/"), " ")
-	if idStr == "" {
+	parts := strings.Split(strings.Trim(strings.TrimSuffix(trimmed, "/"), " "), "/")
+	if len(parts) < 1 || parts[0] == "" {
 		http.NotFound(w, r)
 		return
 	}
-	id, err := strconv.ParseInt(idStr, 10, 64)
+	id, err := strconv.ParseInt(parts[0], 10, 64)
 	if err != nil {
 		http.Error(w, "invalid project id", http.StatusBadRequest)
 		return
 	}
 
+	if len(parts) >= 2 && parts[1] == "members" {
+		s.handleProjectMembers(w, r, id, parts[2:])
+		return
+	}
+
+	if len(parts) == 2 && parts[1] == "mailbox" {
+		s.getProjectMailbox(w, r, id)
+		return
+	}
+
+	if len(parts) >= 2 && parts[1] == "digest" {
+		s.handleProjectDigest(w, r, id, parts[2:])
+		return
+	}
+
+	if len(parts) == 2 && parts[1] == "workflow" {
+		switch r.Method {
+		case http.MethodGet:
+			s.getWorkflow(w, r, id)
+		case http.MethodPut:
+			s.updateWorkflow(w, r, id)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	if len(parts) != 1 {
+		http.NotFound(w, r)
+		return
+	}
+
 	switch r.Method {
 	case http.MethodDelete:
 		s.deleteProjectHandler(w, r, id)
@@ -462,19 +683,14 @@ func (s *Server) listProjects(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "failed to load projects", http.StatusInternalServerError)
 		return
 	}
-	authVal := r.Context().Value(ctxUser)
-	if authVal != nil {
-		if auth, ok := authVal.(authUser); ok && auth.isRestricted {
-			filtered := make([]store.Project, 0, len(auth.allowed))
-			for _, p := range projects {
-				if _, ok := auth.allowed[p.ID]; ok {
-					filtered = append(filtered, p)
-				}
-			}
-			projects = filtered
+	auth := getAuth(r)
+	result := make([]projectResponse, 0, len(projects))
+	for _, p := range projects {
+		if auth.canAccess(p.ID) {
+			result = append(result, projectResponse{Project: p, Role: auth.projectRole(p.ID)})
 		}
 	}
-	writeJSON(w, projects)
+	writeJSON(w, result)
 }
 
 func (s *Server) createProjectHandler(w http.ResponseWriter, r *http.Request) {
@@ -501,16 +717,11 @@ func (s *Server) createProjectHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if auth.isRestricted {
-		current, err := s.store.GetUserProjects(auth.user.ID)
-		if err != nil {
-			log.Printf("failed to load user projects after create: %v", err)
-		} else {
-			next := append(current, p.ID)
-			if err := s.store.SetUserProjects(auth.user.ID, next); err != nil {
-				log.Printf("failed to assign project to user: %v", err)
-			}
+		if _, err := s.store.SetProjectMember(p.ID, auth.user.ID, store.ProjectRoleMaintainer); err != nil {
+			log.Printf("failed to assign project to user: %v", err)
 		}
 	}
+	s.publish(r, events.Event{Type: events.ProjectCreated, ProjectID: p.ID, Data: p})
 	writeJSON(w, p)
 }
 
@@ -532,13 +743,66 @@ func (s *Server) deleteProjectHandler(w http.ResponseWriter, r *http.Request, id
 		http.Error(w, "failed to delete project", http.StatusInternalServerError)
 		return
 	}
+	s.publish(r, events.Event{Type: events.ProjectDeleted, ProjectID: id})
 	w.WriteHeader(http.StatusNoContent)
 }
 
+func (s *Server) getWorkflow(w http.ResponseWriter, r *http.Request, projectID int64) {
+	auth := getAuth(r)
+	if auth.isRestricted && !auth.canAccess(projectID) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	wf, err := s.store.GetWorkflow(projectID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "project not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to load workflow", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, wf)
+}
+
+func (s *Server) updateWorkflow(w http.ResponseWriter, r *http.Request, projectID int64) {
+	auth := getAuth(r)
+	if !auth.canMaintain(projectID) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	var payload struct {
+		Statuses    []store.WorkflowStatus `json:"statuses"`
+		Transitions map[string][]string    `json:"transitions"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	wf, err := s.store.SetWorkflow(projectID, payload.Statuses, payload.Transitions, auth.user.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "project not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, store.ErrInvalidWorkflow) || errors.Is(err, store.ErrStatusInUse) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to update workflow", http.StatusInternalServerError)
+		return
+	}
+	s.publish(r, events.Event{Type: events.ProjectUpdated, ProjectID: projectID, Data: wf})
+	writeJSON(w, wf)
+}
+
 func toTaskResponse(t store.Task, comments []store.TaskComment) taskResponse {
 	if comments == nil {
 		comments = []store.TaskComment{}
 	}
+	if t.Assignees == nil {
+		t.Assignees = []store.TaskAssignee{}
+	}
 	return taskResponse{
 		ID:          t.ID,
 		Title:       t.Title,
@@ -550,6 +814,10 @@ func toTaskResponse(t store.Task, comments []store.TaskComment) taskResponse {
 		AuthorEmail: t.AuthorEmail,
 		AuthorFirst: t.AuthorFirst,
 		AuthorLast:  t.AuthorLast,
+		StartDate:   t.StartDate,
+		DueDate:     t.DueDate,
+		Overdue:     t.Overdue,
+		Assignees:   t.Assignees,
 		Comments:    comments,
 	}
 }
@@ -581,15 +849,51 @@ func (s *Server) listTasks(w http.ResponseWriter, r *http.Request) {
 		}
 		projectID = val
 	}
+	assigneeID := int64(0)
+	if assignee := r.URL.Query().Get("assignee"); assignee != "" {
+		if assignee == "me" {
+			assigneeID = auth.user.ID
+		} else {
+			val, err := strconv.ParseInt(assignee, 10, 64)
+			if err != nil {
+				http.Error(w, "invalid assignee", http.StatusBadRequest)
+				return
+			}
+			assigneeID = val
+		}
+	}
+	filter := store.TaskFilter{ProjectID: projectID, Allowed: auth.allowed, AssigneeID: assigneeID}
+	if dueBefore := r.URL.Query().Get("dueBefore"); dueBefore != "" {
+		val, err := store.ParseDate(dueBefore)
+		if err != nil {
+			http.Error(w, "invalid dueBefore", http.StatusBadRequest)
+			return
+		}
+		filter.DueBefore = &val
+	}
+	if overdue := r.URL.Query().Get("overdue"); overdue != "" {
+		val, err := strconv.ParseBool(overdue)
+		if err != nil {
+			http.Error(w, "invalid overdue", http.StatusBadRequest)
+			return
+		}
+		filter.Overdue = val
+	}
 
 	if auth.isRestricted {
-		if projectID == 0 || !auth.canAccess(projectID) {
+		// "My tasks" views span every project the user can see, so the
+		// project filter is only mandatory for full board listings.
+		if (projectID == 0 && assigneeID == 0) || (projectID != 0 && !auth.canAccess(projectID)) {
 			http.Error(w, "forbidden", http.StatusForbidden)
 			return
 		}
+		if len(auth.allowed) == 0 {
+			writeJSON(w, []taskResponse{})
+			return
+		}
 	}
 
-	tasks, err := s.store.FetchTasks(projectID, "", auth.allowed)
+	tasks, err := s.store.FetchTasks(filter)
 	if err != nil {
 		http.Error(w, "failed to load tasks", http.StatusInternalServerError)
 		return
@@ -606,20 +910,32 @@ func (s *Server) listTasks(w http.ResponseWriter, r *http.Request) {
 func (s *Server) createTask(w http.ResponseWriter, r *http.Request) {
 	auth := getAuth(r)
 	var payload struct {
-		Title       string `json:"title"`
-		Description string `json:"description"`
-		ProjectID   int64  `json:"projectId"`
+		Title       string    `json:"title"`
+		Description string    `json:"description"`
+		ProjectID   int64     `json:"projectId"`
+		StartDate   dateField `json:"startDate"`
+		DueDate     dateField `json:"dueDate"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
 		http.Error(w, "invalid request body", http.StatusBadRequest)
 		return
 	}
+	startDate, err := payload.StartDate.parse()
+	if err != nil {
+		http.Error(w, "invalid startDate", http.StatusBadRequest)
+		return
+	}
+	dueDate, err := payload.DueDate.parse()
+	if err != nil {
+		http.Error(w, "invalid dueDate", http.StatusBadRequest)
+		return
+	}
 	payload.Title = strings.TrimSpace(payload.Title)
 	payload.Description = strings.TrimSpace(payload.Description)
 	if payload.ProjectID == 0 {
 		payload.ProjectID = store.DefaultProjectID
 	}
-	if auth.isRestricted && !auth.canAccess(payload.ProjectID) {
+	if !auth.canWrite(payload.ProjectID) {
 		http.Error(w, "forbidden", http.StatusForbidden)
 		return
 	}
@@ -628,8 +944,12 @@ func (s *Server) createTask(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	created, err := s.store.InsertTask(payload.Title, payload.Description, payload.ProjectID, auth.user.ID)
+	created, err := s.store.InsertTask(payload.Title, payload.Description, payload.ProjectID, auth.user.ID, startDate, dueDate)
 	if err != nil {
+		if errors.Is(err, store.ErrInvalidDates) {
+			http.Error(w, "start date is after due date", http.StatusBadRequest)
+			return
+		}
 		if strings.Contains(err.Error(), "project not found") {
 			http.Error(w, "project not found", http.StatusBadRequest)
 			return
@@ -638,6 +958,7 @@ func (s *Server) createTask(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	s.publishTask(r, events.TaskCreated, created.ProjectID, created)
 	writeJSON(w, toTaskResponse(created, []store.TaskComment{}))
 }
 
@@ -652,7 +973,7 @@ func (s *Server) updateStatus(w http.ResponseWriter, r *http.Request, id int64)
 		http.Error(w, "failed to load task", http.StatusInternalServerError)
 		return
 	}
-	if auth.isRestricted && !auth.canAccess(existing.ProjectID) {
+	if !auth.canWrite(existing.ProjectID) {
 		http.Error(w, "forbidden", http.StatusForbidden)
 		return
 	}
@@ -665,7 +986,7 @@ func (s *Server) updateStatus(w http.ResponseWriter, r *http.Request, id int64)
 	}
 	payload.Status = strings.TrimSpace(payload.Status)
 
-	updated, err := s.store.SetTaskStatus(id, payload.Status)
+	updated, err := s.store.SetTaskStatus(id, payload.Status, auth.user.ID)
 	if errors.Is(err, sql.ErrNoRows) {
 		http.Error(w, "task not found", http.StatusNotFound)
 		return
@@ -674,6 +995,10 @@ func (s *Server) updateStatus(w http.ResponseWriter, r *http.Request, id int64)
 		http.Error(w, "invalid status", http.StatusBadRequest)
 		return
 	}
+	if errors.Is(err, store.ErrTransitionNotAllowed) {
+		http.Error(w, "status transition not allowed", http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, "failed to update task", http.StatusInternalServerError)
 		return
@@ -685,10 +1010,11 @@ func (s *Server) updateStatus(w http.ResponseWriter, r *http.Request, id int64)
 		return
 	}
 
+	s.publishTask(r, events.TaskUpdated, existing.ProjectID, updated)
 	writeJSON(w, toTaskResponse(updated, comments))
 }
 
-func (s *Server) updateDescription(w http.ResponseWriter, r *http.Request, id int64) {
+func (s *Server) updateTask(w http.ResponseWriter, r *http.Request, id int64) {
 	auth := getAuth(r)
 	existing, err := s.store.GetTask(id)
 	if errors.Is(err, sql.ErrNoRows) {
@@ -699,26 +1025,132 @@ func (s *Server) updateDescription(w http.ResponseWriter, r *http.Request, id in
 		http.Error(w, "failed to load task", http.StatusInternalServerError)
 		return
 	}
-	if auth.isRestricted && !auth.canAccess(existing.ProjectID) {
+	if !auth.canWrite(existing.ProjectID) {
 		http.Error(w, "forbidden", http.StatusForbidden)
 		return
 	}
 	var payload struct {
-		Description string `json:"description"`
+		Description *string   `json:"description"`
+		StartDate   dateField `json:"startDate"`
+		DueDate     dateField `json:"dueDate"`
+		ProjectID   *int64    `json:"projectId"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
 		http.Error(w, "invalid request body", http.StatusBadRequest)
 		return
 	}
-	payload.Description = strings.TrimSpace(payload.Description)
+	if payload.Description == nil && !payload.StartDate.set && !payload.DueDate.set && payload.ProjectID == nil {
+		http.Error(w, "nothing to update", http.StatusBadRequest)
+		return
+	}
+	if payload.ProjectID != nil && !auth.canWrite(*payload.ProjectID) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	updated := existing
+	if payload.ProjectID != nil && *payload.ProjectID != existing.ProjectID {
+		updated, err = s.store.MoveTask(id, *payload.ProjectID, auth.user.ID)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "task not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			if strings.Contains(err.Error(), "project not found") {
+				http.Error(w, "project not found", http.StatusBadRequest)
+				return
+			}
+			http.Error(w, "failed to move task", http.StatusInternalServerError)
+			return
+		}
+	}
+	if payload.StartDate.set || payload.DueDate.set {
+		startDate, dueDate := existing.StartDate, existing.DueDate
+		if payload.StartDate.set {
+			if startDate, err = payload.StartDate.parse(); err != nil {
+				http.Error(w, "invalid startDate", http.StatusBadRequest)
+				return
+			}
+		}
+		if payload.DueDate.set {
+			if dueDate, err = payload.DueDate.parse(); err != nil {
+				http.Error(w, "invalid dueDate", http.StatusBadRequest)
+				return
+			}
+		}
+		updated, err = s.store.SetTaskDates(id, startDate, dueDate, auth.user.ID)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "task not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, store.ErrInvalidDates) {
+			http.Error(w, "start date is after due date", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, "failed to update dates", http.StatusInternalServerError)
+			return
+		}
+	}
+	if payload.Description != nil {
+		updated, err = s.store.SetTaskDescription(id, strings.TrimSpace(*payload.Description), auth.user.ID)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "task not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "failed to update description", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	comments, err := s.store.ListTaskComments(id)
+	if err != nil {
+		http.Error(w, "failed to load comments", http.StatusInternalServerError)
+		return
+	}
+
+	s.publishTask(r, events.TaskUpdated, existing.ProjectID, updated)
+	writeJSON(w, toTaskResponse(updated, comments))
+}
+
+func (s *Server) assignTask(w http.ResponseWriter, r *http.Request, id int64) {
+	auth := getAuth(r)
+	existing, err := s.store.GetTask(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "task not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to load task", http.StatusInternalServerError)
+		return
+	}
+	if !auth.canWrite(existing.ProjectID) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	var payload struct {
+		UserID int64 `json:"userId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if payload.UserID == 0 {
+		payload.UserID = auth.user.ID
+	}
 
-	updated, err := s.store.SetTaskDescription(id, payload.Description)
+	updated, err := s.store.AssignTask(id, payload.UserID, auth.user.ID)
+	if errors.Is(err, store.ErrNotProjectMember) {
+		http.Error(w, "user is not a member of the project", http.StatusBadRequest)
+		return
+	}
 	if errors.Is(err, sql.ErrNoRows) {
 		http.Error(w, "task not found", http.StatusNotFound)
 		return
 	}
 	if err != nil {
-		http.Error(w, "failed to update description", http.StatusInternalServerError)
+		http.Error(w, "failed to assign task", http.StatusInternalServerError)
 		return
 	}
 
@@ -728,6 +1160,43 @@ func (s *Server) updateDescription(w http.ResponseWriter, r *http.Request, id in
 		return
 	}
 
+	s.publishTask(r, events.TaskUpdated, existing.ProjectID, updated)
+	writeJSON(w, toTaskResponse(updated, comments))
+}
+
+func (s *Server) unassignTask(w http.ResponseWriter, r *http.Request, id, userID int64) {
+	auth := getAuth(r)
+	existing, err := s.store.GetTask(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "task not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to load task", http.StatusInternalServerError)
+		return
+	}
+	if !auth.canWrite(existing.ProjectID) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	updated, err := s.store.UnassignTask(id, userID, auth.user.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "assignee not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to unassign task", http.StatusInternalServerError)
+		return
+	}
+
+	comments, err := s.store.ListTaskComments(id)
+	if err != nil {
+		http.Error(w, "failed to load comments", http.StatusInternalServerError)
+		return
+	}
+
+	s.publishTask(r, events.TaskUpdated, existing.ProjectID, updated)
 	writeJSON(w, toTaskResponse(updated, comments))
 }
 
@@ -757,7 +1226,16 @@ func (s *Server) listTaskComments(w http.ResponseWriter, r *http.Request, taskID
 	writeJSON(w, comments)
 }
 
-func (s *Server) addTaskComment(w http.ResponseWriter, r *http.Request, taskID int64) {
+// activityItem is one entry of a task timeline: either a recorded event or
+// a comment that still exists.
+type activityItem struct {
+	Kind      string             `json:"kind"`
+	CreatedAt time.Time          `json:"createdAt"`
+	Event     *store.TaskEvent   `json:"event,omitempty"`
+	Comment   *store.TaskComment `json:"comment,omitempty"`
+}
+
+func (s *Server) listTaskActivity(w http.ResponseWriter, r *http.Request, taskID int64) {
 	auth := getAuth(r)
 	existing, err := s.store.GetTask(taskID)
 	if errors.Is(err, sql.ErrNoRows) {
@@ -772,6 +1250,67 @@ func (s *Server) addTaskComment(w http.ResponseWriter, r *http.Request, taskID i
 		http.Error(w, "forbidden", http.StatusForbidden)
 		return
 	}
+	events, err := s.store.ListTaskEvents(taskID)
+	if err != nil {
+		http.Error(w, "failed to load activity", http.StatusInternalServerError)
+		return
+	}
+	comments, err := s.store.ListTaskComments(taskID)
+	if err != nil {
+		http.Error(w, "failed to load comments", http.StatusInternalServerError)
+		return
+	}
+
+	// A comment takes the place of its comment_added event so that the
+	// timeline order does not depend on timestamp resolution. Comments
+	// written before events were recorded are merged in by time.
+	byID := make(map[int64]*store.TaskComment, len(comments))
+	for i := range comments {
+		byID[comments[i].ID] = &comments[i]
+	}
+	items := make([]activityItem, 0, len(events)+len(comments))
+	for i := range events {
+		if events[i].Type != store.EventCommentAdded {
+			items = append(items, activityItem{Kind: "event", CreatedAt: events[i].CreatedAt, Event: &events[i]})
+			continue
+		}
+		var data struct {
+			CommentID int64 `json:"commentId"`
+		}
+		if err := json.Unmarshal(events[i].Data, &data); err != nil {
+			continue
+		}
+		if c, ok := byID[data.CommentID]; ok {
+			items = append(items, activityItem{Kind: "comment", CreatedAt: c.CreatedAt, Comment: c})
+			delete(byID, data.CommentID)
+		}
+	}
+	for i := range comments {
+		if _, ok := byID[comments[i].ID]; ok {
+			items = append(items, activityItem{Kind: "comment", CreatedAt: comments[i].CreatedAt, Comment: &comments[i]})
+		}
+	}
+	sort.SliceStable(items, func(a, b int) bool {
+		return items[a].CreatedAt.Before(items[b].CreatedAt)
+	})
+	writeJSON(w, items)
+}
+
+func (s *Server) addTaskComment(w http.ResponseWriter, r *http.Request, taskID int64) {
+	auth := getAuth(r)
+	existing, err := s.store.GetTask(taskID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "task not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to load task", http.StatusInternalServerError)
+		return
+	}
+	if !auth.canWrite(existing.ProjectID) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
 	var payload struct {
 		Body string `json:"body"`
 	}
@@ -789,6 +1328,7 @@ func (s *Server) addTaskComment(w http.ResponseWriter, r *http.Request, taskID i
 		http.Error(w, "failed to add comment", http.StatusInternalServerError)
 		return
 	}
+	s.publish(r, events.Event{Type: events.CommentCreated, ProjectID: existing.ProjectID, TaskID: taskID, Data: comment})
 	writeJSON(w, comment)
 }
 
@@ -803,7 +1343,7 @@ func (s *Server) deleteTaskComment(w http.ResponseWriter, r *http.Request, taskI
 		http.Error(w, "failed to load task", http.StatusInternalServerError)
 		return
 	}
-	if auth.isRestricted && !auth.canAccess(task.ProjectID) {
+	if !auth.canWrite(task.ProjectID) {
 		http.Error(w, "forbidden", http.StatusForbidden)
 		return
 	}
@@ -820,11 +1360,12 @@ func (s *Server) deleteTaskComment(w http.ResponseWriter, r *http.Request, taskI
 		http.Error(w, "comment does not belong to task", http.StatusBadRequest)
 		return
 	}
-	if comment.AuthorID != auth.user.ID {
+	// Maintainers may remove anyone's comment, members only their own.
+	if comment.AuthorID != auth.user.ID && !auth.canMaintain(task.ProjectID) {
 		http.Error(w, "forbidden", http.StatusForbidden)
 		return
 	}
-	if err := s.store.DeleteTaskComment(commentID); err != nil {
+	if err := s.store.DeleteTaskComment(commentID, auth.user.ID); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			http.Error(w, "comment not found", http.StatusNotFound)
 			return
@@ -832,6 +1373,7 @@ func (s *Server) deleteTaskComment(w http.ResponseWriter, r *http.Request, taskI
 		http.Error(w, "failed to delete comment", http.StatusInternalServerError)
 		return
 	}
+	s.publish(r, events.Event{Type: events.CommentDeleted, ProjectID: task.ProjectID, TaskID: taskID, Data: comment})
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -846,11 +1388,11 @@ func (s *Server) deleteTaskHandler(w http.ResponseWriter, r *http.Request, id in
 		http.Error(w, "failed to load task", http.StatusInternalServerError)
 		return
 	}
-	if auth.isRestricted && !auth.canAccess(existing.ProjectID) {
+	if !auth.canMaintain(existing.ProjectID) {
 		http.Error(w, "forbidden", http.StatusForbidden)
 		return
 	}
-	if err := s.store.DeleteTask(id); err != nil {
+	if err := s.store.DeleteTask(id, auth.user.ID); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			http.Error(w, "task not found", http.StatusNotFound)
 			return
@@ -858,10 +1400,15 @@ func (s *Server) deleteTaskHandler(w http.ResponseWriter, r *http.Request, id in
 		http.Error(w, "failed to delete task", http.StatusInternalServerError)
 		return
 	}
+	s.publish(r, events.Event{Type: events.TaskDeleted, ProjectID: existing.ProjectID, TaskID: id})
 	w.WriteHeader(http.StatusNoContent)
 }
 
 func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	if s.disablePasswordLogin {
+		http.Error(w, "password login disabled", http.StatusForbidden)
+		return
+	}
 	var payload struct {
 		Login    string `json:"login"`
 		Email    string `json:"email"`
@@ -896,29 +1443,33 @@ func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "account blocked", http.StatusForbidden)
 		return
 	}
-	token := createToken(u, s.authSecret)
-	setAuthCookie(w, token)
-	writeJSON(w, struct {
-		ID        int64  `json:"id"`
-		Email     string `json:"email"`
-		Username  string `json:"username"`
-		Role      string `json:"role"`
-		FirstName string `json:"firstName"`
-		LastName  string `json:"lastName"`
-		Telegram  string `json:"telegram"`
-	}{
-		ID:        u.ID,
-		Email:     u.Email,
-		Username:  u.Username,
-		Role:      u.Role,
-		FirstName: u.FirstName,
-		LastName:  u.LastName,
-		Telegram:  u.Telegram,
-	})
+	if s.requireVerification && !u.EmailVerified {
+		http.Error(w, "email not verified", http.StatusForbidden)
+		return
+	}
+	challenge, err := s.loginChallenge(u)
+	if errors.Is(err, store.ErrTooManyLogins) {
+		http.Error(w, "too many login attempts, try again later", http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	if challenge != nil {
+		// The auth cookie is issued by /api/auth/2fa once the code checks out.
+		writeJSONStatus(w, http.StatusAccepted, challenge)
+		return
+	}
+	if err := s.startSession(w, r, u.ID); err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, newCurrentUser(u))
 }
 
 func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
-	if !s.allowRegistration {
+	if !s.allowRegistration || s.disablePasswordLogin {
 		http.Error(w, "registration disabled", http.StatusForbidden)
 		return
 	}
@@ -943,7 +1494,12 @@ func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "password too short", http.StatusBadRequest)
 		return
 	}
-	u, err := s.store.CreateUser(payload.Email, payload.Username, payload.Password, "user", payload.FirstName, payload.LastName)
+	var opts []store.UserOption
+	if s.requireVerification {
+		// The user signs in after following the link in the email.
+		opts = append(opts, store.EmailUnverified())
+	}
+	u, err := s.store.CreateUser(payload.Email, payload.Username, payload.Password, "user", payload.FirstName, payload.LastName, opts...)
 	if err != nil {
 		if strings.Contains(strings.ToLower(err.Error()), "unique") {
 			lower := strings.ToLower(err.Error())
@@ -961,82 +1517,116 @@ func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "server error", http.StatusInternalServerError)
 		return
 	}
-	token := createToken(u, s.authSecret)
-	setAuthCookie(w, token)
-	writeJSON(w, struct {
-		ID        int64  `json:"id"`
-		Email     string `json:"email"`
-		Username  string `json:"username"`
-		Role      string `json:"role"`
-		FirstName string `json:"firstName"`
-		LastName  string `json:"lastName"`
-		Telegram  string `json:"telegram"`
-	}{
-		ID:        u.ID,
-		Email:     u.Email,
-		Username:  u.Username,
-		Role:      u.Role,
-		FirstName: u.FirstName,
-		LastName:  u.LastName,
-		Telegram:  u.Telegram,
-	})
+	if s.requireVerification {
+		if err := s.sendAccountEmail(u, store.TokenEmailVerify); err != nil {
+			log.Printf("failed to send verification email: %v", err)
+		}
+		writeJSONStatus(w, http.StatusAccepted, struct {
+			Email                string `json:"email"`
+			VerificationRequired bool   `json:"verificationRequired"`
+		}{Email: u.Email, VerificationRequired: true})
+		return
+	}
+	if err := s.startSession(w, r, u.ID); err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, newCurrentUser(u))
+}
+
+// currentUser is what login, registration and /api/auth/me return about the
+// signed-in user.
+type currentUser struct {
+	ID               int64                   `json:"id"`
+	Email            string                  `json:"email"`
+	Username         string                  `json:"username"`
+	Role             string                  `json:"role"`
+	FirstName        string                  `json:"firstName"`
+	LastName         string                  `json:"lastName"`
+	Telegram         string                  `json:"telegram"`
+	TelegramLinked   bool                    `json:"telegramLinked"`
+	Notifications    store.NotificationPrefs `json:"notifications"`
+	TwoFactorEnabled bool                    `json:"twoFactorEnabled"`
+}
+
+func newCurrentUser(u store.User) currentUser {
+	return currentUser{
+		ID:               u.ID,
+		Email:            u.Email,
+		Username:         u.Username,
+		Role:             u.Role,
+		FirstName:        u.FirstName,
+		LastName:         u.LastName,
+		Telegram:         u.Telegram,
+		TelegramLinked:   u.TelegramID != 0,
+		Notifications:    u.Notifications,
+		TwoFactorEnabled: u.TwoFactorEnabled,
+	}
 }
 
 func (s *Server) handleMe(w http.ResponseWriter, r *http.Request) {
-	u, err := s.authenticate(r)
+	auth, err := s.authenticate(r)
 	if err != nil {
 		http.Error(w, "unauthorized", http.StatusUnauthorized)
 		return
 	}
-	writeJSON(w, struct {
-		ID        int64  `json:"id"`
-		Email     string `json:"email"`
-		Username  string `json:"username"`
-		Role      string `json:"role"`
-		FirstName string `json:"firstName"`
-		LastName  string `json:"lastName"`
-		Telegram  string `json:"telegram"`
-	}{
-		ID:        u.ID,
-		Email:     u.Email,
-		Username:  u.Username,
-		Role:      u.Role,
-		FirstName: u.FirstName,
-		LastName:  u.LastName,
-		Telegram:  u.Telegram,
-	})
+	u := auth.user
+	writeJSON(w, newCurrentUser(u))
 }
 
-func (s *Server) handleLogout(w http.ResponseWriter, _ *http.Request) {
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if id, ok := s.sessionIDFromCookie(r); ok {
+		if err := s.store.RevokeSession(id); err != nil {
+			log.Printf("failed to revoke session: %v", err)
+		}
+	}
 	clearAuthCookie(w)
 	w.WriteHeader(http.StatusNoContent)
 }
 
 func (s *Server) handleProfile(w http.ResponseWriter, r *http.Request) {
-	u, err := s.authenticate(r)
+	auth, err := s.authenticate(r)
 	if err != nil {
 		http.Error(w, "unauthorized", http.StatusUnauthorized)
 		return
 	}
+	if !tokenAllows(auth.token, r) {
+		http.Error(w, "read-only token", http.StatusForbidden)
+		return
+	}
+	u := auth.user
 
 	switch r.Method {
 	case http.MethodGet:
+		emailPrefs, err := s.store.GetEmailPrefs(u.ID)
+		if err != nil {
+			http.Error(w, "failed to load profile", http.StatusInternalServerError)
+			return
+		}
 		writeJSON(w, struct {
-			ID        int64  `json:"id"`
-			Email     string `json:"email"`
-			Username  string `json:"username"`
-			Role      string `json:"role"`
-			FirstName string `json:"firstName"`
-			LastName  string `json:"lastName"`
-			Telegram  string `json:"telegram"`
+			ID             int64                   `json:"id"`
+			Email          string                  `json:"email"`
+			Username       string                  `json:"username"`
+			Role           string                  `json:"role"`
+			FirstName      string                  `json:"firstName"`
+			LastName       string                  `json:"lastName"`
+			Telegram       string                  `json:"telegram"`
+			TelegramLinked bool                    `json:"telegramLinked"`
+			Notifications  store.NotificationPrefs `json:"notifications"`
+
+			EmailNotifications store.EmailPrefs `json:"emailNotifications"`
 		}{
-			ID:        u.ID,
-			Email:     u.Email,
-			Username:  u.Username,
-			Role:      u.Role,
-			FirstName: u.FirstName,
-			LastName:  u.LastName,
-			Telegram:  u.Telegram,
+			ID:             u.ID,
+			Email:          u.Email,
+			Username:       u.Username,
+			Role:           u.Role,
+			FirstName:      u.FirstName,
+			LastName:       u.LastName,
+			Telegram:       u.Telegram,
+			TelegramLinked: u.TelegramID != 0,
+			Notifications:  u.Notifications,
+
+			EmailNotifications: emailPrefs,
 		})
 	case http.MethodPatch:
 		var payload struct {
@@ -1045,15 +1635,24 @@ func (s *Server) handleProfile(w http.ResponseWriter, r *http.Request) {
 			FirstName *string `json:"firstName"`
 			LastName  *string `json:"lastName"`
 			Username  *string `json:"username"`
+
+			Notifications      *store.NotificationPrefs `json:"notifications"`
+			EmailNotifications *store.EmailPrefs        `json:"emailNotifications"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
 			http.Error(w, "invalid request body", http.StatusBadRequest)
 			return
 		}
-		if payload.Password == nil && payload.Telegram == nil && payload.FirstName == nil && payload.LastName == nil && payload.Username == nil {
+		if payload.Password == nil && payload.Telegram == nil && payload.FirstName == nil && payload.LastName == nil && payload.Username == nil && payload.Notifications == nil && payload.EmailNotifications == nil {
 			http.Error(w, "nothing to update", http.StatusBadRequest)
 			return
 		}
+		// A new password signs out every session, so a token must not set
+		// one without the current password.
+		if auth.token != nil && payload.Password != nil {
+			http.Error(w, "tokens cannot change the password", http.StatusForbidden)
+			return
+		}
 		if payload.Telegram != nil {
 			trimmed := strings.TrimSpace(*payload.Telegram)
 			payload.Telegram = &trimmed
@@ -1094,7 +1693,7 @@ func (s *Server) handleProfile(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
-		updated, err := s.store.UpdateUserProfile(u.ID, payload.Password, payload.Telegram, payload.FirstName, payload.LastName)
+		updated, err := s.store.UpdateUserProfile(u.ID, payload.Password, payload.Telegram, payload.FirstName, payload.LastName, payload.Notifications)
 		if errors.Is(err, sql.ErrNoRows) {
 			http.Error(w, "user not found", http.StatusNotFound)
 			return
@@ -1107,90 +1706,152 @@ func (s *Server) handleProfile(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "failed to update profile", http.StatusInternalServerError)
 			return
 		}
+		emailPrefs, err := s.store.GetEmailPrefs(u.ID)
+		if payload.EmailNotifications != nil && err == nil {
+			emailPrefs, err = s.store.SetEmailPrefs(u.ID, *payload.EmailNotifications)
+		}
+		if err != nil {
+			http.Error(w, "failed to update profile", http.StatusInternalServerError)
+			return
+		}
+		// The password change signed out every session; keep this one going.
+		if payload.Password != nil && auth.session != nil {
+			if err := s.startSession(w, r, u.ID); err != nil {
+				log.Printf("failed to reissue session: %v", err)
+			}
+		}
 		writeJSON(w, struct {
-			ID        int64  `json:"id"`
-			Email     string `json:"email"`
-			Username  string `json:"username"`
-			Role      string `json:"role"`
-			FirstName string `json:"firstName"`
-			LastName  string `json:"lastName"`
-			Telegram  string `json:"telegram"`
+			ID             int64                   `json:"id"`
+			Email          string                  `json:"email"`
+			Username       string                  `json:"username"`
+			Role           string                  `json:"role"`
+			FirstName      string                  `json:"firstName"`
+			LastName       string                  `json:"lastName"`
+			Telegram       string                  `json:"telegram"`
+			TelegramLinked bool                    `json:"telegramLinked"`
+			Notifications  store.NotificationPrefs `json:"notifications"`
+
+			EmailNotifications store.EmailPrefs `json:"emailNotifications"`
 		}{
-			ID:        updated.ID,
-			Email:     updated.Email,
-			Username:  updated.Username,
-			Role:      updated.Role,
-			FirstName: updated.FirstName,
-			LastName:  updated.LastName,
-			Telegram:  updated.Telegram,
+			ID:             updated.ID,
+			Email:          updated.Email,
+			Username:       updated.Username,
+			Role:           updated.Role,
+			FirstName:      updated.FirstName,
+			LastName:       updated.LastName,
+			Telegram:       updated.Telegram,
+			TelegramLinked: updated.TelegramID != 0,
+			Notifications:  updated.Notifications,
+
+			EmailNotifications: emailPrefs,
 		})
 	default:
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
-func (s *Server) authenticate(r *http.Request) (store.User, error) {
-	cookie, err := r.Cookie("auth")
-	if err != nil {
-		return store.User{}, err
-	}
-	claims, err := parseToken(cookie.Value, s.authSecret)
-	if err != nil {
-		return store.User{}, err
+// authenticate resolves the caller from an "Authorization: Bearer" personal
+// token or, failing that, the session in the auth cookie.
+func (s *Server) authenticate(r *http.Request) (authUser, error) {
+	var auth authUser
+	var userID int64
+	if bearer, ok := bearerToken(r); ok {
+		tok, err := s.store.LookupAPIToken(bearer)
+		if err != nil {
+			return authUser{}, err
+		}
+		auth.token = &tok
+		userID = tok.UserID
+	} else {
+		id, ok := s.sessionIDFromCookie(r)
+		if !ok {
+			return authUser{}, errors.New("no session")
+		}
+		sess, err := s.store.TouchSession(id, clientIP(r))
+		if err != nil {
+			return authUser{}, err
+		}
+		auth.session = &sess
+		userID = sess.UserID
 	}
-	u, err := s.store.GetUserByID(claims.UserID)
+	u, err := s.store.GetUserByID(userID)
 	if err != nil {
-		return store.User{}, err
+		return authUser{}, err
 	}
 	if u.Role == "blocked" {
-		return store.User{}, errors.New("blocked")
+		return authUser{}, errors.New("blocked")
 	}
-	return u, nil
+	// Tokens skip the login, so they stop working for an admin without
+	// two-factor authentication while it is required, however the admin
+	// came to lack it.
+	if auth.token != nil && !u.TwoFactorEnabled {
+		required, err := s.twoFactorRequired(u)
+		if err != nil {
+			return authUser{}, err
+		}
+		if required {
+			return authUser{}, errors.New("two-factor authentication required")
+		}
+	}
+	auth.user = u
+	return auth, nil
 }
 
-type tokenClaims struct {
-	UserID int64
-	Role   string
-	Exp    time.Time
+func bearerToken(r *http.Request) (string, bool) {
+	header := strings.TrimSpace(r.Header.Get("Authorization"))
+	if len(header) < 7 || !strings.EqualFold(header[:7], "bearer ") {
+		return "", false
+	}
+	return strings.TrimSpace(header[7:]), true
 }
 
-func createToken(u store.User, secret []byte) string {
-	exp := time.Now().Add(authExpiry).Unix()
-	payload := fmt.Sprintf("%d:%s:%d", u.ID, u.Role, exp)
-	sig := sign(secret, payload)
-	return base64.RawStdEncoding.EncodeToString([]byte(payload)) + "." + sig
+// tokenAllows reports whether the request may proceed with tok. Read-only
+// tokens are limited to safe methods; cookie sessions (nil) are unrestricted.
+func tokenAllows(tok *store.APIToken, r *http.Request) bool {
+	if tok == nil || !tok.ReadOnly {
+		return true
+	}
+	return r.Method == http.MethodGet || r.Method == http.MethodHead
 }
 
-func parseToken(token string, secret []byte) (tokenClaims, error) {
-	parts := strings.Split(token, ".")
-	if len(parts) != 2 {
-		return tokenClaims{}, errors.New("invalid token")
+// startSession opens a server-side session for the user and sets the auth
+// cookie to its signed ID.
+func (s *Server) startSession(w http.ResponseWriter, r *http.Request, userID int64) error {
+	sess, err := s.store.CreateSession(userID, r.UserAgent(), clientIP(r), authExpiry)
+	if err != nil {
+		return err
 	}
-	payloadBytes, err := base64.RawStdEncoding.DecodeString(parts[0])
+	setAuthCookie(w, sess.ID+"."+sign(s.authSecret, sess.ID))
+	return nil
+}
+
+func (s *Server) sessionIDFromCookie(r *http.Request) (string, bool) {
+	cookie, err := r.Cookie("auth")
 	if err != nil {
-		return tokenClaims{}, err
+		return "", false
 	}
-	payload := string(payloadBytes)
-	if !verify(secret, payload, parts[1]) {
-		return tokenClaims{}, errors.New("invalid signature")
+	id, sig, ok := strings.Cut(cookie.Value, ".")
+	if !ok || id == "" || !verify(s.authSecret, id, sig) {
+		return "", false
 	}
-	items := strings.Split(payload, ":")
-	if len(items) != 3 {
-		return tokenClaims{}, errors.New("invalid payload")
+	return id, true
+}
+
+func clientIP(r *http.Request) string {
+	if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+		first, _, _ := strings.Cut(fwd, ",")
+		if ip := strings.TrimSpace(first); ip != "" {
+			return ip
+		}
 	}
-	id, err := strconv.ParseInt(items[0], 10, 64)
-	if err != nil {
-		return tokenClaims{}, err
+	if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); ip != "" {
+		return ip
 	}
-	role := items[1]
-	expUnix, err := strconv.ParseInt(items[2], 10, 64)
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
-		return tokenClaims{}, err
+		return r.RemoteAddr
 	}
-	if time.Now().Unix() > expUnix {
-		return tokenClaims{}, errors.New("token expired")
-	}
-	return tokenClaims{UserID: id, Role: role, Exp: time.Unix(expUnix, 0)}, nil
+	return host
 }
 
 func sign(secret []byte, payload string) string {
@@ -1204,6 +1865,38 @@ func verify(secret []byte, payload, sig string) bool {
 	return hmac.Equal([]byte(expected), []byte(sig))
 }
 
+// dateField is an optional date in a JSON payload. It tells an absent field
+// apart from an explicit null, which clears the date.
+type dateField struct {
+	set   bool
+	value *string
+}
+
+func (d *dateField) UnmarshalJSON(data []byte) error {
+	d.set = true
+	if string(data) == "null" {
+		d.value = nil
+		return nil
+	}
+	var val string
+	if err := json.Unmarshal(data, &val); err != nil {
+		return err
+	}
+	d.value = &val
+	return nil
+}
+
+func (d dateField) parse() (*time.Time, error) {
+	if d.value == nil || strings.TrimSpace(*d.value) == "" {
+		return nil, nil
+	}
+	val, err := store.ParseDate(*d.value)
+	if err != nil {
+		return nil, err
+	}
+	return &val, nil
+}
+
 func getAuth(r *http.Request) authUser {
 	val := r.Context().Value(ctxUser)
 	if val == nil {
@@ -1223,6 +1916,27 @@ func (a authUser) canAccess(projectID int64) bool {
 	return ok
 }
 
+// projectRole is the caller's effective role in a project; admins maintain
+// every project and an empty role means no access.
+func (a authUser) projectRole(projectID int64) string {
+	if !a.isRestricted {
+		return store.ProjectRoleMaintainer
+	}
+	return a.roles[projectID]
+}
+
+// canWrite reports whether the caller may change tasks and comments of the
+// project, which viewers may not.
+func (a authUser) canWrite(projectID int64) bool {
+	return store.ProjectRoleAllows(a.projectRole(projectID), store.ProjectRoleMember)
+}
+
+// canMaintain reports whether the caller may delete tasks, edit the workflow
+// and manage members of the project.
+func (a authUser) canMaintain(projectID int64) bool {
+	return store.ProjectRoleAllows(a.projectRole(projectID), store.ProjectRoleMaintainer)
+}
+
 func setAuthCookie(w http.ResponseWriter, token string) {
 	http.SetCookie(w, &http.Cookie{
 		Name:     "auth",
@@ -1254,6 +1968,19 @@ func writeJSON(w http.ResponseWriter, v any) {
 	}
 }
 
+// writeJSONStatus is writeJSON with a status other than 200. Headers set
+// after WriteHeader are dropped, so Content-Type comes first, and an
+// encoding error can only be logged once the status is out.
+func writeJSONStatus(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(true)
+	if err := enc.Encode(v); err != nil {
+		log.Printf("failed to encode response: %v", err)
+	}
+}
+
 func (s *Server) staticHandler() http.Handler {
 	abs, err := filepath.Abs(s.staticDir)
 	if err != nil {
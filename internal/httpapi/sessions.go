@@ -0,0 +1,79 @@
+package httpapi
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+
+	"litetask/internal/store"
+)
+
+type sessionResponse struct {
+	store.Session
+	Current bool `json:"current"`
+}
+
+// handleSessions lists the caller's sessions; DELETE signs out every other
+// device ("log out everywhere" except here).
+func (s *Server) handleSessions(w http.ResponseWriter, r *http.Request) {
+	auth := getAuth(r)
+	if auth.token != nil {
+		http.Error(w, "tokens cannot manage sessions", http.StatusForbidden)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		sessions, err := s.store.ListSessions(auth.user.ID)
+		if err != nil {
+			http.Error(w, "failed to load sessions", http.StatusInternalServerError)
+			return
+		}
+		result := make([]sessionResponse, 0, len(sessions))
+		for _, sess := range sessions {
+			result = append(result, sessionResponse{Session: sess, Current: auth.session != nil && sess.ID == auth.session.ID})
+		}
+		writeJSON(w, result)
+	case http.MethodDelete:
+		keep := ""
+		if auth.session != nil {
+			keep = auth.session.ID
+		}
+		if err := s.store.DeleteUserSessions(auth.user.ID, keep); err != nil {
+			http.Error(w, "failed to revoke sessions", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleSessionActions(w http.ResponseWriter, r *http.Request) {
+	auth := getAuth(r)
+	if auth.token != nil {
+		http.Error(w, "tokens cannot manage sessions", http.StatusForbidden)
+		return
+	}
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/profile/sessions/"), "/")
+	if id == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodDelete {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := s.store.DeleteSession(auth.user.ID, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "session not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "failed to revoke session", http.StatusInternalServerError)
+		return
+	}
+	if auth.session != nil && auth.session.ID == id {
+		clearAuthCookie(w)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,345 @@
+package httpapi
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"litetask/internal/oidc"
+	"litetask/internal/store"
+)
+
+const (
+	// ssoCookie keeps the state, nonce and PKCE verifier of a login while
+	// the user is at the provider.
+	ssoCookie   = "oidc_login"
+	ssoLoginTTL = 10 * time.Minute
+)
+
+// Reasons a single sign-on failed, passed to the web client as
+// /?sso_error=.
+const (
+	ssoErrUnavailable   = "unavailable"
+	ssoErrExpired       = "expired"
+	ssoErrDenied        = "denied"
+	ssoErrFailed        = "failed"
+	ssoErrNoEmail       = "no_email"
+	ssoErrEmailTaken    = "email_taken"
+	ssoErrBlocked       = "blocked"
+	ssoErrTooManyLogins = "too_many_logins"
+)
+
+// ssoError is a failed sign-on the user is told about.
+type ssoError string
+
+func (e ssoError) Error() string { return "sso: " + string(e) }
+
+// authConfig tells the web client which ways to sign in are on.
+type authConfig struct {
+	PasswordLogin bool           `json:"passwordLogin"`
+	Registration  bool           `json:"registration"`
+	SSO           *ssoAuthConfig `json:"sso"`
+}
+
+type ssoAuthConfig struct {
+	Name string `json:"name"`
+}
+
+func (s *Server) handleAuthConfig(w http.ResponseWriter, r *http.Request) {
+	cfg := authConfig{
+		PasswordLogin: !s.disablePasswordLogin,
+		Registration:  s.allowRegistration && !s.disablePasswordLogin,
+	}
+	if s.oidc != nil {
+		cfg.SSO = &ssoAuthConfig{Name: s.oidc.Config().Name}
+	}
+	writeJSON(w, cfg)
+}
+
+// handleSSOLogin sends the browser to the provider's login page.
+func (s *Server) handleSSOLogin(w http.ResponseWriter, r *http.Request) {
+	if s.oidc == nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	req, err := oidc.NewAuthRequest()
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	target, err := s.oidc.AuthCodeURL(r.Context(), req)
+	if err != nil {
+		log.Printf("sso: %v", err)
+		ssoRedirectError(w, r, ssoErrUnavailable)
+		return
+	}
+	data, err := json.Marshal(req)
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	value := base64.RawURLEncoding.EncodeToString(data)
+	http.SetCookie(w, &http.Cookie{
+		Name:     ssoCookie,
+		Value:    value + "." + sign(s.authSecret, ssoCookie+":"+value),
+		Path:     "/api/auth/oidc",
+		MaxAge:   int(ssoLoginTTL.Seconds()),
+		HttpOnly: true,
+		// Lax lets the cookie come along on the provider's redirect back.
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// handleSSOCallback finishes the login when the provider sends the browser
+// back: it redeems the code, finds or creates the user and opens a session,
+// or passes the web client a login challenge when the user needs a second
+// factor.
+func (s *Server) handleSSOCallback(w http.ResponseWriter, r *http.Request) {
+	if s.oidc == nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	req, ok := s.ssoRequest(r)
+	http.SetCookie(w, &http.Cookie{Name: ssoCookie, Path: "/api/auth/oidc", MaxAge: -1, HttpOnly: true, SameSite: http.SameSiteLaxMode})
+	q := r.URL.Query()
+	if !ok || q.Get("state") != req.State {
+		ssoRedirectError(w, r, ssoErrExpired)
+		return
+	}
+	if q.Get("error") != "" {
+		log.Printf("sso: provider returned %s: %s", q.Get("error"), q.Get("error_description"))
+		ssoRedirectError(w, r, ssoErrDenied)
+		return
+	}
+	claims, err := s.oidc.Exchange(r.Context(), q.Get("code"), req)
+	if err != nil {
+		log.Printf("sso: %v", err)
+		ssoRedirectError(w, r, ssoErrFailed)
+		return
+	}
+	u, err := s.ssoUser(claims)
+	var reason ssoError
+	if errors.As(err, &reason) {
+		ssoRedirectError(w, r, string(reason))
+		return
+	}
+	if err != nil {
+		log.Printf("sso: %v", err)
+		ssoRedirectError(w, r, ssoErrFailed)
+		return
+	}
+	if !s.oidc.Config().TrustMFA {
+		challenge, err := s.loginChallenge(u)
+		if errors.Is(err, store.ErrTooManyLogins) {
+			ssoRedirectError(w, r, ssoErrTooManyLogins)
+			return
+		}
+		if err != nil {
+			log.Printf("sso: %v", err)
+			ssoRedirectError(w, r, ssoErrFailed)
+			return
+		}
+		if challenge != nil {
+			// The web client asks for the code and finishes the login at
+			// /api/auth/2fa like a password login.
+			http.Redirect(w, r, "/?"+url.Values{
+				"sso_challenge": {challenge.Challenge},
+				"two_factor":    {challenge.TwoFactor},
+			}.Encode(), http.StatusFound)
+			return
+		}
+	}
+	if err := s.startSession(w, r, u.ID); err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// ssoRequest reads the login in progress from the signed cookie.
+func (s *Server) ssoRequest(r *http.Request) (oidc.AuthRequest, bool) {
+	var req oidc.AuthRequest
+	cookie, err := r.Cookie(ssoCookie)
+	if err != nil {
+		return req, false
+	}
+	value, sig, ok := strings.Cut(cookie.Value, ".")
+	if !ok || !verify(s.authSecret, ssoCookie+":"+value, sig) {
+		return req, false
+	}
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || json.Unmarshal(data, &req) != nil || req.State == "" {
+		return oidc.AuthRequest{}, false
+	}
+	return req, true
+}
+
+func ssoRedirectError(w http.ResponseWriter, r *http.Request, reason string) {
+	http.Redirect(w, r, "/?sso_error="+url.QueryEscape(reason), http.StatusFound)
+}
+
+// ssoUser returns the account of the provider's user, creating it on the
+// first login, and brings its roles in line with the user's groups.
+func (s *Server) ssoUser(claims oidc.Claims) (store.User, error) {
+	issuer := s.oidc.Config().Issuer
+	u, err := s.store.GetUserByIdentity(issuer, claims.Subject)
+	if errors.Is(err, sql.ErrNoRows) {
+		u, err = s.provisionSSOUser(issuer, claims)
+	}
+	if err != nil {
+		return store.User{}, err
+	}
+	if u.Role == "blocked" {
+		return store.User{}, ssoError(ssoErrBlocked)
+	}
+	return s.syncSSOGroups(u, claims.Groups)
+}
+
+// provisionSSOUser links the identity to the account with the same email
+// address or creates an account for it. An existing account is only taken
+// over when both the provider and LiteTask have verified the address, and
+// its password and sessions are replaced then.
+func (s *Server) provisionSSOUser(issuer string, claims oidc.Claims) (store.User, error) {
+	if claims.Email == "" {
+		return store.User{}, ssoError(ssoErrNoEmail)
+	}
+	u, err := s.store.GetUserByEmail(claims.Email)
+	existing := err == nil
+	switch {
+	case existing:
+		// Whoever registered an address they do not own must not keep a
+		// way into the account once the owner signs in with it.
+		if !claims.EmailVerified || !u.EmailVerified {
+			return store.User{}, ssoError(ssoErrEmailTaken)
+		}
+	case errors.Is(err, sql.ErrNoRows):
+		if u, err = s.createSSOUser(claims); err != nil {
+			return store.User{}, err
+		}
+		log.Printf("sso: created user %s", u.Email)
+	default:
+		return store.User{}, err
+	}
+	if err := s.store.LinkIdentity(u.ID, issuer, claims.Subject); err != nil {
+		if errors.Is(err, store.ErrIdentityLinked) {
+			return store.User{}, ssoError(ssoErrEmailTaken)
+		}
+		return store.User{}, err
+	}
+	if existing {
+		password, err := randomPassword()
+		if err != nil {
+			return store.User{}, err
+		}
+		// Setting the password also ends every session of the account.
+		if u, err = s.store.UpdateUserPassword(u.ID, password); err != nil {
+			return store.User{}, err
+		}
+		log.Printf("sso: linked user %s", u.Email)
+	}
+	return u, nil
+}
+
+// createSSOUser creates the account of a new user with a random password;
+// they sign in through the provider. The provider's username is kept when
+// it is valid and free.
+func (s *Server) createSSOUser(claims oidc.Claims) (store.User, error) {
+	password, err := randomPassword()
+	if err != nil {
+		return store.User{}, err
+	}
+	username := strings.ToLower(claims.PreferredUsername)
+	if username == claims.Email {
+		username = ""
+	}
+	u, err := s.store.CreateUser(claims.Email, username, password, "user", claims.GivenName, claims.FamilyName)
+	if err != nil && username != "" && strings.Contains(err.Error(), "username") {
+		u, err = s.store.CreateUser(claims.Email, "", password, "user", claims.GivenName, claims.FamilyName)
+	}
+	return u, err
+}
+
+// randomPassword returns a password nobody knows, for accounts that sign in
+// through the provider.
+func randomPassword() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// syncSSOGroups gives the user the role and project memberships their
+// groups map to. Roles are left alone without admin groups, and projects
+// that no group maps to are left alone too.
+func (s *Server) syncSSOGroups(u store.User, groups []string) (store.User, error) {
+	cfg := s.oidc.Config()
+	member := make(map[string]bool, len(groups))
+	for _, g := range groups {
+		member[g] = true
+	}
+
+	if len(cfg.AdminGroups) > 0 {
+		role := "user"
+		for _, g := range cfg.AdminGroups {
+			if member[g] {
+				role = "admin"
+			}
+		}
+		if role != u.Role {
+			updated, err := s.store.UpdateUserRole(u.ID, role)
+			switch {
+			case errors.Is(err, store.ErrLastAdmin):
+				log.Printf("sso: %s is the last admin and stays one", u.Email)
+			case err != nil:
+				return store.User{}, err
+			default:
+				u = updated
+			}
+		}
+	}
+
+	if len(cfg.ProjectGroups) == 0 {
+		return u, nil
+	}
+	want := make(map[int64]string)
+	mapped := make(map[int64]bool)
+	for g, grants := range cfg.ProjectGroups {
+		for _, grant := range grants {
+			mapped[grant.ProjectID] = true
+			if !member[g] {
+				continue
+			}
+			// The highest role of the user's groups wins.
+			if current, ok := want[grant.ProjectID]; !ok || !store.ProjectRoleAllows(current, grant.Role) {
+				want[grant.ProjectID] = grant.Role
+			}
+		}
+	}
+	have, err := s.store.GetUserProjectRoles(u.ID)
+	if err != nil {
+		return store.User{}, err
+	}
+	for projectID := range mapped {
+		role, ok := want[projectID]
+		switch {
+		case ok && have[projectID] != role:
+			if _, err := s.store.SetProjectMember(projectID, u.ID, role); err != nil {
+				log.Printf("sso: add %s to project %d: %v", u.Email, projectID, err)
+			}
+		case !ok && have[projectID] != "":
+			if err := s.store.RemoveProjectMember(projectID, u.ID); err != nil {
+				log.Printf("sso: remove %s from project %d: %v", u.Email, projectID, err)
+			}
+		}
+	}
+	return u, nil
+}
@@ -0,0 +1,120 @@
+package httpapi
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// handleTelegramLink issues a one-time code for linking a Telegram account:
+// the user sends "/link <code>" to the bot.
+func (s *Server) handleTelegramLink(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	auth := getAuth(r)
+	if auth.token != nil {
+		http.Error(w, "tokens cannot link Telegram", http.StatusForbidden)
+		return
+	}
+	code, expiresAt, err := s.store.CreateTelegramLinkCode(auth.user.ID)
+	if err != nil {
+		http.Error(w, "failed to create link code", http.StatusInternalServerError)
+		return
+	}
+	writeJSONStatus(w, http.StatusCreated, struct {
+		Code      string    `json:"code"`
+		Command   string    `json:"command"`
+		ExpiresAt time.Time `json:"expiresAt"`
+	}{Code: code, Command: "/link " + code, ExpiresAt: expiresAt})
+}
+
+// handleTelegram unlinks the caller's Telegram account with DELETE.
+func (s *Server) handleTelegram(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	auth := getAuth(r)
+	if auth.token != nil {
+		http.Error(w, "tokens cannot link Telegram", http.StatusForbidden)
+		return
+	}
+	if err := s.store.UnlinkTelegram(auth.user.ID); err != nil {
+		http.Error(w, "failed to unlink Telegram", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleTelegramChats lists the chat-to-project bindings of the bot.
+func (s *Server) handleTelegramChats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	chats, err := s.store.ListTelegramChats()
+	if err != nil {
+		http.Error(w, "failed to load chats", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, chats)
+}
+
+// handleTelegramChatActions binds a chat to a project with PUT and removes
+// the binding with DELETE. Chat ids of groups are negative.
+func (s *Server) handleTelegramChatActions(w http.ResponseWriter, r *http.Request) {
+	trimmed := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/telegram/chats/"), "/")
+	if trimmed == "" || strings.Contains(trimmed, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	chatID, err := strconv.ParseInt(trimmed, 10, 64)
+	if err != nil || chatID == 0 {
+		http.Error(w, "invalid chat id", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		var payload struct {
+			ProjectID int64  `json:"projectId"`
+			Title     string `json:"title"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		if payload.ProjectID == 0 {
+			http.Error(w, "projectId is required", http.StatusBadRequest)
+			return
+		}
+		chat, err := s.store.BindTelegramChat(chatID, payload.ProjectID, payload.Title)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "project not found", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, "failed to bind chat", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, chat)
+	case http.MethodDelete:
+		if err := s.store.UnbindTelegramChat(chatID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, "chat not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "failed to unbind chat", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
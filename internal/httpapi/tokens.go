@@ -0,0 +1,129 @@
+package httpapi
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"litetask/internal/store"
+)
+
+const maxTokenNameLength = 100
+
+// createdToken is the response to token creation, the only time the plain
+// token is shown.
+type createdToken struct {
+	store.APIToken
+	Token string `json:"token"`
+}
+
+func (s *Server) handleTokens(w http.ResponseWriter, r *http.Request) {
+	auth := getAuth(r)
+	if auth.token != nil {
+		http.Error(w, "tokens cannot manage tokens", http.StatusForbidden)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		tokens, err := s.store.ListAPITokens(auth.user.ID)
+		if err != nil {
+			http.Error(w, "failed to load tokens", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, tokens)
+	case http.MethodPost:
+		s.createAPIToken(w, r)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleTokenActions(w http.ResponseWriter, r *http.Request) {
+	auth := getAuth(r)
+	if auth.token != nil {
+		http.Error(w, "tokens cannot manage tokens", http.StatusForbidden)
+		return
+	}
+	idStr := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/profile/tokens/"), "/")
+	if idStr == "" {
+		http.NotFound(w, r)
+		return
+	}
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		http.Error(w, "invalid token id", http.StatusBadRequest)
+		return
+	}
+	if r.Method != http.MethodDelete {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := s.store.DeleteAPIToken(auth.user.ID, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "token not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "failed to revoke token", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) createAPIToken(w http.ResponseWriter, r *http.Request) {
+	auth := getAuth(r)
+	var payload struct {
+		Name      string `json:"name"`
+		ReadOnly  bool   `json:"readOnly"`
+		ExpiresAt string `json:"expiresAt"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	payload.Name = strings.TrimSpace(payload.Name)
+	if payload.Name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+	if len(payload.Name) > maxTokenNameLength {
+		http.Error(w, "name is too long", http.StatusBadRequest)
+		return
+	}
+	var expiresAt *time.Time
+	if val := strings.TrimSpace(payload.ExpiresAt); val != "" {
+		parsed, err := parseExpiry(val)
+		if err != nil {
+			http.Error(w, "invalid expiresAt, expected RFC 3339 time or YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		if !parsed.After(time.Now()) {
+			http.Error(w, "expiresAt must be in the future", http.StatusBadRequest)
+			return
+		}
+		expiresAt = &parsed
+	}
+
+	tok, plain, err := s.store.CreateAPIToken(auth.user.ID, payload.Name, payload.ReadOnly, expiresAt)
+	if err != nil {
+		http.Error(w, "failed to create token", http.StatusInternalServerError)
+		return
+	}
+	writeJSONStatus(w, http.StatusCreated, createdToken{APIToken: tok, Token: plain})
+}
+
+// parseExpiry accepts an RFC 3339 timestamp or a date; a date keeps the token
+// valid through the end of that day (UTC).
+func parseExpiry(val string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, val); err == nil {
+		return t.UTC(), nil
+	}
+	d, err := store.ParseDate(val)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return d.AddDate(0, 0, 1), nil
+}
@@ -0,0 +1,372 @@
+package httpapi
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"litetask/internal/store"
+	"litetask/internal/totp"
+)
+
+const totpIssuer = "LiteTask"
+
+// Steps of a login that needs a second factor: the user enters a code, or
+// an admin who is required to use two-factor authentication sets it up
+// first.
+const (
+	twoFactorVerify = "verify"
+	twoFactorEnroll = "enroll"
+)
+
+type twoFactorChallenge struct {
+	TwoFactor string `json:"twoFactor"`
+	Challenge string `json:"challenge"`
+}
+
+type twoFactorSetup struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+func newTwoFactorSetup(u store.User, secret string) twoFactorSetup {
+	account := u.Email
+	if u.Username != "" {
+		account = u.Username
+	}
+	return twoFactorSetup{Secret: secret, URI: totp.URI(secret, totpIssuer, account)}
+}
+
+// twoFactorRequired reports whether an admin must use two-factor
+// authentication.
+func (s *Server) twoFactorRequired(u store.User) (bool, error) {
+	if u.Role != "admin" {
+		return false, nil
+	}
+	return s.store.RequireAdminTwoFactor()
+}
+
+// loginChallenge opens the second login step for u, or returns nil when the
+// password is enough.
+func (s *Server) loginChallenge(u store.User) (*twoFactorChallenge, error) {
+	step := twoFactorVerify
+	if !u.TwoFactorEnabled {
+		required, err := s.twoFactorRequired(u)
+		if err != nil || !required {
+			return nil, err
+		}
+		step = twoFactorEnroll
+	}
+	token, err := s.store.CreateLoginChallenge(u.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &twoFactorChallenge{TwoFactor: step, Challenge: token}, nil
+}
+
+// challengeUser counts an attempt at a login challenge and returns its user.
+// On error the response is already written.
+func (s *Server) challengeUser(w http.ResponseWriter, token string) (store.User, bool) {
+	userID, err := s.store.UseLoginChallenge(strings.TrimSpace(token))
+	if errors.Is(err, store.ErrInvalidToken) {
+		http.Error(w, "login expired, sign in again", http.StatusUnauthorized)
+		return store.User{}, false
+	}
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return store.User{}, false
+	}
+	u, err := s.store.GetUserByID(userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "login expired, sign in again", http.StatusUnauthorized)
+		return store.User{}, false
+	}
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return store.User{}, false
+	}
+	if u.Role == "blocked" {
+		http.Error(w, "account blocked", http.StatusForbidden)
+		return store.User{}, false
+	}
+	return u, true
+}
+
+// handleLoginEnroll gives an admin who must use two-factor authentication
+// a secret to set up during login.
+func (s *Server) handleLoginEnroll(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Challenge string `json:"challenge"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	u, ok := s.challengeUser(w, payload.Challenge)
+	if !ok {
+		return
+	}
+	secret, err := s.store.BeginTwoFactor(u.ID)
+	if errors.Is(err, store.ErrTwoFactorEnabled) {
+		http.Error(w, "two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, newTwoFactorSetup(u, secret))
+}
+
+// handleLoginTwoFactor finishes a login with a TOTP or recovery code and
+// only then issues the auth cookie. For an admin enrolling during login the
+// code confirms the new secret, and the recovery codes come back with the
+// user.
+func (s *Server) handleLoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Challenge string `json:"challenge"`
+		Code      string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	u, ok := s.challengeUser(w, payload.Challenge)
+	if !ok {
+		return
+	}
+	var recoveryCodes []string
+	var err error
+	if u.TwoFactorEnabled {
+		err = s.store.VerifyTwoFactor(u.ID, payload.Code)
+	} else {
+		recoveryCodes, err = s.store.EnableTwoFactor(u.ID, payload.Code)
+	}
+	switch {
+	case errors.Is(err, store.ErrInvalidCode):
+		http.Error(w, "invalid code", http.StatusUnauthorized)
+		return
+	case errors.Is(err, store.ErrTwoFactorNotEnabled):
+		http.Error(w, "two-factor authentication is not set up", http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	if err := s.store.CloseLoginChallenge(strings.TrimSpace(payload.Challenge)); err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	if err := s.startSession(w, r, u.ID); err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	u.TwoFactorEnabled = true
+	writeJSON(w, struct {
+		currentUser
+		RecoveryCodes []string `json:"recoveryCodes,omitempty"`
+	}{currentUser: newCurrentUser(u), RecoveryCodes: recoveryCodes})
+}
+
+// handleTwoFactor manages the caller's two-factor authentication: GET shows
+// its state, POST starts an enrollment with a new secret, PUT confirms it
+// with a code and returns the recovery codes, and DELETE turns it off, which
+// also takes a current code.
+func (s *Server) handleTwoFactor(w http.ResponseWriter, r *http.Request) {
+	auth := getAuth(r)
+	if auth.token != nil {
+		http.Error(w, "tokens cannot manage two-factor authentication", http.StatusForbidden)
+		return
+	}
+	u := auth.user
+	switch r.Method {
+	case http.MethodGet:
+		tf, err := s.store.GetTwoFactor(u.ID)
+		if err != nil {
+			http.Error(w, "failed to load two-factor authentication", http.StatusInternalServerError)
+			return
+		}
+		required, err := s.twoFactorRequired(u)
+		if err != nil {
+			http.Error(w, "failed to load two-factor authentication", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, struct {
+			store.TwoFactor
+			Required bool `json:"required"`
+		}{TwoFactor: tf, Required: required})
+	case http.MethodPost:
+		secret, err := s.store.BeginTwoFactor(u.ID)
+		if errors.Is(err, store.ErrTwoFactorEnabled) {
+			http.Error(w, "two-factor authentication is already enabled", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, "failed to start enrollment", http.StatusInternalServerError)
+			return
+		}
+		writeJSONStatus(w, http.StatusCreated, newTwoFactorSetup(u, secret))
+	case http.MethodPut:
+		code, ok := decodeCode(w, r)
+		if !ok {
+			return
+		}
+		codes, err := s.store.EnableTwoFactor(u.ID, code)
+		switch {
+		case errors.Is(err, store.ErrInvalidCode):
+			http.Error(w, "invalid code", http.StatusBadRequest)
+			return
+		case errors.Is(err, store.ErrTwoFactorNotEnabled):
+			http.Error(w, "start the enrollment with POST first", http.StatusConflict)
+			return
+		case errors.Is(err, store.ErrTwoFactorEnabled):
+			http.Error(w, "two-factor authentication is already enabled", http.StatusConflict)
+			return
+		case err != nil:
+			http.Error(w, "failed to enable two-factor authentication", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, struct {
+			RecoveryCodes []string `json:"recoveryCodes"`
+		}{RecoveryCodes: codes})
+	case http.MethodDelete:
+		required, err := s.twoFactorRequired(u)
+		if err != nil {
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+		if required {
+			http.Error(w, "two-factor authentication is required for admins", http.StatusForbidden)
+			return
+		}
+		code, ok := decodeCode(w, r)
+		if !ok || !s.checkTwoFactorCode(w, u.ID, code) {
+			return
+		}
+		if err := s.store.DisableTwoFactor(u.ID); err != nil {
+			http.Error(w, "failed to disable two-factor authentication", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleRecoveryCodes replaces the caller's recovery codes with POST, given a
+// current code.
+func (s *Server) handleRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	auth := getAuth(r)
+	if auth.token != nil {
+		http.Error(w, "tokens cannot manage two-factor authentication", http.StatusForbidden)
+		return
+	}
+	code, ok := decodeCode(w, r)
+	if !ok || !s.checkTwoFactorCode(w, auth.user.ID, code) {
+		return
+	}
+	codes, err := s.store.RegenerateRecoveryCodes(auth.user.ID)
+	if err != nil {
+		http.Error(w, "failed to create recovery codes", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, struct {
+		RecoveryCodes []string `json:"recoveryCodes"`
+	}{RecoveryCodes: codes})
+}
+
+func decodeCode(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var payload struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return "", false
+	}
+	if strings.TrimSpace(payload.Code) == "" {
+		http.Error(w, "code required", http.StatusBadRequest)
+		return "", false
+	}
+	return payload.Code, true
+}
+
+func (s *Server) checkTwoFactorCode(w http.ResponseWriter, userID int64, code string) bool {
+	err := s.store.VerifyTwoFactor(userID, code)
+	switch {
+	case errors.Is(err, store.ErrInvalidCode):
+		http.Error(w, "invalid code", http.StatusBadRequest)
+		return false
+	case errors.Is(err, store.ErrTwoFactorNotEnabled):
+		http.Error(w, "two-factor authentication is not enabled", http.StatusConflict)
+		return false
+	case err != nil:
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// resetUserTwoFactor turns off a user's two-factor authentication for an
+// admin, as when the phone was lost. An admin who must use it sets it up
+// again at the next login.
+func (s *Server) resetUserTwoFactor(w http.ResponseWriter, r *http.Request, userID int64) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if _, err := s.store.GetUserByID(userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	if err := s.store.DisableTwoFactor(userID); err != nil {
+		http.Error(w, "failed to reset two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleSecurity reads and changes the server-wide security settings.
+// Requiring two-factor authentication for admins signs out the admins who
+// have not set it up and disables their API tokens, so the admin turning it
+// on must have it already.
+func (s *Server) handleSecurity(w http.ResponseWriter, r *http.Request) {
+	type settings struct {
+		RequireAdminTwoFactor bool `json:"requireAdminTwoFactor"`
+	}
+	switch r.Method {
+	case http.MethodGet:
+		required, err := s.store.RequireAdminTwoFactor()
+		if err != nil {
+			http.Error(w, "failed to load settings", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, settings{RequireAdminTwoFactor: required})
+	case http.MethodPut:
+		var payload settings
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		if payload.RequireAdminTwoFactor && !getAuth(r).user.TwoFactorEnabled {
+			http.Error(w, "enable two-factor authentication for your account first", http.StatusConflict)
+			return
+		}
+		if err := s.store.SetRequireAdminTwoFactor(payload.RequireAdminTwoFactor); err != nil {
+			http.Error(w, "failed to save settings", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, payload)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
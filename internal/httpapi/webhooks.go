@@ -0,0 +1,285 @@
+package httpapi
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"litetask/internal/store"
+	"litetask/internal/webhooks"
+)
+
+const (
+	defaultDeliveryLimit = 50
+	maxDeliveryLimit     = 200
+)
+
+// webhookWithSecret is returned when the secret is created or replaced, the
+// only times it is shown.
+type webhookWithSecret struct {
+	store.Webhook
+	Secret string `json:"secret"`
+}
+
+func (s *Server) handleWebhooks(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		projectID := int64(0)
+		if pid := r.URL.Query().Get("projectId"); pid != "" {
+			val, err := strconv.ParseInt(pid, 10, 64)
+			if err != nil {
+				http.Error(w, "invalid projectId", http.StatusBadRequest)
+				return
+			}
+			projectID = val
+		}
+		hooks, err := s.store.ListWebhooks(projectID)
+		if err != nil {
+			http.Error(w, "failed to load webhooks", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, hooks)
+	case http.MethodPost:
+		s.createWebhook(w, r)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleWebhookActions(w http.ResponseWriter, r *http.Request) {
+	trimmed := strings.TrimPrefix(r.URL.Path, "/api/webhooks/")
+	parts := strings.Split(strings.Trim(trimmed, "/"), "/")
+	if len(parts) < 1 || parts[0] == "" {
+		http.NotFound(w, r)
+		return
+	}
+	id, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		http.Error(w, "invalid webhook id", http.StatusBadRequest)
+		return
+	}
+
+	if len(parts) == 2 && parts[1] == "deliveries" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.listWebhookDeliveries(w, r, id)
+		return
+	}
+	if len(parts) != 1 {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		hook, err := s.store.GetWebhook(id)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "webhook not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "failed to load webhook", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, hook)
+	case http.MethodPatch:
+		s.updateWebhook(w, r, id)
+	case http.MethodDelete:
+		if err := s.store.DeleteWebhook(id); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, "webhook not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "failed to delete webhook", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) createWebhook(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		ProjectID int64    `json:"projectId"`
+		URL       string   `json:"url"`
+		Secret    string   `json:"secret"`
+		Events    []string `json:"events"`
+		Active    *bool    `json:"active"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if payload.ProjectID == 0 {
+		http.Error(w, "projectId is required", http.StatusBadRequest)
+		return
+	}
+	hookURL, err := validateWebhookURL(payload.URL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	eventTypes, err := normalizeWebhookEvents(payload.Events)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	secret := strings.TrimSpace(payload.Secret)
+	if secret == "" {
+		if secret, err = generateWebhookSecret(); err != nil {
+			http.Error(w, "failed to generate secret", http.StatusInternalServerError)
+			return
+		}
+	}
+	active := payload.Active == nil || *payload.Active
+
+	hook, err := s.store.CreateWebhook(payload.ProjectID, hookURL, secret, eventTypes, active)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "project not found", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to create webhook", http.StatusInternalServerError)
+		return
+	}
+	writeJSONStatus(w, http.StatusCreated, webhookWithSecret{Webhook: hook, Secret: hook.Secret})
+}
+
+func (s *Server) updateWebhook(w http.ResponseWriter, r *http.Request, id int64) {
+	var payload struct {
+		URL          *string  `json:"url"`
+		Secret       *string  `json:"secret"`
+		RotateSecret bool     `json:"rotateSecret"`
+		Events       []string `json:"events"`
+		Active       *bool    `json:"active"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if payload.URL != nil {
+		hookURL, err := validateWebhookURL(*payload.URL)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		payload.URL = &hookURL
+	}
+	var eventTypes []string
+	if payload.Events != nil {
+		normalized, err := normalizeWebhookEvents(payload.Events)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		eventTypes = normalized
+	}
+	if payload.Secret != nil {
+		trimmed := strings.TrimSpace(*payload.Secret)
+		if trimmed == "" {
+			http.Error(w, "secret cannot be empty", http.StatusBadRequest)
+			return
+		}
+		payload.Secret = &trimmed
+	} else if payload.RotateSecret {
+		secret, err := generateWebhookSecret()
+		if err != nil {
+			http.Error(w, "failed to generate secret", http.StatusInternalServerError)
+			return
+		}
+		payload.Secret = &secret
+	}
+
+	hook, err := s.store.UpdateWebhook(id, payload.URL, payload.Secret, eventTypes, payload.Active)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "webhook not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to update webhook", http.StatusInternalServerError)
+		return
+	}
+	if payload.Secret != nil {
+		writeJSON(w, webhookWithSecret{Webhook: hook, Secret: hook.Secret})
+		return
+	}
+	writeJSON(w, hook)
+}
+
+func (s *Server) listWebhookDeliveries(w http.ResponseWriter, r *http.Request, id int64) {
+	limit := defaultDeliveryLimit
+	if val := r.URL.Query().Get("limit"); val != "" {
+		n, err := strconv.Atoi(val)
+		if err != nil || n <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxDeliveryLimit)
+	}
+	if _, err := s.store.GetWebhook(id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "webhook not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "failed to load webhook", http.StatusInternalServerError)
+		return
+	}
+	deliveries, err := s.store.ListWebhookDeliveries(id, limit)
+	if err != nil {
+		http.Error(w, "failed to load deliveries", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, deliveries)
+}
+
+func validateWebhookURL(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", errors.New("url is required")
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", errors.New("url must be an absolute http(s) URL")
+	}
+	return raw, nil
+}
+
+// normalizeWebhookEvents validates the requested event types. An empty list
+// subscribes the webhook to every supported event.
+func normalizeWebhookEvents(list []string) ([]string, error) {
+	result := make([]string, 0, len(list))
+	seen := make(map[string]struct{}, len(list))
+	for _, e := range list {
+		e = strings.TrimSpace(strings.ToLower(e))
+		if e == "" {
+			continue
+		}
+		if !webhooks.Supported(e) {
+			return nil, fmt.Errorf("unsupported event %q, expected one of: %s", e, strings.Join(webhooks.EventTypes, ", "))
+		}
+		if _, ok := seen[e]; ok {
+			continue
+		}
+		seen[e] = struct{}{}
+		result = append(result, e)
+	}
+	return result, nil
+}
+
+func generateWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
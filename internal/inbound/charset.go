@@ -0,0 +1,75 @@
+package inbound
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// upperHalves holds the characters of bytes 0x80-0xFF of the single-byte
+// charsets Russian mail still arrives in besides UTF-8. Latin-1 maps them to
+// the same code points and needs no table.
+var upperHalves = map[string][]rune{
+	"windows-1251": []rune("ЂЃ‚ѓ„…†‡€‰Љ‹ЊЌЋЏђ‘’“”•–—\ufffd™љ›њќћџ\u00a0ЎўЈ¤Ґ¦§Ё©Є«¬\u00ad®Ї°" +
+		"±Ііґµ¶·ё№є»јЅѕїАБВГДЕЖЗИЙКЛМНОПРСТУФХЦЧШЩЪЫЬЭЮЯабвгдежзийклмнопрстуфхцчшщъыьэюя"),
+	"koi8-r": []rune("─│┌┐└┘├┤┬┴┼▀▄█▌▐░▒▓⌠■∙√≈≤≥\u00a0⌡°²·÷═║╒ё╓╔╕╖╗╘╙╚╛╜╝╞╟╠╡Ё╢╣╤╥╦╧╨" +
+		"╩╪╫╬©юабцдефгхийклмнопярстужвьызшэщчъЮАБЦДЕФГХИЙКЛМНОПЯРСТУЖВЬЫЗШЭЩЧЪ"),
+}
+
+var charsetAliases = map[string]string{
+	"cp1251":    "windows-1251",
+	"x-cp1251":  "windows-1251",
+	"koi8r":     "koi8-r",
+	"latin1":    "iso-8859-1",
+	"iso8859-1": "iso-8859-1",
+	"us-ascii":  "utf-8",
+	"ascii":     "utf-8",
+	"utf8":      "utf-8",
+}
+
+// decodeCharset converts text in charset to UTF-8. Invalid UTF-8 is
+// replaced rather than rejected, since a garbled character is better than a
+// lost email.
+func decodeCharset(charset string, data []byte) (string, error) {
+	charset = strings.ToLower(strings.TrimSpace(charset))
+	if alias, ok := charsetAliases[charset]; ok {
+		charset = alias
+	}
+	switch charset {
+	case "", "utf-8":
+		return strings.ToValidUTF8(string(data), string(utf8.RuneError)), nil
+	case "iso-8859-1":
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+		}
+		return string(runes), nil
+	}
+	table, ok := upperHalves[charset]
+	if !ok {
+		return "", fmt.Errorf("unsupported charset %q", charset)
+	}
+	var builder strings.Builder
+	for _, b := range data {
+		if b < 0x80 {
+			builder.WriteByte(b)
+		} else {
+			builder.WriteRune(table[b-0x80])
+		}
+	}
+	return builder.String(), nil
+}
+
+// charsetReader lets mime.WordDecoder read the same charsets.
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	data, err := io.ReadAll(input)
+	if err != nil {
+		return nil, err
+	}
+	text, err := decodeCharset(charset, data)
+	if err != nil {
+		return nil, err
+	}
+	return strings.NewReader(text), nil
+}
@@ -0,0 +1,263 @@
+package inbound
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"litetask/internal/events"
+	"litetask/internal/mailer"
+	"litetask/internal/store"
+)
+
+const (
+	// SecretHeader carries the shared secret on every delivery.
+	SecretHeader = "X-LiteTask-Inbound-Secret"
+	// maxMessageSize caps a raw message, attachments included.
+	maxMessageSize = 25 << 20
+	maxTitleLength = 200
+)
+
+var (
+	ErrNoMailbox     = errors.New("no mailbox for the recipient")
+	ErrUnknownSender = errors.New("sender is not a LiteTask user")
+	ErrForbidden     = errors.New("sender cannot write to the project")
+	ErrTaskNotFound  = errors.New("task not found")
+	ErrEmptyReply    = errors.New("reply has no text")
+)
+
+// Result tells what an inbound message turned into.
+type Result struct {
+	TaskID      int64 `json:"taskId,omitempty"`
+	CommentID   int64 `json:"commentId,omitempty"`
+	Attachments int   `json:"attachments"`
+	// Ignored is set for automatic mail, which is dropped.
+	Ignored bool `json:"ignored,omitempty"`
+	// Duplicate is set for a message received before; the IDs are those of
+	// what it created then.
+	Duplicate bool `json:"duplicate,omitempty"`
+}
+
+// Receiver turns email sent to the mailbox into tasks and comments; the API
+// server mounts it at /api/inbound/email. The sender must be a LiteTask user
+// who can write to the project, found by the From address, so the MTA in
+// front is expected to reject spoofed senders (SPF, DKIM).
+type Receiver struct {
+	store   *store.Store
+	bus     *events.Bus
+	mailbox *mailer.Mailbox
+	secret  string
+}
+
+func NewReceiver(st *store.Store, bus *events.Bus, mailbox *mailer.Mailbox, secret string) *Receiver {
+	return &Receiver{
+		// The history records that the changes came by email, like the
+		// events on the bus.
+		store:   st.WithSource(events.SourceEmail),
+		bus:     bus,
+		mailbox: mailbox,
+		secret:  secret,
+	}
+}
+
+// ServeHTTP accepts a raw RFC 5322 message as the request body. The envelope
+// recipient can be passed as ?recipient=, otherwise it is looked up in the
+// headers.
+func (rc *Receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get(SecretHeader)), []byte(rc.secret)) != 1 {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	res, err := rc.Receive(http.MaxBytesReader(w, r.Body, maxMessageSize), r.URL.Query().Get("recipient"))
+	var tooLarge *http.MaxBytesError
+	switch {
+	case err == nil:
+	case errors.As(err, &tooLarge):
+		http.Error(w, "message too large", http.StatusRequestEntityTooLarge)
+		return
+	case errors.Is(err, ErrInvalidMessage), errors.Is(err, ErrEmptyReply):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, ErrNoMailbox), errors.Is(err, ErrTaskNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, ErrUnknownSender), errors.Is(err, ErrForbidden):
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	default:
+		log.Printf("inbound: %v", err)
+		http.Error(w, "failed to process message", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !res.Ignored && !res.Duplicate {
+		w.WriteHeader(http.StatusCreated)
+	}
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		log.Printf("inbound: failed to write response: %v", err)
+	}
+}
+
+// Receive processes a raw message sent to recipient, or to the first address
+// of the mailbox among its headers when recipient is empty. A message with a
+// Message-ID that was received before is not stored again, so the MTA may
+// retry deliveries.
+func (rc *Receiver) Receive(raw io.Reader, recipient string) (Result, error) {
+	e, err := Parse(raw)
+	if err != nil {
+		return Result{}, err
+	}
+	if e.AutoSubmitted {
+		return Result{Ignored: true}, nil
+	}
+	projectID, taskID, ok := rc.route(e, recipient)
+	if !ok {
+		return Result{}, ErrNoMailbox
+	}
+	u, err := rc.store.GetUserByEmail(e.From)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && u.Role == "blocked") {
+		return Result{}, fmt.Errorf("%w: %s", ErrUnknownSender, e.From)
+	}
+	if err != nil {
+		return Result{}, err
+	}
+	var roles map[int64]string
+	if u.Role != "admin" {
+		if roles, err = rc.store.GetUserProjectRoles(u.ID); err != nil {
+			return Result{}, err
+		}
+	}
+	roleIn := func(pid int64) string {
+		if roles == nil {
+			return store.ProjectRoleMaintainer
+		}
+		return roles[pid]
+	}
+
+	if taskID != 0 {
+		return rc.comment(e, u, taskID, roleIn)
+	}
+	if ok, err := rc.store.ProjectExists(projectID); err != nil {
+		return Result{}, err
+	} else if !ok || roleIn(projectID) == "" {
+		return Result{}, ErrNoMailbox
+	}
+	if !store.ProjectRoleAllows(roleIn(projectID), store.ProjectRoleMember) {
+		return Result{}, ErrForbidden
+	}
+	return rc.createTask(e, u, projectID)
+}
+
+func (rc *Receiver) route(e Email, recipient string) (projectID, taskID int64, ok bool) {
+	if recipient != "" {
+		return rc.mailbox.Route(recipient)
+	}
+	for _, addr := range e.Recipients {
+		if projectID, taskID, ok = rc.mailbox.Route(addr); ok {
+			return projectID, taskID, true
+		}
+	}
+	return 0, 0, false
+}
+
+func (rc *Receiver) createTask(e Email, u store.User, projectID int64) (Result, error) {
+	title := e.Subject
+	if title == "" {
+		title = "Без темы"
+	}
+	if runes := []rune(title); len(runes) > maxTitleLength {
+		title = string(runes[:maxTitleLength-1]) + "…"
+	}
+	mailbox := rc.mailbox.ProjectAddress(projectID)
+	if res, ok, err := rc.received(e, mailbox); ok || err != nil {
+		return res, err
+	}
+	t, err := rc.store.InsertEmailTask(e.MessageID, mailbox, title, e.Text, projectID, u.ID, files(e))
+	if errors.Is(err, store.ErrEmailReceived) {
+		res, _, err := rc.received(e, mailbox)
+		return res, err
+	}
+	if err != nil {
+		return Result{}, err
+	}
+	rc.publish(u, events.Event{Type: events.TaskCreated, ProjectID: t.ProjectID, TaskID: t.ID, Data: t})
+	return Result{TaskID: t.ID, Attachments: len(e.Attachments)}, nil
+}
+
+func (rc *Receiver) comment(e Email, u store.User, taskID int64, roleIn func(int64) string) (Result, error) {
+	t, err := rc.store.GetTask(taskID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && roleIn(t.ProjectID) == "") {
+		return Result{}, ErrTaskNotFound
+	}
+	if err != nil {
+		return Result{}, err
+	}
+	if !store.ProjectRoleAllows(roleIn(t.ProjectID), store.ProjectRoleMember) {
+		return Result{}, ErrForbidden
+	}
+	mailbox := rc.mailbox.TaskAddress(t.ID)
+	if res, ok, err := rc.received(e, mailbox); ok || err != nil {
+		return res, err
+	}
+	body := StripQuoted(e.Text)
+	if body == "" {
+		if len(e.Attachments) == 0 {
+			return Result{}, ErrEmptyReply
+		}
+		names := make([]string, len(e.Attachments))
+		for i, a := range e.Attachments {
+			names[i] = a.FileName
+		}
+		body = "Вложения: " + strings.Join(names, ", ")
+	}
+	c, err := rc.store.AddEmailComment(e.MessageID, mailbox, t.ID, body, u.ID, files(e))
+	if errors.Is(err, store.ErrEmailReceived) {
+		res, _, err := rc.received(e, mailbox)
+		return res, err
+	}
+	if err != nil {
+		return Result{}, err
+	}
+	rc.publish(u, events.Event{Type: events.CommentCreated, ProjectID: t.ProjectID, TaskID: t.ID, Data: c})
+	return Result{TaskID: t.ID, CommentID: c.ID, Attachments: len(e.Attachments)}, nil
+}
+
+// received returns the result of an earlier delivery of e to mailbox; ok is
+// false for a new message.
+func (rc *Receiver) received(e Email, mailbox string) (res Result, ok bool, err error) {
+	if e.MessageID == "" {
+		return Result{}, false, nil
+	}
+	r, err := rc.store.GetReceivedEmail(e.MessageID, mailbox)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Result{}, false, nil
+	}
+	if err != nil {
+		return Result{}, false, err
+	}
+	return Result{TaskID: r.TaskID, CommentID: r.CommentID, Attachments: r.Attachments, Duplicate: true}, true, nil
+}
+
+func files(e Email) []store.NewAttachment {
+	files := make([]store.NewAttachment, len(e.Attachments))
+	for i, a := range e.Attachments {
+		files[i] = store.NewAttachment{FileName: a.FileName, ContentType: a.ContentType, Data: a.Data}
+	}
+	return files
+}
+
+func (rc *Receiver) publish(u store.User, e events.Event) {
+	e.Source = events.SourceEmail
+	e.ActorID = u.ID
+	rc.bus.Publish(e)
+}
@@ -0,0 +1,402 @@
+package inbound
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"litetask/internal/events"
+	"litetask/internal/mailer"
+	"litetask/internal/store"
+)
+
+// crlf turns the line ends of a raw message written in the test into the
+// CRLF of the wire format.
+func crlf(s string) string {
+	return strings.ReplaceAll(s, "\n", "\r\n")
+}
+
+// multipartMessage has a text body with an HTML twin and two attachments,
+// one of them named in RFC 2047 encoding.
+const multipartMessage = `From: "Ann Lee" <Ann@Example.com>
+To: tasks+1@example.com
+Cc: Bob <bob@example.com>
+Message-ID: <20261016.1@mail.example.com>
+Subject: =?utf-8?B?0J7RgtGH0ZHRgg==?= for Q3
+MIME-Version: 1.0
+Content-Type: multipart/mixed; boundary=outer
+
+--outer
+Content-Type: multipart/alternative; boundary=inner
+
+--inner
+Content-Type: text/plain; charset=utf-8
+Content-Transfer-Encoding: quoted-printable
+
+=D0=A1=D0=BC. =D0=B2=D0=BB=D0=BE=D0=B6=D0=B5=D0=BD=D0=B8=D1=8F.
+--inner
+Content-Type: text/html; charset=utf-8
+
+<p>See attachments</p>
+--inner--
+--outer
+Content-Type: text/plain; charset=utf-8
+Content-Disposition: attachment; filename="=?utf-8?B?0L7RgtGH0ZHRgi50eHQ=?="
+Content-Transfer-Encoding: base64
+
+0JjRgtC+0LPQvjogNDIK
+--outer
+Content-Type: application/octet-stream; name=data.bin
+Content-Transfer-Encoding: base64
+
+AAECAwQFBgcI
+CQoLDA0ODw==
+--outer--
+`
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want Email
+	}{
+		{
+			name: "multipart with attachments",
+			raw:  multipartMessage,
+			want: Email{
+				MessageID:  "<20261016.1@mail.example.com>",
+				From:       "ann@example.com",
+				Recipients: []string{"tasks+1@example.com", "bob@example.com"},
+				Subject:    "Отчёт for Q3",
+				Text:       "См. вложения.",
+				Attachments: []Attachment{
+					{FileName: "отчёт.txt", ContentType: "text/plain", Data: []byte("Итого: 42\n")},
+					{FileName: "data.bin", ContentType: "application/octet-stream", Data: []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}},
+				},
+			},
+		},
+		{
+			name: "windows-1251 body and koi8-r subject",
+			raw: `From: ivan@example.com
+To: tasks+2@example.com
+Subject: =?koi8-r?B?79Teo9Qg2sEgzsXExczA?=
+Content-Type: text/plain; charset=windows-1251
+Content-Transfer-Encoding: quoted-printable
+
+=CF=F0=E8=E2=E5=F2, =EA=EE=EB=EB=E5=E3=E8!
+=D6=E5=ED=E0: 100 =88
+`,
+			want: Email{
+				From:       "ivan@example.com",
+				Recipients: []string{"tasks+2@example.com"},
+				Subject:    "Отчёт за неделю",
+				Text:       "Привет, коллеги!\nЦена: 100 €",
+			},
+		},
+		{
+			name: "latin-1 body",
+			raw: `From: marie@example.com
+To: tasks+2@example.com
+Subject: Menu
+Content-Type: text/plain; charset=ISO-8859-1
+Content-Transfer-Encoding: quoted-printable
+
+Caf=E9 =E0 c=F4t=E9
+`,
+			want: Email{
+				From:       "marie@example.com",
+				Recipients: []string{"tasks+2@example.com"},
+				Subject:    "Menu",
+				Text:       "Café à côté",
+			},
+		},
+		{
+			name: "html only",
+			raw: `From: ann@example.com
+Delivered-To: tasks+task-5@example.com
+To: team@example.com
+Subject: Re: #5
+Content-Type: text/html; charset=utf-8
+
+<html><head><style>p{}</style></head><body><p>Done&nbsp;&amp; checked</p><div>Line&lt;2&gt;</div></body></html>
+`,
+			want: Email{
+				From:       "ann@example.com",
+				Recipients: []string{"tasks+task-5@example.com", "team@example.com"},
+				Subject:    "Re: #5",
+				Text:       "Done & checked\nLine<2>",
+			},
+		},
+		{
+			name: "auto-submitted",
+			raw: `From: ann@example.com
+To: tasks+1@example.com
+Subject: Out of office
+Auto-Submitted: auto-replied
+
+I am away.
+`,
+			want: Email{
+				From:          "ann@example.com",
+				Recipients:    []string{"tasks+1@example.com"},
+				Subject:       "Out of office",
+				Text:          "I am away.",
+				AutoSubmitted: true,
+			},
+		},
+		{
+			name: "bulk",
+			raw: `From: news@example.com
+To: tasks+1@example.com
+Precedence: bulk
+
+News
+`,
+			want: Email{
+				From:          "news@example.com",
+				Recipients:    []string{"tasks+1@example.com"},
+				Text:          "News",
+				AutoSubmitted: true,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(strings.NewReader(crlf(tt.raw)))
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got  %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRejectsInvalidMessages(t *testing.T) {
+	for name, raw := range map[string]string{
+		"no headers": "just text",
+		"no sender":  "To: tasks+1@example.com\n\nHello\n",
+		"bad sender": "From: nobody\nTo: tasks+1@example.com\n\nHello\n",
+	} {
+		if _, err := Parse(strings.NewReader(crlf(raw))); !errors.Is(err, ErrInvalidMessage) {
+			t.Errorf("%s: err = %v, want ErrInvalidMessage", name, err)
+		}
+	}
+}
+
+func TestStripQuoted(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"plain", "Готово", "Готово"},
+		{"quote", "Готово\n\n> Сделай, пожалуйста\n> до пятницы", "Готово"},
+		{"signature", "Готово\n-- \nИван\n+7 900 000-00-00", "Готово"},
+		{"gmail attribution", "Готово\n\nпн, 12 окт. 2026 г. в 10:00, Иван <ivan@example.com>:\n> Сделай", "Готово"},
+		{"russian attribution", "Сделал.\n\nИван Петров пишет:\n> Сделай", "Сделал."},
+		{"wrapped english attribution", "Done\n\nOn Mon, 12 Oct 2026 at 10:00, Ivan Petrov <ivan@example.com>\nwrote:\n> Do it", "Done"},
+		{"outlook", "Готово\n\n-----Original Message-----\nFrom: Иван\nСделай", "Готово"},
+		{"only a quote", "> Сделай", ""},
+	}
+	for _, tt := range tests {
+		if got := StripQuoted(tt.text); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeCharset(t *testing.T) {
+	tests := []struct {
+		charset string
+		data    []byte
+		want    string
+	}{
+		{"", []byte("plain"), "plain"},
+		{"UTF-8", []byte("ok \xff"), "ok �"},
+		{"us-ascii", []byte("ascii"), "ascii"},
+		{"cp1251", []byte{0xcf, 0xf0, 0xe8, 0xe2, 0xe5, 0xf2}, "Привет"},
+		{"KOI8-R", []byte{0xf0, 0xd2, 0xc9, 0xd7, 0xc5, 0xd4}, "Привет"},
+		{"latin1", []byte{0x43, 0x61, 0x66, 0xe9}, "Café"},
+	}
+	for _, tt := range tests {
+		got, err := decodeCharset(tt.charset, tt.data)
+		if err != nil || got != tt.want {
+			t.Errorf("%q: got %q (%v), want %q", tt.charset, got, err, tt.want)
+		}
+	}
+	if _, err := decodeCharset("gb2312", []byte("x")); err == nil {
+		t.Error("gb2312: want an error")
+	}
+}
+
+type receiveEnv struct {
+	receiver *Receiver
+	store    *store.Store
+	member   store.User
+}
+
+// newReceiveEnv opens a fresh database where member@example.com is a member
+// and viewer@example.com a viewer of the default project.
+func newReceiveEnv(t *testing.T) *receiveEnv {
+	t.Helper()
+	s, err := store.Open(filepath.Join(t.TempDir(), "litetask.db"))
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	mailbox, err := mailer.ParseMailbox("tasks@example.com")
+	if err != nil {
+		t.Fatalf("parse mailbox: %v", err)
+	}
+	e := &receiveEnv{receiver: NewReceiver(s, events.NewBus(), mailbox, "secret"), store: s}
+	for email, role := range map[string]string{"member@example.com": store.ProjectRoleMember, "viewer@example.com": store.ProjectRoleViewer} {
+		u, err := s.CreateUser(email, "", "password", "user", "", "")
+		if err != nil {
+			t.Fatalf("create user: %v", err)
+		}
+		if _, err := s.SetProjectMember(store.DefaultProjectID, u.ID, role); err != nil {
+			t.Fatalf("set project role: %v", err)
+		}
+		if role == store.ProjectRoleMember {
+			e.member = u
+		}
+	}
+	return e
+}
+
+func (e *receiveEnv) receive(t *testing.T, raw, recipient string) (Result, error) {
+	t.Helper()
+	return e.receiver.Receive(strings.NewReader(crlf(raw)), recipient)
+}
+
+func TestReceive(t *testing.T) {
+	e := newReceiveEnv(t)
+	task, err := e.store.InsertTask("Принтер", "", store.DefaultProjectID, e.member.ID, nil, nil)
+	if err != nil {
+		t.Fatalf("insert task: %v", err)
+	}
+	reply := "From: member@example.com\nTo: tasks+task-1@example.com\nMessage-ID: <reply-1@example.com>\nSubject: Re: Принтер\n\n" +
+		"Починил.\n\nOn Mon, 12 Oct 2026 Boss <boss@example.com> wrote:\n> Почини принтер\n"
+
+	tests := []struct {
+		name      string
+		raw       string
+		recipient string
+		want      Result
+		wantErr   error
+	}{
+		{
+			name: "new task",
+			raw:  strings.ReplaceAll(multipartMessage, "Ann@Example.com", "member@example.com"),
+			want: Result{TaskID: task.ID + 1, Attachments: 2},
+		},
+		{
+			name: "retried delivery",
+			raw:  strings.ReplaceAll(multipartMessage, "Ann@Example.com", "member@example.com"),
+			want: Result{TaskID: task.ID + 1, Attachments: 2, Duplicate: true},
+		},
+		{
+			name: "reply",
+			raw:  reply,
+			want: Result{TaskID: task.ID, CommentID: 1},
+		},
+		{
+			name: "retried reply",
+			raw:  reply,
+			want: Result{TaskID: task.ID, CommentID: 1, Duplicate: true},
+		},
+		{
+			name:      "same message to another task",
+			raw:       reply,
+			recipient: "tasks+task-2@example.com",
+			want:      Result{TaskID: task.ID + 1, CommentID: 2},
+		},
+		{
+			name: "auto reply",
+			raw:  "From: member@example.com\nTo: tasks+1@example.com\nAuto-Submitted: auto-replied\n\nAway\n",
+			want: Result{Ignored: true},
+		},
+		{
+			name:    "empty reply",
+			raw:     "From: member@example.com\nTo: tasks+task-1@example.com\n\n> quoted only\n",
+			wantErr: ErrEmptyReply,
+		},
+		{
+			name:    "unknown sender",
+			raw:     "From: stranger@example.com\nTo: tasks+1@example.com\n\nHi\n",
+			wantErr: ErrUnknownSender,
+		},
+		{
+			name:    "viewer",
+			raw:     "From: viewer@example.com\nTo: tasks+1@example.com\n\nHi\n",
+			wantErr: ErrForbidden,
+		},
+		{
+			name:    "project of others",
+			raw:     "From: member@example.com\nTo: tasks+9@example.com\n\nHi\n",
+			wantErr: ErrNoMailbox,
+		},
+		{
+			name:    "other address",
+			raw:     "From: member@example.com\nTo: team@example.com\n\nHi\n",
+			wantErr: ErrNoMailbox,
+		},
+		{
+			name:    "unknown task",
+			raw:     "From: member@example.com\nTo: tasks+task-99@example.com\n\nHi\n",
+			wantErr: ErrTaskNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := e.receive(t, tt.raw, tt.recipient)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("receive: %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("result = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	created, err := e.store.GetTask(task.ID + 1)
+	if err != nil {
+		t.Fatalf("get task: %v", err)
+	}
+	if created.Title != "Отчёт for Q3" || created.Description != "См. вложения." {
+		t.Errorf("task = %q / %q", created.Title, created.Description)
+	}
+	attachments, err := e.store.ListTaskAttachments(created.ID)
+	if err != nil {
+		t.Fatalf("list attachments: %v", err)
+	}
+	if len(attachments) != 2 || attachments[0].FileName != "отчёт.txt" || attachments[1].Size != 16 {
+		t.Errorf("attachments = %+v", attachments)
+	}
+	comments, err := e.store.ListTaskComments(task.ID)
+	if err != nil {
+		t.Fatalf("list comments: %v", err)
+	}
+	if len(comments) != 1 || comments[0].Body != "Починил." {
+		t.Errorf("comments = %+v, want the reply without the quote once", comments)
+	}
+	history, err := e.store.ListTaskEvents(created.ID)
+	if err != nil {
+		t.Fatalf("list task events: %v", err)
+	}
+	for _, te := range history {
+		if te.Source != events.SourceEmail {
+			t.Errorf("%s event: source = %q, want %q", te.Type, te.Source, events.SourceEmail)
+		}
+	}
+}
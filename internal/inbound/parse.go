@@ -0,0 +1,252 @@
+package inbound
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"regexp"
+	"strings"
+)
+
+// maxDepth bounds the nesting of multipart bodies.
+const maxDepth = 10
+
+var ErrInvalidMessage = errors.New("invalid email message")
+
+// Email is the part of an inbound message LiteTask uses.
+type Email struct {
+	// MessageID is the Message-ID header, which tells retried deliveries
+	// apart from new mail. It may be empty.
+	MessageID string
+	From      string
+	// Recipients holds the addresses the message was sent to: To, Cc and
+	// the headers MTAs add for the envelope recipient.
+	Recipients []string
+	Subject    string
+	// Text is the plain text body, converted from HTML when the message has
+	// no plain text part.
+	Text        string
+	Attachments []Attachment
+	// AutoSubmitted is set for vacation replies, bounces and other mail no
+	// person wrote.
+	AutoSubmitted bool
+}
+
+type Attachment struct {
+	FileName    string
+	ContentType string
+	Data        []byte
+}
+
+var (
+	wordDecoder   = &mime.WordDecoder{CharsetReader: charsetReader}
+	addressParser = &mail.AddressParser{WordDecoder: wordDecoder}
+)
+
+// Parse reads a raw RFC 5322 message.
+func Parse(r io.Reader) (Email, error) {
+	msg, err := mail.ReadMessage(bufio.NewReader(r))
+	if err != nil {
+		return Email{}, fmt.Errorf("%w: %v", ErrInvalidMessage, err)
+	}
+	var e Email
+	from, err := addressParser.ParseList(msg.Header.Get("From"))
+	if err != nil || len(from) == 0 {
+		return Email{}, fmt.Errorf("%w: no sender", ErrInvalidMessage)
+	}
+	e.From = strings.ToLower(from[0].Address)
+	for _, key := range []string{"Delivered-To", "X-Original-To", "To", "Cc"} {
+		for _, val := range msg.Header[textproto.CanonicalMIMEHeaderKey(key)] {
+			list, err := addressParser.ParseList(val)
+			if err != nil {
+				continue
+			}
+			for _, addr := range list {
+				e.Recipients = append(e.Recipients, strings.ToLower(addr.Address))
+			}
+		}
+	}
+	e.MessageID = strings.TrimSpace(msg.Header.Get("Message-Id"))
+	e.Subject = decodeHeader(msg.Header.Get("Subject"))
+	auto := strings.ToLower(strings.TrimSpace(msg.Header.Get("Auto-Submitted")))
+	precedence := strings.ToLower(strings.TrimSpace(msg.Header.Get("Precedence")))
+	e.AutoSubmitted = (auto != "" && auto != "no") || precedence == "bulk" || precedence == "junk" || precedence == "auto_reply"
+
+	var htmlBody string
+	if err := e.walk(textproto.MIMEHeader(msg.Header), msg.Body, 0, &htmlBody); err != nil {
+		return Email{}, fmt.Errorf("%w: %v", ErrInvalidMessage, err)
+	}
+	if strings.TrimSpace(e.Text) == "" && htmlBody != "" {
+		e.Text = htmlToText(htmlBody)
+	}
+	e.Text = strings.TrimSpace(strings.ReplaceAll(e.Text, "\r\n", "\n"))
+	return e, nil
+}
+
+// walk collects the body and attachments of a part and its children. The
+// first plain text and HTML parts that are not attachments are the body.
+func (e *Email) walk(header textproto.MIMEHeader, body io.Reader, depth int, htmlBody *string) error {
+	if depth > maxDepth {
+		return errors.New("too deeply nested")
+	}
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := mr.NextRawPart()
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err := e.walk(part.Header, part, depth+1, htmlBody); err != nil {
+				return err
+			}
+		}
+	}
+
+	data, err := io.ReadAll(decodeTransfer(header.Get("Content-Transfer-Encoding"), body))
+	if err != nil {
+		return err
+	}
+	disposition, dispParams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+	name := decodeHeader(dispParams["filename"])
+	if name == "" {
+		name = decodeHeader(params["name"])
+	}
+	isAttachment := disposition == "attachment" || name != ""
+	switch {
+	case !isAttachment && mediaType == "text/plain" && e.Text == "":
+		e.Text, err = decodeCharset(params["charset"], data)
+		return err
+	case !isAttachment && mediaType == "text/html" && *htmlBody == "":
+		*htmlBody, err = decodeCharset(params["charset"], data)
+		return err
+	case !isAttachment && strings.HasPrefix(mediaType, "text/"):
+		// Further body parts, such as the HTML twin of the text.
+		return nil
+	}
+	if name == "" {
+		name = "attachment"
+		if mediaType == "message/rfc822" {
+			name = "message.eml"
+		}
+	}
+	e.Attachments = append(e.Attachments, Attachment{FileName: name, ContentType: mediaType, Data: data})
+	return nil
+}
+
+func decodeTransfer(encoding string, r io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, &newlineStripper{r: r})
+	case "quoted-printable":
+		return quotedprintable.NewReader(r)
+	}
+	return r
+}
+
+// newlineStripper drops the line breaks of base64 bodies, which the decoder
+// does not skip on its own when they are \r\n.
+type newlineStripper struct {
+	r io.Reader
+}
+
+func (n *newlineStripper) Read(p []byte) (int, error) {
+	for {
+		count, err := n.r.Read(p)
+		kept := 0
+		for _, b := range p[:count] {
+			if b != '\r' && b != '\n' && b != ' ' && b != '\t' {
+				p[kept] = b
+				kept++
+			}
+		}
+		if kept > 0 || err != nil {
+			return kept, err
+		}
+	}
+}
+
+func decodeHeader(val string) string {
+	decoded, err := wordDecoder.DecodeHeader(val)
+	if err != nil {
+		return strings.TrimSpace(val)
+	}
+	return strings.TrimSpace(decoded)
+}
+
+var (
+	htmlDropped   = regexp.MustCompile(`(?is)<(script|style|head)[^>]*>.*?</(script|style|head)>`)
+	htmlBreaks    = regexp.MustCompile(`(?i)<br\s*/?>|</(p|div|li|tr|h[1-6]|blockquote)>`)
+	htmlTags      = regexp.MustCompile(`(?s)<[^>]*>`)
+	spaceRuns     = regexp.MustCompile(`[ \t]+`)
+	blankLineRuns = regexp.MustCompile(`\n{3,}`)
+)
+
+// htmlToText keeps the text of an HTML body with its line breaks.
+func htmlToText(s string) string {
+	s = htmlDropped.ReplaceAllString(s, "")
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	s = strings.ReplaceAll(s, "\n", " ")
+	s = htmlBreaks.ReplaceAllString(s, "\n")
+	s = htmlTags.ReplaceAllString(s, "")
+	s = html.UnescapeString(s)
+	s = strings.ReplaceAll(s, " ", " ")
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(spaceRuns.ReplaceAllString(line, " "))
+	}
+	return strings.TrimSpace(blankLineRuns.ReplaceAllString(strings.Join(lines, "\n"), "\n\n"))
+}
+
+var (
+	// attribution matches the line mail clients put above a quote:
+	// "On Mon, 1 Jan 2026 Ivan <ivan@example.com> wrote:", the Russian
+	// "пишет:" / "написал(а):" and Gmail's "пн, 12 окт. 2026 г. в 10:00,
+	// Иван <ivan@example.com>:".
+	attribution = regexp.MustCompile(`(?i)((wrote|пишет|написал[аи]?|написал\(а\))|@[^\s>]+>?)\s*:$`)
+	// separators start a forwarded or quoted original in Outlook and others.
+	separators = []string{"-----Original Message-----", "-------- Исходное сообщение --------", "________________________________"}
+)
+
+// StripQuoted removes the quoted original and the signature from a reply.
+func StripQuoted(text string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		cut := strings.HasPrefix(trimmed, ">") || line == "-- " || line == "--"
+		for _, sep := range separators {
+			cut = cut || strings.HasPrefix(trimmed, sep)
+		}
+		if cut {
+			lines = lines[:i]
+			break
+		}
+	}
+	last := len(lines) - 1
+	for last >= 0 && strings.TrimSpace(lines[last]) == "" {
+		last--
+	}
+	if last >= 0 && attribution.MatchString(strings.TrimSpace(lines[last])) {
+		// Long attributions are wrapped: "On ... Ivan <ivan@example.com>" and
+		// "wrote:" on the next line.
+		if last > 0 && strings.HasPrefix(strings.TrimSpace(lines[last-1]), "On ") && !strings.HasPrefix(strings.TrimSpace(lines[last]), "On ") {
+			last--
+		}
+		lines = lines[:last]
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
@@ -0,0 +1,68 @@
+package mailer
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"strconv"
+	"strings"
+)
+
+// taskTag marks the address replies to a task's notifications go to.
+const taskTag = "task-"
+
+// Mailbox is the address inbound email is delivered to. Projects and tasks
+// get their own addresses by plus addressing: mail to tasks+3@example.com
+// creates a task in project 3, mail to tasks+task-42@example.com comments on
+// task 42.
+type Mailbox struct {
+	local  string
+	domain string
+}
+
+func ParseMailbox(address string) (*Mailbox, error) {
+	addr, err := mail.ParseAddress(strings.TrimSpace(address))
+	if err != nil {
+		return nil, err
+	}
+	at := strings.LastIndex(addr.Address, "@")
+	local := strings.ToLower(addr.Address[:at])
+	if strings.Contains(local, "+") {
+		return nil, errors.New("mailbox address must not contain +")
+	}
+	return &Mailbox{local: local, domain: strings.ToLower(addr.Address[at+1:])}, nil
+}
+
+func (m *Mailbox) ProjectAddress(projectID int64) string {
+	return fmt.Sprintf("%s+%d@%s", m.local, projectID, m.domain)
+}
+
+func (m *Mailbox) TaskAddress(taskID int64) string {
+	return fmt.Sprintf("%s+%s%d@%s", m.local, taskTag, taskID, m.domain)
+}
+
+// Route resolves an address of the mailbox to the project or the task it
+// belongs to; ok is false for any other address.
+func (m *Mailbox) Route(address string) (projectID, taskID int64, ok bool) {
+	address = strings.ToLower(strings.TrimSpace(address))
+	at := strings.LastIndex(address, "@")
+	if at < 0 || address[at+1:] != m.domain {
+		return 0, 0, false
+	}
+	tag, found := strings.CutPrefix(address[:at], m.local+"+")
+	if !found {
+		return 0, 0, false
+	}
+	if rest, isTask := strings.CutPrefix(tag, taskTag); isTask {
+		id, err := strconv.ParseInt(rest, 10, 64)
+		if err != nil || id <= 0 {
+			return 0, 0, false
+		}
+		return 0, id, true
+	}
+	id, err := strconv.ParseInt(tag, 10, 64)
+	if err != nil || id <= 0 {
+		return 0, 0, false
+	}
+	return id, 0, true
+}
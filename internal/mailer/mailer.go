@@ -0,0 +1,243 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TLS modes of the SMTP connection.
+const (
+	// TLSStartTLS upgrades a plain connection with STARTTLS and refuses
+	// servers that do not offer it.
+	TLSStartTLS = "starttls"
+	// TLSImplicit connects over TLS from the start, usually on port 465.
+	TLSImplicit = "tls"
+	// TLSNone never encrypts; meant for local relays and test sinks.
+	TLSNone = "none"
+)
+
+const sendTimeout = 30 * time.Second
+
+// Config is the SMTP server mail is sent through.
+type Config struct {
+	Host     string
+	Port     int
+	TLS      string
+	Username string
+	Password string
+	From     string
+}
+
+// ConfigFromEnv reads SMTP_HOST, SMTP_PORT (default 587, 465 with implicit
+// TLS), SMTP_TLS (starttls, tls or none; default starttls), SMTP_USERNAME,
+// SMTP_PASSWORD and SMTP_FROM. ok is false when SMTP_HOST is unset.
+func ConfigFromEnv() (cfg Config, ok bool, err error) {
+	cfg.Host = strings.TrimSpace(os.Getenv("SMTP_HOST"))
+	if cfg.Host == "" {
+		return Config{}, false, nil
+	}
+	cfg.TLS = strings.ToLower(strings.TrimSpace(os.Getenv("SMTP_TLS")))
+	switch cfg.TLS {
+	case "":
+		cfg.TLS = TLSStartTLS
+	case TLSStartTLS, TLSImplicit, TLSNone:
+	default:
+		return Config{}, false, errors.New("SMTP_TLS must be starttls, tls or none")
+	}
+	cfg.Port = 587
+	if cfg.TLS == TLSImplicit {
+		cfg.Port = 465
+	}
+	if val := strings.TrimSpace(os.Getenv("SMTP_PORT")); val != "" {
+		cfg.Port, err = strconv.Atoi(val)
+		if err != nil || cfg.Port < 1 || cfg.Port > 65535 {
+			return Config{}, false, errors.New("SMTP_PORT must be a port number")
+		}
+	}
+	cfg.Username = strings.TrimSpace(os.Getenv("SMTP_USERNAME"))
+	cfg.Password = os.Getenv("SMTP_PASSWORD")
+	if cfg.Username != "" && cfg.TLS == TLSNone {
+		return Config{}, false, errors.New("SMTP_USERNAME needs SMTP_TLS starttls or tls")
+	}
+	cfg.From = strings.TrimSpace(os.Getenv("SMTP_FROM"))
+	if _, err := mail.ParseAddress(cfg.From); err != nil {
+		return Config{}, false, errors.New("SMTP_FROM must be an email address")
+	}
+	return cfg, true, nil
+}
+
+// Message is an email with a plain text body and, optionally, an HTML
+// alternative. ReplyTo may be empty.
+type Message struct {
+	To      []string
+	Subject string
+	ReplyTo string
+	Text    string
+	HTML    string
+	// ExpiresAt, when set, marks a message carrying a secret such as a
+	// password reset link: the outbox gives it up after that time and does
+	// not keep its body once it is sent.
+	ExpiresAt time.Time
+}
+
+// Mailer sends email over SMTP.
+type Mailer struct {
+	cfg Config
+}
+
+func New(cfg Config) *Mailer {
+	if cfg.TLS == "" {
+		cfg.TLS = TLSStartTLS
+	}
+	return &Mailer{cfg: cfg}
+}
+
+func (m *Mailer) Send(msg Message) error {
+	if len(msg.To) == 0 {
+		return nil
+	}
+	from, err := mail.ParseAddress(m.cfg.From)
+	if err != nil {
+		return err
+	}
+	body, err := compose(from, msg)
+	if err != nil {
+		return err
+	}
+
+	addr := net.JoinHostPort(m.cfg.Host, strconv.Itoa(m.cfg.Port))
+	tlsConfig := &tls.Config{ServerName: m.cfg.Host}
+	dialer := &net.Dialer{Timeout: sendTimeout}
+	var conn net.Conn
+	if m.cfg.TLS == TLSImplicit {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	// The deadline bounds the whole conversation so a stuck server cannot
+	// hold up the outbox.
+	if err := conn.SetDeadline(time.Now().Add(sendTimeout)); err != nil {
+		conn.Close()
+		return err
+	}
+	c, err := smtp.NewClient(conn, m.cfg.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if m.cfg.TLS == TLSStartTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return errors.New("smtp: server does not support STARTTLS")
+		}
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if m.cfg.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", m.cfg.Username, m.cfg.Password, m.cfg.Host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(from.Address); err != nil {
+		return err
+	}
+	for _, to := range msg.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(body); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+func compose(from *mail.Address, msg Message) ([]byte, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	domain := from.Address[strings.LastIndex(from.Address, "@")+1:]
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from.String())
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(msg.To, ", "))
+	if msg.ReplyTo != "" {
+		fmt.Fprintf(&buf, "Reply-To: %s\r\n", msg.ReplyTo)
+	}
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Message-ID: <%s@%s>\r\n", hex.EncodeToString(id), domain)
+	// Everything LiteTask sends is automatic; this keeps mail servers from
+	// answering it with vacation replies.
+	buf.WriteString("Auto-Submitted: auto-generated\r\n")
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	if msg.HTML == "" {
+		buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+		buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+		if err := writeQuoted(&buf, msg.Text); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	mw := multipart.NewWriter(&buf)
+	fmt.Fprintf(&buf, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", mw.Boundary())
+	// Clients show the last part they understand, so HTML goes last.
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", msg.Text},
+		{"text/html; charset=utf-8", msg.HTML},
+	} {
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeQuoted(w, part.body); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeQuoted(w io.Writer, text string) error {
+	qp := quotedprintable.NewWriter(w)
+	text = strings.ReplaceAll(strings.ReplaceAll(text, "\r\n", "\n"), "\n", "\r\n")
+	if _, err := qp.Write([]byte(text)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
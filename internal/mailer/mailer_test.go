@@ -0,0 +1,113 @@
+package mailer
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSend(t *testing.T) {
+	stub := newSMTPStub(t)
+	m := New(stub.config())
+	err := m.Send(Message{
+		To:      []string{"ann@example.com", "bob@example.com"},
+		Subject: "Задача #3: Починить принтер",
+		ReplyTo: "tasks+task-3@example.com",
+		Text:    "Статус: готово\nСпасибо!",
+		HTML:    "<p>Статус: <b>готово</b></p>",
+	})
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	msgs := stub.messages()
+	if len(msgs) != 1 {
+		t.Fatalf("stub received %d messages, want 1", len(msgs))
+	}
+	if msgs[0].from != "tasks@example.com" {
+		t.Errorf("envelope from = %q", msgs[0].from)
+	}
+	if want := []string{"ann@example.com", "bob@example.com"}; !reflect.DeepEqual(msgs[0].to, want) {
+		t.Errorf("envelope to = %v, want %v", msgs[0].to, want)
+	}
+
+	parsed, err := mail.ReadMessage(strings.NewReader(msgs[0].data))
+	if err != nil {
+		t.Fatalf("parse message: %v", err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	if err != nil || subject != "Задача #3: Починить принтер" {
+		t.Errorf("subject = %q (%v)", subject, err)
+	}
+	for name, want := range map[string]string{
+		"Reply-To":       "tasks+task-3@example.com",
+		"Auto-Submitted": "auto-generated",
+		"To":             "ann@example.com, bob@example.com",
+	} {
+		if got := parsed.Header.Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	if !strings.HasSuffix(parsed.Header.Get("Message-ID"), "@example.com>") {
+		t.Errorf("Message-ID = %q", parsed.Header.Get("Message-ID"))
+	}
+
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("content type = %q (%v)", mediaType, err)
+	}
+	parts := multipart.NewReader(parsed.Body, params["boundary"])
+	want := []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", "Статус: готово\nСпасибо!"},
+		{"text/html; charset=utf-8", "<p>Статус: <b>готово</b></p>"},
+	}
+	for i, w := range want {
+		// NextRawPart leaves the quoted-printable encoding to the test.
+		part, err := parts.NextRawPart()
+		if err != nil {
+			t.Fatalf("part %d: %v", i, err)
+		}
+		if got := part.Header.Get("Content-Type"); got != w.contentType {
+			t.Errorf("part %d: content type = %q, want %q", i, got, w.contentType)
+		}
+		body, err := io.ReadAll(quotedprintable.NewReader(part))
+		if err != nil {
+			t.Fatalf("part %d: %v", i, err)
+		}
+		if string(body) != w.body {
+			t.Errorf("part %d: body = %q, want %q", i, body, w.body)
+		}
+	}
+}
+
+func TestSendRejectedRecipient(t *testing.T) {
+	stub := newSMTPStub(t)
+	stub.reject["gone@example.com"] = 550
+	err := New(stub.config()).Send(Message{To: []string{"gone@example.com"}, Subject: "x", Text: "x"})
+	var smtpErr *textproto.Error
+	if !errors.As(err, &smtpErr) || smtpErr.Code != 550 {
+		t.Fatalf("err = %v, want the 550 reply", err)
+	}
+	if n := len(stub.messages()); n != 0 {
+		t.Errorf("stub received %d messages, want none", n)
+	}
+}
+
+func TestSendRequiresStartTLS(t *testing.T) {
+	stub := newSMTPStub(t)
+	cfg := stub.config()
+	cfg.TLS = TLSStartTLS
+	err := New(cfg).Send(Message{To: []string{"ann@example.com"}, Subject: "x", Text: "x"})
+	if err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Fatalf("err = %v, want a STARTTLS error", err)
+	}
+	if n := len(stub.messages()); n != 0 {
+		t.Errorf("stub received %d messages in the clear", n)
+	}
+}
@@ -0,0 +1,272 @@
+package mailer
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"litetask/internal/events"
+	"litetask/internal/store"
+)
+
+const notifyBatch = 100
+
+// mentionPattern finds @username mentions. The character before the @ must
+// not belong to a word, so email addresses in comments are not mentions.
+var mentionPattern = regexp.MustCompile(`(?:^|[^\w@.])@([a-zA-Z0-9_.-]{3,32})`)
+
+// TaskNotice is the data of the task notification templates.
+type TaskNotice struct {
+	Actor   string
+	TaskID  int64
+	Title   string
+	Project string
+	Status  string
+	Due     string
+	Comment string
+	// TaskURL links to the task and URL to the web app; both are empty
+	// without PUBLIC_URL.
+	TaskURL string
+	URL     string
+	// Reply tells that replying to the email comments on the task.
+	Reply bool
+}
+
+// Notifier follows the task history and emails users about assignments,
+// comments and mentions they opted in to. Like the Telegram bot, it reads
+// task_events after a cursor and uses bus events only to wake up.
+//
+// With an inbound mailbox the emails are sent with the task's address as
+// Reply-To, so that replies become comments.
+type Notifier struct {
+	store     *store.Store
+	bus       *events.Bus
+	outbox    *Outbox
+	publicURL string
+	mailbox   *Mailbox
+}
+
+// NewNotifier creates a Notifier; mailbox may be nil.
+func NewNotifier(st *store.Store, bus *events.Bus, outbox *Outbox, publicURL string, mailbox *Mailbox) *Notifier {
+	return &Notifier{store: st, bus: bus, outbox: outbox, publicURL: strings.TrimRight(publicURL, "/"), mailbox: mailbox}
+}
+
+// Run blocks until ctx is cancelled.
+func (n *Notifier) Run(ctx context.Context) {
+	ch, unsubscribe := n.bus.Subscribe()
+	defer unsubscribe()
+
+	cursor, err := n.store.LatestTaskEventID()
+	if err != nil {
+		log.Printf("mailer: notifications disabled: %v", err)
+		return
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e, ok := <-ch:
+			if !ok {
+				return
+			}
+			if e.TaskID == 0 {
+				continue
+			}
+			cursor = n.deliverEvents(cursor)
+		}
+	}
+}
+
+func (n *Notifier) deliverEvents(cursor int64) int64 {
+	for {
+		batch, err := n.store.ListTaskEventsAfter(cursor, notifyBatch)
+		if err != nil {
+			log.Printf("mailer: failed to load task events: %v", err)
+			return cursor
+		}
+		for _, te := range batch {
+			n.deliverEvent(te)
+			cursor = te.ID
+		}
+		if len(batch) < notifyBatch {
+			return cursor
+		}
+	}
+}
+
+func (n *Notifier) deliverEvent(te store.TaskEvent) {
+	if te.Type != store.EventAssigned && te.Type != store.EventCommentAdded {
+		return
+	}
+	var data struct {
+		UserID    int64 `json:"userId"`
+		CommentID int64 `json:"commentId"`
+	}
+	if err := json.Unmarshal(te.Data, &data); err != nil {
+		log.Printf("mailer: invalid data in task event %d: %v", te.ID, err)
+		return
+	}
+	t, err := n.store.GetTask(te.TaskID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return
+	}
+	if err != nil {
+		log.Printf("mailer: failed to load task %d: %v", te.TaskID, err)
+		return
+	}
+	notice := n.notice(t, te)
+
+	switch te.Type {
+	case store.EventAssigned:
+		n.notifyUsers(t, te.ActorID, []int64{data.UserID}, func(p store.EmailPrefs) bool { return p.Assigned },
+			"assigned", fmt.Sprintf("#%d %s: ты исполнитель", t.ID, t.Title), notice)
+	case store.EventCommentAdded:
+		c, err := n.store.GetTaskComment(data.CommentID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return
+		}
+		if err != nil {
+			log.Printf("mailer: failed to load comment %d: %v", data.CommentID, err)
+			return
+		}
+		notice.Comment = c.Body
+		// A mentioned watcher gets the mention rather than both emails.
+		notified := n.notifyUsers(t, te.ActorID, n.mentioned(c.Body), func(p store.EmailPrefs) bool { return p.Mentions },
+			"mention", fmt.Sprintf("#%d %s: тебя упомянули", t.ID, t.Title), notice)
+		watchers := make([]int64, 0)
+		for _, id := range taskWatchers(t) {
+			if !notified[id] {
+				watchers = append(watchers, id)
+			}
+		}
+		n.notifyUsers(t, te.ActorID, watchers, func(p store.EmailPrefs) bool { return p.Comments },
+			"comment", fmt.Sprintf("#%d %s: новый комментарий", t.ID, t.Title), notice)
+	}
+}
+
+func (n *Notifier) notice(t store.Task, te store.TaskEvent) TaskNotice {
+	actor := te.ActorEmail
+	if te.ActorID != 0 {
+		if u, err := n.store.GetUserByID(te.ActorID); err == nil {
+			actor = displayName(u)
+		}
+	}
+	if actor == "" {
+		actor = "Кто-то"
+	}
+	notice := TaskNotice{
+		Actor:   actor,
+		TaskID:  t.ID,
+		Title:   t.Title,
+		Project: n.store.LookupProjectName(t.ProjectID),
+		Status:  n.store.LookupStatusTitle(t.ProjectID, t.Status),
+		URL:     n.publicURL,
+		Reply:   n.mailbox != nil,
+	}
+	if t.DueDate != nil {
+		notice.Due = t.DueDate.Format(store.DateLayout)
+	}
+	if n.publicURL != "" {
+		notice.TaskURL = fmt.Sprintf("%s/?project=%d&task=%d", n.publicURL, t.ProjectID, t.ID)
+	}
+	return notice
+}
+
+// mentioned returns the ids of the users mentioned in a comment.
+func (n *Notifier) mentioned(body string) []int64 {
+	ids := make([]int64, 0)
+	for _, m := range mentionPattern.FindAllStringSubmatch(body, -1) {
+		// A mention at the end of a sentence keeps its full stop.
+		name := strings.TrimRight(m[1], ".")
+		u, err := n.store.GetUserByUsername(name)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			log.Printf("mailer: failed to look up @%s: %v", name, err)
+			continue
+		}
+		ids = append(ids, u.ID)
+	}
+	return ids
+}
+
+// notifyUsers queues the template for userIDs who opted in with wants and
+// can still see the task, skipping the actor. It returns who was emailed.
+func (n *Notifier) notifyUsers(t store.Task, actorID int64, userIDs []int64, wants func(store.EmailPrefs) bool, template, subject string, notice TaskNotice) map[int64]bool {
+	sent := make(map[int64]bool)
+	seen := make(map[int64]bool)
+	for _, id := range userIDs {
+		if id == 0 || id == actorID || seen[id] {
+			continue
+		}
+		seen[id] = true
+		u, err := n.store.GetUserByID(id)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			log.Printf("mailer: failed to load user %d: %v", id, err)
+			continue
+		}
+		if u.Email == "" || u.Role == "blocked" {
+			continue
+		}
+		prefs, err := n.store.GetEmailPrefs(u.ID)
+		if err != nil {
+			log.Printf("mailer: failed to load email preferences of user %d: %v", u.ID, err)
+			continue
+		}
+		if !wants(prefs) {
+			continue
+		}
+		if u.Role != "admin" {
+			roles, err := n.store.GetUserProjectRoles(u.ID)
+			if err != nil {
+				log.Printf("mailer: failed to load user projects: %v", err)
+				continue
+			}
+			if roles[t.ProjectID] == "" {
+				continue
+			}
+		}
+		msg, err := Render(template, subject, notice)
+		if err != nil {
+			log.Printf("mailer: failed to render %s email: %v", template, err)
+			return sent
+		}
+		msg.To = []string{u.Email}
+		if n.mailbox != nil {
+			msg.ReplyTo = n.mailbox.TaskAddress(t.ID)
+		}
+		if err := n.outbox.Send(msg); err != nil {
+			log.Printf("mailer: failed to queue email to user %d: %v", u.ID, err)
+			continue
+		}
+		sent[u.ID] = true
+	}
+	return sent
+}
+
+// taskWatchers returns the users who hear about comments on a task: its
+// author and assignees.
+func taskWatchers(t store.Task) []int64 {
+	ids := []int64{t.CreatedBy}
+	for _, a := range t.Assignees {
+		ids = append(ids, a.ID)
+	}
+	return ids
+}
+
+func displayName(u store.User) string {
+	name := strings.TrimSpace(u.FirstName + " " + u.LastName)
+	if name == "" {
+		return u.Email
+	}
+	return name
+}
@@ -0,0 +1,124 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/textproto"
+	"time"
+
+	"litetask/internal/store"
+)
+
+const (
+	maxAttempts    = 8
+	baseBackoff    = time.Minute
+	pollInterval   = 30 * time.Second
+	batchSize      = 20
+	maxErrorLength = 500
+)
+
+// Outbox queues email in the database and sends it in the background,
+// retrying failures with exponential backoff. Queued mail survives restarts.
+type Outbox struct {
+	store  *store.Store
+	mailer *Mailer
+	wake   chan struct{}
+}
+
+func NewOutbox(st *store.Store, m *Mailer) *Outbox {
+	return &Outbox{store: st, mailer: m, wake: make(chan struct{}, 1)}
+}
+
+// Send queues one email per recipient, so that a bad address does not hold
+// back the others.
+func (o *Outbox) Send(msg Message) error {
+	for _, to := range msg.To {
+		if _, err := o.store.EnqueueEmail(to, msg.Subject, msg.ReplyTo, msg.Text, msg.HTML, msg.ExpiresAt); err != nil {
+			return err
+		}
+	}
+	if len(msg.To) > 0 {
+		select {
+		case o.wake <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// SendNow sends a message right away, bypassing the queue, and reports the
+// SMTP error if any.
+func (o *Outbox) SendNow(msg Message) error {
+	return o.mailer.Send(msg)
+}
+
+// Run sends due mail until ctx is cancelled.
+func (o *Outbox) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		o.sendDue(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-o.wake:
+		}
+	}
+}
+
+func (o *Outbox) sendDue(ctx context.Context) {
+	if err := o.store.ExpireEmails(time.Now()); err != nil {
+		log.Printf("mailer: failed to expire queued mail: %v", err)
+	}
+	for ctx.Err() == nil {
+		due, err := o.store.DueEmails(time.Now(), batchSize)
+		if err != nil {
+			log.Printf("mailer: failed to load queued mail: %v", err)
+			return
+		}
+		if len(due) == 0 {
+			return
+		}
+		for _, e := range due {
+			if ctx.Err() != nil {
+				return
+			}
+			if err := o.attempt(e); err != nil {
+				log.Printf("mailer: failed to update email %d: %v", e.ID, err)
+				return
+			}
+		}
+	}
+}
+
+// attempt sends an email once and records the outcome.
+func (o *Outbox) attempt(e store.OutboxEmail) error {
+	sendErr := o.mailer.Send(Message{To: []string{e.To}, Subject: e.Subject, ReplyTo: e.ReplyTo, Text: e.Text, HTML: e.HTML})
+	if sendErr == nil {
+		return o.store.RecordEmailAttempt(e.ID, true, "", nil)
+	}
+	// A permanent SMTP failure, such as an unknown mailbox, is not retried.
+	var smtpErr *textproto.Error
+	permanent := errors.As(sendErr, &smtpErr) && smtpErr.Code >= 500
+	var next *time.Time
+	if attempts := e.Attempts + 1; attempts < maxAttempts && !permanent {
+		at := time.Now().Add(backoff(attempts))
+		next = &at
+	}
+	return o.store.RecordEmailAttempt(e.ID, false, truncate(sendErr.Error()), next)
+}
+
+// backoff returns the delay before retry number attempt: 1m, 2m, 4m, ...
+func backoff(attempt int) time.Duration {
+	return baseBackoff << (attempt - 1)
+}
+
+func truncate(msg string) string {
+	if len(msg) <= maxErrorLength {
+		return msg
+	}
+	return msg[:maxErrorLength]
+}
@@ -0,0 +1,142 @@
+package mailer
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"litetask/internal/store"
+)
+
+func newTestOutbox(t *testing.T, cfg Config) (*Outbox, *store.Store) {
+	t.Helper()
+	s, err := store.Open(filepath.Join(t.TempDir(), "litetask.db"))
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return NewOutbox(s, New(cfg)), s
+}
+
+// outboxByRecipient returns the state of every queued email.
+func outboxByRecipient(t *testing.T, s *store.Store) map[string]store.OutboxEmail {
+	t.Helper()
+	emails, err := s.ListOutbox("", 100)
+	if err != nil {
+		t.Fatalf("list outbox: %v", err)
+	}
+	byTo := make(map[string]store.OutboxEmail, len(emails))
+	for _, e := range emails {
+		byTo[e.To] = e
+	}
+	return byTo
+}
+
+func TestOutboxRetries(t *testing.T) {
+	stub := newSMTPStub(t)
+	stub.reject["busy@example.com"] = 451
+	stub.reject["gone@example.com"] = 550
+	o, s := newTestOutbox(t, stub.config())
+
+	msg := Message{To: []string{"ann@example.com", "busy@example.com", "gone@example.com"}, Subject: "Привет", Text: "x"}
+	if err := o.Send(msg); err != nil {
+		t.Fatalf("queue: %v", err)
+	}
+	start := time.Now()
+	o.sendDue(context.Background())
+
+	emails := outboxByRecipient(t, s)
+	if e := emails["ann@example.com"]; e.Status != store.DeliveryDelivered || e.SentAt == nil {
+		t.Errorf("ann: %+v, want delivered", e)
+	}
+	// A permanent failure is given up at once.
+	if e := emails["gone@example.com"]; e.Status != store.DeliveryFailed || e.Attempts != 1 || e.NextAttemptAt != nil {
+		t.Errorf("gone: %+v, want failed after one attempt", e)
+	}
+	// A temporary one is retried after the first backoff.
+	busy := emails["busy@example.com"]
+	if busy.Status != store.DeliveryPending || busy.Attempts != 1 || busy.LastError == "" || busy.NextAttemptAt == nil {
+		t.Fatalf("busy: %+v, want pending with a retry", busy)
+	}
+	if next := busy.NextAttemptAt.Sub(start); next < baseBackoff-time.Second || next > baseBackoff+5*time.Second {
+		t.Errorf("busy: next attempt in %v, want %v", next, baseBackoff)
+	}
+
+	// Nothing is due until then.
+	rcpts := stub.rcptCount()
+	o.sendDue(context.Background())
+	if n := stub.rcptCount(); n != rcpts {
+		t.Errorf("sent %d more attempts before the retry was due", n-rcpts)
+	}
+
+	// The last attempt gives up, the one before still schedules a retry.
+	busy.Attempts = maxAttempts - 2
+	if err := o.attempt(busy); err != nil {
+		t.Fatalf("attempt: %v", err)
+	}
+	if e := outboxByRecipient(t, s)["busy@example.com"]; e.Status != store.DeliveryPending {
+		t.Errorf("busy after %d attempts: status %s, want pending", maxAttempts-1, e.Status)
+	}
+	busy.Attempts = maxAttempts - 1
+	if err := o.attempt(busy); err != nil {
+		t.Fatalf("attempt: %v", err)
+	}
+	if e := outboxByRecipient(t, s)["busy@example.com"]; e.Status != store.DeliveryFailed || e.NextAttemptAt != nil {
+		t.Errorf("busy after %d attempts: %+v, want failed", maxAttempts, e)
+	}
+}
+
+func TestOutboxRetriesUnreachableServer(t *testing.T) {
+	stub := newSMTPStub(t)
+	cfg := stub.config()
+	stub.listener.Close()
+	o, s := newTestOutbox(t, cfg)
+	if err := o.Send(Message{To: []string{"ann@example.com"}, Subject: "x", Text: "x"}); err != nil {
+		t.Fatalf("queue: %v", err)
+	}
+	o.sendDue(context.Background())
+	e := outboxByRecipient(t, s)["ann@example.com"]
+	if e.Status != store.DeliveryPending || e.Attempts != 1 || e.NextAttemptAt == nil {
+		t.Fatalf("email: %+v, want pending with a retry", e)
+	}
+}
+
+func TestOutboxErasesExpiringEmails(t *testing.T) {
+	stub := newSMTPStub(t)
+	stub.reject["busy@example.com"] = 451
+	o, s := newTestOutbox(t, stub.config())
+
+	link := Message{To: []string{"ann@example.com", "busy@example.com"}, Subject: "x", Text: "link", ExpiresAt: time.Now().Add(time.Hour)}
+	late := Message{To: []string{"late@example.com"}, Subject: "x", Text: "link", ExpiresAt: time.Now().Add(-time.Second)}
+	for _, msg := range []Message{link, late} {
+		if err := o.Send(msg); err != nil {
+			t.Fatalf("queue: %v", err)
+		}
+	}
+	o.sendDue(context.Background())
+
+	emails := outboxByRecipient(t, s)
+	if e := emails["ann@example.com"]; e.Status != store.DeliveryDelivered || e.Text != "" {
+		t.Errorf("ann: %+v, want delivered without a body", e)
+	}
+	// The body stays while the email may still be sent.
+	if e := emails["busy@example.com"]; e.Status != store.DeliveryPending || e.Text != "link" {
+		t.Errorf("busy: %+v, want pending with the body", e)
+	}
+	if e := emails["late@example.com"]; e.Status != store.DeliveryFailed || e.Attempts != 0 || e.Text != "" {
+		t.Errorf("late: %+v, want given up unsent without a body", e)
+	}
+	if n := stub.rcptCount(); n != 2 {
+		t.Errorf("sent %d attempts, want 2", n)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	want := []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute}
+	for i, w := range want {
+		if got := backoff(i + 1); got != w {
+			t.Errorf("backoff(%d) = %v, want %v", i+1, got, w)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package mailer
+
+import (
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// smtpStub is an SMTP server on a local listener. It accepts every message
+// except for the recipients in reject, which get the reply code given
+// there, and keeps what it received.
+type smtpStub struct {
+	listener net.Listener
+	reject   map[string]int
+
+	mu       sync.Mutex
+	received []stubMessage
+	// rcpts counts the RCPT commands, refused ones included.
+	rcpts int
+}
+
+type stubMessage struct {
+	from string
+	to   []string
+	data string
+}
+
+func newSMTPStub(t *testing.T) *smtpStub {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	s := &smtpStub{listener: l, reject: make(map[string]int)}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+// config returns a Config for sending through the stub without TLS.
+func (s *smtpStub) config() Config {
+	addr := s.listener.Addr().(*net.TCPAddr)
+	return Config{Host: "127.0.0.1", Port: addr.Port, TLS: TLSNone, From: "LiteTask <tasks@example.com>"}
+}
+
+func (s *smtpStub) messages() []stubMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]stubMessage(nil), s.received...)
+}
+
+func (s *smtpStub) rcptCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rcpts
+}
+
+func (s *smtpStub) serve(conn net.Conn) {
+	defer conn.Close()
+	c := textproto.NewConn(conn)
+	c.PrintfLine("220 stub ESMTP") //nolint:errcheck
+	var msg stubMessage
+	for {
+		line, err := c.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			c.PrintfLine("250-stub")     //nolint:errcheck
+			c.PrintfLine("250 8BITMIME") //nolint:errcheck
+		case "MAIL":
+			msg = stubMessage{from: address(arg)}
+			c.PrintfLine("250 OK") //nolint:errcheck
+		case "RCPT":
+			to := address(arg)
+			s.mu.Lock()
+			s.rcpts++
+			code := s.reject[to]
+			s.mu.Unlock()
+			if code != 0 {
+				c.PrintfLine("%d %s refused", code, to) //nolint:errcheck
+				continue
+			}
+			msg.to = append(msg.to, to)
+			c.PrintfLine("250 OK") //nolint:errcheck
+		case "DATA":
+			c.PrintfLine("354 go ahead") //nolint:errcheck
+			// ReadDotBytes also turns CRLF line ends into LF.
+			data, err := c.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			msg.data = string(data)
+			s.mu.Lock()
+			s.received = append(s.received, msg)
+			s.mu.Unlock()
+			c.PrintfLine("250 queued") //nolint:errcheck
+		case "RSET", "NOOP":
+			c.PrintfLine("250 OK") //nolint:errcheck
+		case "QUIT":
+			c.PrintfLine("221 bye") //nolint:errcheck
+			return
+		default:
+			c.PrintfLine("502 not implemented") //nolint:errcheck
+		}
+	}
+}
+
+// address returns the address in "FROM:<a@b> BODY=8BITMIME" and the like.
+func address(arg string) string {
+	start := strings.Index(arg, "<")
+	end := strings.Index(arg, ">")
+	if start < 0 || end < start {
+		return ""
+	}
+	return arg[start+1 : end]
+}
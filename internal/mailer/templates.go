@@ -0,0 +1,128 @@
+package mailer
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+)
+
+// Every template exists in both sets under the same name: the text one is
+// the plain part of the email, the HTML one the alternative.
+
+const textSource = `
+{{define "footer"}}{{if .Reply}}
+Ответь на это письмо, чтобы добавить комментарий к задаче.
+{{end}}
+--
+LiteTask. Письма можно отключить в профиле{{if .URL}}: {{.URL}}{{end}}.
+{{end}}
+
+{{define "assigned"}}{{.Actor}} назначил(а) тебя на задачу #{{.TaskID}} «{{.Title}}» в проекте {{.Project}}.
+Статус: {{.Status}}{{if .Due}}
+Срок: {{.Due}}{{end}}
+{{if .TaskURL}}
+Открыть задачу: {{.TaskURL}}
+{{end}}{{template "footer" .}}{{end}}
+
+{{define "comment"}}{{.Actor}} прокомментировал(а) задачу #{{.TaskID}} «{{.Title}}» в проекте {{.Project}}:
+
+{{.Comment}}
+{{if .TaskURL}}
+Открыть задачу: {{.TaskURL}}
+{{end}}{{template "footer" .}}{{end}}
+
+{{define "mention"}}{{.Actor}} упомянул(а) тебя в комментарии к задаче #{{.TaskID}} «{{.Title}}» в проекте {{.Project}}:
+
+{{.Comment}}
+{{if .TaskURL}}
+Открыть задачу: {{.TaskURL}}
+{{end}}{{template "footer" .}}{{end}}
+
+{{define "account-footer"}}
+Если это был не ты, просто не обращай внимания на письмо.
+--
+LiteTask
+{{end}}
+
+{{define "reset"}}Кто-то попросил сбросить пароль аккаунта {{.Email}} в LiteTask.
+
+Задать новый пароль: {{.Link}}
+
+Ссылка действует {{.Valid}} и сработает один раз.
+{{template "account-footer" .}}{{end}}
+
+{{define "verify"}}Подтверди адрес {{.Email}}, чтобы закончить регистрацию в LiteTask:
+
+{{.Link}}
+
+Ссылка действует {{.Valid}}.
+{{template "account-footer" .}}{{end}}
+`
+
+const htmlSource = `
+{{define "header"}}<!DOCTYPE html>
+<html><body style="font-family: sans-serif; font-size: 14px; color: #1f2937;">
+{{end}}
+
+{{define "footer"}}{{if .TaskURL}}<p><a href="{{.TaskURL}}">Открыть задачу</a></p>
+{{end}}{{if .Reply}}<p>Ответь на это письмо, чтобы добавить комментарий к задаче.</p>
+{{end}}<p style="color: #6b7280; font-size: 12px;">LiteTask. Письма можно отключить в профиле{{if .URL}} на <a href="{{.URL}}">{{.URL}}</a>{{end}}.</p>
+</body></html>
+{{end}}
+
+{{define "task"}}задачу #{{.TaskID}} <b>{{.Title}}</b> в проекте {{.Project}}{{end}}
+
+{{define "comment-body"}}<blockquote style="margin: 12px 0; padding-left: 12px; border-left: 3px solid #d1d5db; white-space: pre-wrap;">{{.Comment}}</blockquote>
+{{end}}
+
+{{define "assigned"}}{{template "header" .}}<p>{{.Actor}} назначил(а) тебя на {{template "task" .}}.</p>
+<p>Статус: {{.Status}}{{if .Due}}<br>Срок: {{.Due}}{{end}}</p>
+{{template "footer" .}}{{end}}
+
+{{define "comment"}}{{template "header" .}}<p>{{.Actor}} прокомментировал(а) {{template "task" .}}:</p>
+{{template "comment-body" .}}{{template "footer" .}}{{end}}
+
+{{define "mention"}}{{template "header" .}}<p>{{.Actor}} упомянул(а) тебя в комментарии к {{template "task" .}}:</p>
+{{template "comment-body" .}}{{template "footer" .}}{{end}}
+
+{{define "account-footer"}}<p style="color: #6b7280; font-size: 12px;">Если это был не ты, просто не обращай внимания на письмо.<br>LiteTask</p>
+</body></html>
+{{end}}
+
+{{define "reset"}}{{template "header" .}}<p>Кто-то попросил сбросить пароль аккаунта {{.Email}} в LiteTask.</p>
+<p><a href="{{.Link}}">Задать новый пароль</a></p>
+<p>Ссылка действует {{.Valid}} и сработает один раз.</p>
+{{template "account-footer" .}}{{end}}
+
+{{define "verify"}}{{template "header" .}}<p>Подтверди адрес {{.Email}}, чтобы закончить регистрацию в LiteTask.</p>
+<p><a href="{{.Link}}">Подтвердить адрес</a></p>
+<p>Ссылка действует {{.Valid}}.</p>
+{{template "account-footer" .}}{{end}}
+`
+
+var (
+	textTemplates = texttemplate.Must(texttemplate.New("email").Parse(textSource))
+	htmlTemplates = htmltemplate.Must(htmltemplate.New("email").Parse(htmlSource))
+)
+
+// AccountNotice is the data of the password reset and verification
+// templates. Valid says how long the link works, e.g. "1 час".
+type AccountNotice struct {
+	Email string
+	Link  string
+	Valid string
+}
+
+// Render executes the named template of both sets and returns a message
+// without recipients.
+func Render(name, subject string, data any) (Message, error) {
+	var text, html bytes.Buffer
+	if err := textTemplates.ExecuteTemplate(&text, name, data); err != nil {
+		return Message{}, err
+	}
+	if err := htmlTemplates.ExecuteTemplate(&html, name, data); err != nil {
+		return Message{}, err
+	}
+	return Message{Subject: subject, Text: strings.TrimSpace(text.String()) + "\n", HTML: html.String()}, nil
+}
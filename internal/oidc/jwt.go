@@ -0,0 +1,348 @@
+package oidc
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256" // SHA-256 for RS256 and ES256
+	_ "crypto/sha512" // SHA-384 and SHA-512 for the longer variants
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// clockSkew is how far the provider's clock may be from ours.
+	clockSkew = time.Minute
+	// keysRefreshInterval limits how often an unknown key id makes us fetch
+	// the provider's keys again, as after a key rotation.
+	keysRefreshInterval = time.Minute
+)
+
+var ErrInvalidToken = errors.New("oidc: invalid ID token")
+
+// Claims are the parts of the ID token LiteTask uses.
+type Claims struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	PreferredUsername string
+	GivenName         string
+	FamilyName        string
+	Groups            []string
+}
+
+type publicKey struct {
+	key crypto.PublicKey
+	alg string
+}
+
+// algorithms maps the JWS algorithms accepted for ID tokens to their hash.
+// "none" and the HMAC algorithms are never accepted.
+var algorithms = map[string]crypto.Hash{
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+	"ES256": crypto.SHA256,
+	"ES384": crypto.SHA384,
+	"ES512": crypto.SHA512,
+}
+
+// Verify checks the signature and claims of an ID token: issuer, audience,
+// expiry and nonce.
+func (p *Provider) Verify(ctx context.Context, raw, nonce string) (Claims, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return Claims{}, err
+	}
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return Claims{}, fmt.Errorf("%w: malformed", ErrInvalidToken)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return Claims{}, fmt.Errorf("%w: header: %v", ErrInvalidToken, err)
+	}
+	hash, ok := algorithms[header.Alg]
+	if !ok {
+		return Claims{}, fmt.Errorf("%w: algorithm %q is not accepted", ErrInvalidToken, header.Alg)
+	}
+	key, err := p.key(ctx, meta.JWKSURI, header.Kid)
+	if err != nil {
+		return Claims{}, err
+	}
+	if key.alg != "" && key.alg != header.Alg {
+		return Claims{}, fmt.Errorf("%w: key is for %s", ErrInvalidToken, key.alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: signature: %v", ErrInvalidToken, err)
+	}
+	h := hash.New()
+	h.Write([]byte(parts[0] + "." + parts[1]))
+	if err := verifySignature(key.key, header.Alg, hash, h.Sum(nil), sig); err != nil {
+		return Claims{}, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: payload: %v", ErrInvalidToken, err)
+	}
+	var claims map[string]any
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+	if err := dec.Decode(&claims); err != nil {
+		return Claims{}, fmt.Errorf("%w: payload: %v", ErrInvalidToken, err)
+	}
+	if err := p.validate(claims, meta.Issuer, nonce, time.Now()); err != nil {
+		return Claims{}, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	c := Claims{
+		Subject:           stringClaim(claims, "sub"),
+		Email:             strings.ToLower(stringClaim(claims, "email")),
+		EmailVerified:     boolClaim(claims, "email_verified"),
+		PreferredUsername: stringClaim(claims, "preferred_username"),
+		GivenName:         stringClaim(claims, "given_name"),
+		FamilyName:        stringClaim(claims, "family_name"),
+		Groups:            listClaim(claims, p.cfg.GroupsClaim),
+	}
+	if c.Subject == "" {
+		return Claims{}, fmt.Errorf("%w: no subject", ErrInvalidToken)
+	}
+	return c, nil
+}
+
+func (p *Provider) validate(claims map[string]any, issuer, nonce string, now time.Time) error {
+	if stringClaim(claims, "iss") != issuer {
+		return fmt.Errorf("issuer %q", stringClaim(claims, "iss"))
+	}
+	audience := listClaim(claims, "aud")
+	if !slices.Contains(audience, p.cfg.ClientID) {
+		return errors.New("not issued for this client")
+	}
+	if azp := stringClaim(claims, "azp"); azp != "" && azp != p.cfg.ClientID {
+		return errors.New("authorized party is another client")
+	}
+	exp, ok := timeClaim(claims, "exp")
+	if !ok {
+		return errors.New("no expiry")
+	}
+	if !now.Before(exp.Add(clockSkew)) {
+		return errors.New("expired")
+	}
+	if iat, ok := timeClaim(claims, "iat"); ok && iat.After(now.Add(clockSkew)) {
+		return errors.New("issued in the future")
+	}
+	if nbf, ok := timeClaim(claims, "nbf"); ok && nbf.After(now.Add(clockSkew)) {
+		return errors.New("not valid yet")
+	}
+	if nonce == "" || stringClaim(claims, "nonce") != nonce {
+		return errors.New("nonce mismatch")
+	}
+	return nil
+}
+
+func verifySignature(key crypto.PublicKey, alg string, hash crypto.Hash, digest, sig []byte) error {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(alg, "RS") {
+			return errors.New("RSA key for " + alg)
+		}
+		return rsa.VerifyPKCS1v15(k, hash, digest, sig)
+	case *ecdsa.PublicKey:
+		if !strings.HasPrefix(alg, "ES") {
+			return errors.New("EC key for " + alg)
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return errors.New("bad signature length")
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return errors.New("bad signature")
+		}
+		return nil
+	}
+	return errors.New("unsupported key")
+}
+
+// key returns the signing key with id kid, fetching the key set again when
+// it is unknown. A token without kid is accepted when the set has one key.
+func (p *Provider) key(ctx context.Context, jwksURI, kid string) (publicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if k, ok := lookupKey(p.keys, kid); ok {
+		return k, nil
+	}
+	if p.keys != nil && time.Since(p.keysFetched) < keysRefreshInterval {
+		return publicKey{}, fmt.Errorf("%w: unknown key %q", ErrInvalidToken, kid)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := p.getJSON(ctx, jwksURI, &set); err != nil {
+		return publicKey{}, fmt.Errorf("oidc: keys: %w", err)
+	}
+	keys := make(map[string]publicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		pub, err := k.publicKey()
+		if err != nil {
+			// One key we cannot read should not break the others.
+			continue
+		}
+		keys[k.Kid] = publicKey{key: pub, alg: k.Alg}
+	}
+	p.keys = keys
+	p.keysFetched = time.Now()
+	if k, ok := lookupKey(p.keys, kid); ok {
+		return k, nil
+	}
+	return publicKey{}, fmt.Errorf("%w: unknown key %q", ErrInvalidToken, kid)
+}
+
+func lookupKey(keys map[string]publicKey, kid string) (publicKey, bool) {
+	if k, ok := keys[kid]; ok {
+		return k, true
+	}
+	if kid == "" && len(keys) == 1 {
+		for _, k := range keys {
+			return k, true
+		}
+	}
+	return publicKey{}, false
+}
+
+// jwk is a JSON Web Key (RFC 7517) with the RSA and EC fields.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		exp := new(big.Int).SetBytes(e)
+		if !exp.IsInt64() || exp.Int64() < 3 || exp.Int64() > 1<<31-1 {
+			return nil, errors.New("bad RSA exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		pub := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, errors.New("point is not on the curve")
+		}
+		return pub, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func stringClaim(claims map[string]any, name string) string {
+	s, _ := claims[name].(string)
+	return s
+}
+
+// boolClaim reads a boolean claim; some providers send "true" as a string.
+func boolClaim(claims map[string]any, name string) bool {
+	switch v := claims[name].(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	}
+	return false
+}
+
+func timeClaim(claims map[string]any, name string) (time.Time, bool) {
+	n, ok := claims[name].(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(f), 0), true
+}
+
+// listClaim reads a claim that is a string or a list of strings. A dotted
+// name looks into nested objects, as in Keycloak's "realm_access.roles".
+func listClaim(claims map[string]any, name string) []string {
+	var value any = claims
+	for _, part := range strings.Split(name, ".") {
+		obj, ok := value.(map[string]any)
+		if !ok {
+			return nil
+		}
+		value = obj[part]
+	}
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []any:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				items = append(items, s)
+			}
+		}
+		return items
+	}
+	return nil
+}
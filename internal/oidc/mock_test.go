@@ -0,0 +1,206 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testClientID     = "litetask"
+	testClientSecret = "s3cret"
+	testRedirectURL  = "https://tasks.example.com/api/auth/oidc/callback"
+)
+
+// mockIssuer is an OpenID Connect provider on a local test server. Its
+// authorization endpoint logs the user in at once and redirects back with a
+// code; the token endpoint checks the client and the PKCE verifier before
+// it signs the ID token.
+type mockIssuer struct {
+	server *httptest.Server
+
+	mu     sync.Mutex
+	key    *rsa.PrivateKey
+	kid    string
+	claims map[string]any
+	// edit changes the claims of the next ID token, after the defaults
+	// were filled in.
+	edit  func(claims map[string]any)
+	codes map[string]pendingCode
+	// jwksRequests counts the fetches of the key set.
+	jwksRequests int
+}
+
+type pendingCode struct {
+	nonce     string
+	challenge string
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	t.Helper()
+	m := &mockIssuer{codes: make(map[string]pendingCode)}
+	m.rotateKey(t, "key-1")
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", m.handleDiscovery)
+	mux.HandleFunc("/jwks", m.handleJWKS)
+	mux.HandleFunc("/authorize", m.handleAuthorize)
+	mux.HandleFunc("/token", m.handleToken)
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+func (m *mockIssuer) issuer() string {
+	return m.server.URL
+}
+
+func (m *mockIssuer) provider(t *testing.T) *Provider {
+	t.Helper()
+	p, err := New(Config{
+		Issuer:       m.issuer(),
+		ClientID:     testClientID,
+		ClientSecret: testClientSecret,
+		RedirectURL:  testRedirectURL,
+	})
+	if err != nil {
+		t.Fatalf("new provider: %v", err)
+	}
+	return p
+}
+
+// rotateKey replaces the signing key, as providers do from time to time.
+func (m *mockIssuer) rotateKey(t *testing.T, kid string) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	m.mu.Lock()
+	m.key, m.kid = key, kid
+	m.mu.Unlock()
+}
+
+func (m *mockIssuer) keyFetches() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.jwksRequests
+}
+
+func (m *mockIssuer) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	writeTestJSON(w, map[string]any{
+		"issuer":                                m.issuer(),
+		"authorization_endpoint":                m.issuer() + "/authorize",
+		"token_endpoint":                        m.issuer() + "/token",
+		"jwks_uri":                              m.issuer() + "/jwks",
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic"},
+	})
+}
+
+func (m *mockIssuer) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.jwksRequests++
+	pub := m.key.PublicKey
+	writeTestJSON(w, map[string]any{"keys": []map[string]string{{
+		"kty": "RSA",
+		"kid": m.kid,
+		"use": "sig",
+		"alg": "RS256",
+		"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+	}}})
+}
+
+func (m *mockIssuer) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != testClientID || q.Get("redirect_uri") != testRedirectURL ||
+		q.Get("response_type") != "code" || q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "bad authorization request", http.StatusBadRequest)
+		return
+	}
+	code := randomTestString()
+	m.mu.Lock()
+	m.codes[code] = pendingCode{nonce: q.Get("nonce"), challenge: q.Get("code_challenge")}
+	m.mu.Unlock()
+	back := url.Values{"code": {code}, "state": {q.Get("state")}}
+	http.Redirect(w, r, q.Get("redirect_uri")+"?"+back.Encode(), http.StatusFound)
+}
+
+func (m *mockIssuer) handleToken(w http.ResponseWriter, r *http.Request) {
+	id, secret, ok := r.BasicAuth()
+	if !ok || id != testClientID || secret != testClientSecret {
+		w.WriteHeader(http.StatusUnauthorized)
+		writeTestJSON(w, map[string]string{"error": "invalid_client"})
+		return
+	}
+	m.mu.Lock()
+	pending, ok := m.codes[r.PostFormValue("code")]
+	delete(m.codes, r.PostFormValue("code"))
+	m.mu.Unlock()
+	verifier := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(verifier[:]) != pending.challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		writeTestJSON(w, map[string]string{"error": "invalid_grant"})
+		return
+	}
+	now := time.Now().Unix()
+	claims := map[string]any{
+		"iss":            m.issuer(),
+		"aud":            testClientID,
+		"sub":            "user-1",
+		"email":          "Ann@Example.com",
+		"email_verified": true,
+		"iat":            now,
+		"exp":            now + 300,
+		"nonce":          pending.nonce,
+	}
+	m.mu.Lock()
+	for k, v := range m.claims {
+		claims[k] = v
+	}
+	if m.edit != nil {
+		m.edit(claims)
+	}
+	m.mu.Unlock()
+	writeTestJSON(w, map[string]string{
+		"access_token": randomTestString(),
+		"token_type":   "Bearer",
+		"id_token":     m.sign(claims),
+	})
+}
+
+// sign returns an RS256 ID token with claims.
+func (m *mockIssuer) sign(claims map[string]any) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": m.kid})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, m.key, crypto.SHA256, digest[:])
+	if err != nil {
+		panic(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func writeTestJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v) //nolint:errcheck
+}
+
+func randomTestString() string {
+	buf := make([]byte, 16)
+	rand.Read(buf) //nolint:errcheck
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
@@ -0,0 +1,323 @@
+// Package oidc signs users in with an OpenID Connect provider: the
+// authorization code flow with PKCE, and validation of the ID token against
+// the provider's published keys.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"litetask/internal/store"
+)
+
+const (
+	requestTimeout = 10 * time.Second
+	// discoveryTTL is how long the provider metadata is trusted before it
+	// is fetched again.
+	discoveryTTL = time.Hour
+)
+
+// ProjectGrant is a project membership given to the members of a group.
+type ProjectGrant struct {
+	ProjectID int64
+	Role      string
+}
+
+// Config is the OpenID Connect client and how its groups map to LiteTask
+// roles.
+type Config struct {
+	// Name labels the login button, e.g. the company's name.
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	// GroupsClaim names the claim with the user's groups; a dotted path
+	// such as "realm_access.roles" reaches into nested objects.
+	GroupsClaim string
+	// AdminGroups make their members admins. When empty, roles are not
+	// managed by the provider.
+	AdminGroups []string
+	// ProjectGroups gives the members of each group a role in projects.
+	ProjectGroups map[string][]ProjectGrant
+	// TrustMFA skips LiteTask's own second factor on single sign-on, for
+	// providers that already ask for one.
+	TrustMFA bool
+}
+
+// ConfigFromEnv reads OIDC_ISSUER, OIDC_CLIENT_ID, OIDC_CLIENT_SECRET,
+// OIDC_REDIRECT_URL (default publicURL + /api/auth/oidc/callback),
+// OIDC_SCOPES (default "openid email profile"), OIDC_NAME (default "SSO"),
+// OIDC_GROUPS_CLAIM (default "groups"), OIDC_ADMIN_GROUPS (comma separated),
+// OIDC_PROJECT_GROUPS ("group=projectId:role,...") and OIDC_TRUST_MFA
+// (default false). ok is false when OIDC_ISSUER is unset.
+func ConfigFromEnv(publicURL string) (cfg Config, ok bool, err error) {
+	cfg.Issuer = strings.TrimSpace(os.Getenv("OIDC_ISSUER"))
+	if cfg.Issuer == "" {
+		return Config{}, false, nil
+	}
+	cfg.ClientID = strings.TrimSpace(os.Getenv("OIDC_CLIENT_ID"))
+	if cfg.ClientID == "" {
+		return Config{}, false, errors.New("OIDC_CLIENT_ID is required")
+	}
+	cfg.ClientSecret = os.Getenv("OIDC_CLIENT_SECRET")
+	cfg.RedirectURL = strings.TrimSpace(os.Getenv("OIDC_REDIRECT_URL"))
+	if cfg.RedirectURL == "" {
+		if publicURL == "" {
+			return Config{}, false, errors.New("OIDC_REDIRECT_URL or PUBLIC_URL is required")
+		}
+		cfg.RedirectURL = strings.TrimRight(publicURL, "/") + "/api/auth/oidc/callback"
+	}
+	cfg.Scopes = strings.Fields(strings.ReplaceAll(os.Getenv("OIDC_SCOPES"), ",", " "))
+	cfg.Name = strings.TrimSpace(os.Getenv("OIDC_NAME"))
+	cfg.GroupsClaim = strings.TrimSpace(os.Getenv("OIDC_GROUPS_CLAIM"))
+	cfg.AdminGroups = splitList(os.Getenv("OIDC_ADMIN_GROUPS"))
+	cfg.ProjectGroups, err = ParseProjectGroups(os.Getenv("OIDC_PROJECT_GROUPS"))
+	if err != nil {
+		return Config{}, false, err
+	}
+	cfg.TrustMFA = strings.TrimSpace(os.Getenv("OIDC_TRUST_MFA")) == "true"
+	return cfg, true, nil
+}
+
+// ParseProjectGroups reads "group=projectId:role" pairs separated by commas.
+// The role is one of the project roles and defaults to member.
+func ParseProjectGroups(value string) (map[string][]ProjectGrant, error) {
+	groups := make(map[string][]ProjectGrant)
+	for _, entry := range splitList(value) {
+		group, target, ok := strings.Cut(entry, "=")
+		group, target = strings.TrimSpace(group), strings.TrimSpace(target)
+		if !ok || group == "" || target == "" {
+			return nil, fmt.Errorf("OIDC_PROJECT_GROUPS: %q is not group=projectId:role", entry)
+		}
+		idText, role, _ := strings.Cut(target, ":")
+		id, err := strconv.ParseInt(strings.TrimSpace(idText), 10, 64)
+		if err != nil || id <= 0 {
+			return nil, fmt.Errorf("OIDC_PROJECT_GROUPS: invalid project id in %q", entry)
+		}
+		role = strings.TrimSpace(role)
+		if role == "" {
+			role = store.ProjectRoleMember
+		}
+		if !store.ValidProjectRole(role) {
+			return nil, fmt.Errorf("OIDC_PROJECT_GROUPS: invalid role %q", role)
+		}
+		groups[group] = append(groups[group], ProjectGrant{ProjectID: id, Role: role})
+	}
+	return groups, nil
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// metadata is the part of the discovery document the flow needs.
+type metadata struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	TokenAuthMethods      []string `json:"token_endpoint_auth_methods_supported"`
+}
+
+// Provider is an OpenID Connect provider. The discovery document and the
+// signing keys are fetched on first use and cached, so the server starts
+// even when the provider is down.
+type Provider struct {
+	cfg    Config
+	client *http.Client
+
+	mu          sync.Mutex
+	meta        *metadata
+	metaFetched time.Time
+	keys        map[string]publicKey
+	keysFetched time.Time
+}
+
+func New(cfg Config) (*Provider, error) {
+	if cfg.Issuer == "" || cfg.ClientID == "" || cfg.RedirectURL == "" {
+		return nil, errors.New("oidc: issuer, client id and redirect URL are required")
+	}
+	if _, err := url.Parse(cfg.Issuer); err != nil {
+		return nil, fmt.Errorf("oidc: invalid issuer: %w", err)
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "email", "profile"}
+	} else if !slices.Contains(cfg.Scopes, "openid") {
+		cfg.Scopes = append([]string{"openid"}, cfg.Scopes...)
+	}
+	if cfg.Name == "" {
+		cfg.Name = "SSO"
+	}
+	if cfg.GroupsClaim == "" {
+		cfg.GroupsClaim = "groups"
+	}
+	return &Provider{cfg: cfg, client: &http.Client{Timeout: requestTimeout}}, nil
+}
+
+func (p *Provider) Config() Config {
+	return p.cfg
+}
+
+// AuthRequest is what the client keeps between sending the user to the
+// provider and the callback: the state against forged callbacks, the nonce
+// tying the ID token to this login and the PKCE verifier.
+type AuthRequest struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+}
+
+func NewAuthRequest() (AuthRequest, error) {
+	var req AuthRequest
+	for _, field := range []*string{&req.State, &req.Nonce, &req.Verifier} {
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			return AuthRequest{}, err
+		}
+		*field = base64.RawURLEncoding.EncodeToString(buf)
+	}
+	return req, nil
+}
+
+// AuthCodeURL returns the provider's login page for req.
+func (p *Provider) AuthCodeURL(ctx context.Context, req AuthRequest) (string, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	challenge := sha256.Sum256([]byte(req.Verifier))
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", p.cfg.ClientID)
+	q.Set("redirect_uri", p.cfg.RedirectURL)
+	q.Set("scope", strings.Join(p.cfg.Scopes, " "))
+	q.Set("state", req.State)
+	q.Set("nonce", req.Nonce)
+	q.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	q.Set("code_challenge_method", "S256")
+	sep := "?"
+	if strings.Contains(meta.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return meta.AuthorizationEndpoint + sep + q.Encode(), nil
+}
+
+// Exchange redeems the authorization code from the callback and returns the
+// claims of the validated ID token.
+func (p *Provider) Exchange(ctx context.Context, code string, req AuthRequest) (Claims, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return Claims{}, err
+	}
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.cfg.RedirectURL)
+	form.Set("code_verifier", req.Verifier)
+	// client_secret_basic is the default; providers that only take the
+	// secret in the body say so in their metadata.
+	basic := p.cfg.ClientSecret != "" &&
+		(len(meta.TokenAuthMethods) == 0 || slices.Contains(meta.TokenAuthMethods, "client_secret_basic"))
+	if !basic {
+		form.Set("client_id", p.cfg.ClientID)
+		if p.cfg.ClientSecret != "" {
+			form.Set("client_secret", p.cfg.ClientSecret)
+		}
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Claims{}, err
+	}
+	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	httpReq.Header.Set("Accept", "application/json")
+	if basic {
+		httpReq.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		return Claims{}, fmt.Errorf("oidc: token request: %w", err)
+	}
+	defer resp.Body.Close()
+	var token struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&token); err != nil {
+		return Claims{}, fmt.Errorf("oidc: token response (%s): %w", resp.Status, err)
+	}
+	if token.Error != "" {
+		return Claims{}, fmt.Errorf("oidc: token request: %s", strings.TrimSpace(token.Error+" "+token.ErrorDescription))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Claims{}, fmt.Errorf("oidc: token request: %s", resp.Status)
+	}
+	if token.IDToken == "" {
+		return Claims{}, errors.New("oidc: token response has no id_token")
+	}
+	return p.Verify(ctx, token.IDToken, req.Nonce)
+}
+
+func (p *Provider) discover(ctx context.Context) (*metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.meta != nil && time.Since(p.metaFetched) < discoveryTTL {
+		return p.meta, nil
+	}
+	var meta metadata
+	if err := p.getJSON(ctx, strings.TrimRight(p.cfg.Issuer, "/")+"/.well-known/openid-configuration", &meta); err != nil {
+		if p.meta != nil {
+			// Keep using what worked while the provider is unreachable.
+			return p.meta, nil
+		}
+		return nil, fmt.Errorf("oidc: discovery: %w", err)
+	}
+	if meta.Issuer != p.cfg.Issuer {
+		return nil, fmt.Errorf("oidc: discovery: issuer is %q, expected %q", meta.Issuer, p.cfg.Issuer)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, errors.New("oidc: discovery: endpoints missing")
+	}
+	p.meta = &meta
+	p.metaFetched = time.Now()
+	return p.meta, nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, target string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", target, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
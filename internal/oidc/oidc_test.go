@@ -0,0 +1,236 @@
+package oidc
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// login goes through the authorization code flow with the mock issuer and
+// returns the result of the code exchange.
+func login(t *testing.T, m *mockIssuer, p *Provider) (Claims, error) {
+	t.Helper()
+	ctx := context.Background()
+	req, err := NewAuthRequest()
+	if err != nil {
+		t.Fatalf("new auth request: %v", err)
+	}
+	target, err := p.AuthCodeURL(ctx, req)
+	if err != nil {
+		t.Fatalf("auth code url: %v", err)
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(target)
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize: status %s", resp.Status)
+	}
+	back, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("callback url: %v", err)
+	}
+	if got := back.Query().Get("state"); got != req.State {
+		t.Fatalf("state = %q, want %q", got, req.State)
+	}
+	return p.Exchange(ctx, back.Query().Get("code"), req)
+}
+
+func TestLogin(t *testing.T) {
+	m := newMockIssuer(t)
+	m.claims = map[string]any{
+		"preferred_username": "ann",
+		"given_name":         "Ann",
+		"family_name":        "Lee",
+		"groups":             []string{"staff", "admins"},
+	}
+	claims, err := login(t, m, m.provider(t))
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	want := Claims{
+		Subject:           "user-1",
+		Email:             "ann@example.com",
+		EmailVerified:     true,
+		PreferredUsername: "ann",
+		GivenName:         "Ann",
+		FamilyName:        "Lee",
+		Groups:            []string{"staff", "admins"},
+	}
+	if !reflect.DeepEqual(claims, want) {
+		t.Fatalf("claims = %+v, want %+v", claims, want)
+	}
+}
+
+func TestLoginNestedGroupsClaim(t *testing.T) {
+	m := newMockIssuer(t)
+	m.claims = map[string]any{"realm_access": map[string]any{"roles": []string{"dev"}}}
+	p, err := New(Config{
+		Issuer:       m.issuer(),
+		ClientID:     testClientID,
+		ClientSecret: testClientSecret,
+		RedirectURL:  testRedirectURL,
+		GroupsClaim:  "realm_access.roles",
+	})
+	if err != nil {
+		t.Fatalf("new provider: %v", err)
+	}
+	claims, err := login(t, m, p)
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	if !reflect.DeepEqual(claims.Groups, []string{"dev"}) {
+		t.Fatalf("groups = %v, want [dev]", claims.Groups)
+	}
+}
+
+func TestLoginRejectsInvalidTokens(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(claims map[string]any)
+	}{
+		{"wrong nonce", func(c map[string]any) { c["nonce"] = "other" }},
+		{"expired", func(c map[string]any) { c["exp"] = time.Now().Add(-2 * time.Minute).Unix() }},
+		{"no expiry", func(c map[string]any) { delete(c, "exp") }},
+		{"not valid yet", func(c map[string]any) { c["nbf"] = time.Now().Add(5 * time.Minute).Unix() }},
+		{"wrong audience", func(c map[string]any) { c["aud"] = "other-app" }},
+		{"other authorized party", func(c map[string]any) {
+			c["aud"] = []string{testClientID, "other-app"}
+			c["azp"] = "other-app"
+		}},
+		{"wrong issuer", func(c map[string]any) { c["iss"] = "https://evil.example.com" }},
+		{"no subject", func(c map[string]any) { delete(c, "sub") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockIssuer(t)
+			m.edit = tt.edit
+			_, err := login(t, m, m.provider(t))
+			if !errors.Is(err, ErrInvalidToken) {
+				t.Fatalf("err = %v, want ErrInvalidToken", err)
+			}
+		})
+	}
+}
+
+func TestLoginAcceptsAudienceList(t *testing.T) {
+	m := newMockIssuer(t)
+	m.edit = func(c map[string]any) {
+		c["aud"] = []string{"other-app", testClientID}
+		c["azp"] = testClientID
+	}
+	if _, err := login(t, m, m.provider(t)); err != nil {
+		t.Fatalf("login: %v", err)
+	}
+}
+
+func TestVerifyRejectsForgedSignatures(t *testing.T) {
+	m := newMockIssuer(t)
+	p := m.provider(t)
+	now := time.Now().Unix()
+	claims := map[string]any{
+		"iss":   m.issuer(),
+		"aud":   testClientID,
+		"sub":   "user-1",
+		"exp":   now + 300,
+		"nonce": "n",
+	}
+	token := m.sign(claims)
+	if _, err := p.Verify(context.Background(), token, "n"); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	parts := strings.Split(token, ".")
+
+	// A changed payload no longer matches the signature.
+	claims["sub"] = "admin"
+	forged := strings.Split(m.sign(claims), ".")[1]
+	if _, err := p.Verify(context.Background(), parts[0]+"."+forged+"."+parts[2], "n"); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("changed payload: err = %v, want ErrInvalidToken", err)
+	}
+
+	// Unsigned tokens and tokens "signed" with the public key as an HMAC
+	// secret are refused outright.
+	for _, alg := range []string{"none", "HS256"} {
+		header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"` + alg + `","kid":"key-1"}`))
+		if _, err := p.Verify(context.Background(), header+"."+parts[1]+".", "n"); !errors.Is(err, ErrInvalidToken) {
+			t.Fatalf("alg %s: err = %v, want ErrInvalidToken", alg, err)
+		}
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	m := newMockIssuer(t)
+	p := m.provider(t)
+	if _, err := login(t, m, p); err != nil {
+		t.Fatalf("first login: %v", err)
+	}
+	// The provider's keys are cached, so a token signed with a new key
+	// fetches them again once.
+	m.rotateKey(t, "key-2")
+	p.mu.Lock()
+	p.keysFetched = time.Now().Add(-keysRefreshInterval)
+	p.mu.Unlock()
+	if _, err := login(t, m, p); err != nil {
+		t.Fatalf("login after rotation: %v", err)
+	}
+	if _, err := login(t, m, p); err != nil {
+		t.Fatalf("second login after rotation: %v", err)
+	}
+	if n := m.keyFetches(); n != 2 {
+		t.Fatalf("key set fetched %d times, want 2", n)
+	}
+
+	// Unknown keys do not make every login fetch the keys again.
+	m.rotateKey(t, "key-3")
+	if _, err := login(t, m, p); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("login right after second rotation: err = %v, want ErrInvalidToken", err)
+	}
+	if n := m.keyFetches(); n != 2 {
+		t.Fatalf("key set fetched %d times, want 2", n)
+	}
+}
+
+func TestDiscoveryChecksIssuer(t *testing.T) {
+	m := newMockIssuer(t)
+	p, err := New(Config{
+		Issuer:      m.issuer() + "/",
+		ClientID:    testClientID,
+		RedirectURL: testRedirectURL,
+	})
+	if err != nil {
+		t.Fatalf("new provider: %v", err)
+	}
+	req, _ := NewAuthRequest()
+	if _, err := p.AuthCodeURL(context.Background(), req); err == nil {
+		t.Fatal("auth code url: want an error for a different issuer")
+	}
+}
+
+func TestParseProjectGroups(t *testing.T) {
+	got, err := ParseProjectGroups(" dev=1, dev = 2:maintainer ,qa=2:viewer")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	want := map[string][]ProjectGrant{
+		"dev": {{ProjectID: 1, Role: "member"}, {ProjectID: 2, Role: "maintainer"}},
+		"qa":  {{ProjectID: 2, Role: "viewer"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("groups = %v, want %v", got, want)
+	}
+	for _, bad := range []string{"dev", "dev=", "=1", "dev=x", "dev=0", "dev=1:owner"} {
+		if _, err := ParseProjectGroups(bad); err == nil {
+			t.Errorf("%q: want an error", bad)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package search
+
+// Russian stemming after the Snowball algorithm:
+// https://snowballstem.org/algorithms/russian/stemmer.html
+//
+// Endings are matched longest first and only inside RV (the part of the word
+// after the first vowel); the derivational suffix must also lie in R2.
+
+var (
+	perfectiveGerund1 = runeList("в", "вши", "вшись")
+	perfectiveGerund2 = runeList("ив", "ивши", "ившись", "ыв", "ывши", "ывшись")
+	adjectiveEndings  = runeList(
+		"ее", "ие", "ые", "ое", "ими", "ыми", "ей", "ий", "ый", "ой", "ем", "им", "ым", "ом",
+		"его", "ого", "ему", "ому", "их", "ых", "ую", "юю", "ая", "яя", "ою", "ею",
+	)
+	participle1 = runeList("ем", "нн", "вш", "ющ", "щ")
+	participle2 = runeList("ивш", "ывш", "ующ")
+	reflexive   = runeList("ся", "сь")
+	verb1       = runeList("ла", "на", "ете", "йте", "ли", "й", "л", "ем", "н", "ло", "но", "ет", "ют", "ны", "ть", "ешь", "нно")
+	verb2       = runeList(
+		"ила", "ыла", "ена", "ейте", "уйте", "ите", "или", "ыли", "ей", "уй", "ил", "ыл", "им", "ым", "ен",
+		"ило", "ыло", "ено", "ят", "ует", "уют", "ит", "ыт", "ены", "ить", "ыть", "ишь", "ую", "ю",
+	)
+	nounEndings = runeList(
+		"а", "ев", "ов", "ие", "ье", "е", "иями", "ями", "ами", "еи", "ии", "и", "ией", "ей", "ой", "ий", "й",
+		"иям", "ям", "ием", "ем", "ам", "ом", "о", "у", "ах", "иях", "ях", "ы", "ь", "ию", "ью", "ю", "ия", "ья", "я",
+	)
+	derivational = runeList("ост", "ость")
+	superlative  = runeList("ейш", "ейше")
+)
+
+func runeList(items ...string) [][]rune {
+	list := make([][]rune, len(items))
+	for i, item := range items {
+		list[i] = []rune(item)
+	}
+	return list
+}
+
+func isVowel(r rune) bool {
+	switch r {
+	case 'а', 'е', 'и', 'о', 'у', 'ы', 'э', 'ю', 'я':
+		return true
+	}
+	return false
+}
+
+// regions returns the start of RV and R2.
+func regions(w []rune) (int, int) {
+	rv := len(w)
+	for i, r := range w {
+		if isVowel(r) {
+			rv = i + 1
+			break
+		}
+	}
+	// next returns the end of the first vowel+non-vowel pair starting at or
+	// after from, which is how R1 is found in the word and R2 in R1.
+	next := func(from int) int {
+		for i := from + 1; i < len(w); i++ {
+			if !isVowel(w[i]) && isVowel(w[i-1]) {
+				return i + 1
+			}
+		}
+		return len(w)
+	}
+	r2 := next(next(0))
+	return rv, r2
+}
+
+// longestSuffix returns the start of the longest ending from lists that fits
+// at or after limit, and the index of the list it came from.
+func longestSuffix(w []rune, limit int, lists ...[][]rune) (int, int) {
+	best, from := -1, -1
+	for li, list := range lists {
+		for _, end := range list {
+			start := len(w) - len(end)
+			if start < limit || (best != -1 && start >= best) {
+				continue
+			}
+			if hasSuffixAt(w, start, end) {
+				best, from = start, li
+			}
+		}
+	}
+	return best, from
+}
+
+func hasSuffixAt(w []rune, start int, end []rune) bool {
+	if start < 0 {
+		return false
+	}
+	for i, r := range end {
+		if w[start+i] != r {
+			return false
+		}
+	}
+	return true
+}
+
+// precededByAYa reports whether the rune before start is а or я inside RV.
+func precededByAYa(w []rune, start, rv int) bool {
+	return start-1 >= rv && (w[start-1] == 'а' || w[start-1] == 'я')
+}
+
+// groupSuffix matches a pair of ending groups where endings of the first
+// group must follow а or я, which stays in place.
+func groupSuffix(w []rune, rv int, first, second [][]rune) (int, bool) {
+	start, from := longestSuffix(w, rv, first, second)
+	if start < 0 {
+		return 0, false
+	}
+	if from == 0 && !precededByAYa(w, start, rv) {
+		return 0, false
+	}
+	return start, true
+}
+
+// stemRussian stems a lower-case Cyrillic word with ё already folded to е.
+func stemRussian(w []rune) []rune {
+	rv, r2 := regions(w)
+	if rv >= len(w) {
+		return w
+	}
+
+	// Step 1.
+	if start, ok := groupSuffix(w, rv, perfectiveGerund1, perfectiveGerund2); ok {
+		w = w[:start]
+	} else {
+		if start, _ := longestSuffix(w, rv, reflexive); start >= 0 {
+			w = w[:start]
+		}
+		if start, _ := longestSuffix(w, rv, adjectiveEndings); start >= 0 {
+			w = w[:start]
+			if pstart, ok := groupSuffix(w, rv, participle1, participle2); ok {
+				w = w[:pstart]
+			}
+		} else if start, ok := groupSuffix(w, rv, verb1, verb2); ok {
+			w = w[:start]
+		} else if start, _ := longestSuffix(w, rv, nounEndings); start >= 0 {
+			w = w[:start]
+		}
+	}
+
+	// Step 2.
+	if len(w) > rv && w[len(w)-1] == 'и' {
+		w = w[:len(w)-1]
+	}
+
+	// Step 3.
+	if start, _ := longestSuffix(w, max(rv, r2), derivational); start >= 0 {
+		w = w[:start]
+	}
+
+	// Step 4.
+	if start, _ := longestSuffix(w, rv, superlative); start >= 0 {
+		w = w[:start]
+		if len(w)-2 >= rv && w[len(w)-1] == 'н' && w[len(w)-2] == 'н' {
+			w = w[:len(w)-1]
+		}
+	} else if len(w)-2 >= rv && w[len(w)-1] == 'н' && w[len(w)-2] == 'н' {
+		w = w[:len(w)-1]
+	} else if len(w) > rv && w[len(w)-1] == 'ь' {
+		w = w[:len(w)-1]
+	}
+	return w
+}
@@ -0,0 +1,164 @@
+// Package search turns task text into stemmed terms for the full-text index
+// and renders highlighted snippets for search results.
+package search
+
+import (
+	"html"
+	"strings"
+	"unicode"
+)
+
+// Token is a word of the original text with its byte range and stem.
+type Token struct {
+	Start, End int
+	Stem       string
+}
+
+// Tokenize splits text into runs of letters and digits.
+func Tokenize(text string) []Token {
+	tokens := make([]Token, 0)
+	start := -1
+	flush := func(end int) {
+		if start >= 0 {
+			tokens = append(tokens, Token{Start: start, End: end, Stem: Stem(text[start:end])})
+			start = -1
+		}
+	}
+	for i, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		flush(i)
+	}
+	flush(len(text))
+	return tokens
+}
+
+// Stem lower-cases a word and reduces Russian words to their stem. Other
+// words are only lower-cased.
+func Stem(word string) string {
+	w := []rune(strings.ToLower(word))
+	cyrillic := true
+	for i, r := range w {
+		if r == 'ё' {
+			w[i] = 'е'
+		}
+		if !unicode.Is(unicode.Cyrillic, r) {
+			cyrillic = false
+		}
+	}
+	if cyrillic {
+		w = stemRussian(w)
+	}
+	return string(w)
+}
+
+// IndexText returns the stems of text joined by spaces, which is what the
+// index stores instead of the raw text.
+func IndexText(text string) string {
+	tokens := Tokenize(text)
+	stems := make([]string, len(tokens))
+	for i, tok := range tokens {
+		stems[i] = tok.Stem
+	}
+	return strings.Join(stems, " ")
+}
+
+// Terms returns the distinct stems of a search query.
+func Terms(query string) []string {
+	seen := make(map[string]bool)
+	terms := make([]string, 0)
+	for _, tok := range Tokenize(query) {
+		if !seen[tok.Stem] {
+			seen[tok.Stem] = true
+			terms = append(terms, tok.Stem)
+		}
+	}
+	return terms
+}
+
+// MatchExpr builds an index query that requires every term as a prefix, so
+// "задач" also finds "задачник". Terms only hold letters and digits, so the
+// bare prefix syntax is valid for both FTS4 and FTS5.
+func MatchExpr(terms []string) string {
+	parts := make([]string, len(terms))
+	for i, term := range terms {
+		parts[i] = term + "*"
+	}
+	return strings.Join(parts, " ")
+}
+
+func matches(stem string, terms []string) bool {
+	for _, term := range terms {
+		if strings.HasPrefix(stem, term) {
+			return true
+		}
+	}
+	return false
+}
+
+// Count returns how many words of text match one of terms.
+func Count(text string, terms []string) int {
+	n := 0
+	for _, tok := range Tokenize(text) {
+		if matches(tok.Stem, terms) {
+			n++
+		}
+	}
+	return n
+}
+
+// Snippet returns HTML-escaped text with matching words wrapped in <mark>.
+// Text longer than maxWords is cut to a window around the first match, with
+// "…" marking the cuts. Pass maxWords <= 0 to keep the whole text.
+func Snippet(text string, terms []string, maxWords int) string {
+	tokens := Tokenize(text)
+	from, to := 0, len(tokens)
+	if maxWords > 0 && len(tokens) > maxWords {
+		first := 0
+		for i, tok := range tokens {
+			if matches(tok.Stem, terms) {
+				first = i
+				break
+			}
+		}
+		from = max(0, first-maxWords/3)
+		to = min(len(tokens), from+maxWords)
+		from = max(0, to-maxWords)
+	}
+	if len(tokens) == 0 {
+		return html.EscapeString(strings.TrimSpace(text))
+	}
+
+	start, end := 0, len(text)
+	if from > 0 {
+		start = tokens[from].Start
+	}
+	if to < len(tokens) {
+		end = tokens[to-1].End
+	}
+
+	var b strings.Builder
+	if from > 0 {
+		b.WriteString("…")
+	}
+	pos := start
+	for _, tok := range tokens[from:to] {
+		if !matches(tok.Stem, terms) {
+			continue
+		}
+		b.WriteString(html.EscapeString(text[pos:tok.Start]))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(text[tok.Start:tok.End]))
+		b.WriteString("</mark>")
+		pos = tok.End
+	}
+	b.WriteString(html.EscapeString(text[pos:end]))
+	if to < len(tokens) {
+		b.WriteString("…")
+	}
+	return strings.TrimSpace(b.String())
+}
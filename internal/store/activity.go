@@ -0,0 +1,117 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// Task event types recorded in task_events.
+const (
+	EventTaskCreated       = "created"
+	EventStatusChanged     = "status_changed"
+	EventDescriptionEdited = "description_edited"
+	EventDatesChanged      = "dates_changed"
+	EventProjectMoved      = "project_moved"
+	EventAssigned          = "assigned"
+	EventUnassigned        = "unassigned"
+	EventCommentAdded      = "comment_added"
+	EventCommentDeleted    = "comment_deleted"
+	// EventTaskDeleted replaces the history of a deleted task.
+	EventTaskDeleted = "deleted"
+	// EventWorkflowChanged is about a whole project and has no task (TaskID
+	// is 0).
+	EventWorkflowChanged = "workflow_changed"
+)
+
+type TaskEvent struct {
+	ID         int64           `json:"id"`
+	TaskID     int64           `json:"taskId"`
+	ActorID    int64           `json:"actorId,omitempty"`
+	ActorEmail string          `json:"actorEmail,omitempty"`
+	Type       string          `json:"type"`
+	Source     string          `json:"source,omitempty"`
+	Data       json.RawMessage `json:"data"`
+	CreatedAt  time.Time       `json:"createdAt"`
+}
+
+const taskEventSelect = `SELECT e.id, e.task_id, e.actor_id, u.email, e.type, e.source, e.data, e.created_at
+	FROM task_events e
+	LEFT JOIN users u ON e.actor_id = u.id`
+
+func scanTaskEvent(row rowScanner) (TaskEvent, error) {
+	var e TaskEvent
+	var actor sql.NullInt64
+	var email sql.NullString
+	var data string
+	if err := row.Scan(&e.ID, &e.TaskID, &actor, &email, &e.Type, &e.Source, &data, &e.CreatedAt); err != nil {
+		return e, err
+	}
+	e.CreatedAt = e.CreatedAt.UTC()
+	if actor.Valid {
+		e.ActorID = actor.Int64
+	}
+	if email.Valid {
+		e.ActorEmail = email.String
+	}
+	e.Data = json.RawMessage(data)
+	return e, nil
+}
+
+func (s *Store) queryTaskEvents(query string, args ...any) ([]TaskEvent, error) {
+	rows, err := s.db.Query(taskEventSelect+" "+query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := make([]TaskEvent, 0)
+	for rows.Next() {
+		e, err := scanTaskEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+func (s *Store) ListTaskEvents(taskID int64) ([]TaskEvent, error) {
+	return s.queryTaskEvents(`WHERE e.task_id = ? ORDER BY e.created_at ASC, e.id ASC`, taskID)
+}
+
+// ListTaskEventsAfter returns up to limit events of all tasks recorded after
+// the event afterID, oldest first. It lets consumers follow the history with
+// a cursor.
+func (s *Store) ListTaskEventsAfter(afterID int64, limit int) ([]TaskEvent, error) {
+	return s.queryTaskEvents(`WHERE e.id > ? ORDER BY e.id ASC LIMIT ?`, afterID, limit)
+}
+
+// ListProjectStatusChanges returns the status changes of a project's tasks,
+// oldest first.
+func (s *Store) ListProjectStatusChanges(projectID int64) ([]TaskEvent, error) {
+	return s.queryTaskEvents(`JOIN tasks t ON t.id = e.task_id WHERE t.project_id = ? AND e.type = ? ORDER BY e.id ASC`, projectID, EventStatusChanged)
+}
+
+// LatestTaskEventID returns the id of the newest event, 0 if there are none.
+func (s *Store) LatestTaskEventID() (int64, error) {
+	var id int64
+	err := s.db.QueryRow(`SELECT COALESCE(MAX(id), 0) FROM task_events`).Scan(&id)
+	return id, err
+}
+
+func (s *Store) recordEvent(tx *sql.Tx, taskID, actorID int64, eventType string, data map[string]any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		`INSERT INTO task_events (task_id, actor_id, type, source, data) VALUES (?, ?, ?, ?, ?)`,
+		taskID,
+		nullableInt64(actorID),
+		eventType,
+		s.source,
+		string(payload),
+	)
+	return err
+}
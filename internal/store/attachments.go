@@ -0,0 +1,89 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+// TaskAttachment is a file kept with a task, such as an attachment of the
+// email the task or one of its comments came from. Data is only loaded by
+// GetTaskAttachment.
+type TaskAttachment struct {
+	ID          int64     `json:"id"`
+	TaskID      int64     `json:"taskId"`
+	CommentID   int64     `json:"commentId,omitempty"`
+	FileName    string    `json:"fileName"`
+	ContentType string    `json:"contentType"`
+	Size        int64     `json:"size"`
+	CreatedBy   int64     `json:"createdBy"`
+	CreatedAt   time.Time `json:"createdAt"`
+	Data        []byte    `json:"-"`
+}
+
+const attachmentColumns = `id, task_id, comment_id, file_name, content_type, size, created_by, created_at`
+
+func scanAttachment(row rowScanner, extra ...any) (TaskAttachment, error) {
+	var a TaskAttachment
+	var commentID sql.NullInt64
+	dest := append([]any{&a.ID, &a.TaskID, &commentID, &a.FileName, &a.ContentType, &a.Size, &a.CreatedBy, &a.CreatedAt}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return a, err
+	}
+	a.CommentID = commentID.Int64
+	a.CreatedAt = a.CreatedAt.UTC()
+	return a, nil
+}
+
+// addTaskAttachmentTx stores a file with a task. commentID is 0 when the
+// file does not belong to a comment.
+func addTaskAttachmentTx(tx *sql.Tx, taskID, commentID int64, fileName, contentType string, data []byte, createdBy int64) (int64, error) {
+	var comment any
+	if commentID != 0 {
+		comment = commentID
+	}
+	res, err := tx.Exec(
+		`INSERT INTO task_attachments (task_id, comment_id, file_name, content_type, size, data, created_by, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		taskID,
+		comment,
+		fileName,
+		contentType,
+		len(data),
+		data,
+		createdBy,
+		time.Now().UTC(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// ListTaskAttachments returns a task's files without their data, oldest
+// first.
+func (s *Store) ListTaskAttachments(taskID int64) ([]TaskAttachment, error) {
+	rows, err := s.db.Query(`SELECT `+attachmentColumns+` FROM task_attachments WHERE task_id = ? ORDER BY id`, taskID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	attachments := make([]TaskAttachment, 0)
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, a)
+	}
+	return attachments, rows.Err()
+}
+
+// GetTaskAttachment returns a file with its data.
+func (s *Store) GetTaskAttachment(id int64) (TaskAttachment, error) {
+	var data []byte
+	a, err := scanAttachment(s.db.QueryRow(`SELECT `+attachmentColumns+`, data FROM task_attachments WHERE id = ?`, id), &data)
+	if err != nil {
+		return a, err
+	}
+	a.Data = data
+	return a, nil
+}
@@ -0,0 +1,181 @@
+package store
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Purposes of the tokens sent by email.
+const (
+	TokenPasswordReset = "password_reset"
+	TokenEmailVerify   = "email_verify"
+)
+
+const (
+	PasswordResetTTL = time.Hour
+	EmailVerifyTTL   = 48 * time.Hour
+	// authTokenCooldown is how soon another token of the same purpose can
+	// be requested, so the endpoints cannot be used to flood a mailbox.
+	authTokenCooldown = time.Minute
+)
+
+var (
+	ErrInvalidToken  = errors.New("invalid or expired token")
+	ErrTokenCooldown = errors.New("a token was requested moments ago")
+)
+
+func hashAuthToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateAuthToken issues a single-use token for purpose that expires after
+// ttl. Earlier tokens of the user for the same purpose stop working. Only a
+// hash of the token is stored.
+func (s *Store) CreateAuthToken(userID int64, purpose string, ttl time.Duration) (string, time.Time, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", time.Time{}, err
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+	now := time.Now().UTC()
+	expiresAt := now.Add(ttl)
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	var recent int
+	if err := tx.QueryRow(
+		`SELECT COUNT(*) FROM auth_tokens WHERE user_id = ? AND purpose = ? AND created_at > ?`,
+		userID,
+		purpose,
+		now.Add(-authTokenCooldown),
+	).Scan(&recent); err != nil {
+		return "", time.Time{}, err
+	}
+	if recent > 0 {
+		return "", time.Time{}, ErrTokenCooldown
+	}
+	if _, err := tx.Exec(`DELETE FROM auth_tokens WHERE (user_id = ? AND purpose = ?) OR expires_at <= ?`, userID, purpose, now); err != nil {
+		return "", time.Time{}, err
+	}
+	if _, err := tx.Exec(
+		`INSERT INTO auth_tokens (token_hash, user_id, purpose, expires_at, created_at) VALUES (?, ?, ?, ?, ?)`,
+		hashAuthToken(token),
+		userID,
+		purpose,
+		expiresAt,
+		now,
+	); err != nil {
+		return "", time.Time{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+// consumeAuthToken deletes a token and returns its user, or ErrInvalidToken
+// when it is unknown, expired or meant for another purpose.
+func consumeAuthToken(tx *sql.Tx, token, purpose string) (int64, error) {
+	var userID int64
+	var expiresAt time.Time
+	err := tx.QueryRow(`SELECT user_id, expires_at FROM auth_tokens WHERE token_hash = ? AND purpose = ?`, hashAuthToken(token), purpose).
+		Scan(&userID, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrInvalidToken
+	}
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(`DELETE FROM auth_tokens WHERE user_id = ? AND purpose = ?`, userID, purpose); err != nil {
+		return 0, err
+	}
+	if !time.Now().UTC().Before(expiresAt) {
+		return 0, ErrInvalidToken
+	}
+	return userID, nil
+}
+
+// ResetPassword consumes a password reset token and sets the new password,
+// which signs the user out everywhere. Receiving the email also proves the
+// address, so it counts as verified.
+func (s *Store) ResetPassword(token, password string) (User, error) {
+	if len(password) < 6 {
+		return User{}, errors.New("password too short")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return User{}, err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return User{}, err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	userID, err := consumeAuthToken(tx, token, TokenPasswordReset)
+	if errors.Is(err, ErrInvalidToken) {
+		// Commit the cleanup so an expired token is gone either way.
+		if err := tx.Commit(); err != nil {
+			return User{}, err
+		}
+		return User{}, ErrInvalidToken
+	}
+	if err != nil {
+		return User{}, err
+	}
+	if _, err := tx.Exec(`UPDATE users SET password_hash = ?, email_verified = 1 WHERE id = ?`, string(hash), userID); err != nil {
+		return User{}, err
+	}
+	if _, err := tx.Exec(`DELETE FROM sessions WHERE user_id = ?`, userID); err != nil {
+		return User{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return User{}, err
+	}
+	return s.GetUserByID(userID)
+}
+
+// VerifyEmail consumes an email verification token and marks the address
+// verified.
+func (s *Store) VerifyEmail(token string) (User, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return User{}, err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	userID, err := consumeAuthToken(tx, token, TokenEmailVerify)
+	if errors.Is(err, ErrInvalidToken) {
+		if err := tx.Commit(); err != nil {
+			return User{}, err
+		}
+		return User{}, ErrInvalidToken
+	}
+	if err != nil {
+		return User{}, err
+	}
+	if _, err := tx.Exec(`UPDATE users SET email_verified = 1 WHERE id = ?`, userID); err != nil {
+		return User{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return User{}, err
+	}
+	return s.GetUserByID(userID)
+}
+
+func (s *Store) SetEmailVerified(userID int64, verified bool) error {
+	_, err := s.db.Exec(`UPDATE users SET email_verified = ? WHERE id = ?`, verified, userID)
+	return err
+}
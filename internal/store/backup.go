@@ -0,0 +1,133 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+var ErrInvalidSnapshot = errors.New("invalid snapshot")
+
+// Backup writes a consistent copy of the database to dest using SQLite's
+// online backup API, so it is safe while the server keeps serving requests.
+// The file appears atomically once complete.
+func (s *Store) Backup(ctx context.Context, dest string) error {
+	return copyDatabase(ctx, s.db, dest)
+}
+
+// CopyDatabase copies the SQLite database at src to dest with the online
+// backup API. src is opened read-only.
+func CopyDatabase(ctx context.Context, src, dest string) error {
+	db, err := sql.Open("sqlite3", "file:"+src+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return copyDatabase(ctx, db, dest)
+}
+
+func copyDatabase(ctx context.Context, src *sql.DB, dest string) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return err
+	}
+	tmp := dest + ".tmp"
+	_ = os.Remove(tmp)
+
+	destDB, err := sql.Open("sqlite3", tmp)
+	if err != nil {
+		return err
+	}
+	err = backupInto(ctx, src, destDB)
+	if closeErr := destDB.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dest)
+}
+
+func backupInto(ctx context.Context, src, dest *sql.DB) error {
+	srcConn, err := src.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+	destConn, err := dest.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer destConn.Close()
+
+	return destConn.Raw(func(destRaw any) error {
+		return srcConn.Raw(func(srcRaw any) error {
+			destSQLite, ok := destRaw.(*sqlite3.SQLiteConn)
+			if !ok {
+				return errors.New("backup: unexpected driver connection")
+			}
+			srcSQLite, ok := srcRaw.(*sqlite3.SQLiteConn)
+			if !ok {
+				return errors.New("backup: unexpected driver connection")
+			}
+			b, err := destSQLite.Backup("main", srcSQLite, "main")
+			if err != nil {
+				return err
+			}
+			if _, err := b.Step(-1); err != nil {
+				b.Finish() //nolint:errcheck
+				return err
+			}
+			return b.Finish()
+		})
+	})
+}
+
+// ValidateSnapshot checks that the file at path is an intact LiteTask
+// database this build can run, and returns its schema version. Version 0
+// means a snapshot taken before migrations were tracked; it is brought up to
+// date on the next start.
+func ValidateSnapshot(path string) (int, error) {
+	if _, err := os.Stat(path); err != nil {
+		return 0, err
+	}
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	var check string
+	if err := db.QueryRow(`PRAGMA quick_check`).Scan(&check); err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+	}
+	if check != "ok" {
+		return 0, fmt.Errorf("%w: integrity check failed: %s", ErrInvalidSnapshot, check)
+	}
+	var tables int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name IN ('tasks', 'users', 'projects')`).Scan(&tables); err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+	}
+	if tables != 3 {
+		return 0, fmt.Errorf("%w: not a LiteTask database", ErrInvalidSnapshot)
+	}
+
+	states, err := migrationStatus(db)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+	}
+	for _, st := range states {
+		if st.Unknown {
+			return 0, fmt.Errorf("%w: version %d (%s)", ErrSchemaTooNew, st.Version, st.Name)
+		}
+		if st.Modified {
+			return 0, fmt.Errorf("%w: %d_%s", ErrMigrationChecksum, st.Version, st.Name)
+		}
+	}
+	return schemaVersion(db)
+}
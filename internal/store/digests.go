@@ -0,0 +1,222 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// Digest frequencies.
+const (
+	DigestOff    = "off"
+	DigestDaily  = "daily"
+	DigestWeekly = "weekly"
+)
+
+var ErrInvalidDigest = errors.New("invalid digest settings")
+
+// DigestSettings is the schedule and delivery of a project's digest. Hour and
+// Weekday (0 is Sunday, weekly digests only) are in TimeZone.
+type DigestSettings struct {
+	ProjectID int64  `json:"projectId"`
+	Frequency string `json:"frequency"`
+	Hour      int    `json:"hour"`
+	Weekday   int    `json:"weekday"`
+	TimeZone  string `json:"timeZone"`
+	// StaleDays is how long a task may stay in progress before the digest
+	// lists it as stuck.
+	StaleDays int      `json:"staleDays"`
+	Telegram  bool     `json:"telegram"`
+	Emails    []string `json:"emails"`
+	// LastSentAt is when the digest last went out, or when it was scheduled
+	// if it never did.
+	LastSentAt *time.Time `json:"lastSentAt,omitempty"`
+}
+
+// DefaultDigestSettings is what a project without saved settings has.
+func DefaultDigestSettings(projectID int64) DigestSettings {
+	return DigestSettings{
+		ProjectID: projectID,
+		Frequency: DigestOff,
+		Hour:      9,
+		Weekday:   int(time.Monday),
+		TimeZone:  "UTC",
+		StaleDays: 3,
+		Telegram:  true,
+		Emails:    []string{},
+	}
+}
+
+// Validate checks the settings and normalizes the email addresses.
+func (d *DigestSettings) Validate() error {
+	switch d.Frequency {
+	case DigestOff, DigestDaily, DigestWeekly:
+	default:
+		return fmt.Errorf("%w: frequency must be off, daily or weekly", ErrInvalidDigest)
+	}
+	if d.Hour < 0 || d.Hour > 23 {
+		return fmt.Errorf("%w: hour must be between 0 and 23", ErrInvalidDigest)
+	}
+	if d.Weekday < 0 || d.Weekday > 6 {
+		return fmt.Errorf("%w: weekday must be between 0 (Sunday) and 6", ErrInvalidDigest)
+	}
+	d.TimeZone = strings.TrimSpace(d.TimeZone)
+	if d.TimeZone == "" {
+		d.TimeZone = "UTC"
+	}
+	if _, err := time.LoadLocation(d.TimeZone); err != nil {
+		return fmt.Errorf("%w: unknown time zone %q", ErrInvalidDigest, d.TimeZone)
+	}
+	if d.StaleDays < 1 || d.StaleDays > 365 {
+		return fmt.Errorf("%w: staleDays must be between 1 and 365", ErrInvalidDigest)
+	}
+	emails := make([]string, 0, len(d.Emails))
+	for _, e := range d.Emails {
+		addr, err := mail.ParseAddress(strings.TrimSpace(e))
+		if err != nil {
+			return fmt.Errorf("%w: invalid email %q", ErrInvalidDigest, e)
+		}
+		emails = append(emails, addr.Address)
+	}
+	d.Emails = emails
+	return nil
+}
+
+// Location returns the digest's time zone, UTC if it cannot be loaded.
+func (d DigestSettings) Location() *time.Location {
+	loc, err := time.LoadLocation(d.TimeZone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// Period is how far back a digest looks for new tasks.
+func (d DigestSettings) Period() time.Duration {
+	if d.Frequency == DigestWeekly {
+		return 7 * 24 * time.Hour
+	}
+	return 24 * time.Hour
+}
+
+// ScheduledBefore returns the latest time at or before now the digest is
+// scheduled for.
+func (d DigestSettings) ScheduledBefore(now time.Time) time.Time {
+	local := now.In(d.Location())
+	at := time.Date(local.Year(), local.Month(), local.Day(), d.Hour, 0, 0, 0, local.Location())
+	if at.After(local) {
+		at = at.AddDate(0, 0, -1)
+	}
+	if d.Frequency == DigestWeekly {
+		for int(at.Weekday()) != d.Weekday {
+			at = at.AddDate(0, 0, -1)
+		}
+	}
+	return at
+}
+
+// Due reports whether the digest should be sent at now: a scheduled time
+// has passed since it was last sent. A digest missed while the server was
+// down is sent once when it is back.
+func (d DigestSettings) Due(now time.Time) bool {
+	if d.Frequency == DigestOff || d.LastSentAt == nil {
+		return false
+	}
+	return d.ScheduledBefore(now).After(*d.LastSentAt)
+}
+
+const digestColumns = `project_id, frequency, hour, weekday, time_zone, stale_days, telegram, emails, last_sent_at`
+
+func scanDigestSettings(row rowScanner) (DigestSettings, error) {
+	var d DigestSettings
+	var emails string
+	var lastSent sql.NullTime
+	if err := row.Scan(&d.ProjectID, &d.Frequency, &d.Hour, &d.Weekday, &d.TimeZone, &d.StaleDays, &d.Telegram, &emails, &lastSent); err != nil {
+		return d, err
+	}
+	d.Emails = splitList(emails)
+	if lastSent.Valid {
+		t := lastSent.Time.UTC()
+		d.LastSentAt = &t
+	}
+	return d, nil
+}
+
+// GetDigestSettings returns the digest settings of a project, the defaults
+// if none were saved. It returns sql.ErrNoRows when the project does not
+// exist.
+func (s *Store) GetDigestSettings(projectID int64) (DigestSettings, error) {
+	d, err := scanDigestSettings(s.db.QueryRow(`SELECT `+digestColumns+` FROM project_digests WHERE project_id = ?`, projectID))
+	if !errors.Is(err, sql.ErrNoRows) {
+		return d, err
+	}
+	ok, err := s.ProjectExists(projectID)
+	if err != nil {
+		return DigestSettings{}, err
+	}
+	if !ok {
+		return DigestSettings{}, sql.ErrNoRows
+	}
+	return DefaultDigestSettings(projectID), nil
+}
+
+// ListActiveDigests returns the settings of the digests that are not off.
+func (s *Store) ListActiveDigests() ([]DigestSettings, error) {
+	rows, err := s.db.Query(`SELECT `+digestColumns+` FROM project_digests WHERE frequency != ? ORDER BY project_id`, DigestOff)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	digests := make([]DigestSettings, 0)
+	for rows.Next() {
+		d, err := scanDigestSettings(rows)
+		if err != nil {
+			return nil, err
+		}
+		digests = append(digests, d)
+	}
+	return digests, rows.Err()
+}
+
+// SetDigestSettings validates and saves a project's digest settings. The
+// schedule starts from now: the first digest goes out at the next scheduled
+// time rather than right away.
+func (s *Store) SetDigestSettings(d DigestSettings) (DigestSettings, error) {
+	if err := d.Validate(); err != nil {
+		return DigestSettings{}, err
+	}
+	ok, err := s.ProjectExists(d.ProjectID)
+	if err != nil {
+		return DigestSettings{}, err
+	}
+	if !ok {
+		return DigestSettings{}, sql.ErrNoRows
+	}
+	if _, err := s.db.Exec(
+		`INSERT INTO project_digests (project_id, frequency, hour, weekday, time_zone, stale_days, telegram, emails, last_sent_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(project_id) DO UPDATE SET frequency = excluded.frequency, hour = excluded.hour, weekday = excluded.weekday,
+			time_zone = excluded.time_zone, stale_days = excluded.stale_days, telegram = excluded.telegram, emails = excluded.emails,
+			last_sent_at = excluded.last_sent_at`,
+		d.ProjectID,
+		d.Frequency,
+		d.Hour,
+		d.Weekday,
+		d.TimeZone,
+		d.StaleDays,
+		d.Telegram,
+		strings.Join(d.Emails, ","),
+		time.Now().UTC(),
+	); err != nil {
+		return DigestSettings{}, err
+	}
+	return s.GetDigestSettings(d.ProjectID)
+}
+
+func (s *Store) MarkDigestSent(projectID int64, at time.Time) error {
+	_, err := s.db.Exec(`UPDATE project_digests SET last_sent_at = ? WHERE project_id = ?`, at.UTC(), projectID)
+	return err
+}
@@ -0,0 +1,194 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// EmailPrefs are the notification emails a user opted in to: being assigned
+// to a task, comments on tasks they created or are assigned to, and
+// mentions by @username in comments.
+type EmailPrefs struct {
+	Assigned bool `json:"assigned"`
+	Comments bool `json:"comments"`
+	Mentions bool `json:"mentions"`
+}
+
+// GetEmailPrefs returns the user's email preferences, all off if they never
+// set them.
+func (s *Store) GetEmailPrefs(userID int64) (EmailPrefs, error) {
+	var p EmailPrefs
+	err := s.db.QueryRow(`SELECT assigned, comments, mentions FROM email_prefs WHERE user_id = ?`, userID).
+		Scan(&p.Assigned, &p.Comments, &p.Mentions)
+	if errors.Is(err, sql.ErrNoRows) {
+		return EmailPrefs{}, nil
+	}
+	return p, err
+}
+
+func (s *Store) SetEmailPrefs(userID int64, p EmailPrefs) (EmailPrefs, error) {
+	if _, err := s.db.Exec(
+		`INSERT INTO email_prefs (user_id, assigned, comments, mentions) VALUES (?, ?, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET assigned = excluded.assigned, comments = excluded.comments, mentions = excluded.mentions`,
+		userID,
+		p.Assigned,
+		p.Comments,
+		p.Mentions,
+	); err != nil {
+		return EmailPrefs{}, err
+	}
+	return s.GetEmailPrefs(userID)
+}
+
+// OutboxEmail is an email waiting in or sent from the outbox. Its states are
+// those of webhook deliveries.
+type OutboxEmail struct {
+	ID            int64      `json:"id"`
+	To            string     `json:"to"`
+	Subject       string     `json:"subject"`
+	ReplyTo       string     `json:"replyTo,omitempty"`
+	Text          string     `json:"-"`
+	HTML          string     `json:"-"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt *time.Time `json:"nextAttemptAt,omitempty"`
+	LastError     string     `json:"lastError,omitempty"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	SentAt        *time.Time `json:"sentAt,omitempty"`
+}
+
+const outboxColumns = `id, recipient, subject, reply_to, text_body, html_body, status, attempts, next_attempt_at, last_error, created_at, sent_at`
+
+func scanOutbox(rows *sql.Rows) ([]OutboxEmail, error) {
+	emails := make([]OutboxEmail, 0)
+	for rows.Next() {
+		var e OutboxEmail
+		var next sql.NullTime
+		var sent sql.NullTime
+		if err := rows.Scan(&e.ID, &e.To, &e.Subject, &e.ReplyTo, &e.Text, &e.HTML, &e.Status, &e.Attempts, &next, &e.LastError, &e.CreatedAt, &sent); err != nil {
+			return nil, err
+		}
+		e.CreatedAt = e.CreatedAt.UTC()
+		if next.Valid {
+			t := next.Time.UTC()
+			e.NextAttemptAt = &t
+		}
+		if sent.Valid {
+			t := sent.Time.UTC()
+			e.SentAt = &t
+		}
+		emails = append(emails, e)
+	}
+	return emails, rows.Err()
+}
+
+// EnqueueEmail adds an email for one recipient to the outbox, due at once.
+// replyTo may be empty. An email with a non-zero expiresAt carries a secret,
+// such as a password reset link: it is given up at expiresAt and its body is
+// erased once it is sent or given up.
+func (s *Store) EnqueueEmail(to, subject, replyTo, text, html string, expiresAt time.Time) (int64, error) {
+	var expires any
+	if !expiresAt.IsZero() {
+		expires = expiresAt.UTC()
+	}
+	res, err := s.db.Exec(
+		`INSERT INTO email_outbox (recipient, subject, reply_to, text_body, html_body, status, next_attempt_at, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		to,
+		subject,
+		replyTo,
+		text,
+		html,
+		DeliveryPending,
+		time.Now().UTC(),
+		expires,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// ExpireEmails gives up the pending emails that expired by now and erases
+// their bodies.
+func (s *Store) ExpireEmails(now time.Time) error {
+	_, err := s.db.Exec(
+		`UPDATE email_outbox
+		SET status = ?, last_error = 'expired', next_attempt_at = NULL, text_body = '', html_body = ''
+		WHERE status = ? AND expires_at <= ?`,
+		DeliveryFailed,
+		DeliveryPending,
+		now.UTC(),
+	)
+	return err
+}
+
+// DueEmails returns pending emails whose next attempt is due.
+func (s *Store) DueEmails(now time.Time, limit int) ([]OutboxEmail, error) {
+	rows, err := s.db.Query(
+		`SELECT `+outboxColumns+` FROM email_outbox
+		WHERE status = ? AND next_attempt_at <= ?
+		ORDER BY next_attempt_at, id
+		LIMIT ?`,
+		DeliveryPending,
+		now.UTC(),
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanOutbox(rows)
+}
+
+// ListOutbox returns the newest emails, only those in status unless it is
+// empty.
+func (s *Store) ListOutbox(status string, limit int) ([]OutboxEmail, error) {
+	query := `SELECT ` + outboxColumns + ` FROM email_outbox`
+	args := make([]any, 0)
+	if status != "" {
+		query += ` WHERE status = ?`
+		args = append(args, status)
+	}
+	rows, err := s.db.Query(query+` ORDER BY id DESC LIMIT ?`, append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanOutbox(rows)
+}
+
+// RecordEmailAttempt stores the outcome of a sending attempt. A nil
+// nextAttempt with a failed attempt gives the email up.
+func (s *Store) RecordEmailAttempt(id int64, sent bool, lastError string, nextAttempt *time.Time) error {
+	status := DeliveryPending
+	var sentAt any
+	switch {
+	case sent:
+		status = DeliveryDelivered
+		sentAt = time.Now().UTC()
+	case nextAttempt == nil:
+		status = DeliveryFailed
+	}
+	var next any
+	if nextAttempt != nil {
+		next = nextAttempt.UTC()
+	}
+	// Expiring emails keep their body only while they may still be sent.
+	done := status != DeliveryPending
+	_, err := s.db.Exec(
+		`UPDATE email_outbox
+		SET status = ?, attempts = attempts + 1, last_error = ?, next_attempt_at = ?, sent_at = ?,
+			text_body = CASE WHEN expires_at IS NOT NULL AND ? THEN '' ELSE text_body END,
+			html_body = CASE WHEN expires_at IS NOT NULL AND ? THEN '' ELSE html_body END
+		WHERE id = ?`,
+		status,
+		lastError,
+		next,
+		sentAt,
+		done,
+		done,
+		id,
+	)
+	return err
+}
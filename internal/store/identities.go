@@ -0,0 +1,38 @@
+package store
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// ErrIdentityLinked is returned when the account is already linked to
+// another identity at the same provider.
+var ErrIdentityLinked = errors.New("account is linked to another identity")
+
+// GetUserByIdentity returns the user linked to the subject at an OpenID
+// Connect issuer, or sql.ErrNoRows.
+func (s *Store) GetUserByIdentity(issuer, subject string) (User, error) {
+	return scanUser(s.db.QueryRow(
+		`SELECT `+userColumns+` FROM users
+		WHERE id = (SELECT user_id FROM user_identities WHERE issuer = ? AND subject = ?)`,
+		issuer,
+		subject,
+	))
+}
+
+// LinkIdentity links the subject at issuer to a user, so that later logins
+// find the account even when the email address changes at the provider.
+func (s *Store) LinkIdentity(userID int64, issuer, subject string) error {
+	_, err := s.db.Exec(
+		`INSERT INTO user_identities (issuer, subject, user_id, created_at) VALUES (?, ?, ?, ?)`,
+		issuer,
+		subject,
+		userID,
+		time.Now().UTC(),
+	)
+	if err != nil && strings.Contains(strings.ToLower(err.Error()), "unique") {
+		return ErrIdentityLinked
+	}
+	return err
+}
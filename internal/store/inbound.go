@@ -0,0 +1,135 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrEmailReceived is returned for an inbound email that was stored before,
+// as when the mail server retries a delivery.
+var ErrEmailReceived = errors.New("email already received")
+
+// NewAttachment is a file to store with a task or comment created from an
+// email.
+type NewAttachment struct {
+	FileName    string
+	ContentType string
+	Data        []byte
+}
+
+// ReceivedEmail is what an inbound email was stored as. CommentID is 0 when
+// it created a task.
+type ReceivedEmail struct {
+	TaskID      int64
+	CommentID   int64
+	Attachments int
+}
+
+// Inbound emails are remembered by Message-ID and the mailbox address they
+// were delivered to, so the same message sent to two projects creates a task
+// in each.
+
+// GetReceivedEmail looks up an email stored before. It returns
+// sql.ErrNoRows for a new one.
+func (s *Store) GetReceivedEmail(messageID, mailbox string) (ReceivedEmail, error) {
+	var r ReceivedEmail
+	err := s.db.QueryRow(
+		`SELECT task_id, comment_id, attachments FROM inbound_emails WHERE message_id = ? AND mailbox = ?`,
+		messageID,
+		strings.ToLower(mailbox),
+	).Scan(&r.TaskID, &r.CommentID, &r.Attachments)
+	return r, err
+}
+
+// InsertEmailTask creates a task with the attachments of an email in one
+// transaction. It returns ErrEmailReceived when the email with messageID
+// was delivered to mailbox before; an empty messageID is never a duplicate.
+func (s *Store) InsertEmailTask(messageID, mailbox, title, description string, projectID, createdBy int64, files []NewAttachment) (Task, error) {
+	var t Task
+	ok, err := s.ProjectExists(projectID)
+	if err != nil {
+		return t, err
+	}
+	if !ok {
+		return t, fmt.Errorf("project not found")
+	}
+	wf, err := s.GetWorkflow(projectID)
+	if err != nil {
+		return t, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return t, err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	id, err := s.insertTaskTx(tx, wf, title, description, projectID, createdBy, nil, nil)
+	if err != nil {
+		return t, err
+	}
+	if err := addEmailFilesTx(tx, id, 0, files, createdBy); err != nil {
+		return t, err
+	}
+	if err := recordReceivedEmailTx(tx, messageID, mailbox, ReceivedEmail{TaskID: id, Attachments: len(files)}); err != nil {
+		return t, err
+	}
+	if err := tx.Commit(); err != nil {
+		return t, err
+	}
+	return s.GetTask(id)
+}
+
+// AddEmailComment adds a comment with the attachments of an email in one
+// transaction. Duplicates are refused like in InsertEmailTask.
+func (s *Store) AddEmailComment(messageID, mailbox string, taskID int64, body string, authorID int64, files []NewAttachment) (TaskComment, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return TaskComment{}, err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	id, err := s.addTaskCommentTx(tx, taskID, body, authorID)
+	if err != nil {
+		return TaskComment{}, err
+	}
+	if err := addEmailFilesTx(tx, taskID, id, files, authorID); err != nil {
+		return TaskComment{}, err
+	}
+	if err := recordReceivedEmailTx(tx, messageID, mailbox, ReceivedEmail{TaskID: taskID, CommentID: id, Attachments: len(files)}); err != nil {
+		return TaskComment{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return TaskComment{}, err
+	}
+	return s.GetTaskComment(id)
+}
+
+func addEmailFilesTx(tx *sql.Tx, taskID, commentID int64, files []NewAttachment, createdBy int64) error {
+	for _, f := range files {
+		if _, err := addTaskAttachmentTx(tx, taskID, commentID, f.FileName, f.ContentType, f.Data, createdBy); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func recordReceivedEmailTx(tx *sql.Tx, messageID, mailbox string, r ReceivedEmail) error {
+	if messageID == "" {
+		return nil
+	}
+	_, err := tx.Exec(
+		`INSERT INTO inbound_emails (message_id, mailbox, task_id, comment_id, attachments) VALUES (?, ?, ?, ?, ?)`,
+		messageID,
+		strings.ToLower(mailbox),
+		r.TaskID,
+		r.CommentID,
+		r.Attachments,
+	)
+	if err != nil && strings.Contains(strings.ToLower(err.Error()), "unique") {
+		return ErrEmailReceived
+	}
+	return err
+}
@@ -0,0 +1,192 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// Project roles, from least to most privileged. Viewers can read a board,
+// members can also change tasks and comment, maintainers can additionally
+// delete tasks, edit the workflow and manage membership. Global admins act
+// as maintainers of every project.
+const (
+	ProjectRoleViewer     = "viewer"
+	ProjectRoleMember     = "member"
+	ProjectRoleMaintainer = "maintainer"
+)
+
+var ErrInvalidProjectRole = errors.New("invalid project role")
+
+var projectRoleRank = map[string]int{
+	ProjectRoleViewer:     1,
+	ProjectRoleMember:     2,
+	ProjectRoleMaintainer: 3,
+}
+
+// ValidProjectRole reports whether role is one of the project roles.
+func ValidProjectRole(role string) bool {
+	_, ok := projectRoleRank[role]
+	return ok
+}
+
+// ProjectRoleAllows reports whether role grants at least the rights of
+// required. An empty role grants nothing.
+func ProjectRoleAllows(role, required string) bool {
+	return role != "" && projectRoleRank[role] >= projectRoleRank[required]
+}
+
+type ProjectMember struct {
+	UserID    int64  `json:"userId"`
+	Email     string `json:"email"`
+	Username  string `json:"username,omitempty"`
+	FirstName string `json:"firstName,omitempty"`
+	LastName  string `json:"lastName,omitempty"`
+	Role      string `json:"role"`
+}
+
+const projectMemberSelect = `SELECT u.id, u.email, COALESCE(u.username, ''), u.first_name, u.last_name, up.role
+	FROM user_projects up
+	JOIN users u ON u.id = up.user_id`
+
+func scanProjectMember(row rowScanner) (ProjectMember, error) {
+	var m ProjectMember
+	err := row.Scan(&m.UserID, &m.Email, &m.Username, &m.FirstName, &m.LastName, &m.Role)
+	return m, err
+}
+
+// GetUserProjectRoles returns the user's role in each project they belong to.
+func (s *Store) GetUserProjectRoles(userID int64) (map[int64]string, error) {
+	rows, err := s.db.Query(`SELECT project_id, role FROM user_projects WHERE user_id = ?`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	roles := make(map[int64]string)
+	for rows.Next() {
+		var pid int64
+		var role string
+		if err := rows.Scan(&pid, &role); err != nil {
+			return nil, err
+		}
+		roles[pid] = role
+	}
+	return roles, rows.Err()
+}
+
+// ListProjectMembers returns the users explicitly added to a project. Admins
+// are not listed unless added.
+func (s *Store) ListProjectMembers(projectID int64) ([]ProjectMember, error) {
+	ok, err := s.ProjectExists(projectID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	rows, err := s.db.Query(projectMemberSelect+` WHERE up.project_id = ? ORDER BY u.email`, projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	members := make([]ProjectMember, 0)
+	for rows.Next() {
+		m, err := scanProjectMember(rows)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
+
+// SetProjectMember adds a user to a project or changes their role there.
+func (s *Store) SetProjectMember(projectID, userID int64, role string) (ProjectMember, error) {
+	if !ValidProjectRole(role) {
+		return ProjectMember{}, ErrInvalidProjectRole
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return ProjectMember{}, err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	ok, err := s.projectExistsTx(tx, projectID)
+	if err != nil {
+		return ProjectMember{}, err
+	}
+	if !ok {
+		return ProjectMember{}, errors.New("project not found")
+	}
+	var exists bool
+	if err := tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM users WHERE id = ?)`, userID).Scan(&exists); err != nil {
+		return ProjectMember{}, err
+	}
+	if !exists {
+		return ProjectMember{}, sql.ErrNoRows
+	}
+	if _, err := tx.Exec(
+		`INSERT INTO user_projects (user_id, project_id, role) VALUES (?, ?, ?)
+		ON CONFLICT(user_id, project_id) DO UPDATE SET role = excluded.role`,
+		userID,
+		projectID,
+		role,
+	); err != nil {
+		return ProjectMember{}, err
+	}
+	m, err := scanProjectMember(tx.QueryRow(projectMemberSelect+` WHERE up.project_id = ? AND up.user_id = ?`, projectID, userID))
+	if err != nil {
+		return ProjectMember{}, err
+	}
+	return m, tx.Commit()
+}
+
+// RemoveProjectMember also unassigns the user from the project's tasks, so
+// they stop showing up as an assignee where they no longer have access.
+func (s *Store) RemoveProjectMember(projectID, userID int64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	res, err := tx.Exec(`DELETE FROM user_projects WHERE project_id = ? AND user_id = ?`, projectID, userID)
+	if err != nil {
+		return err
+	}
+	affected, _ := res.RowsAffected()
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	rows, err := tx.Query(
+		`SELECT task_id FROM task_assignees
+		WHERE user_id = ? AND task_id IN (SELECT id FROM tasks WHERE project_id = ?)
+		ORDER BY task_id`,
+		userID,
+		projectID,
+	)
+	if err != nil {
+		return err
+	}
+	var taskIDs []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		taskIDs = append(taskIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, taskID := range taskIDs {
+		if _, err := tx.Exec(`DELETE FROM task_assignees WHERE task_id = ? AND user_id = ?`, taskID, userID); err != nil {
+			return err
+		}
+		if err := s.recordEvent(tx, taskID, 0, EventUnassigned, map[string]any{"userId": userID}); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
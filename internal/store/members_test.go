@@ -0,0 +1,56 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestRemoveProjectMemberUnassignsTasks(t *testing.T) {
+	s, err := Open(filepath.Join(t.TempDir(), "litetask.db"))
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	u, err := s.CreateUser("ann@example.com", "", "password", "user", "", "")
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	other, err := s.CreateProject("Other")
+	if err != nil {
+		t.Fatalf("create project: %v", err)
+	}
+	var tasks []Task
+	for _, projectID := range []int64{DefaultProjectID, other.ID} {
+		if _, err := s.SetProjectMember(projectID, u.ID, ProjectRoleMember); err != nil {
+			t.Fatalf("set member: %v", err)
+		}
+		task, err := s.InsertTask("Plan", "", projectID, u.ID, nil, nil)
+		if err != nil {
+			t.Fatalf("insert task: %v", err)
+		}
+		if _, err := s.AssignTask(task.ID, u.ID, u.ID); err != nil {
+			t.Fatalf("assign task: %v", err)
+		}
+		tasks = append(tasks, task)
+	}
+
+	if err := s.RemoveProjectMember(DefaultProjectID, u.ID); err != nil {
+		t.Fatalf("remove member: %v", err)
+	}
+	for i, want := range []int{0, 1} {
+		task, err := s.GetTask(tasks[i].ID)
+		if err != nil {
+			t.Fatalf("get task: %v", err)
+		}
+		if len(task.Assignees) != want {
+			t.Errorf("task %d: %d assignees, want %d", task.ID, len(task.Assignees), want)
+		}
+	}
+	evs, err := s.ListTaskEvents(tasks[0].ID)
+	if err != nil {
+		t.Fatalf("list events: %v", err)
+	}
+	if last := evs[len(evs)-1]; last.Type != EventUnassigned {
+		t.Errorf("last event = %q, want %q", last.Type, EventUnassigned)
+	}
+}
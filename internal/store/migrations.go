@@ -0,0 +1,695 @@
+package store
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMigrationChecksum = errors.New("applied migration was modified")
+	ErrSchemaTooNew      = errors.New("database schema is newer than this build")
+)
+
+// migration is one ordered schema change. All of its steps run in a single
+// transaction together with the schema_migrations bookkeeping, so a failed
+// migration leaves no trace. Applied migrations must never be edited: the
+// checksum of their steps is verified on every run. Add a new version
+// instead.
+type migration struct {
+	version int
+	name    string
+	steps   []step
+}
+
+// step is a unit of a migration. desc is what gets checksummed, so it must
+// describe the step completely.
+type step struct {
+	desc string
+	run  func(tx *sql.Tx) error
+}
+
+func execStep(query string) step {
+	return step{desc: query, run: func(tx *sql.Tx) error {
+		_, err := tx.Exec(query)
+		return err
+	}}
+}
+
+// addColumnStep adds a column unless it already exists, so databases created
+// before migrations were tracked can be adopted.
+func addColumnStep(table, column, decl string) step {
+	return step{
+		desc: fmt.Sprintf("ADD COLUMN %s.%s %s", table, column, decl),
+		run: func(tx *sql.Tx) error {
+			var count int
+			if err := tx.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, table, column).Scan(&count); err != nil {
+				return err
+			}
+			if count > 0 {
+				return nil
+			}
+			_, err := tx.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, column, decl))
+			return err
+		},
+	}
+}
+
+func (m migration) checksum() string {
+	h := sha256.New()
+	h.Write([]byte(m.name))
+	for _, st := range m.steps {
+		h.Write([]byte{0})
+		h.Write([]byte(st.desc))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Every statement is idempotent so that databases created by releases that
+// predate schema_migrations are brought in line by simply running them all.
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "baseline",
+		steps: []step{
+			execStep(`
+CREATE TABLE IF NOT EXISTS projects (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL UNIQUE,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE TABLE IF NOT EXISTS users (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	email TEXT NOT NULL UNIQUE,
+	username TEXT,
+	password_hash TEXT NOT NULL,
+	role TEXT NOT NULL DEFAULT 'user',
+	first_name TEXT NOT NULL DEFAULT '',
+	last_name TEXT NOT NULL DEFAULT '',
+	telegram TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE TABLE IF NOT EXISTS tasks (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	title TEXT NOT NULL,
+	status TEXT NOT NULL,
+	comment TEXT DEFAULT '',
+	description TEXT DEFAULT '',
+	project_id INTEGER NOT NULL DEFAULT 1,
+	created_by INTEGER,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY(project_id) REFERENCES projects(id) ON DELETE CASCADE,
+	FOREIGN KEY(created_by) REFERENCES users(id) ON DELETE SET NULL
+);
+CREATE INDEX IF NOT EXISTS idx_tasks_status ON tasks(status);
+CREATE INDEX IF NOT EXISTS idx_tasks_project ON tasks(project_id);
+CREATE TABLE IF NOT EXISTS task_comments (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	task_id INTEGER NOT NULL,
+	author_id INTEGER,
+	body TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY(task_id) REFERENCES tasks(id) ON DELETE CASCADE,
+	FOREIGN KEY(author_id) REFERENCES users(id) ON DELETE SET NULL
+);
+CREATE INDEX IF NOT EXISTS idx_task_comments_task ON task_comments(task_id);
+CREATE TABLE IF NOT EXISTS user_projects (
+	user_id INTEGER NOT NULL,
+	project_id INTEGER NOT NULL,
+	PRIMARY KEY (user_id, project_id),
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE,
+	FOREIGN KEY(project_id) REFERENCES projects(id) ON DELETE CASCADE
+);`),
+			addColumnStep("users", "username", "TEXT"),
+			execStep(`CREATE UNIQUE INDEX IF NOT EXISTS idx_users_username ON users(username) WHERE username IS NOT NULL AND username != ''`),
+			addColumnStep("tasks", "comment", "TEXT DEFAULT ''"),
+			addColumnStep("tasks", "project_id", "INTEGER NOT NULL DEFAULT 1"),
+			addColumnStep("tasks", "description", "TEXT DEFAULT ''"),
+			addColumnStep("tasks", "created_by", "INTEGER"),
+			addColumnStep("users", "telegram", "TEXT NOT NULL DEFAULT ''"),
+			addColumnStep("users", "first_name", "TEXT NOT NULL DEFAULT ''"),
+			addColumnStep("users", "last_name", "TEXT NOT NULL DEFAULT ''"),
+			execStep(`UPDATE tasks SET project_id = 1 WHERE project_id IS NULL OR project_id = 0`),
+			execStep(`UPDATE tasks SET description = comment WHERE (description IS NULL OR description = '') AND comment IS NOT NULL AND comment != ''`),
+		},
+	},
+	{
+		version: 2,
+		name:    "task_assignees",
+		steps: []step{
+			execStep(`
+CREATE TABLE IF NOT EXISTS task_assignees (
+	task_id INTEGER NOT NULL,
+	user_id INTEGER NOT NULL,
+	assigned_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (task_id, user_id),
+	FOREIGN KEY(task_id) REFERENCES tasks(id) ON DELETE CASCADE,
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_task_assignees_user ON task_assignees(user_id);`),
+		},
+	},
+	{
+		version: 3,
+		name:    "project_workflows",
+		steps: []step{
+			execStep(`
+CREATE TABLE IF NOT EXISTS project_statuses (
+	project_id INTEGER NOT NULL,
+	key TEXT NOT NULL,
+	title TEXT NOT NULL,
+	position INTEGER NOT NULL DEFAULT 0,
+	is_final INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (project_id, key),
+	FOREIGN KEY(project_id) REFERENCES projects(id) ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS project_transitions (
+	project_id INTEGER NOT NULL,
+	from_status TEXT NOT NULL,
+	to_status TEXT NOT NULL,
+	PRIMARY KEY (project_id, from_status, to_status),
+	FOREIGN KEY(project_id) REFERENCES projects(id) ON DELETE CASCADE
+);`),
+		},
+	},
+	{
+		version: 4,
+		name:    "task_dates",
+		steps: []step{
+			addColumnStep("tasks", "start_date", "DATE"),
+			addColumnStep("tasks", "due_date", "DATE"),
+			execStep(`CREATE INDEX IF NOT EXISTS idx_tasks_due_date ON tasks(due_date)`),
+		},
+	},
+	{
+		version: 5,
+		name:    "task_events",
+		steps: []step{
+			execStep(`
+CREATE TABLE IF NOT EXISTS task_events (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	task_id INTEGER NOT NULL,
+	actor_id INTEGER,
+	type TEXT NOT NULL,
+	data TEXT NOT NULL DEFAULT '{}',
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY(task_id) REFERENCES tasks(id) ON DELETE CASCADE,
+	FOREIGN KEY(actor_id) REFERENCES users(id) ON DELETE SET NULL
+);
+CREATE INDEX IF NOT EXISTS idx_task_events_task ON task_events(task_id);`),
+		},
+	},
+	{
+		version: 6,
+		name:    "webhooks",
+		steps: []step{
+			execStep(`
+CREATE TABLE IF NOT EXISTS webhooks (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	project_id INTEGER NOT NULL,
+	url TEXT NOT NULL,
+	secret TEXT NOT NULL,
+	events TEXT NOT NULL DEFAULT '',
+	active INTEGER NOT NULL DEFAULT 1,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY(project_id) REFERENCES projects(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_webhooks_project ON webhooks(project_id);
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	webhook_id INTEGER NOT NULL,
+	event_type TEXT NOT NULL,
+	payload TEXT NOT NULL,
+	status TEXT NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at TIMESTAMP,
+	response_code INTEGER NOT NULL DEFAULT 0,
+	last_error TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	delivered_at TIMESTAMP,
+	FOREIGN KEY(webhook_id) REFERENCES webhooks(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook ON webhook_deliveries(webhook_id);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries(status, next_attempt_at);`),
+		},
+	},
+	{
+		version: 7,
+		name:    "api_tokens",
+		steps: []step{
+			execStep(`
+CREATE TABLE IF NOT EXISTS api_tokens (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	token_hash TEXT NOT NULL UNIQUE,
+	hint TEXT NOT NULL DEFAULT '',
+	read_only INTEGER NOT NULL DEFAULT 0,
+	expires_at TIMESTAMP,
+	last_used_at TIMESTAMP,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_api_tokens_user ON api_tokens(user_id);`),
+		},
+	},
+	{
+		version: 8,
+		name:    "sessions",
+		steps: []step{
+			execStep(`
+CREATE TABLE IF NOT EXISTS sessions (
+	id TEXT PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	user_agent TEXT NOT NULL DEFAULT '',
+	ip TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP NOT NULL,
+	last_seen_at TIMESTAMP NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions(user_id);`),
+		},
+	},
+	{
+		version: 9,
+		name:    "search_index",
+		steps: []step{
+			execStep(`
+CREATE TABLE IF NOT EXISTS search_docs (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	task_id INTEGER NOT NULL,
+	comment_id INTEGER NOT NULL DEFAULT 0,
+	UNIQUE(task_id, comment_id)
+);`),
+			{desc: "CREATE VIRTUAL TABLE search_index USING fts5(title, body) or fts4(title, body)", run: createSearchIndex},
+			{desc: "index existing tasks and comments", run: reindexAllTx},
+		},
+	},
+	{
+		version: 10,
+		name:    "project_roles",
+		steps: []step{
+			addColumnStep("user_projects", "role", "TEXT NOT NULL DEFAULT 'member'"),
+		},
+	},
+	{
+		version: 11,
+		name:    "telegram_links",
+		steps: []step{
+			addColumnStep("users", "telegram_id", "INTEGER"),
+			execStep(`
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_telegram_id ON users(telegram_id) WHERE telegram_id IS NOT NULL;
+CREATE TABLE IF NOT EXISTS telegram_link_codes (
+	code_hash TEXT PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);`),
+		},
+	},
+	{
+		version: 12,
+		name:    "notification_prefs",
+		steps: []step{
+			addColumnStep("users", "notify_assigned", "INTEGER NOT NULL DEFAULT 0"),
+			addColumnStep("users", "notify_comments", "INTEGER NOT NULL DEFAULT 0"),
+			addColumnStep("users", "notify_status", "INTEGER NOT NULL DEFAULT 0"),
+		},
+	},
+	{
+		version: 13,
+		name:    "telegram_chats",
+		steps: []step{
+			execStep(`CREATE TABLE IF NOT EXISTS telegram_chats (
+	chat_id INTEGER PRIMARY KEY,
+	project_id INTEGER NOT NULL,
+	title TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY(project_id) REFERENCES projects(id) ON DELETE CASCADE
+);`),
+			execStep(`CREATE INDEX IF NOT EXISTS idx_telegram_chats_project ON telegram_chats(project_id);`),
+		},
+	},
+	{
+		version: 14,
+		name:    "project_digests",
+		steps: []step{
+			execStep(`CREATE TABLE IF NOT EXISTS project_digests (
+	project_id INTEGER PRIMARY KEY,
+	frequency TEXT NOT NULL DEFAULT 'off',
+	hour INTEGER NOT NULL DEFAULT 9,
+	weekday INTEGER NOT NULL DEFAULT 1,
+	time_zone TEXT NOT NULL DEFAULT 'UTC',
+	stale_days INTEGER NOT NULL DEFAULT 3,
+	telegram INTEGER NOT NULL DEFAULT 1,
+	emails TEXT NOT NULL DEFAULT '',
+	last_sent_at TIMESTAMP,
+	FOREIGN KEY(project_id) REFERENCES projects(id) ON DELETE CASCADE
+);`),
+		},
+	},
+	{
+		version: 15,
+		name:    "email_notifications",
+		steps: []step{
+			execStep(`
+CREATE TABLE IF NOT EXISTS email_prefs (
+	user_id INTEGER PRIMARY KEY,
+	assigned INTEGER NOT NULL DEFAULT 0,
+	comments INTEGER NOT NULL DEFAULT 0,
+	mentions INTEGER NOT NULL DEFAULT 0,
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS email_outbox (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	recipient TEXT NOT NULL,
+	subject TEXT NOT NULL,
+	text_body TEXT NOT NULL,
+	html_body TEXT NOT NULL DEFAULT '',
+	status TEXT NOT NULL DEFAULT 'pending',
+	attempts INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at TIMESTAMP,
+	last_error TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	sent_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_email_outbox_due ON email_outbox(status, next_attempt_at);`),
+		},
+	},
+	{
+		version: 16,
+		name:    "inbound_email",
+		steps: []step{
+			execStep(`
+CREATE TABLE IF NOT EXISTS task_attachments (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	task_id INTEGER NOT NULL,
+	comment_id INTEGER,
+	file_name TEXT NOT NULL,
+	content_type TEXT NOT NULL,
+	size INTEGER NOT NULL,
+	data BLOB NOT NULL,
+	created_by INTEGER NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY(task_id) REFERENCES tasks(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_task_attachments_task ON task_attachments(task_id);`),
+			addColumnStep("email_outbox", "reply_to", "TEXT NOT NULL DEFAULT ''"),
+		},
+	},
+	{
+		version: 17,
+		name:    "auth_tokens",
+		steps: []step{
+			// Accounts that exist already count as verified.
+			addColumnStep("users", "email_verified", "INTEGER NOT NULL DEFAULT 1"),
+			execStep(`
+CREATE TABLE IF NOT EXISTS auth_tokens (
+	token_hash TEXT PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	purpose TEXT NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_auth_tokens_user ON auth_tokens(user_id, purpose);`),
+		},
+	},
+	{
+		version: 18,
+		name:    "two_factor",
+		steps: []step{
+			execStep(`
+CREATE TABLE IF NOT EXISTS user_totp (
+	user_id INTEGER PRIMARY KEY,
+	secret TEXT NOT NULL,
+	enabled INTEGER NOT NULL DEFAULT 0,
+	last_step INTEGER NOT NULL DEFAULT 0,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS recovery_codes (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	code_hash TEXT NOT NULL,
+	used_at TIMESTAMP,
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_recovery_codes_user ON recovery_codes(user_id);
+CREATE TABLE IF NOT EXISTS login_challenges (
+	token_hash TEXT PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	expires_at TIMESTAMP NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS settings (
+	key TEXT PRIMARY KEY,
+	value TEXT NOT NULL
+);`),
+		},
+	},
+	{
+		version: 19,
+		name:    "oidc_identities",
+		steps: []step{
+			execStep(`
+CREATE TABLE IF NOT EXISTS user_identities (
+	issuer TEXT NOT NULL,
+	subject TEXT NOT NULL,
+	user_id INTEGER NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY(issuer, subject),
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_user_identities_user ON user_identities(user_id, issuer);`),
+		},
+	},
+	{
+		version: 20,
+		name:    "task_event_source",
+		steps: []step{
+			addColumnStep("task_events", "source", "TEXT NOT NULL DEFAULT ''"),
+		},
+	},
+	{
+		version: 21,
+		name:    "inbound_emails",
+		steps: []step{
+			execStep(`
+CREATE TABLE IF NOT EXISTS inbound_emails (
+	message_id TEXT NOT NULL,
+	mailbox TEXT NOT NULL,
+	task_id INTEGER NOT NULL,
+	comment_id INTEGER NOT NULL DEFAULT 0,
+	attachments INTEGER NOT NULL DEFAULT 0,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY(message_id, mailbox)
+);`),
+		},
+	},
+	{
+		version: 22,
+		name:    "email_outbox_expiry",
+		steps: []step{
+			addColumnStep("email_outbox", "expires_at", "TIMESTAMP"),
+		},
+	},
+}
+
+// MigrationState describes one migration known to the build or recorded in
+// the database.
+type MigrationState struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	Applied   bool       `json:"applied"`
+	AppliedAt *time.Time `json:"appliedAt,omitempty"`
+	// Modified is set when the recorded checksum differs from the build's.
+	Modified bool `json:"modified,omitempty"`
+	// Unknown is set for versions recorded by a newer build.
+	Unknown bool `json:"unknown,omitempty"`
+}
+
+type appliedMigration struct {
+	name      string
+	checksum  string
+	appliedAt time.Time
+}
+
+// LatestSchemaVersion is the schema version this build migrates to.
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+func ensureMigrationsTable(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+	version INTEGER PRIMARY KEY,
+	name TEXT NOT NULL,
+	checksum TEXT NOT NULL,
+	applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+)`)
+	return err
+}
+
+func hasMigrationsTable(db *sql.DB) (bool, error) {
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations'`).Scan(&count)
+	return count > 0, err
+}
+
+// loadAppliedMigrations reads schema_migrations without creating it, so that
+// inspecting a database never changes it.
+func loadAppliedMigrations(db *sql.DB) (map[int]appliedMigration, error) {
+	applied := make(map[int]appliedMigration)
+	if ok, err := hasMigrationsTable(db); err != nil || !ok {
+		return applied, err
+	}
+	rows, err := db.Query(`SELECT version, name, checksum, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var version int
+		var m appliedMigration
+		if err := rows.Scan(&version, &m.name, &m.checksum, &m.appliedAt); err != nil {
+			return nil, err
+		}
+		m.appliedAt = m.appliedAt.UTC()
+		applied[version] = m
+	}
+	return applied, rows.Err()
+}
+
+// MigrationStatus lists every migration of the build plus any unknown
+// versions found in the database, in version order.
+func (s *Store) MigrationStatus() ([]MigrationState, error) {
+	return migrationStatus(s.db)
+}
+
+func migrationStatus(db *sql.DB) ([]MigrationState, error) {
+	applied, err := loadAppliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+	states := make([]MigrationState, 0, len(migrations))
+	known := make(map[int]struct{}, len(migrations))
+	for _, m := range migrations {
+		known[m.version] = struct{}{}
+		state := MigrationState{Version: m.version, Name: m.name}
+		if rec, ok := applied[m.version]; ok {
+			at := rec.appliedAt
+			state.Applied = true
+			state.AppliedAt = &at
+			state.Modified = rec.checksum != m.checksum()
+		}
+		states = append(states, state)
+	}
+	extra := make([]MigrationState, 0)
+	for version, rec := range applied {
+		if _, ok := known[version]; ok {
+			continue
+		}
+		at := rec.appliedAt
+		extra = append(extra, MigrationState{Version: version, Name: rec.name, Applied: true, AppliedAt: &at, Unknown: true})
+	}
+	states = append(states, extra...)
+	sort.Slice(states, func(i, j int) bool { return states[i].Version < states[j].Version })
+	return states, nil
+}
+
+// SchemaVersion returns the highest applied migration version, 0 for a
+// database that has never been migrated.
+func (s *Store) SchemaVersion() (int, error) {
+	return schemaVersion(s.db)
+}
+
+func schemaVersion(db *sql.DB) (int, error) {
+	if ok, err := hasMigrationsTable(db); err != nil || !ok {
+		return 0, err
+	}
+	var version sql.NullInt64
+	if err := db.QueryRow(`SELECT MAX(version) FROM schema_migrations`).Scan(&version); err != nil {
+		return 0, err
+	}
+	return int(version.Int64), nil
+}
+
+// Migrate applies pending migrations in order and returns the ones it ran.
+// It refuses to touch a database whose applied migrations were modified or
+// that was migrated by a newer build.
+func (s *Store) Migrate() ([]MigrationState, error) {
+	return migrate(s.db)
+}
+
+func migrate(db *sql.DB) ([]MigrationState, error) {
+	states, err := migrationStatus(db)
+	if err != nil {
+		return nil, err
+	}
+	done := make(map[int]bool, len(states))
+	for _, st := range states {
+		done[st.Version] = st.Applied
+		if st.Modified {
+			return nil, fmt.Errorf("%w: %d_%s", ErrMigrationChecksum, st.Version, st.Name)
+		}
+		if st.Unknown {
+			return nil, fmt.Errorf("%w: version %d (%s)", ErrSchemaTooNew, st.Version, st.Name)
+		}
+	}
+
+	if err := ensureMigrationsTable(db); err != nil {
+		return nil, err
+	}
+	applied := make([]MigrationState, 0)
+	for _, m := range migrations {
+		if done[m.version] {
+			continue
+		}
+		if err := applyMigration(db, m); err != nil {
+			return applied, fmt.Errorf("migration %d_%s: %w", m.version, m.name, err)
+		}
+		now := time.Now().UTC()
+		applied = append(applied, MigrationState{Version: m.version, Name: m.name, Applied: true, AppliedAt: &now})
+	}
+	return applied, nil
+}
+
+func applyMigration(db *sql.DB, m migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	for _, st := range m.steps {
+		if err := st.run(tx); err != nil {
+			return fmt.Errorf("%s: %w", firstLine(st.desc), err)
+		}
+	}
+	if _, err := tx.Exec(
+		`INSERT INTO schema_migrations (version, name, checksum, applied_at) VALUES (?, ?, ?, ?)`,
+		m.version,
+		m.name,
+		m.checksum(),
+		time.Now().UTC(),
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func firstLine(text string) string {
+	text = strings.TrimSpace(text)
+	if i := strings.IndexByte(text, '\n'); i >= 0 {
+		return text[:i]
+	}
+	return text
+}
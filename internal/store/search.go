@@ -0,0 +1,258 @@
+package store
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"litetask/internal/search"
+)
+
+const (
+	searchTitleWeight = 3
+	searchSnippetSize = 30
+)
+
+// SearchResult is a task matching a search query. Snippets are HTML with
+// matching words wrapped in <mark>; Snippet comes from the description or,
+// when CommentID is set, from that comment.
+type SearchResult struct {
+	Task         Task   `json:"task"`
+	TitleSnippet string `json:"titleSnippet"`
+	Snippet      string `json:"snippet"`
+	CommentID    int64  `json:"commentId,omitempty"`
+	Score        int    `json:"score"`
+}
+
+// The index holds one document per task and per comment. search_docs maps
+// them to index rowids; the index itself only stores stemmed words (see
+// search.IndexText), so snippets are rendered from the original rows.
+
+// createSearchIndex prefers FTS5 and falls back to FTS4 when the SQLite
+// library was built without it; queries are valid for both.
+func createSearchIndex(tx *sql.Tx) error {
+	var exists bool
+	if err := tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE name = 'search_index')`).Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+	_, err := tx.Exec(`CREATE VIRTUAL TABLE search_index USING fts5(title, body, tokenize = 'ascii')`)
+	if err != nil && strings.Contains(err.Error(), "no such module") {
+		_, err = tx.Exec(`CREATE VIRTUAL TABLE search_index USING fts4(title, body)`)
+	}
+	return err
+}
+
+func reindexAllTx(tx *sql.Tx) error {
+	type doc struct {
+		taskID, commentID int64
+		title, body       string
+	}
+	docs := make([]doc, 0)
+	rows, err := tx.Query(`SELECT id, title, COALESCE(description, comment, '') FROM tasks`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var d doc
+		if err := rows.Scan(&d.taskID, &d.title, &d.body); err != nil {
+			rows.Close()
+			return err
+		}
+		docs = append(docs, d)
+	}
+	rows.Close()
+	rows, err = tx.Query(`SELECT id, task_id, body FROM task_comments`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var d doc
+		if err := rows.Scan(&d.commentID, &d.taskID, &d.body); err != nil {
+			rows.Close()
+			return err
+		}
+		docs = append(docs, d)
+	}
+	rows.Close()
+
+	for _, d := range docs {
+		if err := indexDocTx(tx, d.taskID, d.commentID, d.title, d.body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func indexDocTx(tx *sql.Tx, taskID, commentID int64, title, body string) error {
+	if _, err := tx.Exec(
+		`INSERT INTO search_docs (task_id, comment_id) VALUES (?, ?) ON CONFLICT(task_id, comment_id) DO NOTHING`,
+		taskID,
+		commentID,
+	); err != nil {
+		return err
+	}
+	var docID int64
+	if err := tx.QueryRow(`SELECT id FROM search_docs WHERE task_id = ? AND comment_id = ?`, taskID, commentID).Scan(&docID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM search_index WHERE rowid = ?`, docID); err != nil {
+		return err
+	}
+	_, err := tx.Exec(
+		`INSERT INTO search_index (rowid, title, body) VALUES (?, ?, ?)`,
+		docID,
+		search.IndexText(title),
+		search.IndexText(body),
+	)
+	return err
+}
+
+func indexTaskTx(tx *sql.Tx, taskID int64, title, description string) error {
+	return indexDocTx(tx, taskID, 0, title, description)
+}
+
+func indexCommentTx(tx *sql.Tx, taskID, commentID int64, body string) error {
+	return indexDocTx(tx, taskID, commentID, "", body)
+}
+
+// unindexDocsTx drops the documents selected by where, a condition on
+// search_docs.
+func unindexDocsTx(tx *sql.Tx, where string, args ...any) error {
+	if _, err := tx.Exec(`DELETE FROM search_index WHERE rowid IN (SELECT id FROM search_docs WHERE `+where+`)`, args...); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`DELETE FROM search_docs WHERE `+where, args...)
+	return err
+}
+
+// unindexTaskTx drops a task and its comments from the index.
+func unindexTaskTx(tx *sql.Tx, taskID int64) error {
+	return unindexDocsTx(tx, `task_id = ?`, taskID)
+}
+
+func unindexCommentTx(tx *sql.Tx, commentID int64) error {
+	return unindexDocsTx(tx, `comment_id = ?`, commentID)
+}
+
+// Search finds tasks whose title, description or comments contain every
+// word of query in any grammatical form. filter.ProjectID and filter.Allowed
+// narrow the results like in FetchTasks; the other fields are ignored.
+// Results are ordered by the number of matching words, title matches
+// counting more. Ranking and limit are applied in SQL, so only the tasks
+// returned are loaded.
+func (s *Store) Search(query string, filter TaskFilter, limit int) ([]SearchResult, error) {
+	results := make([]SearchResult, 0)
+	terms := search.Terms(query)
+	if len(terms) == 0 {
+		return results, nil
+	}
+
+	hits := `SELECT DISTINCT d.task_id
+		FROM search_index
+		JOIN search_docs d ON d.id = search_index.rowid
+		JOIN tasks t ON t.id = d.task_id
+		WHERE search_index MATCH ?`
+	args := []any{search.MatchExpr(terms)}
+	if filter.ProjectID > 0 {
+		hits += ` AND t.project_id = ?`
+		args = append(args, filter.ProjectID)
+	}
+	if len(filter.Allowed) > 0 {
+		placeholders := make([]string, 0, len(filter.Allowed))
+		for pid := range filter.Allowed {
+			placeholders = append(placeholders, "?")
+			args = append(args, pid)
+		}
+		hits += ` AND t.project_id IN (` + strings.Join(placeholders, ",") + `)`
+	}
+
+	// The score counts the matching words of every document of a hit task
+	// in the indexed stems, like search.Count does on the original text.
+	titleScore, titleArgs := stemCountExpr("search_index.title", terms)
+	bodyScore, bodyArgs := stemCountExpr("search_index.body", terms)
+	sqlQuery := `WITH hits AS (` + hits + `)
+		SELECT d.task_id, SUM(` + strconv.Itoa(searchTitleWeight) + ` * ` + titleScore + ` + ` + bodyScore + `) AS score
+		FROM hits
+		JOIN search_docs d ON d.task_id = hits.task_id
+		JOIN search_index ON search_index.rowid = d.id
+		JOIN tasks t ON t.id = d.task_id
+		GROUP BY d.task_id
+		ORDER BY score DESC, t.created_at DESC, d.task_id DESC`
+	args = append(args, titleArgs...)
+	args = append(args, bodyArgs...)
+	if limit > 0 {
+		sqlQuery += ` LIMIT ?`
+		args = append(args, limit)
+	}
+
+	rows, err := s.db.Query(sqlQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	order := make([]int64, 0)
+	scores := make(map[int64]int)
+	for rows.Next() {
+		var taskID int64
+		var score int
+		if err := rows.Scan(&taskID, &score); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		order = append(order, taskID)
+		scores[taskID] = score
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(order) == 0 {
+		return results, nil
+	}
+
+	comments, err := s.ListCommentsByTaskIDs(order)
+	if err != nil {
+		return nil, err
+	}
+	for _, taskID := range order {
+		task, err := s.GetTask(taskID)
+		if err != nil {
+			return nil, err
+		}
+		res := SearchResult{
+			Task:         task,
+			TitleSnippet: search.Snippet(task.Title, terms, 0),
+			Score:        scores[taskID],
+		}
+		best := search.Count(task.Description, terms)
+		snippetSource := task.Description
+		for _, c := range comments[taskID] {
+			if n := search.Count(c.Body, terms); n > best {
+				best = n
+				snippetSource = c.Body
+				res.CommentID = c.ID
+			}
+		}
+		if best > 0 {
+			res.Snippet = search.Snippet(snippetSource, terms, searchSnippetSize)
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+// stemCountExpr returns an SQL expression counting the stems in column, a
+// space separated list, that start with one of terms.
+func stemCountExpr(column string, terms []string) (string, []any) {
+	parts := make([]string, len(terms))
+	args := make([]any, 0, 2*len(terms))
+	for i, term := range terms {
+		prefix := " " + term
+		parts[i] = `(length(' ' || ` + column + `) - length(replace(' ' || ` + column + `, ?, ''))) / ?`
+		args = append(args, prefix, utf8.RuneCountInString(prefix))
+	}
+	return `(` + strings.Join(parts, " + ") + `)`, args
+}
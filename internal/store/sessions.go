@@ -0,0 +1,140 @@
+package store
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+const sessionTouchInterval = time.Minute
+
+var ErrSessionExpired = errors.New("session expired")
+
+// Session is a signed-in browser. Its ID travels in the auth cookie, so
+// deleting the row logs the device out.
+type Session struct {
+	ID         string    `json:"id"`
+	UserID     int64     `json:"userId"`
+	UserAgent  string    `json:"userAgent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastSeenAt time.Time `json:"lastSeenAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+}
+
+const sessionColumns = `id, user_id, user_agent, ip, created_at, last_seen_at, expires_at`
+
+func scanSession(row rowScanner) (Session, error) {
+	var sess Session
+	if err := row.Scan(&sess.ID, &sess.UserID, &sess.UserAgent, &sess.IP, &sess.CreatedAt, &sess.LastSeenAt, &sess.ExpiresAt); err != nil {
+		return sess, err
+	}
+	sess.CreatedAt = sess.CreatedAt.UTC()
+	sess.LastSeenAt = sess.LastSeenAt.UTC()
+	sess.ExpiresAt = sess.ExpiresAt.UTC()
+	return sess, nil
+}
+
+func (s *Store) CreateSession(userID int64, userAgent, ip string, ttl time.Duration) (Session, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return Session{}, err
+	}
+	now := time.Now().UTC()
+	sess := Session{
+		ID:         hex.EncodeToString(buf),
+		UserID:     userID,
+		UserAgent:  userAgent,
+		IP:         ip,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(ttl),
+	}
+	if _, err := s.db.Exec(`DELETE FROM sessions WHERE expires_at <= ?`, now); err != nil {
+		return Session{}, err
+	}
+	_, err := s.db.Exec(
+		`INSERT INTO sessions (id, user_id, user_agent, ip, created_at, last_seen_at, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		sess.ID,
+		sess.UserID,
+		sess.UserAgent,
+		sess.IP,
+		sess.CreatedAt,
+		sess.LastSeenAt,
+		sess.ExpiresAt,
+	)
+	if err != nil {
+		return Session{}, err
+	}
+	return sess, nil
+}
+
+// TouchSession loads a live session and refreshes its last-seen metadata at
+// most once a minute.
+func (s *Store) TouchSession(id, ip string) (Session, error) {
+	sess, err := scanSession(s.db.QueryRow(`SELECT `+sessionColumns+` FROM sessions WHERE id = ?`, id))
+	if err != nil {
+		return Session{}, err
+	}
+	now := time.Now().UTC()
+	if !now.Before(sess.ExpiresAt) {
+		return Session{}, ErrSessionExpired
+	}
+	if now.Sub(sess.LastSeenAt) > sessionTouchInterval || (ip != "" && ip != sess.IP) {
+		if _, err := s.db.Exec(`UPDATE sessions SET last_seen_at = ?, ip = ? WHERE id = ?`, now, ip, id); err != nil {
+			return Session{}, err
+		}
+		sess.LastSeenAt = now
+		sess.IP = ip
+	}
+	return sess, nil
+}
+
+func (s *Store) ListSessions(userID int64) ([]Session, error) {
+	rows, err := s.db.Query(
+		`SELECT `+sessionColumns+` FROM sessions WHERE user_id = ? AND expires_at > ? ORDER BY last_seen_at DESC`,
+		userID,
+		time.Now().UTC(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sessions := make([]Session, 0)
+	for rows.Next() {
+		sess, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, sess)
+	}
+	return sessions, rows.Err()
+}
+
+// DeleteSession revokes one of the user's sessions.
+func (s *Store) DeleteSession(userID int64, id string) error {
+	res, err := s.db.Exec(`DELETE FROM sessions WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return err
+	}
+	affected, _ := res.RowsAffected()
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteUserSessions revokes every session of the user except keepID, which
+// may be empty.
+func (s *Store) DeleteUserSessions(userID int64, keepID string) error {
+	_, err := s.db.Exec(`DELETE FROM sessions WHERE user_id = ? AND id != ?`, userID, keepID)
+	return err
+}
+
+// RevokeSession deletes a session regardless of its owner, as on logout.
+func (s *Store) RevokeSession(id string) error {
+	_, err := s.db.Exec(`DELETE FROM sessions WHERE id = ?`, id)
+	return err
+}
@@ -25,16 +25,12 @@ const (
 )
 
 var (
-	allowedStatuses = map[string]struct{}{
-		"new":         {},
-		"in_progress": {},
-		"done":        {},
-	}
 	allowedRoles = map[string]struct{}{
 		"admin":   {},
 		"user":    {},
 		"blocked": {},
 	}
+	// StatusTitles holds the titles of the DefaultWorkflow statuses.
 	StatusTitles = map[string]string{
 		"new":         "Новая",
 		"in_progress": "В работе",
@@ -44,6 +40,9 @@ var (
 	ErrInvalidRole   = errors.New("invalid role")
 	ErrLastAdmin     = errors.New("cannot remove last admin")
 	ErrUsernameSet   = errors.New("username already set")
+
+	ErrNotProjectMember = errors.New("user is not a project member")
+	ErrInvalidDates     = errors.New("start date is after due date")
 )
 
 type Task struct {
@@ -57,6 +56,30 @@ type Task struct {
 	AuthorEmail string    `json:"authorEmail"`
 	AuthorFirst string    `json:"authorFirstName,omitempty"`
 	AuthorLast  string    `json:"authorLastName,omitempty"`
+
+	StartDate *time.Time `json:"startDate,omitempty"`
+	DueDate   *time.Time `json:"dueDate,omitempty"`
+	Overdue   bool       `json:"overdue"`
+
+	Assignees []TaskAssignee `json:"assignees"`
+}
+
+// TaskFilter narrows FetchTasks results. Zero values disable a filter.
+type TaskFilter struct {
+	ProjectID  int64
+	Status     string
+	Allowed    map[int64]struct{}
+	AssigneeID int64
+	DueBefore  *time.Time
+	Overdue    bool
+}
+
+type TaskAssignee struct {
+	ID        int64  `json:"id"`
+	Email     string `json:"email"`
+	Username  string `json:"username,omitempty"`
+	FirstName string `json:"firstName,omitempty"`
+	LastName  string `json:"lastName,omitempty"`
 }
 
 type TaskComment struct {
@@ -74,33 +97,57 @@ type Project struct {
 	CreatedAt time.Time `json:"createdAt"`
 }
 
+// NotificationPrefs are the Telegram messages a user opted in to. They are
+// only delivered once the account is linked to Telegram.
+type NotificationPrefs struct {
+	// Assigned: the user was assigned to a task.
+	Assigned bool `json:"assigned"`
+	// Comments: someone commented on a task the user created or is assigned to.
+	Comments bool `json:"comments"`
+	// StatusChanges: such a task moved to another status.
+	StatusChanges bool `json:"statusChanges"`
+}
+
 type User struct {
-	ID        int64     `json:"id"`
-	Email     string    `json:"email"`
-	Username  string    `json:"username"`
-	Password  string    `json:"-"`
-	Role      string    `json:"role"`
-	FirstName string    `json:"firstName"`
-	LastName  string    `json:"lastName"`
-	Telegram  string    `json:"telegram"`
-	CreatedAt time.Time `json:"createdAt"`
+	ID        int64  `json:"id"`
+	Email     string `json:"email"`
+	Username  string `json:"username"`
+	Password  string `json:"-"`
+	Role      string `json:"role"`
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+	Telegram  string `json:"telegram"`
+	// TelegramID is the Telegram account linked with /link, 0 if none.
+	TelegramID    int64             `json:"telegramId,omitempty"`
+	Notifications NotificationPrefs `json:"notifications"`
+	// EmailVerified is false while a self-registered user has not opened
+	// the verification link.
+	EmailVerified    bool      `json:"emailVerified"`
+	TwoFactorEnabled bool      `json:"twoFactorEnabled"`
+	CreatedAt        time.Time `json:"createdAt"`
 }
 
 type Store struct {
 	db *sql.DB
+	// source is recorded with the task events of changes made through this
+	// Store, see WithSource.
+	source string
 }
 
+// Open opens the database, applies pending migrations and seeds the default
+// project, workflows and admin user.
 func Open(path string) (*Store, error) {
-	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
-		return nil, err
-	}
-
-	db, err := sql.Open("sqlite3", path)
+	s, err := OpenUnmigrated(path)
 	if err != nil {
 		return nil, err
 	}
+	db := s.db
 
-	if err := setupSchema(db); err != nil {
+	applied, err := migrate(db)
+	for _, m := range applied {
+		log.Printf("applied migration %d_%s", m.Version, m.Name)
+	}
+	if err != nil {
 		db.Close()
 		return nil, err
 	}
@@ -108,19 +155,45 @@ func Open(path string) (*Store, error) {
 	if err := ensureDefaultProject(db); err != nil {
 		log.Printf("warning: unable to ensure default project: %v", err)
 	}
+	if err := ensureDefaultWorkflows(db); err != nil {
+		log.Printf("warning: unable to ensure default workflows: %v", err)
+	}
 	if err := ensureAdminUser(db); err != nil {
 		log.Printf("warning: unable to ensure admin user: %v", err)
 	}
 
+	return s, nil
+}
+
+// OpenUnmigrated opens the database as is, for tools such as the migrate
+// command that must inspect the schema before changing it.
+func OpenUnmigrated(path string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
 	return &Store{db: db}, nil
 }
 
+// WithSource returns a Store on the same database that records source, such
+// as events.SourceTelegram, with the task events of its changes. Consumers of
+// the history use it to skip changes they made themselves.
+func (s *Store) WithSource(source string) *Store {
+	return &Store{db: s.db, source: source}
+}
+
 func (s *Store) Close() error {
 	return s.db.Close()
 }
 
-func (s *Store) InsertTask(title, description string, projectID, createdBy int64) (Task, error) {
+func (s *Store) InsertTask(title, description string, projectID, createdBy int64, startDate, dueDate *time.Time) (Task, error) {
 	var t Task
+	if err := validateDates(startDate, dueDate); err != nil {
+		return t, err
+	}
 	ok, err := s.ProjectExists(projectID)
 	if err != nil {
 		return t, err
@@ -129,54 +202,83 @@ func (s *Store) InsertTask(title, description string, projectID, createdBy int64
 		return t, fmt.Errorf("project not found")
 	}
 
-	res, err := s.db.Exec(
-		`INSERT INTO tasks (title, status, description, project_id, created_by) VALUES (?, 'new', ?, ?, ?)`,
+	wf, err := s.GetWorkflow(projectID)
+	if err != nil {
+		return t, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return t, err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	id, err := s.insertTaskTx(tx, wf, title, description, projectID, createdBy, startDate, dueDate)
+	if err != nil {
+		return t, err
+	}
+	if err := tx.Commit(); err != nil {
+		return t, err
+	}
+	return s.GetTask(id)
+}
+
+// insertTaskTx creates a task in the first status of wf, its project's
+// workflow, and returns its id.
+func (s *Store) insertTaskTx(tx *sql.Tx, wf Workflow, title, description string, projectID, createdBy int64, startDate, dueDate *time.Time) (int64, error) {
+	res, err := tx.Exec(
+		`INSERT INTO tasks (title, status, description, project_id, created_by, start_date, due_date) VALUES (?, ?, ?, ?, ?, ?, ?)`,
 		title,
+		wf.Statuses[0].Key,
 		description,
 		projectID,
 		nullableInt64(createdBy),
+		nullableDate(startDate),
+		nullableDate(dueDate),
 	)
 	if err != nil {
-		return t, err
+		return 0, err
 	}
 	id, _ := res.LastInsertId()
-	var created sql.NullInt64
-	var email sql.NullString
-	var first sql.NullString
-	var last sql.NullString
-	err = s.db.QueryRow(
-		`SELECT t.id, t.title, t.status, COALESCE(t.description, t.comment, ''), t.project_id, t.created_at, t.created_by, u.email, u.first_name, u.last_name
-			FROM tasks t
-			LEFT JOIN users u ON t.created_by = u.id
-			WHERE t.id = ?`,
-		id,
-	).Scan(&t.ID, &t.Title, &t.Status, &t.Description, &t.ProjectID, &t.CreatedAt, &created, &email, &first, &last)
-	if err != nil {
+	if err := indexTaskTx(tx, id, title, description); err != nil {
+		return 0, err
+	}
+	if err := s.recordEvent(tx, id, createdBy, EventTaskCreated, map[string]any{"projectId": projectID, "status": wf.Statuses[0].Key}); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// SetTaskStatus moves a task to another status of its project's workflow,
+// enforcing the workflow's transition rules.
+func (s *Store) SetTaskStatus(id int64, status string, actorID int64) (Task, error) {
+	var t Task
+	var projectID int64
+	var current string
+	if err := s.db.QueryRow(`SELECT project_id, status FROM tasks WHERE id = ?`, id).Scan(&projectID, &current); err != nil {
 		return t, err
 	}
-	t.CreatedAt = t.CreatedAt.UTC()
-	if created.Valid {
-		t.CreatedBy = created.Int64
+	wf, err := s.GetWorkflow(projectID)
+	if err != nil {
+		return t, err
 	}
-	if email.Valid {
-		t.AuthorEmail = email.String
+	if !wf.Has(status) {
+		return t, ErrInvalidStatus
 	}
-	if first.Valid {
-		t.AuthorFirst = first.String
+	if !wf.CanMove(current, status) {
+		return t, ErrTransitionNotAllowed
 	}
-	if last.Valid {
-		t.AuthorLast = last.String
+	if current == status {
+		return s.GetTask(id)
 	}
-	return t, nil
-}
 
-func (s *Store) SetTaskStatus(id int64, status string) (Task, error) {
-	var t Task
-	if _, ok := allowedStatuses[status]; !ok {
-		return t, ErrInvalidStatus
+	tx, err := s.db.Begin()
+	if err != nil {
+		return t, err
 	}
+	defer tx.Rollback() //nolint:errcheck
 
-	res, err := s.db.Exec(`UPDATE tasks SET status = ? WHERE id = ?`, status, id)
+	res, err := tx.Exec(`UPDATE tasks SET status = ? WHERE id = ?`, status, id)
 	if err != nil {
 		return t, err
 	}
@@ -184,79 +286,155 @@ func (s *Store) SetTaskStatus(id int64, status string) (Task, error) {
 	if affected == 0 {
 		return t, sql.ErrNoRows
 	}
+	if err := s.recordEvent(tx, id, actorID, EventStatusChanged, map[string]any{"from": current, "to": status}); err != nil {
+		return t, err
+	}
+	if err := tx.Commit(); err != nil {
+		return t, err
+	}
 
-	var created sql.NullInt64
-	var email sql.NullString
-	var first sql.NullString
-	var last sql.NullString
-	err = s.db.QueryRow(
-		`SELECT t.id, t.title, t.status, COALESCE(t.description, t.comment, ''), t.project_id, t.created_at, t.created_by, u.email, u.first_name, u.last_name
-			FROM tasks t
-			LEFT JOIN users u ON t.created_by = u.id
-			WHERE t.id = ?`,
-		id,
-	).Scan(&t.ID, &t.Title, &t.Status, &t.Description, &t.ProjectID, &t.CreatedAt, &created, &email, &first, &last)
+	return s.GetTask(id)
+}
+
+func (s *Store) SetTaskDescription(id int64, description string, actorID int64) (Task, error) {
+	var t Task
+	tx, err := s.db.Begin()
 	if err != nil {
 		return t, err
 	}
-	t.CreatedAt = t.CreatedAt.UTC()
-	if created.Valid {
-		t.CreatedBy = created.Int64
+	defer tx.Rollback() //nolint:errcheck
+
+	var title, previous string
+	if err := tx.QueryRow(`SELECT title, COALESCE(description, comment, '') FROM tasks WHERE id = ?`, id).Scan(&title, &previous); err != nil {
+		return t, err
 	}
-	if email.Valid {
-		t.AuthorEmail = email.String
+	if previous == description {
+		return s.GetTask(id)
 	}
-	if first.Valid {
-		t.AuthorFirst = first.String
+	res, err := tx.Exec(`UPDATE tasks SET description = ? WHERE id = ?`, description, id)
+	if err != nil {
+		return t, err
 	}
-	if last.Valid {
-		t.AuthorLast = last.String
+	affected, _ := res.RowsAffected()
+	if affected == 0 {
+		return t, sql.ErrNoRows
 	}
-	return t, nil
+	if err := indexTaskTx(tx, id, title, description); err != nil {
+		return t, err
+	}
+	if err := s.recordEvent(tx, id, actorID, EventDescriptionEdited, map[string]any{"from": previous, "to": description}); err != nil {
+		return t, err
+	}
+	if err := tx.Commit(); err != nil {
+		return t, err
+	}
+	return s.GetTask(id)
 }
 
-func (s *Store) SetTaskDescription(id int64, description string) (Task, error) {
-	var t Task
-	res, err := s.db.Exec(`UPDATE tasks SET description = ? WHERE id = ?`, description, id)
+// SetTaskDates replaces both planning dates of a task; nil clears a date.
+func (s *Store) SetTaskDates(id int64, startDate, dueDate *time.Time, actorID int64) (Task, error) {
+	if err := validateDates(startDate, dueDate); err != nil {
+		return Task{}, err
+	}
+	tx, err := s.db.Begin()
 	if err != nil {
-		return t, err
+		return Task{}, err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	res, err := tx.Exec(`UPDATE tasks SET start_date = ?, due_date = ? WHERE id = ?`, nullableDate(startDate), nullableDate(dueDate), id)
+	if err != nil {
+		return Task{}, err
 	}
 	affected, _ := res.RowsAffected()
 	if affected == 0 {
-		return t, sql.ErrNoRows
+		return Task{}, sql.ErrNoRows
+	}
+	if err := s.recordEvent(tx, id, actorID, EventDatesChanged, map[string]any{"startDate": nullableDate(startDate), "dueDate": nullableDate(dueDate)}); err != nil {
+		return Task{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Task{}, err
+	}
+	return s.GetTask(id)
+}
+
+// MoveTask transfers a task to another project. A status unknown to the
+// target workflow is reset to that workflow's first status.
+func (s *Store) MoveTask(id, projectID, actorID int64) (Task, error) {
+	var fromProject int64
+	var status string
+	if err := s.db.QueryRow(`SELECT project_id, status FROM tasks WHERE id = ?`, id).Scan(&fromProject, &status); err != nil {
+		return Task{}, err
+	}
+	if fromProject == projectID {
+		return s.GetTask(id)
+	}
+	wf, err := s.GetWorkflow(projectID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Task{}, fmt.Errorf("project not found")
 	}
-	var created sql.NullInt64
-	var email sql.NullString
-	var first sql.NullString
-	var last sql.NullString
-	err = s.db.QueryRow(
-		`SELECT t.id, t.title, t.status, COALESCE(t.description, t.comment, ''), t.project_id, t.created_at, t.created_by, u.email, u.first_name, u.last_name
-			FROM tasks t
-			LEFT JOIN users u ON t.created_by = u.id
-			WHERE t.id = ?`,
-		id,
-	).Scan(&t.ID, &t.Title, &t.Status, &t.Description, &t.ProjectID, &t.CreatedAt, &created, &email, &first, &last)
 	if err != nil {
-		return t, err
+		return Task{}, err
 	}
-	t.CreatedAt = t.CreatedAt.UTC()
-	if created.Valid {
-		t.CreatedBy = created.Int64
+	nextStatus := status
+	if !wf.Has(status) {
+		nextStatus = wf.Statuses[0].Key
 	}
-	if email.Valid {
-		t.AuthorEmail = email.String
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return Task{}, err
 	}
-	if first.Valid {
-		t.AuthorFirst = first.String
+	defer tx.Rollback() //nolint:errcheck
+
+	if _, err := tx.Exec(`UPDATE tasks SET project_id = ?, status = ? WHERE id = ?`, projectID, nextStatus, id); err != nil {
+		return Task{}, err
 	}
-	if last.Valid {
-		t.AuthorLast = last.String
+	data := map[string]any{"from": fromProject, "to": projectID}
+	if nextStatus != status {
+		data["fromStatus"] = status
+		data["toStatus"] = nextStatus
 	}
-	return t, nil
+	if err := s.recordEvent(tx, id, actorID, EventProjectMoved, data); err != nil {
+		return Task{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Task{}, err
+	}
+	return s.GetTask(id)
 }
 
-func (s *Store) DeleteTask(id int64) error {
-	res, err := s.db.Exec(`DELETE FROM tasks WHERE id = ?`, id)
+// DeleteTask removes a task with its assignees, attachments and history.
+// Only an EventTaskDeleted stays in task_events, for the consumers that
+// follow it.
+func (s *Store) DeleteTask(id, actorID int64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	var projectID int64
+	if err := tx.QueryRow(`SELECT project_id FROM tasks WHERE id = ?`, id).Scan(&projectID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM task_assignees WHERE task_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM task_events WHERE task_id = ?`, id); err != nil {
+		return err
+	}
+	if err := s.recordEvent(tx, id, actorID, EventTaskDeleted, map[string]any{"projectId": projectID}); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM task_attachments WHERE task_id = ?`, id); err != nil {
+		return err
+	}
+	if err := unindexTaskTx(tx, id); err != nil {
+		return err
+	}
+	res, err := tx.Exec(`DELETE FROM tasks WHERE id = ?`, id)
 	if err != nil {
 		return err
 	}
@@ -264,7 +442,7 @@ func (s *Store) DeleteTask(id int64) error {
 	if affected == 0 {
 		return sql.ErrNoRows
 	}
-	return nil
+	return tx.Commit()
 }
 
 func (s *Store) ProjectExists(id int64) (bool, error) {
@@ -274,49 +452,45 @@ func (s *Store) ProjectExists(id int64) (bool, error) {
 }
 
 func (s *Store) GetTask(id int64) (Task, error) {
-	var t Task
-	var created sql.NullInt64
-	var email sql.NullString
-	var first sql.NullString
-	var last sql.NullString
-	err := s.db.QueryRow(
-		`SELECT t.id, t.title, t.status, COALESCE(t.description, t.comment, ''), t.project_id, t.created_at, t.created_by, u.email, u.first_name, u.last_name
-			FROM tasks t
-			LEFT JOIN users u ON t.created_by = u.id
-			WHERE t.id = ?`,
-		id,
-	).Scan(&t.ID, &t.Title, &t.Status, &t.Description, &t.ProjectID, &t.CreatedAt, &created, &email, &first, &last)
+	t, err := scanTask(s.db.QueryRow(taskSelect+` WHERE t.id = ?`, id))
 	if err != nil {
 		return t, err
 	}
-	t.CreatedAt = t.CreatedAt.UTC()
-	if created.Valid {
-		t.CreatedBy = created.Int64
-	}
-	if email.Valid {
-		t.AuthorEmail = email.String
-	}
-	if first.Valid {
-		t.AuthorFirst = first.String
+	assignees, err := s.ListAssigneesByTaskIDs([]int64{id})
+	if err != nil {
+		return t, err
 	}
-	if last.Valid {
-		t.AuthorLast = last.String
+	t.Assignees = assignees[id]
+	if t.Assignees == nil {
+		t.Assignees = []TaskAssignee{}
 	}
 	return t, nil
 }
 
 func (s *Store) CreateProject(name string) (Project, error) {
 	var p Project
-	res, err := s.db.Exec(`INSERT INTO projects (name) VALUES (?)`, name)
+	tx, err := s.db.Begin()
+	if err != nil {
+		return p, err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	res, err := tx.Exec(`INSERT INTO projects (name) VALUES (?)`, name)
 	if err != nil {
 		return p, err
 	}
 	id, _ := res.LastInsertId()
-	err = s.db.QueryRow(`SELECT id, name, created_at FROM projects WHERE id = ?`, id).
+	if err := insertWorkflowTx(tx, id, DefaultWorkflow); err != nil {
+		return p, err
+	}
+	err = tx.QueryRow(`SELECT id, name, created_at FROM projects WHERE id = ?`, id).
 		Scan(&p.ID, &p.Name, &p.CreatedAt)
 	if err != nil {
 		return p, err
 	}
+	if err := tx.Commit(); err != nil {
+		return p, err
+	}
 	p.CreatedAt = p.CreatedAt.UTC()
 	return p, nil
 }
@@ -346,9 +520,42 @@ func (s *Store) DeleteProject(id int64) error {
 	}
 	defer tx.Rollback() //nolint: errcheck
 
+	if _, err := tx.Exec(`DELETE FROM task_assignees WHERE task_id IN (SELECT id FROM tasks WHERE project_id = ?)`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM task_events WHERE task_id IN (SELECT id FROM tasks WHERE project_id = ?)`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM task_attachments WHERE task_id IN (SELECT id FROM tasks WHERE project_id = ?)`, id); err != nil {
+		return err
+	}
+	if err := unindexDocsTx(tx, `task_id IN (SELECT id FROM tasks WHERE project_id = ?)`, id); err != nil {
+		return err
+	}
 	if _, err := tx.Exec(`DELETE FROM tasks WHERE project_id = ?`, id); err != nil {
 		return err
 	}
+	if _, err := tx.Exec(`DELETE FROM project_statuses WHERE project_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM project_transitions WHERE project_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM user_projects WHERE project_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM webhook_deliveries WHERE webhook_id IN (SELECT id FROM webhooks WHERE project_id = ?)`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM webhooks WHERE project_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM telegram_chats WHERE project_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM project_digests WHERE project_id = ?`, id); err != nil {
+		return err
+	}
 
 	res, err := tx.Exec(`DELETE FROM projects WHERE id = ?`, id)
 	if err != nil {
@@ -362,8 +569,25 @@ func (s *Store) DeleteProject(id int64) error {
 	return tx.Commit()
 }
 
-func (s *Store) CreateUser(email, username, password, role, firstName, lastName string) (User, error) {
+// UserOption changes how CreateUser sets up an account.
+type UserOption func(*newUser)
+
+type newUser struct {
+	emailVerified bool
+}
+
+// EmailUnverified creates the account with an email address still to be
+// confirmed, so it cannot sign in before that.
+func EmailUnverified() UserOption {
+	return func(n *newUser) { n.emailVerified = false }
+}
+
+func (s *Store) CreateUser(email, username, password, role, firstName, lastName string, opts ...UserOption) (User, error) {
 	var u User
+	n := newUser{emailVerified: true}
+	for _, opt := range opts {
+		opt(&n)
+	}
 	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
 		return u, err
@@ -377,24 +601,23 @@ func (s *Store) CreateUser(email, username, password, role, firstName, lastName
 		}
 	}
 	res, err := s.db.Exec(
-		`INSERT INTO users (email, username, password_hash, role, first_name, last_name, telegram) VALUES (?, ?, ?, ?, ?, ?, '')`,
+		`INSERT INTO users (email, username, password_hash, role, first_name, last_name, telegram, email_verified) VALUES (?, ?, ?, ?, ?, ?, '', ?)`,
 		email,
 		nullableString(username),
 		string(hash),
 		role,
 		firstName,
 		lastName,
+		n.emailVerified,
 	)
 	if err != nil {
 		return u, err
 	}
 	id, _ := res.LastInsertId()
-	err = s.db.QueryRow(`SELECT id, email, COALESCE(username, ''), password_hash, role, created_at, telegram, first_name, last_name FROM users WHERE id = ?`, id).
-		Scan(&u.ID, &u.Email, &u.Username, &u.Password, &u.Role, &u.CreatedAt, &u.Telegram, &u.FirstName, &u.LastName)
+	u, err = scanUser(s.db.QueryRow(`SELECT `+userColumns+` FROM users WHERE id = ?`, id))
 	if err != nil {
 		return u, err
 	}
-	u.CreatedAt = u.CreatedAt.UTC()
 	if err := s.SetUserProjects(u.ID, []int64{DefaultProjectID}); err != nil {
 		log.Printf("warning: failed to assign default project: %v", err)
 	}
@@ -402,59 +625,42 @@ func (s *Store) CreateUser(email, username, password, role, firstName, lastName
 }
 
 func (s *Store) GetUserByEmail(email string) (User, error) {
-	var u User
-	err := s.db.QueryRow(`SELECT id, email, COALESCE(username, ''), password_hash, role, created_at, telegram, first_name, last_name FROM users WHERE email = ?`, email).
-		Scan(&u.ID, &u.Email, &u.Username, &u.Password, &u.Role, &u.CreatedAt, &u.Telegram, &u.FirstName, &u.LastName)
-	if err != nil {
-		return u, err
-	}
-	u.CreatedAt = u.CreatedAt.UTC()
-	return u, nil
+	return scanUser(s.db.QueryRow(`SELECT `+userColumns+` FROM users WHERE email = ?`, email))
+}
+
+func (s *Store) GetUserByUsername(username string) (User, error) {
+	return scanUser(s.db.QueryRow(`SELECT `+userColumns+` FROM users WHERE username = ?`, strings.ToLower(username)))
 }
 
 func (s *Store) GetUserByEmailOrUsername(login string) (User, error) {
-	var u User
 	login = strings.TrimSpace(strings.ToLower(login))
-	err := s.db.QueryRow(
-		`SELECT id, email, COALESCE(username, ''), password_hash, role, created_at, telegram, first_name, last_name
+	return scanUser(s.db.QueryRow(
+		`SELECT `+userColumns+`
 		FROM users
 		WHERE email = ? OR username = ?
 		ORDER BY id
 		LIMIT 1`,
 		login,
 		login,
-	).Scan(&u.ID, &u.Email, &u.Username, &u.Password, &u.Role, &u.CreatedAt, &u.Telegram, &u.FirstName, &u.LastName)
-	if err != nil {
-		return u, err
-	}
-	u.CreatedAt = u.CreatedAt.UTC()
-	return u, nil
+	))
 }
 
 func (s *Store) GetUserByID(id int64) (User, error) {
-	var u User
-	err := s.db.QueryRow(`SELECT id, email, COALESCE(username, ''), password_hash, role, created_at, telegram, first_name, last_name FROM users WHERE id = ?`, id).
-		Scan(&u.ID, &u.Email, &u.Username, &u.Password, &u.Role, &u.CreatedAt, &u.Telegram, &u.FirstName, &u.LastName)
-	if err != nil {
-		return u, err
-	}
-	u.CreatedAt = u.CreatedAt.UTC()
-	return u, nil
+	return scanUser(s.db.QueryRow(`SELECT `+userColumns+` FROM users WHERE id = ?`, id))
 }
 
 func (s *Store) ListUsers() ([]User, error) {
-	rows, err := s.db.Query(`SELECT id, email, COALESCE(username, ''), password_hash, role, created_at, telegram, first_name, last_name FROM users ORDER BY created_at DESC`)
+	rows, err := s.db.Query(`SELECT ` + userColumns + ` FROM users ORDER BY created_at DESC`)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	users := make([]User, 0)
 	for rows.Next() {
-		var u User
-		if err := rows.Scan(&u.ID, &u.Email, &u.Username, &u.Password, &u.Role, &u.CreatedAt, &u.Telegram, &u.FirstName, &u.LastName); err != nil {
+		u, err := scanUser(rows)
+		if err != nil {
 			return nil, err
 		}
-		u.CreatedAt = u.CreatedAt.UTC()
 		users = append(users, u)
 	}
 	return users, nil
@@ -513,7 +719,13 @@ func (s *Store) UpdateUserRole(id int64, role string) (User, error) {
 		}
 	}
 
-	res, err := s.db.Exec(`UPDATE users SET role = ? WHERE id = ?`, role, id)
+	tx, err := s.db.Begin()
+	if err != nil {
+		return User{}, err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	res, err := tx.Exec(`UPDATE users SET role = ? WHERE id = ?`, role, id)
 	if err != nil {
 		return User{}, err
 	}
@@ -521,6 +733,15 @@ func (s *Store) UpdateUserRole(id int64, role string) (User, error) {
 	if affected == 0 {
 		return User{}, sql.ErrNoRows
 	}
+	// Sessions were opened under the old role; make the user sign in again.
+	if role != currentRole {
+		if _, err := tx.Exec(`DELETE FROM sessions WHERE user_id = ?`, id); err != nil {
+			return User{}, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return User{}, err
+	}
 
 	return s.GetUserByID(id)
 }
@@ -539,7 +760,13 @@ func (s *Store) UpdateUserPassword(id int64, password string) (User, error) {
 	if err != nil {
 		return User{}, err
 	}
-	res, err := s.db.Exec(`UPDATE users SET password_hash = ? WHERE id = ?`, string(hash), id)
+	tx, err := s.db.Begin()
+	if err != nil {
+		return User{}, err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	res, err := tx.Exec(`UPDATE users SET password_hash = ? WHERE id = ?`, string(hash), id)
 	if err != nil {
 		return User{}, err
 	}
@@ -547,11 +774,17 @@ func (s *Store) UpdateUserPassword(id int64, password string) (User, error) {
 	if affected == 0 {
 		return User{}, sql.ErrNoRows
 	}
+	if _, err := tx.Exec(`DELETE FROM sessions WHERE user_id = ?`, id); err != nil {
+		return User{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return User{}, err
+	}
 	return s.GetUserByID(id)
 }
 
-func (s *Store) UpdateUserProfile(id int64, password *string, telegram *string, firstName *string, lastName *string) (User, error) {
-	if password == nil && telegram == nil && firstName == nil && lastName == nil {
+func (s *Store) UpdateUserProfile(id int64, password *string, telegram *string, firstName *string, lastName *string, notifications *NotificationPrefs) (User, error) {
+	if password == nil && telegram == nil && firstName == nil && lastName == nil && notifications == nil {
 		return s.GetUserByID(id)
 	}
 	sets := make([]string, 0)
@@ -584,10 +817,21 @@ func (s *Store) UpdateUserProfile(id int64, password *string, telegram *string,
 		args = append(args, strings.TrimSpace(*lastName))
 	}
 
+	if notifications != nil {
+		sets = append(sets, "notify_assigned = ?", "notify_comments = ?", "notify_status = ?")
+		args = append(args, notifications.Assigned, notifications.Comments, notifications.StatusChanges)
+	}
+
 	args = append(args, id)
 
+	tx, err := s.db.Begin()
+	if err != nil {
+		return User{}, err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
 	query := `UPDATE users SET ` + strings.Join(sets, ", ") + ` WHERE id = ?`
-	res, err := s.db.Exec(query, args...)
+	res, err := tx.Exec(query, args...)
 	if err != nil {
 		return User{}, err
 	}
@@ -595,6 +839,16 @@ func (s *Store) UpdateUserProfile(id int64, password *string, telegram *string,
 	if affected == 0 {
 		return User{}, sql.ErrNoRows
 	}
+	// A new password signs the user out everywhere; callers re-issue the
+	// current session if needed.
+	if password != nil {
+		if _, err := tx.Exec(`DELETE FROM sessions WHERE user_id = ?`, id); err != nil {
+			return User{}, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return User{}, err
+	}
 	return s.GetUserByID(id)
 }
 
@@ -615,11 +869,22 @@ func (s *Store) SetUserProjects(userID int64, projectIDs []int64) error {
 		}
 	}
 
-	if _, err := tx.Exec(`DELETE FROM user_projects WHERE user_id = ?`, userID); err != nil {
+	// Projects the user keeps retain their role; new ones start as member.
+	keep := make([]string, 0, len(projectIDs))
+	args := []any{userID}
+	for _, pid := range projectIDs {
+		keep = append(keep, "?")
+		args = append(args, pid)
+	}
+	query := `DELETE FROM user_projects WHERE user_id = ?`
+	if len(keep) > 0 {
+		query += ` AND project_id NOT IN (` + strings.Join(keep, ",") + `)`
+	}
+	if _, err := tx.Exec(query, args...); err != nil {
 		return err
 	}
 	for _, pid := range projectIDs {
-		if _, err := tx.Exec(`INSERT INTO user_projects (user_id, project_id) VALUES (?, ?)`, userID, pid); err != nil {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO user_projects (user_id, project_id, role) VALUES (?, ?, ?)`, userID, pid, ProjectRoleMember); err != nil {
 			return err
 		}
 	}
@@ -649,26 +914,37 @@ func (s *Store) projectExistsTx(tx *sql.Tx, id int64) (bool, error) {
 	return exists, err
 }
 
-func (s *Store) FetchTasks(projectID int64, status string, allowed map[int64]struct{}) ([]Task, error) {
-	query := `SELECT t.id, t.title, t.status, COALESCE(t.description, t.comment, ''), t.project_id, t.created_at, t.created_by, u.email, u.first_name, u.last_name FROM tasks t LEFT JOIN users u ON t.created_by = u.id`
+func (s *Store) FetchTasks(filter TaskFilter) ([]Task, error) {
+	query := taskSelect
 	conds := make([]string, 0)
 	args := make([]any, 0)
 
-	if projectID > 0 {
+	if filter.ProjectID > 0 {
 		conds = append(conds, "t.project_id = ?")
-		args = append(args, projectID)
+		args = append(args, filter.ProjectID)
 	}
-	if len(allowed) > 0 {
-		placeholders := make([]string, 0, len(allowed))
-		for pid := range allowed {
+	if len(filter.Allowed) > 0 {
+		placeholders := make([]string, 0, len(filter.Allowed))
+		for pid := range filter.Allowed {
 			placeholders = append(placeholders, "?")
 			args = append(args, pid)
 		}
 		conds = append(conds, "t.project_id IN ("+strings.Join(placeholders, ",")+")")
 	}
-	if status != "" {
+	if filter.Status != "" {
 		conds = append(conds, "t.status = ?")
-		args = append(args, status)
+		args = append(args, filter.Status)
+	}
+	if filter.AssigneeID > 0 {
+		conds = append(conds, "EXISTS(SELECT 1 FROM task_assignees a WHERE a.task_id = t.id AND a.user_id = ?)")
+		args = append(args, filter.AssigneeID)
+	}
+	if filter.DueBefore != nil {
+		conds = append(conds, "t.due_date IS NOT NULL AND t.due_date < ?")
+		args = append(args, filter.DueBefore.Format(DateLayout))
+	}
+	if filter.Overdue {
+		conds = append(conds, overdueCond)
 	}
 	if len(conds) > 0 {
 		query += " WHERE " + strings.Join(conds, " AND ")
@@ -682,46 +958,167 @@ func (s *Store) FetchTasks(projectID int64, status string, allowed map[int64]str
 	defer rows.Close()
 
 	tasks := make([]Task, 0)
+	ids := make([]int64, 0)
 	for rows.Next() {
-		var t Task
-		var created time.Time
-		var authorID sql.NullInt64
-		var email sql.NullString
-		var first sql.NullString
-		var last sql.NullString
-		if err := rows.Scan(&t.ID, &t.Title, &t.Status, &t.Description, &t.ProjectID, &created, &authorID, &email, &first, &last); err != nil {
+		t, err := scanTask(rows)
+		if err != nil {
 			return nil, err
 		}
-		t.CreatedAt = created.UTC()
-		if authorID.Valid {
-			t.CreatedBy = authorID.Int64
+		tasks = append(tasks, t)
+		ids = append(ids, t.ID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	assignees, err := s.ListAssigneesByTaskIDs(ids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range tasks {
+		tasks[i].Assignees = assignees[tasks[i].ID]
+		if tasks[i].Assignees == nil {
+			tasks[i].Assignees = []TaskAssignee{}
 		}
-		if email.Valid {
-			t.AuthorEmail = email.String
+	}
+	return tasks, nil
+}
+
+// AssignTask adds userID to the task assignees. The user must be an admin or
+// a member of the task's project.
+func (s *Store) AssignTask(taskID, userID, actorID int64) (Task, error) {
+	var projectID int64
+	if err := s.db.QueryRow(`SELECT project_id FROM tasks WHERE id = ?`, taskID).Scan(&projectID); err != nil {
+		return Task{}, err
+	}
+	ok, err := s.IsProjectMember(userID, projectID)
+	if err != nil {
+		return Task{}, err
+	}
+	if !ok {
+		return Task{}, ErrNotProjectMember
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return Task{}, err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	res, err := tx.Exec(`INSERT OR IGNORE INTO task_assignees (task_id, user_id) VALUES (?, ?)`, taskID, userID)
+	if err != nil {
+		return Task{}, err
+	}
+	if affected, _ := res.RowsAffected(); affected > 0 {
+		if err := s.recordEvent(tx, taskID, actorID, EventAssigned, map[string]any{"userId": userID}); err != nil {
+			return Task{}, err
 		}
-		if first.Valid {
-			t.AuthorFirst = first.String
+	}
+	if err := tx.Commit(); err != nil {
+		return Task{}, err
+	}
+	return s.GetTask(taskID)
+}
+
+func (s *Store) UnassignTask(taskID, userID, actorID int64) (Task, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return Task{}, err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	res, err := tx.Exec(`DELETE FROM task_assignees WHERE task_id = ? AND user_id = ?`, taskID, userID)
+	if err != nil {
+		return Task{}, err
+	}
+	affected, _ := res.RowsAffected()
+	if affected == 0 {
+		return Task{}, sql.ErrNoRows
+	}
+	if err := s.recordEvent(tx, taskID, actorID, EventUnassigned, map[string]any{"userId": userID}); err != nil {
+		return Task{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Task{}, err
+	}
+	return s.GetTask(taskID)
+}
+
+// IsProjectMember reports whether the user may work on tasks of the project:
+// admins are members of every project, blocked users and viewers of none.
+func (s *Store) IsProjectMember(userID, projectID int64) (bool, error) {
+	var role string
+	if err := s.db.QueryRow(`SELECT role FROM users WHERE id = ?`, userID).Scan(&role); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
 		}
-		if last.Valid {
-			t.AuthorLast = last.String
+		return false, err
+	}
+	switch role {
+	case "admin":
+		return s.ProjectExists(projectID)
+	case "blocked":
+		return false, nil
+	}
+	var exists bool
+	err := s.db.QueryRow(
+		`SELECT EXISTS(SELECT 1 FROM user_projects WHERE user_id = ? AND project_id = ? AND role != ?)`,
+		userID,
+		projectID,
+		ProjectRoleViewer,
+	).Scan(&exists)
+	return exists, err
+}
+
+func (s *Store) ListAssigneesByTaskIDs(taskIDs []int64) (map[int64][]TaskAssignee, error) {
+	result := make(map[int64][]TaskAssignee, len(taskIDs))
+	if len(taskIDs) == 0 {
+		return result, nil
+	}
+	placeholders := make([]string, 0, len(taskIDs))
+	args := make([]any, 0, len(taskIDs))
+	for _, id := range taskIDs {
+		placeholders = append(placeholders, "?")
+		args = append(args, id)
+	}
+	rows, err := s.db.Query(
+		`SELECT a.task_id, u.id, u.email, COALESCE(u.username, ''), u.first_name, u.last_name
+		FROM task_assignees a
+		JOIN users u ON a.user_id = u.id
+		WHERE a.task_id IN (`+strings.Join(placeholders, ",")+`)
+		ORDER BY a.assigned_at ASC, u.id ASC`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var taskID int64
+		var a TaskAssignee
+		if err := rows.Scan(&taskID, &a.ID, &a.Email, &a.Username, &a.FirstName, &a.LastName); err != nil {
+			return nil, err
 		}
-		tasks = append(tasks, t)
+		result[taskID] = append(result[taskID], a)
 	}
-	return tasks, nil
+	return result, rows.Err()
 }
 
 func (s *Store) AddTaskComment(taskID int64, body string, authorID int64) (TaskComment, error) {
 	var c TaskComment
-	res, err := s.db.Exec(
-		`INSERT INTO task_comments (task_id, body, author_id) VALUES (?, ?, ?)`,
-		taskID,
-		body,
-		nullableInt64(authorID),
-	)
+	tx, err := s.db.Begin()
 	if err != nil {
 		return c, err
 	}
-	id, _ := res.LastInsertId()
+	defer tx.Rollback() //nolint:errcheck
+
+	id, err := s.addTaskCommentTx(tx, taskID, body, authorID)
+	if err != nil {
+		return c, err
+	}
+	if err := tx.Commit(); err != nil {
+		return c, err
+	}
 	var created sql.NullInt64
 	var email sql.NullString
 	err = s.db.QueryRow(
@@ -744,6 +1141,26 @@ func (s *Store) AddTaskComment(taskID int64, body string, authorID int64) (TaskC
 	return c, nil
 }
 
+func (s *Store) addTaskCommentTx(tx *sql.Tx, taskID int64, body string, authorID int64) (int64, error) {
+	res, err := tx.Exec(
+		`INSERT INTO task_comments (task_id, body, author_id) VALUES (?, ?, ?)`,
+		taskID,
+		body,
+		nullableInt64(authorID),
+	)
+	if err != nil {
+		return 0, err
+	}
+	id, _ := res.LastInsertId()
+	if err := indexCommentTx(tx, taskID, id, body); err != nil {
+		return 0, err
+	}
+	if err := s.recordEvent(tx, taskID, authorID, EventCommentAdded, map[string]any{"commentId": id}); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
 func (s *Store) ListTaskComments(taskID int64) ([]TaskComment, error) {
 	commentsMap, err := s.ListCommentsByTaskIDs([]int64{taskID})
 	if err != nil {
@@ -776,16 +1193,30 @@ func (s *Store) GetTaskComment(commentID int64) (TaskComment, error) {
 	return c, nil
 }
 
-func (s *Store) DeleteTaskComment(commentID int64) error {
-	res, err := s.db.Exec(`DELETE FROM task_comments WHERE id = ?`, commentID)
+func (s *Store) DeleteTaskComment(commentID, actorID int64) error {
+	tx, err := s.db.Begin()
 	if err != nil {
 		return err
 	}
-	affected, _ := res.RowsAffected()
-	if affected == 0 {
-		return sql.ErrNoRows
+	defer tx.Rollback() //nolint:errcheck
+
+	var taskID int64
+	var body string
+	var authorID sql.NullInt64
+	var createdAt time.Time
+	if err := tx.QueryRow(`SELECT task_id, body, author_id, created_at FROM task_comments WHERE id = ?`, commentID).Scan(&taskID, &body, &authorID, &createdAt); err != nil {
+		return err
 	}
-	return nil
+	if _, err := tx.Exec(`DELETE FROM task_comments WHERE id = ?`, commentID); err != nil {
+		return err
+	}
+	if err := unindexCommentTx(tx, commentID); err != nil {
+		return err
+	}
+	if err := s.recordEvent(tx, taskID, actorID, EventCommentDeleted, map[string]any{"commentId": commentID, "body": body, "authorId": authorID.Int64, "createdAt": createdAt.UTC()}); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 func (s *Store) ListCommentsByTaskIDs(taskIDs []int64) (map[int64][]TaskComment, error) {
@@ -854,129 +1285,6 @@ func (s *Store) LookupProjectName(id int64) string {
 	return fmt.Sprintf("Проект %d", id)
 }
 
-func setupSchema(db *sql.DB) error {
-	schema := `
-CREATE TABLE IF NOT EXISTS projects (
-	id INTEGER PRIMARY KEY AUTOINCREMENT,
-	name TEXT NOT NULL UNIQUE,
-	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
-);
-CREATE TABLE IF NOT EXISTS users (
-	id INTEGER PRIMARY KEY AUTOINCREMENT,
-	email TEXT NOT NULL UNIQUE,
-	username TEXT,
-	password_hash TEXT NOT NULL,
-	role TEXT NOT NULL DEFAULT 'user',
-	first_name TEXT NOT NULL DEFAULT '',
-	last_name TEXT NOT NULL DEFAULT '',
-	telegram TEXT NOT NULL DEFAULT '',
-	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
-);
-CREATE TABLE IF NOT EXISTS tasks (
-	id INTEGER PRIMARY KEY AUTOINCREMENT,
-	title TEXT NOT NULL,
-	status TEXT NOT NULL,
-	comment TEXT DEFAULT '',
-	description TEXT DEFAULT '',
-	project_id INTEGER NOT NULL DEFAULT 1,
-	created_by INTEGER,
-	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
-	FOREIGN KEY(project_id) REFERENCES projects(id) ON DELETE CASCADE,
-	FOREIGN KEY(created_by) REFERENCES users(id) ON DELETE SET NULL
-);
-CREATE INDEX IF NOT EXISTS idx_tasks_status ON tasks(status);
-CREATE INDEX IF NOT EXISTS idx_tasks_project ON tasks(project_id);
-CREATE TABLE IF NOT EXISTS task_comments (
-	id INTEGER PRIMARY KEY AUTOINCREMENT,
-	task_id INTEGER NOT NULL,
-	author_id INTEGER,
-	body TEXT NOT NULL,
-	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
-	FOREIGN KEY(task_id) REFERENCES tasks(id) ON DELETE CASCADE,
-	FOREIGN KEY(author_id) REFERENCES users(id) ON DELETE SET NULL
-);
-CREATE INDEX IF NOT EXISTS idx_task_comments_task ON task_comments(task_id);
-CREATE TABLE IF NOT EXISTS user_projects (
-	user_id INTEGER NOT NULL,
-	project_id INTEGER NOT NULL,
-	PRIMARY KEY (user_id, project_id),
-	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE,
-	FOREIGN KEY(project_id) REFERENCES projects(id) ON DELETE CASCADE
-);
-`
-	if _, err := db.Exec(schema); err != nil {
-		return err
-	}
-
-	if _, err := db.Exec(`ALTER TABLE users ADD COLUMN username TEXT`); err != nil {
-		if !strings.Contains(strings.ToLower(err.Error()), "duplicate column") {
-			log.Printf("warning: unable to add username column: %v", err)
-		}
-	}
-	if _, err := db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_users_username ON users(username) WHERE username IS NOT NULL AND username != ''`); err != nil {
-		log.Printf("warning: unable to ensure idx_users_username: %v", err)
-	}
-
-	if _, err := db.Exec(`ALTER TABLE tasks ADD COLUMN comment TEXT DEFAULT ''`); err != nil {
-		if !strings.Contains(strings.ToLower(err.Error()), "duplicate column") {
-			log.Printf("warning: unable to add comment column: %v", err)
-		}
-	}
-
-	if _, err := db.Exec(`ALTER TABLE tasks ADD COLUMN project_id INTEGER NOT NULL DEFAULT 1`); err != nil {
-		if !strings.Contains(strings.ToLower(err.Error()), "duplicate column") {
-			log.Printf("warning: unable to add project_id column: %v", err)
-		}
-	}
-	if _, err := db.Exec(`ALTER TABLE tasks ADD COLUMN description TEXT DEFAULT ''`); err != nil {
-		if !strings.Contains(strings.ToLower(err.Error()), "duplicate column") {
-			log.Printf("warning: unable to add description column: %v", err)
-		}
-	}
-	if _, err := db.Exec(`ALTER TABLE tasks ADD COLUMN created_by INTEGER`); err != nil {
-		if !strings.Contains(strings.ToLower(err.Error()), "duplicate column") {
-			log.Printf("warning: unable to add created_by column: %v", err)
-		}
-	}
-	if _, err := db.Exec(`ALTER TABLE users ADD COLUMN telegram TEXT NOT NULL DEFAULT ''`); err != nil {
-		if !strings.Contains(strings.ToLower(err.Error()), "duplicate column") {
-			log.Printf("warning: unable to add telegram column: %v", err)
-		}
-	}
-	if _, err := db.Exec(`ALTER TABLE users ADD COLUMN first_name TEXT NOT NULL DEFAULT ''`); err != nil {
-		if !strings.Contains(strings.ToLower(err.Error()), "duplicate column") {
-			log.Printf("warning: unable to add first_name column: %v", err)
-		}
-	}
-	if _, err := db.Exec(`ALTER TABLE users ADD COLUMN last_name TEXT NOT NULL DEFAULT ''`); err != nil {
-		if !strings.Contains(strings.ToLower(err.Error()), "duplicate column") {
-			log.Printf("warning: unable to add last_name column: %v", err)
-		}
-	}
-	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS task_comments (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		task_id INTEGER NOT NULL,
-		author_id INTEGER,
-		body TEXT NOT NULL,
-		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
-		FOREIGN KEY(task_id) REFERENCES tasks(id) ON DELETE CASCADE,
-		FOREIGN KEY(author_id) REFERENCES users(id) ON DELETE SET NULL
-	)`); err != nil {
-		return err
-	}
-	if _, err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_task_comments_task ON task_comments(task_id)`); err != nil {
-		log.Printf("warning: unable to ensure idx_task_comments_task: %v", err)
-	}
-	if _, err := db.Exec(`UPDATE tasks SET project_id = ? WHERE project_id IS NULL OR project_id = 0`, DefaultProjectID); err != nil {
-		log.Printf("warning: unable to backfill project_id: %v", err)
-	}
-	if _, err := db.Exec(`UPDATE tasks SET description = comment WHERE (description IS NULL OR description = '') AND comment IS NOT NULL AND comment != ''`); err != nil {
-		log.Printf("warning: unable to backfill description from comment: %v", err)
-	}
-
-	return nil
-}
-
 func ensureDefaultProject(db *sql.DB) error {
 	if _, err := db.Exec(`INSERT OR IGNORE INTO projects (id, name) VALUES (?, ?)`, DefaultProjectID, DefaultProjectName); err != nil {
 		return err
@@ -1045,6 +1353,85 @@ func randomPassword() string {
 	return base64.RawStdEncoding.EncodeToString(b)
 }
 
+// DateLayout is the format of task start and due dates.
+const DateLayout = "2006-01-02"
+
+// overdueCond matches tasks whose due date has passed while they are still
+// outside of a final status of their project's workflow.
+const overdueCond = `(t.due_date IS NOT NULL AND t.due_date < date('now') AND NOT EXISTS (
+		SELECT 1 FROM project_statuses ps WHERE ps.project_id = t.project_id AND ps.key = t.status AND ps.is_final = 1))`
+
+const taskSelect = `SELECT t.id, t.title, t.status, COALESCE(t.description, t.comment, ''), t.project_id, t.created_at, t.created_by, u.email, u.first_name, u.last_name,
+		t.start_date, t.due_date, ` + overdueCond + `
+	FROM tasks t
+	LEFT JOIN users u ON t.created_by = u.id`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+const userColumns = `id, email, COALESCE(username, ''), password_hash, role, created_at, telegram, COALESCE(telegram_id, 0), first_name, last_name,
+	notify_assigned, notify_comments, notify_status, email_verified,
+	EXISTS (SELECT 1 FROM user_totp WHERE user_totp.user_id = users.id AND user_totp.enabled = 1)`
+
+func scanUser(row rowScanner) (User, error) {
+	var u User
+	err := row.Scan(
+		&u.ID,
+		&u.Email,
+		&u.Username,
+		&u.Password,
+		&u.Role,
+		&u.CreatedAt,
+		&u.Telegram,
+		&u.TelegramID,
+		&u.FirstName,
+		&u.LastName,
+		&u.Notifications.Assigned,
+		&u.Notifications.Comments,
+		&u.Notifications.StatusChanges,
+		&u.EmailVerified,
+		&u.TwoFactorEnabled,
+	)
+	u.CreatedAt = u.CreatedAt.UTC()
+	return u, err
+}
+
+func scanTask(row rowScanner) (Task, error) {
+	var t Task
+	var created sql.NullInt64
+	var email sql.NullString
+	var first sql.NullString
+	var last sql.NullString
+	var start sql.NullTime
+	var due sql.NullTime
+	if err := row.Scan(&t.ID, &t.Title, &t.Status, &t.Description, &t.ProjectID, &t.CreatedAt, &created, &email, &first, &last, &start, &due, &t.Overdue); err != nil {
+		return t, err
+	}
+	if start.Valid {
+		d := start.Time.UTC()
+		t.StartDate = &d
+	}
+	if due.Valid {
+		d := due.Time.UTC()
+		t.DueDate = &d
+	}
+	t.CreatedAt = t.CreatedAt.UTC()
+	if created.Valid {
+		t.CreatedBy = created.Int64
+	}
+	if email.Valid {
+		t.AuthorEmail = email.String
+	}
+	if first.Valid {
+		t.AuthorFirst = first.String
+	}
+	if last.Valid {
+		t.AuthorLast = last.String
+	}
+	return t, nil
+}
+
 func nullableInt64(val int64) any {
 	if val == 0 {
 		return nil
@@ -1052,6 +1439,34 @@ func nullableInt64(val int64) any {
 	return val
 }
 
+func nullableDate(val *time.Time) any {
+	if val == nil {
+		return nil
+	}
+	return val.Format(DateLayout)
+}
+
+// ParseDate accepts a calendar date (2006-01-02) or an RFC 3339 timestamp
+// and returns the date at midnight UTC.
+func ParseDate(val string) (time.Time, error) {
+	val = strings.TrimSpace(val)
+	if d, err := time.Parse(DateLayout, val); err == nil {
+		return d, nil
+	}
+	ts, err := time.Parse(time.RFC3339, val)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q", val)
+	}
+	return time.Date(ts.Year(), ts.Month(), ts.Day(), 0, 0, 0, 0, time.UTC), nil
+}
+
+func validateDates(startDate, dueDate *time.Time) error {
+	if startDate != nil && dueDate != nil && startDate.After(*dueDate) {
+		return ErrInvalidDates
+	}
+	return nil
+}
+
 func nullableString(val string) any {
 	if strings.TrimSpace(val) == "" {
 		return nil
@@ -0,0 +1,205 @@
+package store
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+// TelegramLinkTTL is how long a link code stays valid.
+const TelegramLinkTTL = 10 * time.Minute
+
+// linkCodeAlphabet leaves out characters that are easy to confuse when the
+// code is retyped from the screen.
+const linkCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+var ErrInvalidLinkCode = errors.New("invalid or expired link code")
+
+func hashLinkCode(code string) string {
+	sum := sha256.Sum256([]byte(strings.ToUpper(strings.TrimSpace(code))))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateTelegramLinkCode issues a one-time code that links the Telegram
+// account sending "/link <code>" to the user. Earlier codes of the user stop
+// working. Only a hash of the code is stored.
+func (s *Store) CreateTelegramLinkCode(userID int64) (string, time.Time, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", time.Time{}, err
+	}
+	code := make([]byte, len(buf))
+	for i, b := range buf {
+		code[i] = linkCodeAlphabet[int(b)%len(linkCodeAlphabet)]
+	}
+	now := time.Now().UTC()
+	expiresAt := now.Add(TelegramLinkTTL)
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	if _, err := tx.Exec(`DELETE FROM telegram_link_codes WHERE user_id = ? OR expires_at <= ?`, userID, now); err != nil {
+		return "", time.Time{}, err
+	}
+	if _, err := tx.Exec(
+		`INSERT INTO telegram_link_codes (code_hash, user_id, expires_at) VALUES (?, ?, ?)`,
+		hashLinkCode(string(code)),
+		userID,
+		expiresAt,
+	); err != nil {
+		return "", time.Time{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", time.Time{}, err
+	}
+	return string(code), expiresAt, nil
+}
+
+// LinkTelegram consumes a link code and attaches the Telegram account to its
+// user, detaching it from any other user first. The Telegram username fills
+// the profile field when that is still empty.
+func (s *Store) LinkTelegram(code string, telegramID int64, telegramUsername string) (User, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return User{}, err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	var userID int64
+	var expiresAt time.Time
+	err = tx.QueryRow(`SELECT user_id, expires_at FROM telegram_link_codes WHERE code_hash = ?`, hashLinkCode(code)).Scan(&userID, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, ErrInvalidLinkCode
+	}
+	if err != nil {
+		return User{}, err
+	}
+	if _, err := tx.Exec(`DELETE FROM telegram_link_codes WHERE user_id = ?`, userID); err != nil {
+		return User{}, err
+	}
+	if !time.Now().UTC().Before(expiresAt) {
+		// Commit the cleanup so the stale code is gone either way.
+		if err := tx.Commit(); err != nil {
+			return User{}, err
+		}
+		return User{}, ErrInvalidLinkCode
+	}
+	if _, err := tx.Exec(`UPDATE users SET telegram_id = NULL WHERE telegram_id = ?`, telegramID); err != nil {
+		return User{}, err
+	}
+	res, err := tx.Exec(`UPDATE users SET telegram_id = ? WHERE id = ?`, telegramID, userID)
+	if err != nil {
+		return User{}, err
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return User{}, ErrInvalidLinkCode
+	}
+	if telegramUsername != "" {
+		if _, err := tx.Exec(`UPDATE users SET telegram = ? WHERE id = ? AND telegram = ''`, "@"+telegramUsername, userID); err != nil {
+			return User{}, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return User{}, err
+	}
+	return s.GetUserByID(userID)
+}
+
+func (s *Store) UnlinkTelegram(userID int64) error {
+	_, err := s.db.Exec(`UPDATE users SET telegram_id = NULL WHERE id = ?`, userID)
+	return err
+}
+
+// GetUserByTelegramID returns the user linked to a Telegram account.
+func (s *Store) GetUserByTelegramID(telegramID int64) (User, error) {
+	return scanUser(s.db.QueryRow(`SELECT `+userColumns+` FROM users WHERE telegram_id = ?`, telegramID))
+}
+
+// TelegramChat binds a group chat to a project: bot commands in the chat
+// default to the project and its notifications go there.
+type TelegramChat struct {
+	ChatID    int64     `json:"chatId"`
+	ProjectID int64     `json:"projectId"`
+	Title     string    `json:"title"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+const telegramChatColumns = `chat_id, project_id, title, created_at`
+
+func scanTelegramChat(row rowScanner) (TelegramChat, error) {
+	var c TelegramChat
+	err := row.Scan(&c.ChatID, &c.ProjectID, &c.Title, &c.CreatedAt)
+	c.CreatedAt = c.CreatedAt.UTC()
+	return c, err
+}
+
+func (s *Store) queryTelegramChats(query string, args ...any) ([]TelegramChat, error) {
+	rows, err := s.db.Query(`SELECT `+telegramChatColumns+` FROM telegram_chats `+query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	chats := make([]TelegramChat, 0)
+	for rows.Next() {
+		c, err := scanTelegramChat(rows)
+		if err != nil {
+			return nil, err
+		}
+		chats = append(chats, c)
+	}
+	return chats, rows.Err()
+}
+
+func (s *Store) ListTelegramChats() ([]TelegramChat, error) {
+	return s.queryTelegramChats(`ORDER BY project_id, chat_id`)
+}
+
+// ListProjectTelegramChats returns the chats bound to a project.
+func (s *Store) ListProjectTelegramChats(projectID int64) ([]TelegramChat, error) {
+	return s.queryTelegramChats(`WHERE project_id = ? ORDER BY chat_id`, projectID)
+}
+
+func (s *Store) GetTelegramChat(chatID int64) (TelegramChat, error) {
+	return scanTelegramChat(s.db.QueryRow(`SELECT `+telegramChatColumns+` FROM telegram_chats WHERE chat_id = ?`, chatID))
+}
+
+// BindTelegramChat binds a chat to a project, replacing its previous binding.
+// It returns sql.ErrNoRows when the project does not exist.
+func (s *Store) BindTelegramChat(chatID, projectID int64, title string) (TelegramChat, error) {
+	ok, err := s.ProjectExists(projectID)
+	if err != nil {
+		return TelegramChat{}, err
+	}
+	if !ok {
+		return TelegramChat{}, sql.ErrNoRows
+	}
+	if _, err := s.db.Exec(
+		`INSERT INTO telegram_chats (chat_id, project_id, title) VALUES (?, ?, ?)
+		ON CONFLICT(chat_id) DO UPDATE SET project_id = excluded.project_id, title = excluded.title`,
+		chatID,
+		projectID,
+		strings.TrimSpace(title),
+	); err != nil {
+		return TelegramChat{}, err
+	}
+	return s.GetTelegramChat(chatID)
+}
+
+func (s *Store) UnbindTelegramChat(chatID int64) error {
+	res, err := s.db.Exec(`DELETE FROM telegram_chats WHERE chat_id = ?`, chatID)
+	if err != nil {
+		return err
+	}
+	affected, _ := res.RowsAffected()
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
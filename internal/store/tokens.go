@@ -0,0 +1,139 @@
+package store
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+// APITokenPrefix marks personal access tokens so they are easy to tell apart
+// from session cookies and to spot in leaked logs.
+const APITokenPrefix = "lt_"
+
+const tokenTouchInterval = time.Minute
+
+var ErrTokenExpired = errors.New("token expired")
+
+type APIToken struct {
+	ID         int64      `json:"id"`
+	UserID     int64      `json:"userId"`
+	Name       string     `json:"name"`
+	Hint       string     `json:"hint"`
+	ReadOnly   bool       `json:"readOnly"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+const apiTokenColumns = `id, user_id, name, hint, read_only, expires_at, last_used_at, created_at`
+
+func scanAPIToken(row rowScanner) (APIToken, error) {
+	var t APIToken
+	var expires sql.NullTime
+	var lastUsed sql.NullTime
+	if err := row.Scan(&t.ID, &t.UserID, &t.Name, &t.Hint, &t.ReadOnly, &expires, &lastUsed, &t.CreatedAt); err != nil {
+		return t, err
+	}
+	t.CreatedAt = t.CreatedAt.UTC()
+	if expires.Valid {
+		val := expires.Time.UTC()
+		t.ExpiresAt = &val
+	}
+	if lastUsed.Valid {
+		val := lastUsed.Time.UTC()
+		t.LastUsedAt = &val
+	}
+	return t, nil
+}
+
+// CreateAPIToken issues a new token for the user. The plain value is returned
+// once; only its hash is stored.
+func (s *Store) CreateAPIToken(userID int64, name string, readOnly bool, expiresAt *time.Time) (APIToken, string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return APIToken{}, "", err
+	}
+	plain := APITokenPrefix + base64.RawURLEncoding.EncodeToString(buf)
+	var expires any
+	if expiresAt != nil {
+		expires = expiresAt.UTC()
+	}
+	res, err := s.db.Exec(
+		`INSERT INTO api_tokens (user_id, name, token_hash, hint, read_only, expires_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		userID,
+		name,
+		hashAPIToken(plain),
+		plain[len(plain)-4:],
+		readOnly,
+		expires,
+	)
+	if err != nil {
+		return APIToken{}, "", err
+	}
+	id, _ := res.LastInsertId()
+	t, err := scanAPIToken(s.db.QueryRow(`SELECT `+apiTokenColumns+` FROM api_tokens WHERE id = ?`, id))
+	return t, plain, err
+}
+
+func (s *Store) ListAPITokens(userID int64) ([]APIToken, error) {
+	rows, err := s.db.Query(`SELECT `+apiTokenColumns+` FROM api_tokens WHERE user_id = ? ORDER BY id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tokens := make([]APIToken, 0)
+	for rows.Next() {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+// DeleteAPIToken revokes one of the user's tokens.
+func (s *Store) DeleteAPIToken(userID, id int64) error {
+	res, err := s.db.Exec(`DELETE FROM api_tokens WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return err
+	}
+	affected, _ := res.RowsAffected()
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// LookupAPIToken resolves a plain token to its record, rejecting expired
+// ones, and notes when it was last used.
+func (s *Store) LookupAPIToken(plain string) (APIToken, error) {
+	if !strings.HasPrefix(plain, APITokenPrefix) {
+		return APIToken{}, sql.ErrNoRows
+	}
+	t, err := scanAPIToken(s.db.QueryRow(`SELECT `+apiTokenColumns+` FROM api_tokens WHERE token_hash = ?`, hashAPIToken(plain)))
+	if err != nil {
+		return APIToken{}, err
+	}
+	now := time.Now().UTC()
+	if t.ExpiresAt != nil && !now.Before(*t.ExpiresAt) {
+		return APIToken{}, ErrTokenExpired
+	}
+	if t.LastUsedAt == nil || now.Sub(*t.LastUsedAt) > tokenTouchInterval {
+		if _, err := s.db.Exec(`UPDATE api_tokens SET last_used_at = ? WHERE id = ?`, now, t.ID); err != nil {
+			return APIToken{}, err
+		}
+		t.LastUsedAt = &now
+	}
+	return t, nil
+}
+
+func hashAPIToken(plain string) string {
+	sum := sha256.Sum256([]byte(plain))
+	return hex.EncodeToString(sum[:])
+}
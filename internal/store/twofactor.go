@@ -0,0 +1,364 @@
+package store
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+
+	"litetask/internal/totp"
+)
+
+const (
+	// RecoveryCodeCount is how many recovery codes a user gets; each works
+	// once in place of a TOTP code.
+	RecoveryCodeCount = 10
+	// LoginChallengeTTL is how long the second login step stays open after
+	// the password was accepted.
+	LoginChallengeTTL = 5 * time.Minute
+	// maxChallengeAttempts is how many codes may be tried per password, and
+	// maxOpenChallenges how many logins may wait for a code at once; together
+	// they bound how fast codes can be guessed.
+	maxChallengeAttempts = 5
+	maxOpenChallenges    = 3
+
+	settingRequireAdminTwoFactor = "require_admin_2fa"
+)
+
+// recoveryAlphabet has no letters that are easy to mistake for digits.
+const recoveryAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+var (
+	ErrTwoFactorEnabled    = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorNotEnabled = errors.New("two-factor authentication is not enabled")
+	ErrInvalidCode         = errors.New("invalid code")
+	ErrTooManyLogins       = errors.New("too many logins waiting for a code")
+)
+
+// TwoFactor is the state of a user's two-factor authentication. Secret is
+// set from the start of the enrollment, Enabled once it was confirmed with a
+// code.
+type TwoFactor struct {
+	Enabled           bool   `json:"enabled"`
+	Secret            string `json:"-"`
+	RecoveryCodesLeft int    `json:"recoveryCodesLeft"`
+}
+
+// GetTwoFactor returns the two-factor state of a user; a user who never
+// enrolled gets the zero value.
+func (s *Store) GetTwoFactor(userID int64) (TwoFactor, error) {
+	var tf TwoFactor
+	err := s.db.QueryRow(`SELECT secret, enabled FROM user_totp WHERE user_id = ?`, userID).Scan(&tf.Secret, &tf.Enabled)
+	if errors.Is(err, sql.ErrNoRows) {
+		return TwoFactor{}, nil
+	}
+	if err != nil {
+		return TwoFactor{}, err
+	}
+	if err := s.db.QueryRow(
+		`SELECT COUNT(*) FROM recovery_codes WHERE user_id = ? AND used_at IS NULL`,
+		userID,
+	).Scan(&tf.RecoveryCodesLeft); err != nil {
+		return TwoFactor{}, err
+	}
+	return tf, nil
+}
+
+// BeginTwoFactor starts an enrollment with a new secret, replacing one that
+// was never confirmed.
+func (s *Store) BeginTwoFactor(userID int64) (string, error) {
+	secret, err := totp.NewSecret()
+	if err != nil {
+		return "", err
+	}
+	res, err := s.db.Exec(
+		`INSERT INTO user_totp (user_id, secret, enabled, last_step, created_at) VALUES (?, ?, 0, 0, ?)
+		ON CONFLICT(user_id) DO UPDATE SET secret = excluded.secret, created_at = excluded.created_at
+		WHERE user_totp.enabled = 0`,
+		userID,
+		secret,
+		time.Now().UTC(),
+	)
+	if err != nil {
+		return "", err
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return "", ErrTwoFactorEnabled
+	}
+	return secret, nil
+}
+
+// EnableTwoFactor finishes the enrollment once code shows that the
+// authenticator app has the secret, and returns the new recovery codes.
+func (s *Store) EnableTwoFactor(userID int64, code string) ([]string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	var secret string
+	var enabled bool
+	err = tx.QueryRow(`SELECT secret, enabled FROM user_totp WHERE user_id = ?`, userID).Scan(&secret, &enabled)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTwoFactorNotEnabled
+	}
+	if err != nil {
+		return nil, err
+	}
+	if enabled {
+		return nil, ErrTwoFactorEnabled
+	}
+	step, ok := totp.Validate(secret, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+	if _, err := tx.Exec(`UPDATE user_totp SET enabled = 1, last_step = ? WHERE user_id = ?`, step, userID); err != nil {
+		return nil, err
+	}
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return codes, tx.Commit()
+}
+
+// VerifyTwoFactor checks a TOTP code or, failing that, a recovery code,
+// which is used up. A TOTP code is accepted only once.
+func (s *Store) VerifyTwoFactor(userID int64, code string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	var secret string
+	var lastStep int64
+	err = tx.QueryRow(`SELECT secret, last_step FROM user_totp WHERE user_id = ? AND enabled = 1`, userID).Scan(&secret, &lastStep)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrTwoFactorNotEnabled
+	}
+	if err != nil {
+		return err
+	}
+	if step, ok := totp.Validate(secret, code, time.Now()); ok {
+		if step <= lastStep {
+			return ErrInvalidCode
+		}
+		if _, err := tx.Exec(`UPDATE user_totp SET last_step = ? WHERE user_id = ?`, step, userID); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	res, err := tx.Exec(
+		`UPDATE recovery_codes SET used_at = ? WHERE user_id = ? AND code_hash = ? AND used_at IS NULL`,
+		time.Now().UTC(),
+		userID,
+		hashAuthToken(normalizeRecoveryCode(code)),
+	)
+	if err != nil {
+		return err
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return ErrInvalidCode
+	}
+	return tx.Commit()
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes.
+func (s *Store) RegenerateRecoveryCodes(userID int64) ([]string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	var enabled bool
+	err = tx.QueryRow(`SELECT enabled FROM user_totp WHERE user_id = ?`, userID).Scan(&enabled)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !enabled) {
+		return nil, ErrTwoFactorNotEnabled
+	}
+	if err != nil {
+		return nil, err
+	}
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return codes, tx.Commit()
+}
+
+// DisableTwoFactor removes the user's secret and recovery codes, as when the
+// user turns it off or an admin resets it for a user who lost the phone.
+func (s *Store) DisableTwoFactor(userID int64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	for _, stmt := range []string{
+		`DELETE FROM user_totp WHERE user_id = ?`,
+		`DELETE FROM recovery_codes WHERE user_id = ?`,
+		`DELETE FROM login_challenges WHERE user_id = ?`,
+	} {
+		if _, err := tx.Exec(stmt, userID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func replaceRecoveryCodes(tx *sql.Tx, userID int64) ([]string, error) {
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return nil, err
+	}
+	codes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(`INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)`, userID, hashAuthToken(code)); err != nil {
+			return nil, err
+		}
+		codes[i] = code[:5] + "-" + code[5:]
+	}
+	return codes, nil
+}
+
+// newRecoveryCode returns ten random characters, shown split in two halves.
+// rand.Int draws every character of the alphabet with the same chance.
+func newRecoveryCode() (string, error) {
+	buf := make([]byte, 10)
+	size := big.NewInt(int64(len(recoveryAlphabet)))
+	for i := range buf {
+		n, err := rand.Int(rand.Reader, size)
+		if err != nil {
+			return "", err
+		}
+		buf[i] = recoveryAlphabet[n.Int64()]
+	}
+	return string(buf), nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// CreateLoginChallenge opens the second login step for a user whose
+// password was accepted. Only a hash of the token is stored. It returns
+// ErrTooManyLogins while the user has too many challenges open.
+func (s *Store) CreateLoginChallenge(userID int64) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+	now := time.Now().UTC()
+	tx, err := s.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	if _, err := tx.Exec(`DELETE FROM login_challenges WHERE expires_at <= ?`, now); err != nil {
+		return "", err
+	}
+	var open int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM login_challenges WHERE user_id = ?`, userID).Scan(&open); err != nil {
+		return "", err
+	}
+	if open >= maxOpenChallenges {
+		return "", ErrTooManyLogins
+	}
+	if _, err := tx.Exec(
+		`INSERT INTO login_challenges (token_hash, user_id, attempts, expires_at, created_at) VALUES (?, ?, 0, ?, ?)`,
+		hashAuthToken(token),
+		userID,
+		now.Add(LoginChallengeTTL),
+		now,
+	); err != nil {
+		return "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// UseLoginChallenge counts an attempt at the second login step and returns
+// the user it is for. It returns ErrInvalidToken when the challenge is
+// unknown, expired or out of attempts.
+func (s *Store) UseLoginChallenge(token string) (int64, error) {
+	hash := hashAuthToken(token)
+	var userID int64
+	err := s.db.QueryRow(
+		`UPDATE login_challenges SET attempts = attempts + 1
+		WHERE token_hash = ? AND expires_at > ? AND attempts < ?
+		RETURNING user_id`,
+		hash,
+		time.Now().UTC(),
+		maxChallengeAttempts,
+	).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrInvalidToken
+	}
+	return userID, err
+}
+
+// CloseLoginChallenge removes a challenge once the login went through.
+func (s *Store) CloseLoginChallenge(token string) error {
+	_, err := s.db.Exec(`DELETE FROM login_challenges WHERE token_hash = ?`, hashAuthToken(token))
+	return err
+}
+
+// RequireAdminTwoFactor reports whether admins must use two-factor
+// authentication to sign in.
+func (s *Store) RequireAdminTwoFactor() (bool, error) {
+	var value string
+	err := s.db.QueryRow(`SELECT value FROM settings WHERE key = ?`, settingRequireAdminTwoFactor).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return value == "true", err
+}
+
+// SetRequireAdminTwoFactor turns the requirement on or off. Turning it on
+// signs out the admins without two-factor authentication, who have to
+// enroll at their next login.
+func (s *Store) SetRequireAdminTwoFactor(required bool) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	value := "false"
+	if required {
+		value = "true"
+	}
+	if _, err := tx.Exec(
+		`INSERT INTO settings (key, value) VALUES (?, ?) ON CONFLICT(key) DO UPDATE SET value = excluded.value`,
+		settingRequireAdminTwoFactor,
+		value,
+	); err != nil {
+		return err
+	}
+	if required {
+		if _, err := tx.Exec(
+			`DELETE FROM sessions WHERE user_id IN (
+				SELECT id FROM users WHERE role = 'admin'
+				AND id NOT IN (SELECT user_id FROM user_totp WHERE enabled = 1)
+			)`,
+		); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,95 @@
+package store
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"litetask/internal/totp"
+)
+
+func TestVerifyTwoFactorRejectsReplay(t *testing.T) {
+	s, err := Open(filepath.Join(t.TempDir(), "litetask.db"))
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	u, err := s.CreateUser("ann@example.com", "", "password", "user", "", "")
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	secret, err := s.BeginTwoFactor(u.ID)
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	code := func(step int64) string {
+		c, err := totp.Code(secret, step)
+		if err != nil {
+			t.Fatalf("code: %v", err)
+		}
+		return c
+	}
+	now := totp.Step(time.Now())
+	recovery, err := s.EnableTwoFactor(u.ID, code(now))
+	if err != nil {
+		t.Fatalf("enable: %v", err)
+	}
+
+	// The code that confirmed the enrollment is spent, and so is every
+	// code up to the newest one accepted.
+	steps := []struct {
+		name string
+		step int64
+		want error
+	}{
+		{"enrollment code", now, ErrInvalidCode},
+		{"next code", now + 1, nil},
+		{"next code again", now + 1, ErrInvalidCode},
+		{"older code", now, ErrInvalidCode},
+	}
+	for _, st := range steps {
+		if err := s.VerifyTwoFactor(u.ID, code(st.step)); !errors.Is(err, st.want) {
+			t.Errorf("%s: err = %v, want %v", st.name, err, st.want)
+		}
+	}
+
+	// Recovery codes work once, with or without the dash.
+	if err := s.VerifyTwoFactor(u.ID, strings.ToUpper(strings.ReplaceAll(recovery[0], "-", ""))); err != nil {
+		t.Fatalf("recovery code: %v", err)
+	}
+	if err := s.VerifyTwoFactor(u.ID, recovery[0]); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("used recovery code: err = %v, want ErrInvalidCode", err)
+	}
+	tf, err := s.GetTwoFactor(u.ID)
+	if err != nil {
+		t.Fatalf("get two-factor: %v", err)
+	}
+	if tf.RecoveryCodesLeft != RecoveryCodeCount-1 {
+		t.Errorf("recovery codes left = %d, want %d", tf.RecoveryCodesLeft, RecoveryCodeCount-1)
+	}
+}
+
+func TestNewRecoveryCode(t *testing.T) {
+	seen := make(map[rune]bool)
+	for i := 0; i < 200; i++ {
+		code, err := newRecoveryCode()
+		if err != nil {
+			t.Fatalf("new recovery code: %v", err)
+		}
+		if len(code) != 10 {
+			t.Fatalf("code %q has %d characters, want 10", code, len(code))
+		}
+		for _, r := range code {
+			if !strings.ContainsRune(recoveryAlphabet, r) {
+				t.Fatalf("code %q has %q outside the alphabet", code, r)
+			}
+			seen[r] = true
+		}
+	}
+	// 2000 draws leave a character out with a chance of about 1e-27.
+	if len(seen) != len(recoveryAlphabet) {
+		t.Errorf("drew %d of the %d characters", len(seen), len(recoveryAlphabet))
+	}
+}
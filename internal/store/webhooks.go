@@ -0,0 +1,354 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Webhook delivery states.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+type Webhook struct {
+	ID        int64     `json:"id"`
+	ProjectID int64     `json:"projectId"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"-"`
+	Events    []string  `json:"events"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Accepts reports whether the webhook subscribes to eventType. An empty
+// event list subscribes to everything.
+func (w Webhook) Accepts(eventType string) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, e := range w.Events {
+		if e == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+type WebhookDelivery struct {
+	ID            int64      `json:"id"`
+	WebhookID     int64      `json:"webhookId"`
+	EventType     string     `json:"eventType"`
+	Payload       string     `json:"payload"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt *time.Time `json:"nextAttemptAt,omitempty"`
+	ResponseCode  int        `json:"responseCode,omitempty"`
+	LastError     string     `json:"lastError,omitempty"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	DeliveredAt   *time.Time `json:"deliveredAt,omitempty"`
+}
+
+const webhookColumns = `id, project_id, url, secret, events, active, created_at`
+
+func scanWebhook(row rowScanner) (Webhook, error) {
+	var w Webhook
+	var eventList string
+	if err := row.Scan(&w.ID, &w.ProjectID, &w.URL, &w.Secret, &eventList, &w.Active, &w.CreatedAt); err != nil {
+		return w, err
+	}
+	w.CreatedAt = w.CreatedAt.UTC()
+	w.Events = splitList(eventList)
+	return w, nil
+}
+
+func (s *Store) CreateWebhook(projectID int64, url, secret string, eventTypes []string, active bool) (Webhook, error) {
+	ok, err := s.ProjectExists(projectID)
+	if err != nil {
+		return Webhook{}, err
+	}
+	if !ok {
+		return Webhook{}, sql.ErrNoRows
+	}
+	res, err := s.db.Exec(
+		`INSERT INTO webhooks (project_id, url, secret, events, active) VALUES (?, ?, ?, ?, ?)`,
+		projectID,
+		url,
+		secret,
+		strings.Join(eventTypes, ","),
+		active,
+	)
+	if err != nil {
+		return Webhook{}, err
+	}
+	id, _ := res.LastInsertId()
+	return s.GetWebhook(id)
+}
+
+func (s *Store) GetWebhook(id int64) (Webhook, error) {
+	return scanWebhook(s.db.QueryRow(`SELECT `+webhookColumns+` FROM webhooks WHERE id = ?`, id))
+}
+
+// ListWebhooks returns the webhooks of a project, or of all projects when
+// projectID is 0.
+func (s *Store) ListWebhooks(projectID int64) ([]Webhook, error) {
+	query := `SELECT ` + webhookColumns + ` FROM webhooks`
+	args := make([]any, 0)
+	if projectID > 0 {
+		query += ` WHERE project_id = ?`
+		args = append(args, projectID)
+	}
+	rows, err := s.db.Query(query+` ORDER BY id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	hooks := make([]Webhook, 0)
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, w)
+	}
+	return hooks, rows.Err()
+}
+
+func (s *Store) UpdateWebhook(id int64, url *string, secret *string, eventTypes []string, active *bool) (Webhook, error) {
+	sets := make([]string, 0)
+	args := make([]any, 0)
+	if url != nil {
+		sets = append(sets, "url = ?")
+		args = append(args, *url)
+	}
+	if secret != nil {
+		sets = append(sets, "secret = ?")
+		args = append(args, *secret)
+	}
+	if eventTypes != nil {
+		sets = append(sets, "events = ?")
+		args = append(args, strings.Join(eventTypes, ","))
+	}
+	if active != nil {
+		sets = append(sets, "active = ?")
+		args = append(args, *active)
+	}
+	if len(sets) == 0 {
+		return s.GetWebhook(id)
+	}
+	args = append(args, id)
+	res, err := s.db.Exec(`UPDATE webhooks SET `+strings.Join(sets, ", ")+` WHERE id = ?`, args...)
+	if err != nil {
+		return Webhook{}, err
+	}
+	affected, _ := res.RowsAffected()
+	if affected == 0 {
+		return Webhook{}, sql.ErrNoRows
+	}
+	return s.GetWebhook(id)
+}
+
+func (s *Store) DeleteWebhook(id int64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	if _, err := tx.Exec(`DELETE FROM webhook_deliveries WHERE webhook_id = ?`, id); err != nil {
+		return err
+	}
+	res, err := tx.Exec(`DELETE FROM webhooks WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	affected, _ := res.RowsAffected()
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return tx.Commit()
+}
+
+// ActiveWebhooksFor returns the enabled webhooks of a project subscribed to
+// eventType.
+func (s *Store) ActiveWebhooksFor(projectID int64, eventType string) ([]Webhook, error) {
+	hooks, err := s.ListWebhooks(projectID)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]Webhook, 0, len(hooks))
+	for _, w := range hooks {
+		if w.Active && w.Accepts(eventType) {
+			result = append(result, w)
+		}
+	}
+	return result, nil
+}
+
+// settingWebhookCursor keeps the last task event turned into webhook
+// deliveries.
+const settingWebhookCursor = "webhook_event_cursor"
+
+// NewWebhookDelivery is a delivery to queue for a webhook.
+type NewWebhookDelivery struct {
+	WebhookID int64
+	EventType string
+	Payload   string
+}
+
+// WebhookCursor returns the last task event turned into webhook deliveries.
+// The first call starts at the newest event, so that webhooks do not replay
+// the history recorded before they were followed.
+func (s *Store) WebhookCursor() (int64, error) {
+	var value string
+	err := s.db.QueryRow(`SELECT value FROM settings WHERE key = ?`, settingWebhookCursor).Scan(&value)
+	if err == nil {
+		return strconv.ParseInt(value, 10, 64)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+	cursor, err := s.LatestTaskEventID()
+	if err != nil {
+		return 0, err
+	}
+	_, err = s.db.Exec(
+		`INSERT INTO settings (key, value) VALUES (?, ?)`,
+		settingWebhookCursor,
+		strconv.FormatInt(cursor, 10),
+	)
+	return cursor, err
+}
+
+// EnqueueWebhookDeliveries queues the deliveries for the task events up to
+// cursor and moves the cursor there in the same transaction, so that every
+// event is queued exactly once.
+func (s *Store) EnqueueWebhookDeliveries(cursor int64, deliveries []NewWebhookDelivery) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	now := time.Now().UTC()
+	for _, d := range deliveries {
+		if _, err := tx.Exec(
+			`INSERT INTO webhook_deliveries (webhook_id, event_type, payload, status, next_attempt_at) VALUES (?, ?, ?, ?, ?)`,
+			d.WebhookID,
+			d.EventType,
+			d.Payload,
+			DeliveryPending,
+			now,
+		); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(
+		`INSERT INTO settings (key, value) VALUES (?, ?) ON CONFLICT(key) DO UPDATE SET value = excluded.value`,
+		settingWebhookCursor,
+		strconv.FormatInt(cursor, 10),
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DueWebhookDeliveries returns pending deliveries whose next attempt is due.
+func (s *Store) DueWebhookDeliveries(now time.Time, limit int) ([]WebhookDelivery, error) {
+	rows, err := s.db.Query(
+		`SELECT `+deliveryColumns+` FROM webhook_deliveries
+		WHERE status = ? AND next_attempt_at <= ?
+		ORDER BY next_attempt_at, id
+		LIMIT ?`,
+		DeliveryPending,
+		now.UTC(),
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanDeliveries(rows)
+}
+
+func (s *Store) ListWebhookDeliveries(webhookID int64, limit int) ([]WebhookDelivery, error) {
+	rows, err := s.db.Query(
+		`SELECT `+deliveryColumns+` FROM webhook_deliveries WHERE webhook_id = ? ORDER BY id DESC LIMIT ?`,
+		webhookID,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanDeliveries(rows)
+}
+
+// RecordWebhookAttempt stores the outcome of a delivery attempt. A nil
+// nextAttempt with a failed attempt gives the delivery up.
+func (s *Store) RecordWebhookAttempt(id int64, delivered bool, responseCode int, lastError string, nextAttempt *time.Time) error {
+	status := DeliveryPending
+	var deliveredAt any
+	switch {
+	case delivered:
+		status = DeliveryDelivered
+		deliveredAt = time.Now().UTC()
+	case nextAttempt == nil:
+		status = DeliveryFailed
+	}
+	var next any
+	if nextAttempt != nil {
+		next = nextAttempt.UTC()
+	}
+	_, err := s.db.Exec(
+		`UPDATE webhook_deliveries
+		SET status = ?, attempts = attempts + 1, response_code = ?, last_error = ?, next_attempt_at = ?, delivered_at = ?
+		WHERE id = ?`,
+		status,
+		responseCode,
+		lastError,
+		next,
+		deliveredAt,
+		id,
+	)
+	return err
+}
+
+const deliveryColumns = `id, webhook_id, event_type, payload, status, attempts, next_attempt_at, response_code, last_error, created_at, delivered_at`
+
+func scanDeliveries(rows *sql.Rows) ([]WebhookDelivery, error) {
+	deliveries := make([]WebhookDelivery, 0)
+	for rows.Next() {
+		var d WebhookDelivery
+		var next sql.NullTime
+		var delivered sql.NullTime
+		if err := rows.Scan(&d.ID, &d.WebhookID, &d.EventType, &d.Payload, &d.Status, &d.Attempts, &next, &d.ResponseCode, &d.LastError, &d.CreatedAt, &delivered); err != nil {
+			return nil, err
+		}
+		d.CreatedAt = d.CreatedAt.UTC()
+		if next.Valid {
+			t := next.Time.UTC()
+			d.NextAttemptAt = &t
+		}
+		if delivered.Valid {
+			t := delivered.Time.UTC()
+			d.DeliveredAt = &t
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+func splitList(val string) []string {
+	result := make([]string, 0)
+	for _, item := range strings.Split(val, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
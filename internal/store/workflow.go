@@ -0,0 +1,325 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrTransitionNotAllowed = errors.New("status transition not allowed")
+	ErrInvalidWorkflow      = errors.New("invalid workflow")
+	ErrStatusInUse          = errors.New("status is used by tasks")
+)
+
+// DefaultWorkflow is the board every project starts with and the one
+// existing databases are migrated to.
+var DefaultWorkflow = []WorkflowStatus{
+	{Key: "new", Title: "Новая"},
+	{Key: "in_progress", Title: "В работе"},
+	{Key: "done", Title: "Готова", Final: true},
+}
+
+type WorkflowStatus struct {
+	Key   string `json:"key"`
+	Title string `json:"title"`
+	Final bool   `json:"final"`
+}
+
+// Workflow is the ordered set of board columns of a project. When
+// Transitions is empty a task may move between any two statuses; otherwise
+// only the listed moves are allowed.
+type Workflow struct {
+	ProjectID   int64               `json:"projectId"`
+	Statuses    []WorkflowStatus    `json:"statuses"`
+	Transitions map[string][]string `json:"transitions"`
+}
+
+func (w Workflow) Has(key string) bool {
+	for _, st := range w.Statuses {
+		if st.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+func (w Workflow) Title(key string) string {
+	for _, st := range w.Statuses {
+		if st.Key == key {
+			return st.Title
+		}
+	}
+	return key
+}
+
+func (w Workflow) Keys() []string {
+	keys := make([]string, 0, len(w.Statuses))
+	for _, st := range w.Statuses {
+		keys = append(keys, st.Key)
+	}
+	return keys
+}
+
+// CanMove reports whether a task in status from may be moved to status to.
+func (w Workflow) CanMove(from, to string) bool {
+	if !w.Has(to) {
+		return false
+	}
+	if from == to || len(w.Transitions) == 0 {
+		return true
+	}
+	for _, next := range w.Transitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Store) GetWorkflow(projectID int64) (Workflow, error) {
+	wf := Workflow{ProjectID: projectID, Statuses: []WorkflowStatus{}, Transitions: map[string][]string{}}
+	ok, err := s.ProjectExists(projectID)
+	if err != nil {
+		return wf, err
+	}
+	if !ok {
+		return wf, sql.ErrNoRows
+	}
+
+	rows, err := s.db.Query(`SELECT key, title, is_final FROM project_statuses WHERE project_id = ? ORDER BY position, key`, projectID)
+	if err != nil {
+		return wf, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var st WorkflowStatus
+		if err := rows.Scan(&st.Key, &st.Title, &st.Final); err != nil {
+			return wf, err
+		}
+		wf.Statuses = append(wf.Statuses, st)
+	}
+	if err := rows.Err(); err != nil {
+		return wf, err
+	}
+	if len(wf.Statuses) == 0 {
+		wf.Statuses = append(wf.Statuses, DefaultWorkflow...)
+	}
+
+	trows, err := s.db.Query(`SELECT from_status, to_status FROM project_transitions WHERE project_id = ? ORDER BY from_status, to_status`, projectID)
+	if err != nil {
+		return wf, err
+	}
+	defer trows.Close()
+	for trows.Next() {
+		var from, to string
+		if err := trows.Scan(&from, &to); err != nil {
+			return wf, err
+		}
+		wf.Transitions[from] = append(wf.Transitions[from], to)
+	}
+	return wf, trows.Err()
+}
+
+// SetWorkflow replaces the statuses and transition rules of a project.
+// Statuses that still have tasks cannot be removed.
+func (s *Store) SetWorkflow(projectID int64, statuses []WorkflowStatus, transitions map[string][]string, actorID int64) (Workflow, error) {
+	if err := validateWorkflow(statuses, transitions); err != nil {
+		return Workflow{}, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return Workflow{}, err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	ok, err := s.projectExistsTx(tx, projectID)
+	if err != nil {
+		return Workflow{}, err
+	}
+	if !ok {
+		return Workflow{}, sql.ErrNoRows
+	}
+
+	keep := make(map[string]struct{}, len(statuses))
+	for _, st := range statuses {
+		keep[st.Key] = struct{}{}
+	}
+	rows, err := tx.Query(`SELECT DISTINCT status FROM tasks WHERE project_id = ?`, projectID)
+	if err != nil {
+		return Workflow{}, err
+	}
+	for rows.Next() {
+		var used string
+		if err := rows.Scan(&used); err != nil {
+			rows.Close()
+			return Workflow{}, err
+		}
+		if _, ok := keep[used]; !ok {
+			rows.Close()
+			return Workflow{}, fmt.Errorf("%w: %s", ErrStatusInUse, used)
+		}
+	}
+	rows.Close()
+
+	if _, err := tx.Exec(`DELETE FROM project_statuses WHERE project_id = ?`, projectID); err != nil {
+		return Workflow{}, err
+	}
+	if _, err := tx.Exec(`DELETE FROM project_transitions WHERE project_id = ?`, projectID); err != nil {
+		return Workflow{}, err
+	}
+	if err := insertWorkflowTx(tx, projectID, statuses); err != nil {
+		return Workflow{}, err
+	}
+	for from, targets := range transitions {
+		for _, to := range targets {
+			if _, err := tx.Exec(`INSERT OR IGNORE INTO project_transitions (project_id, from_status, to_status) VALUES (?, ?, ?)`, projectID, from, to); err != nil {
+				return Workflow{}, err
+			}
+		}
+	}
+	if err := s.recordEvent(tx, 0, actorID, EventWorkflowChanged, map[string]any{"projectId": projectID}); err != nil {
+		return Workflow{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Workflow{}, err
+	}
+	return s.GetWorkflow(projectID)
+}
+
+// StatusTitleMap returns status titles keyed by project and status key.
+func (s *Store) StatusTitleMap() map[int64]map[string]string {
+	result := make(map[int64]map[string]string)
+	rows, err := s.db.Query(`SELECT project_id, key, title FROM project_statuses`)
+	if err != nil {
+		return result
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var pid int64
+		var key, title string
+		if err := rows.Scan(&pid, &key, &title); err != nil {
+			return result
+		}
+		if result[pid] == nil {
+			result[pid] = make(map[string]string)
+		}
+		result[pid][key] = title
+	}
+	return result
+}
+
+// LookupStatusTitle returns the display title of a status in the project's
+// workflow, falling back to the key itself.
+func (s *Store) LookupStatusTitle(projectID int64, key string) string {
+	var title string
+	err := s.db.QueryRow(`SELECT title FROM project_statuses WHERE project_id = ? AND key = ?`, projectID, key).Scan(&title)
+	if err == nil {
+		return title
+	}
+	if title, ok := StatusTitles[key]; ok {
+		return title
+	}
+	return key
+}
+
+func insertWorkflowTx(tx *sql.Tx, projectID int64, statuses []WorkflowStatus) error {
+	for i, st := range statuses {
+		if _, err := tx.Exec(
+			`INSERT INTO project_statuses (project_id, key, title, position, is_final) VALUES (?, ?, ?, ?, ?)`,
+			projectID,
+			st.Key,
+			st.Title,
+			i,
+			st.Final,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateWorkflow(statuses []WorkflowStatus, transitions map[string][]string) error {
+	if len(statuses) == 0 {
+		return fmt.Errorf("%w: at least one status required", ErrInvalidWorkflow)
+	}
+	seen := make(map[string]struct{}, len(statuses))
+	for i := range statuses {
+		statuses[i].Key = strings.TrimSpace(strings.ToLower(statuses[i].Key))
+		statuses[i].Title = strings.TrimSpace(statuses[i].Title)
+		key := statuses[i].Key
+		if !validStatusKey(key) {
+			return fmt.Errorf("%w: status key %q must be 1-32 characters of a-z, 0-9 or _", ErrInvalidWorkflow, key)
+		}
+		if _, dup := seen[key]; dup {
+			return fmt.Errorf("%w: duplicate status %q", ErrInvalidWorkflow, key)
+		}
+		seen[key] = struct{}{}
+		if statuses[i].Title == "" {
+			statuses[i].Title = key
+		}
+	}
+	for from, targets := range transitions {
+		if _, ok := seen[from]; !ok {
+			return fmt.Errorf("%w: unknown status %q in transitions", ErrInvalidWorkflow, from)
+		}
+		for _, to := range targets {
+			if _, ok := seen[to]; !ok {
+				return fmt.Errorf("%w: unknown status %q in transitions", ErrInvalidWorkflow, to)
+			}
+		}
+	}
+	return nil
+}
+
+func validStatusKey(key string) bool {
+	if len(key) == 0 || len(key) > 32 {
+		return false
+	}
+	for _, r := range key {
+		switch {
+		case r >= 'a' && r <= 'z':
+		case r >= '0' && r <= '9':
+		case r == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// ensureDefaultWorkflows seeds the default statuses for projects created
+// before workflows became configurable.
+func ensureDefaultWorkflows(db *sql.DB) error {
+	rows, err := db.Query(`SELECT id FROM projects p WHERE NOT EXISTS (SELECT 1 FROM project_statuses ps WHERE ps.project_id = p.id)`)
+	if err != nil {
+		return err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if len(ids) == 0 {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+	for _, id := range ids {
+		if err := insertWorkflowTx(tx, id, DefaultWorkflow); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
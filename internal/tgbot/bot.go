@@ -5,86 +5,350 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"html"
 	"log"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
+	"unicode"
 
+	"litetask/internal/events"
 	"litetask/internal/store"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
+const searchLimit = 10
+
+// Sender is the part of the Telegram Bot API used to answer and notify.
+type Sender interface {
+	Send(c tgbotapi.Chattable) (tgbotapi.Message, error)
+	Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error)
+}
+
+// Updates is the long polling part of the Bot API.
+type Updates interface {
+	GetUpdatesChan(config tgbotapi.UpdateConfig) tgbotapi.UpdatesChannel
+	StopReceivingUpdates()
+}
+
+// Client is everything the bot needs from Telegram, including raw requests
+// for methods the library lacks. *tgbotapi.BotAPI implements it; tests use an
+// in-memory fake.
+type Client interface {
+	Sender
+	Updates
+	MakeRequest(endpoint string, params tgbotapi.Params) (*tgbotapi.APIResponse, error)
+}
+
 type Bot struct {
 	store  *store.Store
-	api    *tgbotapi.BotAPI
-	chatID int64
+	events *events.Bus
+	api    Client
+	// self is the bot's own account.
+	self      tgbotapi.User
+	chatID    int64
+	publicURL string
 }
 
-func Start(ctx context.Context, s *store.Store, token, chatID string) {
-	if token == "" || chatID == "" {
-		log.Printf("telegram bot is disabled: BOT_TOKEN or BOT_CHAT_ID not set")
-		return
+func newBot(s *store.Store, bus *events.Bus, api Client, self tgbotapi.User, chatID int64, publicURL string) *Bot {
+	return &Bot{
+		// Changes made through the bot are marked so that notify does not
+		// repeat them in the chats.
+		store:     s.WithSource(events.SourceTelegram),
+		events:    bus,
+		api:       api,
+		self:      self,
+		chatID:    chatID,
+		publicURL: strings.TrimRight(publicURL, "/"),
 	}
+}
 
-	chatIDInt, err := strconv.ParseInt(chatID, 10, 64)
-	if err != nil {
-		log.Printf("telegram bot disabled: invalid BOT_CHAT_ID: %v", err)
+type Options struct {
+	Token string
+	// ChatID is the team chat for projects without a chat of their own.
+	// Optional once chats are bound to projects.
+	ChatID string
+	// PublicURL is the address of the web UI, used for "open task" buttons.
+	// The buttons are left out when it is empty.
+	PublicURL string
+	// Webhook switches from long polling to updates pushed by Telegram.
+	// The bot polls when it is nil or cannot be registered.
+	Webhook *Webhook
+	// Poster carries messages from the rest of the server to project chats.
+	Poster *Poster
+}
+
+// Start serves the team chat BOT_CHAT_ID, group chats bound to a project
+// and private chats with the bot. Apart from /help and /link, every command
+// runs as the LiteTask user linked to the sender's Telegram account and is
+// limited to that user's projects. Task changes are pushed to the same
+// chats, see notify.
+func Start(ctx context.Context, s *store.Store, bus *events.Bus, opts Options) {
+	if opts.Token == "" {
+		log.Printf("telegram bot is disabled: BOT_TOKEN not set")
 		return
 	}
 
-	api, err := tgbotapi.NewBotAPI(token)
+	var chatIDInt int64
+	if opts.ChatID != "" {
+		var err error
+		chatIDInt, err = strconv.ParseInt(opts.ChatID, 10, 64)
+		if err != nil {
+			log.Printf("telegram bot disabled: invalid BOT_CHAT_ID: %v", err)
+			return
+		}
+	}
+
+	api, err := tgbotapi.NewBotAPI(opts.Token)
 	if err != nil {
 		log.Printf("telegram bot disabled: %v", err)
 		return
 	}
 
-	b := &Bot{store: s, api: api, chatID: chatIDInt}
+	b := newBot(s, bus, api, api.Self, chatIDInt, opts.PublicURL)
+	go b.notify(ctx)
+	b.run(ctx, opts.Webhook, opts.Poster)
+}
 
-	u := tgbotapi.NewUpdate(0)
-	u.Timeout = 30
+// run receives updates until ctx is done, through hook when it is set and
+// can be registered, by polling otherwise.
+func (b *Bot) run(ctx context.Context, hook *Webhook, poster *Poster) {
+	api := b.api
 
-	updates := api.GetUpdatesChan(u)
-	log.Printf("telegram bot started for chat %d", chatIDInt)
+	var posts <-chan post
+	if poster != nil {
+		posts = poster.queue
+		poster.active.Store(true)
+		defer poster.active.Store(false)
+	}
+
+	// A nil channel never delivers, so only the active sources are read.
+	var pushed, polled <-chan tgbotapi.Update
+	webhookSet := false
+	if hook != nil {
+		pushed = hook.updates
+		hook.active.Store(true)
+		defer hook.active.Store(false)
+		if err := hook.register(api); err != nil {
+			log.Printf("telegram bot: failed to set webhook, falling back to polling: %v", err)
+		} else {
+			webhookSet = true
+			log.Printf("telegram bot started as @%s, receiving updates at %s", b.self.UserName, hook.url)
+		}
+	}
+	if !webhookSet {
+		// getUpdates is refused while a webhook is set, e.g. by an earlier run.
+		if _, err := api.Request(tgbotapi.DeleteWebhookConfig{}); err != nil {
+			log.Printf("telegram bot: failed to delete webhook: %v", err)
+		}
+		u := tgbotapi.NewUpdate(0)
+		u.Timeout = 30
+		polled = api.GetUpdatesChan(u)
+		defer api.StopReceivingUpdates()
+		log.Printf("telegram bot started as @%s, polling for updates", b.self.UserName)
+	}
 
 	for {
 		select {
 		case <-ctx.Done():
-			api.StopReceivingUpdates()
 			return
-		case update, ok := <-updates:
+		case update, ok := <-polled:
 			if !ok {
 				return
 			}
-			if update.Message == nil || update.Message.Chat == nil {
-				continue
-			}
-			if update.Message.Chat.ID != chatIDInt {
-				continue
+			b.handleUpdate(update)
+		case update := <-pushed:
+			b.handleUpdate(update)
+		case p := <-posts:
+			for _, chatID := range b.projectChats(p.projectID) {
+				b.sendTo(chatID, p.text, nil)
 			}
-			b.handleMessage(update.Message)
 		}
 	}
 }
 
+func (b *Bot) handleUpdate(update tgbotapi.Update) {
+	if q := update.CallbackQuery; q != nil {
+		if q.Message != nil && b.allowedChat(q.Message.Chat) {
+			b.handleCallback(q)
+		}
+		return
+	}
+	if update.Message == nil || update.Message.Chat == nil {
+		return
+	}
+	// Other groups may only be bound to a project.
+	if cmd, _ := splitCommand(strings.TrimSpace(update.Message.Text)); cmd != "/bind" && !b.allowedChat(update.Message.Chat) {
+		return
+	}
+	b.handleMessage(update.Message)
+}
+
+// allowedChat reports whether the bot serves chat: the team chat, a chat
+// bound to a project or a private chat with the bot.
+func (b *Bot) allowedChat(chat *tgbotapi.Chat) bool {
+	if chat == nil {
+		return false
+	}
+	if chat.ID == b.chatID || chat.IsPrivate() {
+		return true
+	}
+	return b.chatProject(chat.ID) != 0
+}
+
+// chatProject returns the project bound to a chat, 0 if there is none.
+func (b *Bot) chatProject(chatID int64) int64 {
+	c, err := b.store.GetTelegramChat(chatID)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("bot: failed to load chat binding: %v", err)
+		}
+		return 0
+	}
+	return c.ProjectID
+}
+
+// defaultProject is the project commands in a chat act on unless told
+// otherwise: the chat's bound project or the shared one.
+func (b *Bot) defaultProject(chatID int64) int64 {
+	if pid := b.chatProject(chatID); pid != 0 {
+		return pid
+	}
+	return store.DefaultProjectID
+}
+
+// access is what the linked user may do, mirroring the web API checks.
+type access struct {
+	user store.User
+	// roles holds the project roles of non-admins; nil for admins.
+	roles map[int64]string
+}
+
+func (a access) role(projectID int64) string {
+	if a.roles == nil {
+		return store.ProjectRoleMaintainer
+	}
+	return a.roles[projectID]
+}
+
+func (a access) canRead(projectID int64) bool {
+	return a.role(projectID) != ""
+}
+
+func (a access) canWrite(projectID int64) bool {
+	return store.ProjectRoleAllows(a.role(projectID), store.ProjectRoleMember)
+}
+
+// filter restricts a task query to the user's projects. ok is false when the
+// user has no projects at all, since an empty Allowed set means no limit.
+func (a access) filter(f store.TaskFilter) (store.TaskFilter, bool) {
+	if a.roles == nil {
+		return f, true
+	}
+	f.Allowed = make(map[int64]struct{}, len(a.roles))
+	for pid := range a.roles {
+		f.Allowed[pid] = struct{}{}
+	}
+	return f, len(f.Allowed) > 0
+}
+
+// accessFor resolves the LiteTask user linked to the sender. When there is
+// none it explains how to link and returns false.
+func (b *Bot) accessFor(msg *tgbotapi.Message) (access, bool) {
+	a, refusal := b.userAccess(msg.From)
+	if refusal != "" {
+		b.reply(msg, refusal)
+		return access{}, false
+	}
+	return a, true
+}
+
+// userAccess resolves the LiteTask user linked to a Telegram account. When
+// access is refused it returns the explanation for the sender.
+func (b *Bot) userAccess(from *tgbotapi.User) (access, string) {
+	if from == nil {
+		return access{}, "Не удалось определить твой аккаунт Telegram"
+	}
+	u, err := b.store.GetUserByTelegramID(from.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return access{}, "Аккаунт Telegram не привязан. Получи код в профиле LiteTask и отправь /link <код>."
+	}
+	if err != nil {
+		log.Printf("bot: failed to find user: %v", err)
+		return access{}, "Не удалось проверить доступ"
+	}
+	if u.Role == "blocked" {
+		return access{}, "Аккаунт заблокирован"
+	}
+	a := access{user: u}
+	if u.Role != "admin" {
+		roles, err := b.store.GetUserProjectRoles(u.ID)
+		if err != nil {
+			log.Printf("bot: failed to load user projects: %v", err)
+			return access{}, "Не удалось проверить доступ"
+		}
+		a.roles = roles
+	}
+	return a, ""
+}
+
 func (b *Bot) handleMessage(msg *tgbotapi.Message) {
 	text := strings.TrimSpace(msg.Text)
 	if text == "" {
 		return
 	}
 
+	// Replies to task messages are comments, unless they are commands.
+	if taskID, ok := b.commentTarget(msg); ok && !strings.HasPrefix(text, "/") {
+		if acc, ok := b.accessFor(msg); ok {
+			b.addComment(msg, acc, taskID, text)
+		}
+		return
+	}
+
 	cmd, rest := splitCommand(text)
 	switch cmd {
 	case "/start", "/help":
 		reply := "LiteTask бот\n\n" +
+			"Сначала привяжи аккаунт: получи код в профиле LiteTask и отправь /link <код> (лучше в личном чате с ботом).\n\n" +
 			"Команды:\n" +
-			"/new [projectId] <название> |описание — создать задачу в проекте (по умолчанию Общий)\n" +
-			"/status <id> <new|in_progress|done> — сменить статус\n" +
-			"/list [projectId] [all] — показать задачи (по умолчанию новые задачи в Общем, all — все статусы, projectId=all — все проекты)\n" +
+			"/new [projectId] <название> [@ГГГГ-ММ-ДД] |описание — создать задачу в проекте (по умолчанию проект чата или Общий), @дата — срок\n" +
+			"/task <id> — задача с описанием и последними комментариями\n" +
+			"/comment <id> <текст> — прокомментировать задачу (или просто ответь на сообщение бота с задачей)\n" +
+			"/status <id> <статус> — сменить статус (статусы проекта: new, in_progress, done или настроенные)\n" +
+			"/list [projectId] [all] — показать задачи (по умолчанию новые задачи проекта чата или Общего, all — все статусы, projectId=all — все проекты)\n" +
+			"/my — мои задачи\n" +
+			"/search <запрос> — найти задачи по названию, описанию и комментариям\n" +
 			"/projects — список проектов\n" +
-			"/project <название> — создать проект"
-		b.send(reply)
+			"/project <название> — создать проект\n" +
+			"/link <код> — привязать аккаунт LiteTask, /unlink — отвязать\n" +
+			"/bind <projectId> — привязать групповой чат к проекту, /unbind — отвязать (только администраторы)\n\n" +
+			"В групповом чате бот показывает только задачи проектов этого чата, остальное присылает в личный чат.\n\n" +
+			"Под задачами есть кнопки: смена статуса, комментарий (ответь на сообщение бота) и ссылка на задачу в LiteTask."
+		b.reply(msg, reply)
+		return
+	case "/link":
+		b.link(msg, rest)
+		return
+	}
+
+	acc, ok := b.accessFor(msg)
+	if !ok {
+		return
+	}
+
+	switch cmd {
+	case "/unlink":
+		if err := b.store.UnlinkTelegram(acc.user.ID); err != nil {
+			log.Printf("bot: failed to unlink: %v", err)
+			b.reply(msg, "Не удалось отвязать аккаунт")
+			return
+		}
+		b.reply(msg, "Аккаунт Telegram отвязан от LiteTask")
 	case "/new", "/add":
-		projectID := int64(store.DefaultProjectID)
+		projectID := b.defaultProject(msg.Chat.ID)
 		content := rest
 		fields := strings.Fields(rest)
 		if len(fields) > 0 {
@@ -94,57 +358,115 @@ func (b *Bot) handleMessage(msg *tgbotapi.Message) {
 			}
 		}
 		if content == "" {
-			b.send("Используй: /new [projectId] <название> |описание (описание необязательно)")
+			b.reply(msg, "Используй: /new [projectId] <название> [@ГГГГ-ММ-ДД] |описание (срок и описание необязательны)")
 			return
 		}
 		title, description := parseTitleAndDescription(content)
+		title, dueDate, err := parseDueDate(title)
+		if err != nil {
+			b.reply(msg, "Срок указывается как @ГГГГ-ММ-ДД, например @2026-11-01")
+			return
+		}
 		if title == "" {
-			b.send("Название задачи не может быть пустым")
+			b.reply(msg, "Название задачи не может быть пустым")
+			return
+		}
+		if ok, _ := b.store.ProjectExists(projectID); !ok || !acc.canRead(projectID) {
+			b.reply(msg, "Проект не найден")
 			return
 		}
-		if ok, _ := b.store.ProjectExists(projectID); !ok {
-			b.send("Проект не найден")
+		if !acc.canWrite(projectID) {
+			b.reply(msg, "Нет прав на создание задач в этом проекте")
 			return
 		}
 
-		t, err := b.store.InsertTask(title, description, projectID, 0)
+		t, err := b.store.InsertTask(title, description, projectID, acc.user.ID, nil, dueDate)
 		if err != nil {
 			log.Printf("bot: failed to insert task: %v", err)
-			b.send("Не удалось создать задачу")
+			b.reply(msg, "Не удалось создать задачу")
 			return
 		}
-		projectName := b.store.LookupProjectName(projectID)
-		b.send(fmt.Sprintf("Создана #%d (%s) [%s]: %s", t.ID, projectName, store.StatusTitles[t.Status], t.Title))
+		b.publish(acc, events.Event{Type: events.TaskCreated, ProjectID: t.ProjectID, TaskID: t.ID, Data: t})
+		b.sendTo(msg.Chat.ID, "Создана "+b.taskCard(t), b.taskKeyboard(t))
 	case "/status", "/move":
 		parts := strings.Fields(rest)
 		if len(parts) < 2 {
-			b.send("Используй: /status <id> <new|in_progress|done>")
+			b.reply(msg, "Используй: /status <id> <статус>")
 			return
 		}
-		taskID, err := strconv.ParseInt(parts[0], 10, 64)
+		taskID, err := parseTaskID(parts[0])
 		if err != nil {
-			b.send("ID задачи должен быть числом")
+			b.reply(msg, "ID задачи должен быть числом")
+			return
+		}
+		existing, err := b.store.GetTask(taskID)
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && !acc.canRead(existing.ProjectID)) {
+			b.reply(msg, "Задача не найдена")
+			return
+		}
+		if err != nil {
+			log.Printf("bot: failed to load task: %v", err)
+			b.reply(msg, "Не удалось обновить статус")
+			return
+		}
+		if !acc.canWrite(existing.ProjectID) {
+			b.reply(msg, "Нет прав на изменение задач в этом проекте")
 			return
 		}
 		status := strings.ToLower(strings.TrimSpace(parts[1]))
-		t, err := b.store.SetTaskStatus(taskID, status)
-		if errors.Is(err, store.ErrInvalidStatus) {
-			b.send("Недопустимый статус. Используй new, in_progress или done.")
+		t, err := b.store.SetTaskStatus(taskID, status, acc.user.ID)
+		if errors.Is(err, store.ErrInvalidStatus) || errors.Is(err, store.ErrTransitionNotAllowed) {
+			b.reply(msg, b.statusHint(taskID, err))
 			return
 		}
 		if errors.Is(err, sql.ErrNoRows) {
-			b.send("Задача не найдена")
+			b.reply(msg, "Задача не найдена")
 			return
 		}
 		if err != nil {
 			log.Printf("bot: failed to update status: %v", err)
-			b.send("Не удалось обновить статус")
+			b.reply(msg, "Не удалось обновить статус")
 			return
 		}
+		b.publish(acc, events.Event{Type: events.TaskUpdated, ProjectID: t.ProjectID, TaskID: t.ID, Data: t})
 		projectName := b.store.LookupProjectName(t.ProjectID)
-		b.send(fmt.Sprintf("Статус задачи #%d (%s) теперь [%s]", t.ID, projectName, store.StatusTitles[t.Status]))
+		b.reply(msg, fmt.Sprintf("Статус задачи #%d (%s) теперь [%s]", t.ID, projectName, b.store.LookupStatusTitle(t.ProjectID, t.Status)))
+	case "/task":
+		if rest == "" {
+			b.reply(msg, "Используй: /task <id>")
+			return
+		}
+		taskID, err := parseTaskID(rest)
+		if err != nil {
+			b.reply(msg, "ID задачи должен быть числом")
+			return
+		}
+		t, err := b.store.GetTask(taskID)
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && !acc.canRead(t.ProjectID)) {
+			b.reply(msg, "Задача не найдена")
+			return
+		}
+		if err != nil {
+			log.Printf("bot: failed to load task: %v", err)
+			b.reply(msg, "Не удалось загрузить задачу")
+			return
+		}
+		b.replyAbout(msg, []int64{t.ProjectID}, b.taskDetail(t), b.taskKeyboard(t))
+	case "/comment":
+		// The text may start on the next line.
+		end := strings.IndexFunc(rest, unicode.IsSpace)
+		if end < 0 || strings.TrimSpace(rest[end:]) == "" {
+			b.reply(msg, "Используй: /comment <id> <текст>")
+			return
+		}
+		taskID, err := parseTaskID(rest[:end])
+		if err != nil {
+			b.reply(msg, "ID задачи должен быть числом")
+			return
+		}
+		b.addComment(msg, acc, taskID, strings.TrimSpace(rest[end:]))
 	case "/list":
-		projectID := int64(store.DefaultProjectID)
+		projectID := b.defaultProject(msg.Chat.ID)
 		statusFilter := "new"
 		fields := strings.Fields(rest)
 		if len(fields) > 0 {
@@ -158,15 +480,24 @@ func (b *Bot) handleMessage(msg *tgbotapi.Message) {
 				}
 			}
 		}
+		if projectID != 0 && !acc.canRead(projectID) {
+			b.reply(msg, "Проект не найден")
+			return
+		}
 
-		tasks, err := b.store.FetchTasks(projectID, statusFilter, nil)
+		filter, ok := acc.filter(store.TaskFilter{ProjectID: projectID, Status: statusFilter})
+		if !ok {
+			b.reply(msg, "Задач пока нет")
+			return
+		}
+		tasks, err := b.store.FetchTasks(filter)
 		if err != nil {
 			log.Printf("bot: failed to fetch tasks: %v", err)
-			b.send("Не удалось получить список задач")
+			b.reply(msg, "Не удалось получить список задач")
 			return
 		}
 		if len(tasks) == 0 {
-			b.send("Задач пока нет")
+			b.reply(msg, "Задач пока нет")
 			return
 		}
 		var builder strings.Builder
@@ -184,54 +515,290 @@ func (b *Bot) handleMessage(msg *tgbotapi.Message) {
 		}
 		builder.WriteString(title + "\n")
 		projNames := b.store.ProjectNameMap()
+		titles := b.store.StatusTitleMap()
 		for _, t := range tasks {
 			name := projNames[t.ProjectID]
-			fmt.Fprintf(&builder, "#%d (%s) [%s] %s\n", t.ID, name, store.StatusTitles[t.Status], t.Title)
+			fmt.Fprintf(&builder, "#%d (%s) [%s] %s%s\n", t.ID, name, statusTitle(titles, t), t.Title, dueSuffix(t))
+		}
+		b.replyAbout(msg, []int64{projectID}, builder.String(), listKeyboard(tasks))
+	case "/my":
+		filter, ok := acc.filter(store.TaskFilter{AssigneeID: acc.user.ID})
+		if !ok {
+			b.reply(msg, "На тебя пока ничего не назначено")
+			return
+		}
+		tasks, err := b.store.FetchTasks(filter)
+		if err != nil {
+			log.Printf("bot: failed to fetch tasks: %v", err)
+			b.reply(msg, "Не удалось получить список задач")
+			return
+		}
+		if len(tasks) == 0 {
+			b.reply(msg, "На тебя пока ничего не назначено")
+			return
+		}
+		var builder strings.Builder
+		builder.WriteString("Мои задачи:\n")
+		projNames := b.store.ProjectNameMap()
+		titles := b.store.StatusTitleMap()
+		projectIDs := make([]int64, 0, len(tasks))
+		for _, t := range tasks {
+			fmt.Fprintf(&builder, "#%d (%s) [%s] %s%s\n", t.ID, projNames[t.ProjectID], statusTitle(titles, t), t.Title, dueSuffix(t))
+			projectIDs = append(projectIDs, t.ProjectID)
+		}
+		b.replyAbout(msg, projectIDs, builder.String(), listKeyboard(tasks))
+	case "/search", "/find":
+		if rest == "" {
+			b.reply(msg, "Используй: /search <запрос>")
+			return
+		}
+		filter, ok := acc.filter(store.TaskFilter{})
+		if !ok {
+			b.reply(msg, "Ничего не найдено")
+			return
+		}
+		results, err := b.store.Search(rest, filter, searchLimit)
+		if err != nil {
+			log.Printf("bot: failed to search: %v", err)
+			b.reply(msg, "Не удалось выполнить поиск")
+			return
+		}
+		if len(results) == 0 {
+			b.reply(msg, "Ничего не найдено")
+			return
 		}
-		b.send(builder.String())
+		var builder strings.Builder
+		builder.WriteString("Найдено:\n")
+		projNames := b.store.ProjectNameMap()
+		titles := b.store.StatusTitleMap()
+		projectIDs := make([]int64, 0, len(results))
+		for _, res := range results {
+			t := res.Task
+			fmt.Fprintf(&builder, "#%d (%s) [%s] %s\n", t.ID, projNames[t.ProjectID], statusTitle(titles, t), plainSnippet(res.TitleSnippet))
+			if res.Snippet != "" {
+				fmt.Fprintf(&builder, "   %s\n", plainSnippet(res.Snippet))
+			}
+			projectIDs = append(projectIDs, t.ProjectID)
+		}
+		b.replyAbout(msg, projectIDs, builder.String(), nil)
 	case "/projects":
 		projects, err := b.store.ListProjects()
 		if err != nil {
 			log.Printf("bot: failed to list projects: %v", err)
-			b.send("Не удалось получить проекты")
-			return
-		}
-		if len(projects) == 0 {
-			b.send("Проектов пока нет")
+			b.reply(msg, "Не удалось получить проекты")
 			return
 		}
 		var builder strings.Builder
-		builder.WriteString("Проекты:\n")
+		projectIDs := make([]int64, 0, len(projects))
 		for _, p := range projects {
-			fmt.Fprintf(&builder, "%d — %s\n", p.ID, p.Name)
+			if acc.canRead(p.ID) {
+				fmt.Fprintf(&builder, "%d — %s\n", p.ID, p.Name)
+				projectIDs = append(projectIDs, p.ID)
+			}
+		}
+		if builder.Len() == 0 {
+			b.reply(msg, "Проектов пока нет")
+			return
 		}
-		b.send(builder.String())
+		b.replyAbout(msg, projectIDs, "Проекты:\n"+builder.String(), nil)
 	case "/project":
 		if rest == "" {
-			b.send("Используй: /project <название>")
+			b.reply(msg, "Используй: /project <название>")
 			return
 		}
 		p, err := b.store.CreateProject(strings.TrimSpace(rest))
 		if err != nil {
 			if strings.Contains(strings.ToLower(err.Error()), "unique") {
-				b.send("Проект с таким названием уже существует")
+				b.reply(msg, "Проект с таким названием уже существует")
 				return
 			}
 			log.Printf("bot: failed to create project: %v", err)
-			b.send("Не удалось создать проект")
+			b.reply(msg, "Не удалось создать проект")
 			return
 		}
-		b.send(fmt.Sprintf("Проект создан: #%d %s", p.ID, p.Name))
+		if acc.roles != nil {
+			if _, err := b.store.SetProjectMember(p.ID, acc.user.ID, store.ProjectRoleMaintainer); err != nil {
+				log.Printf("bot: failed to assign project to user: %v", err)
+			}
+		}
+		b.publish(acc, events.Event{Type: events.ProjectCreated, ProjectID: p.ID, Data: p})
+		b.reply(msg, fmt.Sprintf("Проект создан: #%d %s", p.ID, p.Name))
+	case "/bind", "/unbind":
+		b.bind(msg, acc, cmd == "/bind", rest)
 	default:
-		b.send("Неизвестная команда. Отправь /help для подсказки.")
+		b.reply(msg, "Неизвестная команда. Отправь /help для подсказки.")
+	}
+}
+
+// bind binds a group chat to a project, or removes the binding. Only global
+// admins may do it, as a binding routes the project's notifications to
+// everybody in the chat.
+func (b *Bot) bind(msg *tgbotapi.Message, acc access, bind bool, arg string) {
+	if acc.roles != nil {
+		b.reply(msg, "Привязывать чаты к проектам могут только администраторы")
+		return
+	}
+	if msg.Chat.IsPrivate() {
+		b.reply(msg, "Привязать к проекту можно только групповой чат")
+		return
+	}
+	if !bind {
+		err := b.store.UnbindTelegramChat(msg.Chat.ID)
+		if errors.Is(err, sql.ErrNoRows) {
+			b.reply(msg, "Чат не привязан к проекту")
+			return
+		}
+		if err != nil {
+			log.Printf("bot: failed to unbind chat: %v", err)
+			b.reply(msg, "Не удалось отвязать чат")
+			return
+		}
+		b.reply(msg, "Чат отвязан от проекта")
+		return
+	}
+	projectID, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		b.reply(msg, "Используй: /bind <projectId>")
+		return
+	}
+	chat, err := b.store.BindTelegramChat(msg.Chat.ID, projectID, msg.Chat.Title)
+	if errors.Is(err, sql.ErrNoRows) {
+		b.reply(msg, "Проект не найден")
+		return
+	}
+	if err != nil {
+		log.Printf("bot: failed to bind chat: %v", err)
+		b.reply(msg, "Не удалось привязать чат")
+		return
+	}
+	b.reply(msg, fmt.Sprintf("Чат привязан к проекту %s: команды здесь работают с ним по умолчанию, уведомления проекта приходят сюда", b.store.LookupProjectName(chat.ProjectID)))
+}
+
+// link handles "/link <code>" with a code from the web profile. In the team
+// chat the message is deleted afterwards when the bot is allowed to.
+func (b *Bot) link(msg *tgbotapi.Message, code string) {
+	if code == "" {
+		b.reply(msg, "Используй: /link <код из профиля LiteTask>")
+		return
+	}
+	if msg.From == nil {
+		b.reply(msg, "Не удалось определить твой аккаунт Telegram")
+		return
+	}
+	if !msg.Chat.IsPrivate() {
+		if _, err := b.api.Request(tgbotapi.NewDeleteMessage(msg.Chat.ID, msg.MessageID)); err != nil {
+			log.Printf("bot: failed to delete link message: %v", err)
+		}
 	}
+	u, err := b.store.LinkTelegram(code, msg.From.ID, msg.From.UserName)
+	if errors.Is(err, store.ErrInvalidLinkCode) {
+		b.reply(msg, "Код неверный или устарел. Получи новый в профиле LiteTask.")
+		return
+	}
+	if err != nil {
+		log.Printf("bot: failed to link account: %v", err)
+		b.reply(msg, "Не удалось привязать аккаунт")
+		return
+	}
+	b.reply(msg, fmt.Sprintf("Аккаунт Telegram привязан к %s", u.Email))
+}
+
+func (b *Bot) publish(acc access, e events.Event) {
+	e.Source = events.SourceTelegram
+	e.ActorID = acc.user.ID
+	b.events.Publish(e)
 }
 
-func (b *Bot) send(text string) {
-	msg := tgbotapi.NewMessage(b.chatID, text)
+// reply answers in the chat the message came from.
+func (b *Bot) reply(to *tgbotapi.Message, text string) {
+	b.sendTo(to.Chat.ID, text, nil)
+}
+
+// replyAbout answers a read command with text about tasks of projectIDs,
+// see sendAbout.
+func (b *Bot) replyAbout(to *tgbotapi.Message, projectIDs []int64, text string, markup any) {
+	b.sendAbout(to.Chat, to.From, projectIDs, text, markup)
+}
+
+// sendAbout sends text about tasks of projectIDs to chat when everybody
+// there may see them, and to the private chat of from otherwise.
+func (b *Bot) sendAbout(chat *tgbotapi.Chat, from *tgbotapi.User, projectIDs []int64, text string, markup any) {
+	shown := true
+	for _, pid := range projectIDs {
+		if !b.chatShows(chat, pid) {
+			shown = false
+			break
+		}
+	}
+	if shown {
+		b.sendTo(chat.ID, text, markup)
+		return
+	}
+	msg := tgbotapi.NewMessage(from.ID, text)
+	msg.ReplyMarkup = markup
 	if _, err := b.api.Send(msg); err != nil {
-		log.Printf("failed to send bot message: %v", err)
+		// Bots cannot start a private chat; the user has to.
+		log.Printf("failed to send bot message to %d: %v", from.ID, err)
+		b.sendTo(chat.ID, "Ответ касается других проектов, а личный чат со мной не начат. Напиши мне /start в личку и повтори команду.", nil)
+		return
+	}
+	b.sendTo(chat.ID, "Ответ касается других проектов, отправил его в личный чат", nil)
+}
+
+// chatShows reports whether chat may show tasks of projectID, 0 standing
+// for all projects. Everybody in a group chat reads the answers, so it only
+// shows the projects whose notifications it gets: the bound project, or
+// those without a chat in the team chat. Private chats show everything.
+func (b *Bot) chatShows(chat *tgbotapi.Chat, projectID int64) bool {
+	if chat.IsPrivate() {
+		return true
+	}
+	return projectID != 0 && slices.Contains(b.projectChats(projectID), chat.ID)
+}
+
+// statusHint explains why a status change was rejected, listing the moves
+// available in the task's project workflow.
+func (b *Bot) statusHint(taskID int64, err error) string {
+	t, terr := b.store.GetTask(taskID)
+	if terr != nil {
+		return "Недопустимый статус"
+	}
+	wf, werr := b.store.GetWorkflow(t.ProjectID)
+	if werr != nil {
+		return "Недопустимый статус"
+	}
+	if errors.Is(err, store.ErrTransitionNotAllowed) {
+		next := wf.Transitions[t.Status]
+		if len(next) == 0 {
+			return fmt.Sprintf("Из статуса %s переходы запрещены", t.Status)
+		}
+		return fmt.Sprintf("Из статуса %s можно перейти в: %s", t.Status, strings.Join(next, ", "))
+	}
+	return fmt.Sprintf("Недопустимый статус. Доступные: %s", strings.Join(wf.Keys(), ", "))
+}
+
+func statusTitle(titles map[int64]map[string]string, t store.Task) string {
+	if title, ok := titles[t.ProjectID][t.Status]; ok {
+		return title
 	}
+	return t.Status
+}
+
+func dueSuffix(t store.Task) string {
+	if t.DueDate == nil {
+		return ""
+	}
+	if t.Overdue {
+		return fmt.Sprintf(" (просрочена, срок %s)", t.DueDate.Format(store.DateLayout))
+	}
+	return fmt.Sprintf(" (срок %s)", t.DueDate.Format(store.DateLayout))
+}
+
+// plainSnippet turns a search snippet into plain text, showing matches in
+// «guillemets».
+func plainSnippet(snippet string) string {
+	snippet = strings.NewReplacer("<mark>", "«", "</mark>", "»").Replace(snippet)
+	return html.UnescapeString(snippet)
 }
 
 func splitCommand(text string) (string, string) {
@@ -243,6 +810,11 @@ func splitCommand(text string) (string, string) {
 	return cmd, strings.TrimSpace(parts[1])
 }
 
+// parseTaskID accepts a task id with or without the "#" shown in messages.
+func parseTaskID(s string) (int64, error) {
+	return strconv.ParseInt(strings.TrimPrefix(s, "#"), 10, 64)
+}
+
 func parseTitleAndDescription(input string) (string, string) {
 	parts := strings.SplitN(input, "|", 2)
 	title := strings.TrimSpace(parts[0])
@@ -251,3 +823,20 @@ func parseTitleAndDescription(input string) (string, string) {
 	}
 	return title, ""
 }
+
+// parseDueDate extracts a trailing "@2026-11-01" due date from a task title.
+func parseDueDate(title string) (string, *time.Time, error) {
+	fields := strings.Fields(title)
+	if len(fields) == 0 {
+		return title, nil, nil
+	}
+	last := fields[len(fields)-1]
+	if len(last) < 2 || last[0] != '@' || last[1] < '0' || last[1] > '9' {
+		return title, nil, nil
+	}
+	due, err := time.Parse(store.DateLayout, strings.TrimPrefix(last, "@"))
+	if err != nil {
+		return title, nil, err
+	}
+	return strings.TrimSpace(strings.TrimSuffix(title, last)), &due, nil
+}
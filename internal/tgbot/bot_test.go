@@ -0,0 +1,1160 @@
+package tgbot
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"litetask/internal/store"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const botID = 1000
+
+// Telegram accounts of the test users.
+const (
+	adminTG    = 1
+	memberTG   = 2
+	viewerTG   = 3
+	unlinkedTG = 99
+)
+
+// backendProject is created by newTestEnv; only the admin may see it.
+const backendProject = 2
+
+type testEnv struct {
+	bot   *Bot
+	api   *fakeClient
+	store *store.Store
+	users map[int64]store.User
+}
+
+// newTestEnv opens a fresh database with an admin, a member and a viewer of
+// the default project, each linked to a Telegram account, and a second
+// project.
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+	s, err := store.Open(filepath.Join(t.TempDir(), "litetask.db"))
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	api := newFakeClient()
+	e := &testEnv{
+		bot:   newBot(s, nil, api, tgbotapi.User{ID: botID, IsBot: true, UserName: "litetask_bot"}, 0, ""),
+		api:   api,
+		store: s,
+		users: make(map[int64]store.User),
+	}
+	e.addUser(t, "boss@example.com", "admin", adminTG)
+	e.addUser(t, "member@example.com", "user", memberTG)
+	viewer := e.addUser(t, "viewer@example.com", "user", viewerTG)
+	if _, err := s.SetProjectMember(store.DefaultProjectID, viewer.ID, store.ProjectRoleViewer); err != nil {
+		t.Fatalf("set viewer role: %v", err)
+	}
+	p, err := s.CreateProject("Бэкенд")
+	if err != nil {
+		t.Fatalf("create project: %v", err)
+	}
+	if p.ID != backendProject {
+		t.Fatalf("backend project id = %d, want %d", p.ID, backendProject)
+	}
+	return e
+}
+
+func (e *testEnv) addUser(t *testing.T, email, role string, telegramID int64) store.User {
+	t.Helper()
+	u, err := e.store.CreateUser(email, "", "password", role, "", "")
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	code, _, err := e.store.CreateTelegramLinkCode(u.ID)
+	if err != nil {
+		t.Fatalf("create link code: %v", err)
+	}
+	if u, err = e.store.LinkTelegram(code, telegramID, ""); err != nil {
+		t.Fatalf("link telegram: %v", err)
+	}
+	e.users[telegramID] = u
+	return u
+}
+
+func (e *testEnv) addTask(t *testing.T, title string, projectID int64, status string) store.Task {
+	t.Helper()
+	task, err := e.store.InsertTask(title, "", projectID, e.users[adminTG].ID, nil, nil)
+	if err != nil {
+		t.Fatalf("insert task: %v", err)
+	}
+	if status != "" && status != task.Status {
+		if task, err = e.store.SetTaskStatus(task.ID, status, e.users[adminTG].ID); err != nil {
+			t.Fatalf("set status: %v", err)
+		}
+	}
+	return task
+}
+
+// send delivers text from a Telegram account in its private chat with the
+// bot and returns the messages the bot sent in response.
+func (e *testEnv) send(from int64, text string) []tgbotapi.MessageConfig {
+	return e.sendIn(&tgbotapi.Chat{ID: from, Type: "private"}, from, text)
+}
+
+func (e *testEnv) sendIn(chat *tgbotapi.Chat, from int64, text string) []tgbotapi.MessageConfig {
+	return e.replyTo(chat, from, text, nil)
+}
+
+// replyTo delivers text as a reply to to, a message the bot sent.
+func (e *testEnv) replyTo(chat *tgbotapi.Chat, from int64, text string, to *tgbotapi.MessageConfig) []tgbotapi.MessageConfig {
+	msg := &tgbotapi.Message{
+		MessageID: 1,
+		From:      &tgbotapi.User{ID: from},
+		Chat:      chat,
+		Text:      text,
+	}
+	if to != nil {
+		msg.ReplyToMessage = &tgbotapi.Message{From: &e.bot.self, Chat: chat, Text: to.Text}
+		if kb, ok := to.ReplyMarkup.(tgbotapi.InlineKeyboardMarkup); ok {
+			msg.ReplyToMessage.ReplyMarkup = &kb
+		}
+	}
+	e.bot.handleUpdate(tgbotapi.Update{Message: msg})
+	return e.api.messages()
+}
+
+// reply is like send for commands answered with exactly one message.
+func (e *testEnv) reply(t *testing.T, from int64, text string) string {
+	t.Helper()
+	msgs := e.send(from, text)
+	if len(msgs) != 1 {
+		t.Fatalf("%q: got %d messages, want 1", text, len(msgs))
+	}
+	return msgs[0].Text
+}
+
+// lastTask returns the most recently created task, if any.
+func (e *testEnv) lastTask(t *testing.T) (store.Task, bool) {
+	t.Helper()
+	tasks, err := e.store.FetchTasks(store.TaskFilter{})
+	if err != nil {
+		t.Fatalf("fetch tasks: %v", err)
+	}
+	var last store.Task
+	for _, task := range tasks {
+		if task.ID > last.ID {
+			last = task
+		}
+	}
+	return last, last.ID != 0
+}
+
+func TestNewCommand(t *testing.T) {
+	tests := []struct {
+		name      string
+		from      int64
+		text      string
+		wantReply string
+		// The task expected to be created; none when wantTitle is empty.
+		wantTitle       string
+		wantDescription string
+		wantProject     int64
+		wantDue         string
+	}{
+		{
+			name:        "title only",
+			from:        memberTG,
+			text:        "/new Купить молоко",
+			wantReply:   "Создана #1 (Общий) [Новая]: Купить молоко",
+			wantTitle:   "Купить молоко",
+			wantProject: store.DefaultProjectID,
+		},
+		{
+			name:            "due date and description",
+			from:            memberTG,
+			text:            "/new Починить принтер @2026-11-01 | на третьем этаже ",
+			wantReply:       "Создана #1 (Общий) [Новая]: Починить принтер (срок 2026-11-01)\n\nна третьем этаже",
+			wantTitle:       "Починить принтер",
+			wantDescription: "на третьем этаже",
+			wantProject:     store.DefaultProjectID,
+			wantDue:         "2026-11-01",
+		},
+		{
+			name:        "description keeps later pipes",
+			from:        memberTG,
+			text:        "/new Отчёт |раз | два",
+			wantReply:   "Создана #1",
+			wantTitle:   "Отчёт",
+			wantProject: store.DefaultProjectID,
+			// Only the first pipe separates the description.
+			wantDescription: "раз | два",
+		},
+		{
+			name:        "project id",
+			from:        adminTG,
+			text:        "/new 2 Настроить CI",
+			wantReply:   "Создана #1 (Бэкенд) [Новая]: Настроить CI",
+			wantTitle:   "Настроить CI",
+			wantProject: backendProject,
+		},
+		{
+			name:        "command is case insensitive",
+			from:        memberTG,
+			text:        "/NEW Громко",
+			wantReply:   "Создана #1",
+			wantTitle:   "Громко",
+			wantProject: store.DefaultProjectID,
+		},
+		{
+			name:        "add alias",
+			from:        memberTG,
+			text:        "/add Синоним",
+			wantReply:   "Создана #1",
+			wantTitle:   "Синоним",
+			wantProject: store.DefaultProjectID,
+		},
+		{
+			name:        "at sign without a date stays in the title",
+			from:        memberTG,
+			text:        "/new Письмо на @home",
+			wantReply:   "Создана #1",
+			wantTitle:   "Письмо на @home",
+			wantProject: store.DefaultProjectID,
+		},
+		{
+			name:      "leading number is a project id",
+			from:      adminTG,
+			text:      "/new 2026 год",
+			wantReply: "Проект не найден",
+		},
+		{
+			name:      "no arguments",
+			from:      memberTG,
+			text:      "/new",
+			wantReply: "Используй: /new [projectId] <название>",
+		},
+		{
+			name:      "project id only",
+			from:      adminTG,
+			text:      "/new 2",
+			wantReply: "Используй: /new [projectId] <название>",
+		},
+		{
+			name:      "description only",
+			from:      memberTG,
+			text:      "/new |только описание",
+			wantReply: "Название задачи не может быть пустым",
+		},
+		{
+			name:      "date only",
+			from:      memberTG,
+			text:      "/new @2026-11-01",
+			wantReply: "Название задачи не может быть пустым",
+		},
+		{
+			name:      "invalid date",
+			from:      memberTG,
+			text:      "/new Задача @2026-13-01",
+			wantReply: "Срок указывается как @ГГГГ-ММ-ДД",
+		},
+		{
+			name:      "project of others",
+			from:      memberTG,
+			text:      "/new 2 Чужая задача",
+			wantReply: "Проект не найден",
+		},
+		{
+			name:      "viewer",
+			from:      viewerTG,
+			text:      "/new Задача",
+			wantReply: "Нет прав на создание задач в этом проекте",
+		},
+		{
+			name:      "unlinked account",
+			from:      unlinkedTG,
+			text:      "/new Задача",
+			wantReply: "Аккаунт Telegram не привязан",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newTestEnv(t)
+			got := e.reply(t, tt.from, tt.text)
+			if !strings.HasPrefix(got, tt.wantReply) {
+				t.Errorf("reply = %q, want prefix %q", got, tt.wantReply)
+			}
+			task, created := e.lastTask(t)
+			if tt.wantTitle == "" {
+				if created {
+					t.Errorf("unexpected task %+v", task)
+				}
+				return
+			}
+			if !created {
+				t.Fatal("no task created")
+			}
+			if task.Title != tt.wantTitle || task.Description != tt.wantDescription || task.ProjectID != tt.wantProject {
+				t.Errorf("task = %q / %q in project %d, want %q / %q in project %d",
+					task.Title, task.Description, task.ProjectID, tt.wantTitle, tt.wantDescription, tt.wantProject)
+			}
+			due := ""
+			if task.DueDate != nil {
+				due = task.DueDate.Format(store.DateLayout)
+			}
+			if due != tt.wantDue {
+				t.Errorf("due date = %q, want %q", due, tt.wantDue)
+			}
+			if task.CreatedBy != e.users[tt.from].ID {
+				t.Errorf("created by %d, want %d", task.CreatedBy, e.users[tt.from].ID)
+			}
+		})
+	}
+}
+
+func TestNewCommandInBoundChat(t *testing.T) {
+	e := newTestEnv(t)
+	group := &tgbotapi.Chat{ID: -42, Type: "group"}
+
+	if msgs := e.sendIn(group, adminTG, "/new Задача"); len(msgs) != 0 {
+		t.Fatalf("unbound group got %d messages, want none", len(msgs))
+	}
+	if _, err := e.store.BindTelegramChat(group.ID, backendProject, "backend"); err != nil {
+		t.Fatalf("bind chat: %v", err)
+	}
+	msgs := e.sendIn(group, adminTG, "/new Задача")
+	if len(msgs) != 1 || msgs[0].ChatID != group.ID {
+		t.Fatalf("got %+v, want one message to the group", msgs)
+	}
+	task, _ := e.lastTask(t)
+	if task.ProjectID != backendProject {
+		t.Errorf("task project = %d, want %d", task.ProjectID, backendProject)
+	}
+	if _, ok := msgs[0].ReplyMarkup.(tgbotapi.InlineKeyboardMarkup); !ok {
+		t.Errorf("created task has no buttons: %#v", msgs[0].ReplyMarkup)
+	}
+
+	// An explicit project id still wins.
+	e.sendIn(group, adminTG, "/new 1 Другая")
+	if task, _ := e.lastTask(t); task.ProjectID != store.DefaultProjectID {
+		t.Errorf("task project = %d, want %d", task.ProjectID, store.DefaultProjectID)
+	}
+}
+
+func TestStatusCommand(t *testing.T) {
+	tests := []struct {
+		name string
+		from int64
+		// text is formatted with the id of a new task in project.
+		text       string
+		project    int64
+		wantReply  string
+		wantStatus string
+	}{
+		{
+			name:       "change",
+			from:       memberTG,
+			text:       "/status %d in_progress",
+			wantReply:  "Статус задачи #1 (Общий) теперь [В работе]",
+			wantStatus: "in_progress",
+		},
+		{
+			name:       "status is case insensitive",
+			from:       memberTG,
+			text:       "/status %d DONE",
+			wantReply:  "Статус задачи #1 (Общий) теперь [Готова]",
+			wantStatus: "done",
+		},
+		{
+			name:       "extra spaces",
+			from:       memberTG,
+			text:       "/status   %d    done  ",
+			wantReply:  "Статус задачи #1 (Общий) теперь [Готова]",
+			wantStatus: "done",
+		},
+		{
+			name:       "id with hash",
+			from:       memberTG,
+			text:       "/status #%d done",
+			wantReply:  "Статус задачи #1 (Общий) теперь [Готова]",
+			wantStatus: "done",
+		},
+		{
+			name:       "move alias",
+			from:       adminTG,
+			text:       "/move %d done",
+			project:    backendProject,
+			wantReply:  "Статус задачи #1 (Бэкенд) теперь [Готова]",
+			wantStatus: "done",
+		},
+		{
+			name:       "missing status",
+			from:       memberTG,
+			text:       "/status %d",
+			wantReply:  "Используй: /status <id> <статус>",
+			wantStatus: "new",
+		},
+		{
+			name:       "status before id",
+			from:       memberTG,
+			text:       "/status done %d",
+			wantReply:  "ID задачи должен быть числом",
+			wantStatus: "new",
+		},
+		{
+			name:       "unknown status",
+			from:       memberTG,
+			text:       "/status %d closed",
+			wantReply:  "Недопустимый статус. Доступные: new, in_progress, done",
+			wantStatus: "new",
+		},
+		{
+			name:       "status title instead of key",
+			from:       memberTG,
+			text:       "/status %d Готова",
+			wantReply:  "Недопустимый статус",
+			wantStatus: "new",
+		},
+		{
+			name:       "task of others",
+			from:       memberTG,
+			text:       "/status %d done",
+			project:    backendProject,
+			wantReply:  "Задача не найдена",
+			wantStatus: "new",
+		},
+		{
+			name:       "viewer",
+			from:       viewerTG,
+			text:       "/status %d done",
+			wantReply:  "Нет прав на изменение задач в этом проекте",
+			wantStatus: "new",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newTestEnv(t)
+			project := tt.project
+			if project == 0 {
+				project = store.DefaultProjectID
+			}
+			task := e.addTask(t, "Задача", project, "")
+			got := e.reply(t, tt.from, fmt.Sprintf(tt.text, task.ID))
+			if !strings.HasPrefix(got, tt.wantReply) {
+				t.Errorf("reply = %q, want prefix %q", got, tt.wantReply)
+			}
+			task, err := e.store.GetTask(task.ID)
+			if err != nil {
+				t.Fatalf("get task: %v", err)
+			}
+			if task.Status != tt.wantStatus {
+				t.Errorf("status = %q, want %q", task.Status, tt.wantStatus)
+			}
+		})
+	}
+
+	t.Run("missing task", func(t *testing.T) {
+		e := newTestEnv(t)
+		if got := e.reply(t, adminTG, "/status 999 done"); got != "Задача не найдена" {
+			t.Errorf("reply = %q", got)
+		}
+	})
+	t.Run("no arguments", func(t *testing.T) {
+		e := newTestEnv(t)
+		if got := e.reply(t, memberTG, "/status"); got != "Используй: /status <id> <статус>" {
+			t.Errorf("reply = %q", got)
+		}
+	})
+}
+
+func TestTaskCommand(t *testing.T) {
+	e := newTestEnv(t)
+	task, err := e.store.InsertTask("Починить принтер", "На третьем этаже", store.DefaultProjectID, e.users[adminTG].ID, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= detailComments+2; i++ {
+		if _, err := e.store.AddTaskComment(task.ID, fmt.Sprintf("комментарий %d", i), e.users[memberTG].ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	other := e.addTask(t, "Чужая", backendProject, "")
+
+	msgs := e.send(memberTG, fmt.Sprintf("/task #%d", task.ID))
+	if len(msgs) != 1 {
+		t.Fatalf("got %d messages, want 1", len(msgs))
+	}
+	got := msgs[0].Text
+	for _, s := range []string{"#1 (Общий) [Новая]: Починить принтер", "На третьем этаже", "Последние комментарии (5 из 7)", "member@example.com", "комментарий 7"} {
+		if !strings.Contains(got, s) {
+			t.Errorf("reply = %q, want %q in it", got, s)
+		}
+	}
+	if strings.Contains(got, "комментарий 2\n") {
+		t.Errorf("reply = %q, want only the latest comments", got)
+	}
+	if _, ok := markupTask(keyboardOf(msgs[0])); !ok {
+		t.Errorf("task message has no comment button")
+	}
+
+	tests := []struct {
+		from int64
+		text string
+		want string
+	}{
+		{memberTG, "/task", "Используй: /task <id>"},
+		{memberTG, "/task abc", "ID задачи должен быть числом"},
+		{memberTG, "/task 999", "Задача не найдена"},
+		{memberTG, fmt.Sprintf("/task %d", other.ID), "Задача не найдена"},
+		{adminTG, fmt.Sprintf("/task %d", other.ID), "#2 (Бэкенд) [Новая]: Чужая"},
+		{viewerTG, fmt.Sprintf("/task %d", task.ID), "#1 (Общий)"},
+	}
+	for _, tt := range tests {
+		if got := e.reply(t, tt.from, tt.text); !strings.HasPrefix(got, tt.want) {
+			t.Errorf("%q: reply = %q, want prefix %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestCommentCommand(t *testing.T) {
+	tests := []struct {
+		name string
+		from int64
+		// text is formatted with the id of a new task in project.
+		text      string
+		project   int64
+		wantReply string
+		wantBody  string
+	}{
+		{
+			name:      "comment",
+			from:      memberTG,
+			text:      "/comment %d Готово, проверьте",
+			wantReply: "Комментарий добавлен к #1",
+			wantBody:  "Готово, проверьте",
+		},
+		{
+			name:      "hash and text on the next line",
+			from:      memberTG,
+			text:      "/comment #%d\nпервая строка\nвторая строка",
+			wantReply: "Комментарий добавлен к #1",
+			wantBody:  "первая строка\nвторая строка",
+		},
+		{
+			name:      "no text",
+			from:      memberTG,
+			text:      "/comment %d  ",
+			wantReply: "Используй: /comment <id> <текст>",
+		},
+		{
+			name:      "text before id",
+			from:      memberTG,
+			text:      "/comment текст %d",
+			wantReply: "ID задачи должен быть числом",
+		},
+		{
+			name:      "task of others",
+			from:      memberTG,
+			text:      "/comment %d текст",
+			project:   backendProject,
+			wantReply: "Задача не найдена",
+		},
+		{
+			name:      "viewer",
+			from:      viewerTG,
+			text:      "/comment %d текст",
+			wantReply: "Нет прав на комментарии в этом проекте",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newTestEnv(t)
+			project := tt.project
+			if project == 0 {
+				project = store.DefaultProjectID
+			}
+			task := e.addTask(t, "Задача", project, "")
+			if got := e.reply(t, tt.from, fmt.Sprintf(tt.text, task.ID)); got != tt.wantReply {
+				t.Errorf("reply = %q, want %q", got, tt.wantReply)
+			}
+			comments, err := e.store.ListTaskComments(task.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantBody == "" {
+				if len(comments) != 0 {
+					t.Errorf("unexpected comments %+v", comments)
+				}
+				return
+			}
+			if len(comments) != 1 || comments[0].Body != tt.wantBody || comments[0].AuthorID != e.users[tt.from].ID {
+				t.Errorf("comments = %+v, want %q by user %d", comments, tt.wantBody, e.users[tt.from].ID)
+			}
+		})
+	}
+}
+
+func TestReplyComment(t *testing.T) {
+	e := newTestEnv(t)
+	chat := &tgbotapi.Chat{ID: memberTG, Type: "private"}
+	created := e.send(memberTG, "/new Задача")
+	if len(created) != 1 {
+		t.Fatalf("got %d messages, want 1", len(created))
+	}
+	task, _ := e.lastTask(t)
+	list := e.send(memberTG, "/list")
+
+	tests := []struct {
+		name      string
+		to        *tgbotapi.MessageConfig
+		text      string
+		wantReply string
+		comment   bool
+	}{
+		{name: "task message", to: &created[0], text: "Сделаю завтра", wantReply: "Комментарий добавлен к #1", comment: true},
+		{name: "command in a reply", to: &created[0], text: "/projects", wantReply: "Проекты:"},
+		{name: "task list", to: &list[0], text: "Сделаю завтра", wantReply: "Неизвестная команда"},
+		{name: "not a reply", text: "Сделаю завтра", wantReply: "Неизвестная команда"},
+	}
+	for _, tt := range tests {
+		before, _ := e.store.ListTaskComments(task.ID)
+		msgs := e.replyTo(chat, memberTG, tt.text, tt.to)
+		if len(msgs) != 1 || !strings.HasPrefix(msgs[0].Text, tt.wantReply) {
+			t.Errorf("%s: got %+v, want a reply starting with %q", tt.name, msgs, tt.wantReply)
+		}
+		after, _ := e.store.ListTaskComments(task.ID)
+		if added := len(after) > len(before); added != tt.comment {
+			t.Errorf("%s: comment added = %v, want %v", tt.name, added, tt.comment)
+		}
+	}
+
+	// A reply to the prompt of the comment button works the same way.
+	e.bot.promptComment(chat.ID, &tgbotapi.User{ID: memberTG, FirstName: "Member"}, task)
+	prompt := e.api.messages()
+	if len(prompt) != 1 {
+		t.Fatalf("got %d prompts, want 1", len(prompt))
+	}
+	if msgs := e.replyTo(chat, memberTG, "Через кнопку", &prompt[0]); len(msgs) != 1 || msgs[0].Text != "Комментарий добавлен к #1" {
+		t.Errorf("prompt reply: got %+v", msgs)
+	}
+
+	// Unlinked users are told to link instead.
+	if msgs := e.replyTo(&tgbotapi.Chat{ID: unlinkedTG, Type: "private"}, unlinkedTG, "Привет", &created[0]); len(msgs) != 1 || !strings.HasPrefix(msgs[0].Text, "Аккаунт Telegram не привязан") {
+		t.Errorf("unlinked reply: got %+v", msgs)
+	}
+}
+
+func keyboardOf(m tgbotapi.MessageConfig) *tgbotapi.InlineKeyboardMarkup {
+	kb, ok := m.ReplyMarkup.(tgbotapi.InlineKeyboardMarkup)
+	if !ok {
+		return nil
+	}
+	return &kb
+}
+
+func TestListCommand(t *testing.T) {
+	e := newTestEnv(t)
+	e.addTask(t, "Первая", store.DefaultProjectID, "")
+	e.addTask(t, "Вторая", store.DefaultProjectID, "done")
+	e.addTask(t, "Третья", backendProject, "")
+
+	tests := []struct {
+		name    string
+		from    int64
+		text    string
+		header  string
+		want    []string
+		notWant []string
+	}{
+		{
+			name:    "new tasks of the default project",
+			from:    adminTG,
+			text:    "/list",
+			header:  "Новые задачи: (проект Общий)",
+			want:    []string{"Первая"},
+			notWant: []string{"Вторая", "Третья"},
+		},
+		{
+			name:   "all projects and statuses",
+			from:   adminTG,
+			text:   "/list all",
+			header: "Задачи: (все проекты) (все статусы)",
+			want:   []string{"Первая", "Вторая", "Третья"},
+		},
+		{
+			name:   "all is case insensitive",
+			from:   adminTG,
+			text:   "/list ALL",
+			header: "Задачи: (все проекты) (все статусы)",
+			want:   []string{"Первая", "Вторая", "Третья"},
+		},
+		{
+			name:    "project",
+			from:    adminTG,
+			text:    "/list 2",
+			header:  "Новые задачи: (проект Бэкенд)",
+			want:    []string{"Третья"},
+			notWant: []string{"Первая", "Вторая"},
+		},
+		{
+			name:    "project with all statuses",
+			from:    adminTG,
+			text:    "/list 1 all",
+			header:  "Задачи: (проект Общий) (все статусы)",
+			want:    []string{"Первая", "[Готова] Вторая"},
+			notWant: []string{"Третья"},
+		},
+		{
+			name:    "all projects of a member",
+			from:    memberTG,
+			text:    "/list all",
+			header:  "Задачи: (все проекты) (все статусы)",
+			want:    []string{"Первая", "Вторая"},
+			notWant: []string{"Третья"},
+		},
+		{
+			name:    "viewer",
+			from:    viewerTG,
+			text:    "/list",
+			header:  "Новые задачи: (проект Общий)",
+			want:    []string{"Первая"},
+			notWant: []string{"Вторая", "Третья"},
+		},
+		{
+			name:    "unknown word is ignored",
+			from:    adminTG,
+			text:    "/list мои",
+			header:  "Новые задачи: (проект Общий)",
+			want:    []string{"Первая"},
+			notWant: []string{"Вторая", "Третья"},
+		},
+		{
+			name:   "project of others",
+			from:   memberTG,
+			text:   "/list 2",
+			header: "Проект не найден",
+		},
+		{
+			name:   "missing project",
+			from:   adminTG,
+			text:   "/list 7",
+			header: "Задач пока нет",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msgs := e.send(tt.from, tt.text)
+			if len(msgs) != 1 {
+				t.Fatalf("got %d messages, want 1", len(msgs))
+			}
+			got := msgs[0].Text
+			if !strings.HasPrefix(got, tt.header) {
+				t.Errorf("reply = %q, want prefix %q", got, tt.header)
+			}
+			for _, s := range tt.want {
+				if !strings.Contains(got, s) {
+					t.Errorf("reply = %q, want %q in it", got, s)
+				}
+			}
+			for _, s := range tt.notWant {
+				if strings.Contains(got, s) {
+					t.Errorf("reply = %q, want no %q in it", got, s)
+				}
+			}
+			// Listed tasks get a button each.
+			buttons := 0
+			if kb, ok := msgs[0].ReplyMarkup.(tgbotapi.InlineKeyboardMarkup); ok {
+				buttons = len(kb.InlineKeyboard)
+			}
+			if buttons != len(tt.want) {
+				t.Errorf("got %d task buttons, want %d", buttons, len(tt.want))
+			}
+		})
+	}
+}
+
+func TestReadCommandsInGroupChats(t *testing.T) {
+	e := newTestEnv(t)
+	shared := e.addTask(t, "Общая задача", store.DefaultProjectID, "")
+	backend := e.addTask(t, "Задача бэкенда", backendProject, "")
+	group := &tgbotapi.Chat{ID: -42, Type: "group"}
+	team := &tgbotapi.Chat{ID: -7, Type: "supergroup"}
+	e.bot.chatID = team.ID
+	if _, err := e.store.BindTelegramChat(group.ID, backendProject, "backend"); err != nil {
+		t.Fatalf("bind chat: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		chat *tgbotapi.Chat
+		text string
+		// private is whether the answer goes to the sender's private chat.
+		private bool
+		want    string
+	}{
+		{"bound project", group, "/list", false, "Задача бэкенда"},
+		{"all projects", group, "/list all", true, "Общая задача"},
+		{"other project", group, "/list 1", true, "Общая задача"},
+		{"task of the bound project", group, fmt.Sprintf("/task %d", backend.ID), false, "Задача бэкенда"},
+		{"task of another project", group, fmt.Sprintf("/task %d", shared.ID), true, "Общая задача"},
+		{"search", group, "/search задача", true, "Общий"},
+		{"projects", group, "/projects", true, "Общий"},
+		{"team chat", team, "/list 1", false, "Общая задача"},
+		{"project with a chat of its own", team, "/list 2", true, "Задача бэкенда"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msgs := e.sendIn(tt.chat, adminTG, tt.text)
+			if !tt.private {
+				if len(msgs) != 1 || msgs[0].ChatID != tt.chat.ID || !strings.Contains(msgs[0].Text, tt.want) {
+					t.Fatalf("got %+v, want an answer with %q in the chat", msgs, tt.want)
+				}
+				return
+			}
+			if len(msgs) != 2 {
+				t.Fatalf("got %d messages, want the answer and a notice", len(msgs))
+			}
+			if msgs[0].ChatID != adminTG || !strings.Contains(msgs[0].Text, tt.want) {
+				t.Errorf("answer = %q to %d, want %q in the private chat", msgs[0].Text, msgs[0].ChatID, tt.want)
+			}
+			if msgs[1].ChatID != tt.chat.ID || strings.Contains(msgs[1].Text, tt.want) {
+				t.Errorf("notice = %q to %d, want one without the answer in the chat", msgs[1].Text, msgs[1].ChatID)
+			}
+		})
+	}
+}
+
+func TestProjectsCommand(t *testing.T) {
+	e := newTestEnv(t)
+	tests := []struct {
+		from    int64
+		want    []string
+		notWant []string
+	}{
+		{from: adminTG, want: []string{"1 — Общий", "2 — Бэкенд"}},
+		{from: memberTG, want: []string{"1 — Общий"}, notWant: []string{"Бэкенд"}},
+		{from: viewerTG, want: []string{"1 — Общий"}, notWant: []string{"Бэкенд"}},
+	}
+	for _, tt := range tests {
+		got := e.reply(t, tt.from, "/projects")
+		if !strings.HasPrefix(got, "Проекты:\n") {
+			t.Errorf("user %d: reply = %q", tt.from, got)
+		}
+		for _, s := range tt.want {
+			if !strings.Contains(got, s) {
+				t.Errorf("user %d: reply = %q, want %q in it", tt.from, got, s)
+			}
+		}
+		for _, s := range tt.notWant {
+			if strings.Contains(got, s) {
+				t.Errorf("user %d: reply = %q, want no %q in it", tt.from, got, s)
+			}
+		}
+	}
+
+	if err := e.store.RemoveProjectMember(store.DefaultProjectID, e.users[viewerTG].ID); err != nil {
+		t.Fatal(err)
+	}
+	if got := e.reply(t, viewerTG, "/projects"); got != "Проектов пока нет" {
+		t.Errorf("reply without projects = %q", got)
+	}
+}
+
+func TestProjectCommand(t *testing.T) {
+	e := newTestEnv(t)
+
+	got := e.reply(t, memberTG, "/project   Мобильное приложение ")
+	if got != "Проект создан: #3 Мобильное приложение" {
+		t.Fatalf("reply = %q", got)
+	}
+	// Users who are not admins manage the projects they create.
+	roles, err := e.store.GetUserProjectRoles(e.users[memberTG].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if roles[3] != store.ProjectRoleMaintainer {
+		t.Errorf("creator role = %q, want %q", roles[3], store.ProjectRoleMaintainer)
+	}
+	if got := e.reply(t, memberTG, "/list 3"); got != "Задач пока нет" {
+		t.Errorf("creator cannot read the project: %q", got)
+	}
+
+	if got := e.reply(t, adminTG, "/project Мобильное приложение"); got != "Проект с таким названием уже существует" {
+		t.Errorf("duplicate: reply = %q", got)
+	}
+	if got := e.reply(t, adminTG, "/project"); got != "Используй: /project <название>" {
+		t.Errorf("no name: reply = %q", got)
+	}
+
+	if got := e.reply(t, adminTG, "/project Админка"); got != "Проект создан: #4 Админка" {
+		t.Fatalf("reply = %q", got)
+	}
+	members, err := e.store.ListProjectMembers(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 0 {
+		t.Errorf("admin added as a member: %+v", members)
+	}
+}
+
+func TestUnknownCommand(t *testing.T) {
+	e := newTestEnv(t)
+	if got := e.reply(t, memberTG, "/frobnicate"); !strings.HasPrefix(got, "Неизвестная команда") {
+		t.Errorf("reply = %q", got)
+	}
+	if msgs := e.send(memberTG, "   "); len(msgs) != 0 {
+		t.Errorf("blank message answered: %+v", msgs)
+	}
+}
+
+func TestNotifySkipsBotChangesPerEvent(t *testing.T) {
+	e := newTestEnv(t)
+	e.bot.chatID = -7
+	cursor, err := e.store.LatestTaskEventID()
+	if err != nil {
+		t.Fatalf("latest event: %v", err)
+	}
+	// One wake-up delivers both changes; only the one made elsewhere is
+	// posted to the team chat.
+	e.send(adminTG, "/new Из бота")
+	e.addTask(t, "Из веба", store.DefaultProjectID, "")
+	e.bot.deliverEvents(cursor)
+	msgs := e.api.messages()
+	if len(msgs) != 1 || msgs[0].ChatID != e.bot.chatID || !strings.Contains(msgs[0].Text, "Из веба") {
+		t.Fatalf("got %+v, want one notification about the web task", msgs)
+	}
+}
+
+func TestRunPolling(t *testing.T) {
+	e := newTestEnv(t)
+	e.api.updates <- tgbotapi.Update{Message: &tgbotapi.Message{
+		From: &tgbotapi.User{ID: adminTG},
+		Chat: &tgbotapi.Chat{ID: adminTG, Type: "private"},
+		Text: "/projects",
+	}}
+	close(e.api.updates)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	e.bot.run(ctx, nil, nil)
+
+	e.api.mu.Lock()
+	sent := append([]tgbotapi.Chattable(nil), e.api.sent...)
+	stopped := e.api.stopped
+	e.api.mu.Unlock()
+	if len(sent) != 2 {
+		t.Fatalf("sent %d requests, want the webhook removal and a reply", len(sent))
+	}
+	if _, ok := sent[0].(tgbotapi.DeleteWebhookConfig); !ok {
+		t.Errorf("first request = %T, want DeleteWebhookConfig", sent[0])
+	}
+	if m, ok := sent[1].(tgbotapi.MessageConfig); !ok || !strings.HasPrefix(m.Text, "Проекты:") {
+		t.Errorf("reply = %#v", sent[1])
+	}
+	if !stopped {
+		t.Error("polling not stopped")
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	e := newTestEnv(t)
+	hook, err := NewWebhook("https://tasks.example.com/api/telegram/webhook", "s3cret")
+	if err != nil {
+		t.Fatalf("new webhook: %v", err)
+	}
+	update := `{"update_id":1,"message":{"message_id":1,"from":{"id":1},"chat":{"id":1,"type":"private"},"text":"/projects"}}`
+	post := func(secret, body string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/telegram/webhook", strings.NewReader(body))
+		if secret != "" {
+			req.Header.Set(secretHeader, secret)
+		}
+		rec := httptest.NewRecorder()
+		hook.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := post("s3cret", update); code != http.StatusServiceUnavailable {
+		t.Fatalf("before the bot runs: status %d, want 503", code)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		e.bot.run(ctx, hook, nil)
+		close(done)
+	}()
+	for deadline := time.Now().Add(5 * time.Second); !hook.active.Load(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("webhook not activated")
+		}
+	}
+
+	tests := []struct {
+		name   string
+		secret string
+		body   string
+		want   int
+	}{
+		{"no secret", "", update, http.StatusForbidden},
+		{"wrong secret", "other", update, http.StatusForbidden},
+		{"invalid update", "s3cret", "{", http.StatusBadRequest},
+		{"update", "s3cret", update, http.StatusOK},
+	}
+	for _, tt := range tests {
+		if code := post(tt.secret, tt.body); code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, code, tt.want)
+		}
+	}
+	// Only the accepted update is answered.
+	var msgs []tgbotapi.MessageConfig
+	for deadline := time.Now().Add(5 * time.Second); len(msgs) == 0 && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		msgs = e.api.messages()
+	}
+	if len(msgs) != 1 || !strings.HasPrefix(msgs[0].Text, "Проекты:") {
+		t.Errorf("replies = %+v, want the project list", msgs)
+	}
+	e.api.mu.Lock()
+	requests := append([]string(nil), e.api.requests...)
+	e.api.mu.Unlock()
+	if len(requests) != 1 || requests[0] != "setWebhook" {
+		t.Errorf("raw requests = %v, want setWebhook", requests)
+	}
+
+	cancel()
+	<-done
+	if code := post("s3cret", update); code != http.StatusServiceUnavailable {
+		t.Errorf("after the bot stopped: status %d, want 503", code)
+	}
+
+	// Nobody reads the queue now; once it is full Telegram is told to retry.
+	hook.active.Store(true)
+	for i := 0; i < webhookQueue; i++ {
+		if code := post("s3cret", update); code != http.StatusOK {
+			t.Fatalf("update %d: status %d, want 200", i, code)
+		}
+	}
+	if code := post("s3cret", update); code != http.StatusServiceUnavailable {
+		t.Errorf("full queue: status %d, want 503", code)
+	}
+}
+
+func TestPoster(t *testing.T) {
+	e := newTestEnv(t)
+	poster := NewPoster()
+	if err := poster.PostToProject(backendProject, "Сводка"); err != ErrBotNotRunning {
+		t.Fatalf("post before start: err = %v, want %v", err, ErrBotNotRunning)
+	}
+	if _, err := e.store.BindTelegramChat(-42, backendProject, "backend"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		e.bot.run(ctx, nil, poster)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for poster.PostToProject(backendProject, "Сводка") != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("poster did not become active")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for {
+		if msgs := e.api.messages(); len(msgs) > 0 {
+			if len(msgs) != 1 || msgs[0].ChatID != -42 || msgs[0].Text != "Сводка" {
+				t.Fatalf("got %+v, want the text in the project chat", msgs)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("nothing posted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		text, cmd, rest string
+	}{
+		{"/list", "/list", ""},
+		{"/LIST all", "/list", "all"},
+		{"/new  2   Задача  ", "/new", "2   Задача"},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		cmd, rest := splitCommand(tt.text)
+		if cmd != tt.cmd || rest != tt.rest {
+			t.Errorf("splitCommand(%q) = %q, %q, want %q, %q", tt.text, cmd, rest, tt.cmd, tt.rest)
+		}
+	}
+}
+
+func TestParseTitleAndDescription(t *testing.T) {
+	tests := []struct {
+		input, title, description string
+	}{
+		{"Задача", "Задача", ""},
+		{" Задача | описание ", "Задача", "описание"},
+		{"Задача|", "Задача", ""},
+		{"|описание", "", "описание"},
+		{"a|b|c", "a", "b|c"},
+	}
+	for _, tt := range tests {
+		title, description := parseTitleAndDescription(tt.input)
+		if title != tt.title || description != tt.description {
+			t.Errorf("parseTitleAndDescription(%q) = %q, %q, want %q, %q", tt.input, title, description, tt.title, tt.description)
+		}
+	}
+}
+
+func TestParseDueDate(t *testing.T) {
+	tests := []struct {
+		input   string
+		title   string
+		due     string
+		wantErr bool
+	}{
+		{input: "Задача", title: "Задача"},
+		{input: "Задача @2026-11-01", title: "Задача", due: "2026-11-01"},
+		{input: "@2026-11-01", title: "", due: "2026-11-01"},
+		{input: "@2026-11-01 Задача", title: "@2026-11-01 Задача"},
+		{input: "Написать @ivan", title: "Написать @ivan"},
+		{input: "Оплатить @", title: "Оплатить @"},
+		{input: "", title: ""},
+		{input: "Задача @2026-02-30", wantErr: true},
+		{input: "Задача @1.11.2026", wantErr: true},
+	}
+	for _, tt := range tests {
+		title, due, err := parseDueDate(tt.input)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseDueDate(%q): want an error", tt.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseDueDate(%q): %v", tt.input, err)
+			continue
+		}
+		gotDue := ""
+		if due != nil {
+			gotDue = due.Format(store.DateLayout)
+		}
+		if title != tt.title || gotDue != tt.due {
+			t.Errorf("parseDueDate(%q) = %q, %q, want %q, %q", tt.input, title, gotDue, tt.title, tt.due)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package tgbot
+
+import (
+	"sync"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// fakeClient is an in-memory Client. It records what the bot sends and
+// delivers the updates written to its channel as if they were polled.
+type fakeClient struct {
+	mu       sync.Mutex
+	nextID   int
+	sent     []tgbotapi.Chattable
+	requests []string
+	updates  chan tgbotapi.Update
+	stopped  bool
+}
+
+var _ Client = (*fakeClient)(nil)
+var _ Client = (*tgbotapi.BotAPI)(nil)
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{updates: make(chan tgbotapi.Update, 10)}
+}
+
+func (f *fakeClient) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sent = append(f.sent, c)
+	f.nextID++
+	msg := tgbotapi.Message{MessageID: f.nextID, From: &tgbotapi.User{ID: botID, IsBot: true}}
+	if m, ok := c.(tgbotapi.MessageConfig); ok {
+		msg.Chat = &tgbotapi.Chat{ID: m.ChatID}
+		msg.Text = m.Text
+	}
+	return msg, nil
+}
+
+func (f *fakeClient) Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sent = append(f.sent, c)
+	return &tgbotapi.APIResponse{Ok: true}, nil
+}
+
+func (f *fakeClient) MakeRequest(endpoint string, params tgbotapi.Params) (*tgbotapi.APIResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, endpoint)
+	return &tgbotapi.APIResponse{Ok: true}, nil
+}
+
+func (f *fakeClient) GetUpdatesChan(config tgbotapi.UpdateConfig) tgbotapi.UpdatesChannel {
+	return f.updates
+}
+
+func (f *fakeClient) StopReceivingUpdates() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stopped = true
+}
+
+// messages returns the messages sent since the last call.
+func (f *fakeClient) messages() []tgbotapi.MessageConfig {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	msgs := make([]tgbotapi.MessageConfig, 0)
+	for _, c := range f.sent {
+		if m, ok := c.(tgbotapi.MessageConfig); ok {
+			msgs = append(msgs, m)
+		}
+	}
+	f.sent = nil
+	return msgs
+}
@@ -0,0 +1,345 @@
+package tgbot
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"litetask/internal/events"
+	"litetask/internal/store"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Callback data is "<kind>:<taskID>[:<arg>]", well under Telegram's 64-byte
+// limit since status keys are at most 32 characters.
+const (
+	callbackStatus  = "st" // arg is the target status
+	callbackComment = "cm"
+	callbackTask    = "tk"
+)
+
+const (
+	// listButtons caps the task buttons under a task list.
+	listButtons = 20
+	// buttonTitleLen is how many characters of a task title fit on a button.
+	buttonTitleLen = 40
+	statusesPerRow = 3
+	// detailComments is how many of the latest comments /task shows.
+	detailComments = 5
+	// commentPreviewLen caps each of them, and detailLen the whole message,
+	// well below Telegram's 4096 characters.
+	commentPreviewLen = 500
+	detailLen         = 3500
+)
+
+// commentPrompt starts the text after the sender's name in the message asking
+// for a comment; replies to it become comments on the task.
+const commentPrompt = "комментарий к задаче #"
+
+// taskCard describes a task in a message that task buttons are attached to.
+func (b *Bot) taskCard(t store.Task) string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "#%d (%s) [%s]: %s%s", t.ID, b.store.LookupProjectName(t.ProjectID), b.store.LookupStatusTitle(t.ProjectID, t.Status), t.Title, dueSuffix(t))
+	if len(t.Assignees) > 0 {
+		names := make([]string, len(t.Assignees))
+		for i, a := range t.Assignees {
+			names[i] = assigneeName(a)
+		}
+		fmt.Fprintf(&builder, "\nИсполнители: %s", strings.Join(names, ", "))
+	}
+	if t.Description != "" {
+		fmt.Fprintf(&builder, "\n\n%s", t.Description)
+	}
+	return builder.String()
+}
+
+// taskDetail is the task card followed by the latest comments.
+func (b *Bot) taskDetail(t store.Task) string {
+	card := b.taskCard(t)
+	comments, err := b.store.ListTaskComments(t.ID)
+	if err != nil {
+		log.Printf("bot: failed to load comments: %v", err)
+		return card
+	}
+	if len(comments) == 0 {
+		return card
+	}
+	var builder strings.Builder
+	builder.WriteString(card)
+	if len(comments) > detailComments {
+		fmt.Fprintf(&builder, "\n\nПоследние комментарии (%d из %d):", detailComments, len(comments))
+		comments = comments[len(comments)-detailComments:]
+	} else {
+		builder.WriteString("\n\nКомментарии:")
+	}
+	authors := make(map[int64]string)
+	for _, c := range comments {
+		author, ok := authors[c.AuthorID]
+		if !ok {
+			author = c.AuthorEmail
+			if u, err := b.store.GetUserByID(c.AuthorID); err == nil {
+				author = displayName(u)
+			}
+			authors[c.AuthorID] = author
+		}
+		fmt.Fprintf(&builder, "\n\n%s, %s:\n%s", author, c.CreatedAt.Local().Format("2006-01-02 15:04"), truncate(c.Body, commentPreviewLen))
+	}
+	return truncate(builder.String(), detailLen)
+}
+
+// taskKeyboard offers the statuses the task may move to, a comment button
+// and, when the public URL is known, a link to the task in the web UI.
+// Permissions are checked when a button is pressed, since in the team chat
+// everybody sees the same buttons.
+func (b *Bot) taskKeyboard(t store.Task) tgbotapi.InlineKeyboardMarkup {
+	rows := make([][]tgbotapi.InlineKeyboardButton, 0)
+	wf, err := b.store.GetWorkflow(t.ProjectID)
+	if err != nil {
+		log.Printf("bot: failed to load workflow: %v", err)
+	} else {
+		row := make([]tgbotapi.InlineKeyboardButton, 0, statusesPerRow)
+		for _, st := range wf.Statuses {
+			if st.Key == t.Status || !wf.CanMove(t.Status, st.Key) {
+				continue
+			}
+			data := fmt.Sprintf("%s:%d:%s", callbackStatus, t.ID, st.Key)
+			row = append(row, tgbotapi.NewInlineKeyboardButtonData(st.Title, data))
+			if len(row) == statusesPerRow {
+				rows = append(rows, row)
+				row = make([]tgbotapi.InlineKeyboardButton, 0, statusesPerRow)
+			}
+		}
+		if len(row) > 0 {
+			rows = append(rows, row)
+		}
+	}
+	actions := tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("Комментировать", fmt.Sprintf("%s:%d", callbackComment, t.ID)),
+	)
+	if b.publicURL != "" {
+		url := fmt.Sprintf("%s/?project=%d&task=%d", b.publicURL, t.ProjectID, t.ID)
+		actions = append(actions, tgbotapi.NewInlineKeyboardButtonURL("Открыть", url))
+	}
+	rows = append(rows, actions)
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// listKeyboard has a button per task that posts the task with its own
+// buttons. It is nil for an empty list.
+func listKeyboard(tasks []store.Task) any {
+	rows := make([][]tgbotapi.InlineKeyboardButton, 0)
+	for _, t := range tasks {
+		if len(rows) == listButtons {
+			break
+		}
+		label := fmt.Sprintf("#%d %s", t.ID, truncate(t.Title, buttonTitleLen))
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(label, fmt.Sprintf("%s:%d", callbackTask, t.ID)),
+		))
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+func parseCallback(data string) (kind string, taskID int64, arg string, ok bool) {
+	parts := strings.SplitN(data, ":", 3)
+	if len(parts) < 2 {
+		return "", 0, "", false
+	}
+	taskID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return "", 0, "", false
+	}
+	if len(parts) == 3 {
+		arg = parts[2]
+	}
+	return parts[0], taskID, arg, true
+}
+
+// handleCallback runs a task button. Every press is answered so the client
+// stops showing progress; refusals are shown as alerts.
+func (b *Bot) handleCallback(q *tgbotapi.CallbackQuery) {
+	kind, taskID, arg, ok := parseCallback(q.Data)
+	if !ok {
+		b.answer(q, "", false)
+		return
+	}
+	acc, refusal := b.userAccess(q.From)
+	if refusal != "" {
+		b.answer(q, refusal, true)
+		return
+	}
+	t, err := b.store.GetTask(taskID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !acc.canRead(t.ProjectID)) {
+		b.answer(q, "Задача не найдена", true)
+		return
+	}
+	if err != nil {
+		log.Printf("bot: failed to load task: %v", err)
+		b.answer(q, "Не удалось загрузить задачу", true)
+		return
+	}
+
+	switch kind {
+	case callbackTask:
+		b.answer(q, "", false)
+		b.sendAbout(q.Message.Chat, q.From, []int64{t.ProjectID}, b.taskCard(t), b.taskKeyboard(t))
+	case callbackComment:
+		if !acc.canWrite(t.ProjectID) {
+			b.answer(q, "Нет прав на комментарии в этом проекте", true)
+			return
+		}
+		b.answer(q, "", false)
+		b.promptComment(q.Message.Chat.ID, q.From, t)
+	case callbackStatus:
+		if !acc.canWrite(t.ProjectID) {
+			b.answer(q, "Нет прав на изменение задач в этом проекте", true)
+			return
+		}
+		updated, err := b.store.SetTaskStatus(taskID, arg, acc.user.ID)
+		if errors.Is(err, store.ErrInvalidStatus) || errors.Is(err, store.ErrTransitionNotAllowed) {
+			b.answer(q, b.statusHint(taskID, err), true)
+			return
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			b.answer(q, "Задача не найдена", true)
+			return
+		}
+		if err != nil {
+			log.Printf("bot: failed to update status: %v", err)
+			b.answer(q, "Не удалось обновить статус", true)
+			return
+		}
+		b.publish(acc, events.Event{Type: events.TaskUpdated, ProjectID: updated.ProjectID, TaskID: updated.ID, Data: updated})
+		b.answer(q, "Статус: "+b.store.LookupStatusTitle(updated.ProjectID, updated.Status), false)
+		edit := tgbotapi.NewEditMessageTextAndMarkup(q.Message.Chat.ID, q.Message.MessageID, b.taskCard(updated), b.taskKeyboard(updated))
+		if _, err := b.api.Request(edit); err != nil {
+			log.Printf("bot: failed to edit task message: %v", err)
+		}
+	default:
+		b.answer(q, "", false)
+	}
+}
+
+func (b *Bot) answer(q *tgbotapi.CallbackQuery, text string, alert bool) {
+	cb := tgbotapi.NewCallback(q.ID, text)
+	cb.ShowAlert = alert
+	if _, err := b.api.Request(cb); err != nil {
+		log.Printf("bot: failed to answer callback: %v", err)
+	}
+}
+
+// promptComment asks the user who pressed "Комментировать" to reply with the
+// comment text. The message mentions them so that only their client opens
+// the reply field.
+func (b *Bot) promptComment(chatID int64, from *tgbotapi.User, t store.Task) {
+	name := strings.TrimSpace(from.FirstName + " " + from.LastName)
+	if name == "" {
+		name = from.UserName
+	}
+	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("%s, %s%d (%s): ответь на это сообщение текстом", name, commentPrompt, t.ID, t.Title))
+	msg.Entities = []tgbotapi.MessageEntity{{
+		Type:   "text_mention",
+		Offset: 0,
+		Length: len(utf16.Encode([]rune(name))),
+		User:   from,
+	}}
+	msg.ReplyMarkup = tgbotapi.ForceReply{ForceReply: true, Selective: true, InputFieldPlaceholder: "Комментарий"}
+	if _, err := b.api.Send(msg); err != nil {
+		log.Printf("failed to send bot message to %d: %v", chatID, err)
+	}
+}
+
+// commentTarget returns the task a reply to one of the bot's messages is a
+// comment on: the task of a comment prompt or of a message with task buttons.
+func (b *Bot) commentTarget(msg *tgbotapi.Message) (int64, bool) {
+	prompt := msg.ReplyToMessage
+	if prompt == nil || prompt.From == nil || prompt.From.ID != b.self.ID {
+		return 0, false
+	}
+	if taskID, ok := markupTask(prompt.ReplyMarkup); ok {
+		return taskID, true
+	}
+	i := strings.Index(prompt.Text, commentPrompt)
+	if i < 0 {
+		return 0, false
+	}
+	rest := prompt.Text[i+len(commentPrompt):]
+	end := strings.IndexFunc(rest, func(r rune) bool { return r < '0' || r > '9' })
+	if end < 0 {
+		end = len(rest)
+	}
+	taskID, err := strconv.ParseInt(rest[:end], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return taskID, true
+}
+
+// markupTask finds the task of a message with task buttons by its comment
+// button.
+func markupTask(markup *tgbotapi.InlineKeyboardMarkup) (int64, bool) {
+	if markup == nil {
+		return 0, false
+	}
+	for _, row := range markup.InlineKeyboard {
+		for _, button := range row {
+			if button.CallbackData == nil {
+				continue
+			}
+			if kind, taskID, _, ok := parseCallback(*button.CallbackData); ok && kind == callbackComment {
+				return taskID, true
+			}
+		}
+	}
+	return 0, false
+}
+
+func (b *Bot) addComment(msg *tgbotapi.Message, acc access, taskID int64, body string) {
+	t, err := b.store.GetTask(taskID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !acc.canRead(t.ProjectID)) {
+		b.reply(msg, "Задача не найдена")
+		return
+	}
+	if err != nil {
+		log.Printf("bot: failed to load task: %v", err)
+		b.reply(msg, "Не удалось добавить комментарий")
+		return
+	}
+	if !acc.canWrite(t.ProjectID) {
+		b.reply(msg, "Нет прав на комментарии в этом проекте")
+		return
+	}
+	c, err := b.store.AddTaskComment(taskID, body, acc.user.ID)
+	if err != nil {
+		log.Printf("bot: failed to add comment: %v", err)
+		b.reply(msg, "Не удалось добавить комментарий")
+		return
+	}
+	b.publish(acc, events.Event{Type: events.CommentCreated, ProjectID: t.ProjectID, TaskID: t.ID, Data: c})
+	b.reply(msg, fmt.Sprintf("Комментарий добавлен к #%d", t.ID))
+}
+
+// truncate shortens s to at most n characters, marking the cut with "…".
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(append(r[:n-1], '…'))
+}
+
+func assigneeName(a store.TaskAssignee) string {
+	name := strings.TrimSpace(a.FirstName + " " + a.LastName)
+	if name == "" {
+		return a.Email
+	}
+	return name
+}
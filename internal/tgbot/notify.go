@@ -0,0 +1,214 @@
+package tgbot
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"litetask/internal/events"
+	"litetask/internal/store"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const notifyBatch = 100
+
+// notify follows the task history and pushes changes to Telegram. Bus events
+// only wake it up: the changes themselves are read from task_events after a
+// cursor, so events the bus drops are still delivered with the next one.
+//
+// The project's chats (or the team chat) hear about created tasks, status
+// changes and comments made outside the bot, as told by the source of each
+// task event; the bot already answers commands where they are sent.
+// Linked users get private messages they opted in to in their profile, never
+// about their own actions.
+func (b *Bot) notify(ctx context.Context) {
+	ch, unsubscribe := b.events.Subscribe()
+	defer unsubscribe()
+
+	cursor, err := b.store.LatestTaskEventID()
+	if err != nil {
+		log.Printf("bot: notifications disabled: %v", err)
+		return
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e, ok := <-ch:
+			if !ok {
+				return
+			}
+			if e.TaskID == 0 {
+				continue
+			}
+			cursor = b.deliverEvents(cursor)
+		}
+	}
+}
+
+// deliverEvents sends everything recorded after cursor and returns the new
+// cursor.
+func (b *Bot) deliverEvents(cursor int64) int64 {
+	for {
+		batch, err := b.store.ListTaskEventsAfter(cursor, notifyBatch)
+		if err != nil {
+			log.Printf("bot: failed to load task events: %v", err)
+			return cursor
+		}
+		for _, te := range batch {
+			b.deliverEvent(te)
+			cursor = te.ID
+		}
+		if len(batch) < notifyBatch {
+			return cursor
+		}
+	}
+}
+
+func (b *Bot) deliverEvent(te store.TaskEvent) {
+	var data struct {
+		Status    string `json:"status"`
+		From      string `json:"from"`
+		To        string `json:"to"`
+		UserID    int64  `json:"userId"`
+		CommentID int64  `json:"commentId"`
+	}
+	if err := json.Unmarshal(te.Data, &data); err != nil {
+		log.Printf("bot: invalid data in task event %d: %v", te.ID, err)
+		return
+	}
+	t, err := b.store.GetTask(te.TaskID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return
+	}
+	if err != nil {
+		log.Printf("bot: failed to load task %d: %v", te.TaskID, err)
+		return
+	}
+
+	actor := te.ActorEmail
+	if te.ActorID != 0 {
+		if u, err := b.store.GetUserByID(te.ActorID); err == nil {
+			actor = displayName(u)
+		}
+	}
+	if actor == "" {
+		actor = "кто-то"
+	}
+	task := fmt.Sprintf("#%d (%s) %s", t.ID, b.store.LookupProjectName(t.ProjectID), t.Title)
+	keyboard := b.taskKeyboard(t)
+
+	var chatText string
+	switch te.Type {
+	case store.EventTaskCreated:
+		chatText = fmt.Sprintf("Новая задача %s [%s]%s\nАвтор: %s", task, b.store.LookupStatusTitle(t.ProjectID, data.Status), dueSuffix(t), actor)
+	case store.EventStatusChanged:
+		text := fmt.Sprintf("%s: %s → %s (%s)", task, b.store.LookupStatusTitle(t.ProjectID, data.From), b.store.LookupStatusTitle(t.ProjectID, data.To), actor)
+		chatText = text
+		b.notifyUsers(t, te.ActorID, taskWatchers(t), func(p store.NotificationPrefs) bool { return p.StatusChanges }, "Статус задачи "+text, keyboard)
+	case store.EventCommentAdded:
+		c, err := b.store.GetTaskComment(data.CommentID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return
+		}
+		if err != nil {
+			log.Printf("bot: failed to load comment %d: %v", data.CommentID, err)
+			return
+		}
+		chatText = fmt.Sprintf("%s прокомментировал(а) %s:\n%s", actor, task, c.Body)
+		b.notifyUsers(t, te.ActorID, taskWatchers(t), func(p store.NotificationPrefs) bool { return p.Comments }, chatText, keyboard)
+	case store.EventAssigned:
+		text := fmt.Sprintf("%s назначил(а) тебя на задачу %s [%s]%s", actor, task, b.store.LookupStatusTitle(t.ProjectID, t.Status), dueSuffix(t))
+		b.notifyUsers(t, te.ActorID, []int64{data.UserID}, func(p store.NotificationPrefs) bool { return p.Assigned }, text, keyboard)
+	}
+
+	if chatText != "" && te.Source != events.SourceTelegram {
+		for _, chatID := range b.projectChats(t.ProjectID) {
+			b.sendTo(chatID, chatText, keyboard)
+		}
+	}
+}
+
+// projectChats returns the chats bound to a project, falling back to the
+// team chat when there are none.
+func (b *Bot) projectChats(projectID int64) []int64 {
+	chats, err := b.store.ListProjectTelegramChats(projectID)
+	if err != nil {
+		log.Printf("bot: failed to load project chats: %v", err)
+	}
+	ids := make([]int64, 0, len(chats))
+	for _, c := range chats {
+		ids = append(ids, c.ChatID)
+	}
+	if len(ids) == 0 && b.chatID != 0 {
+		ids = append(ids, b.chatID)
+	}
+	return ids
+}
+
+// notifyUsers sends text to the private chats of userIDs who opted in with
+// wants and can still see the task, skipping the actor.
+func (b *Bot) notifyUsers(t store.Task, actorID int64, userIDs []int64, wants func(store.NotificationPrefs) bool, text string, keyboard tgbotapi.InlineKeyboardMarkup) {
+	seen := make(map[int64]bool)
+	for _, id := range userIDs {
+		if id == 0 || id == actorID || seen[id] {
+			continue
+		}
+		seen[id] = true
+		u, err := b.store.GetUserByID(id)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			log.Printf("bot: failed to load user %d: %v", id, err)
+			continue
+		}
+		if u.TelegramID == 0 || u.Role == "blocked" || !wants(u.Notifications) {
+			continue
+		}
+		if u.Role != "admin" {
+			roles, err := b.store.GetUserProjectRoles(u.ID)
+			if err != nil {
+				log.Printf("bot: failed to load user projects: %v", err)
+				continue
+			}
+			if roles[t.ProjectID] == "" {
+				continue
+			}
+		}
+		// A private chat has the same id as the user.
+		b.sendTo(u.TelegramID, text, keyboard)
+	}
+}
+
+// taskWatchers returns the users who hear about a task: its author and
+// assignees.
+func taskWatchers(t store.Task) []int64 {
+	ids := []int64{t.CreatedBy}
+	for _, a := range t.Assignees {
+		ids = append(ids, a.ID)
+	}
+	return ids
+}
+
+func displayName(u store.User) string {
+	name := strings.TrimSpace(u.FirstName + " " + u.LastName)
+	if name == "" {
+		return u.Email
+	}
+	return name
+}
+
+// sendTo sends text to a chat. markup is a keyboard or nil.
+func (b *Bot) sendTo(chatID int64, text string, markup any) {
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ReplyMarkup = markup
+	if _, err := b.api.Send(msg); err != nil {
+		log.Printf("failed to send bot message to %d: %v", chatID, err)
+	}
+}
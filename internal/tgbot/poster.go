@@ -0,0 +1,43 @@
+package tgbot
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+const posterQueue = 100
+
+var (
+	ErrBotNotRunning = errors.New("telegram bot is not running")
+	ErrPosterBusy    = errors.New("telegram message queue is full")
+)
+
+type post struct {
+	projectID int64
+	text      string
+}
+
+// Poster lets other parts of the server, such as digests, post to the
+// Telegram chats of a project through the running bot.
+type Poster struct {
+	queue  chan post
+	active atomic.Bool
+}
+
+func NewPoster() *Poster {
+	return &Poster{queue: make(chan post, posterQueue)}
+}
+
+// PostToProject queues text for the project's chats, or the team chat when
+// it has none.
+func (p *Poster) PostToProject(projectID int64, text string) error {
+	if !p.active.Load() {
+		return ErrBotNotRunning
+	}
+	select {
+	case p.queue <- post{projectID: projectID, text: text}:
+		return nil
+	default:
+		return ErrPosterBusy
+	}
+}
@@ -0,0 +1,106 @@
+package tgbot
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	webhookQueue = 100
+	// secretHeader carries the secret_token given to setWebhook on every
+	// update Telegram sends.
+	secretHeader = "X-Telegram-Bot-Api-Secret-Token"
+)
+
+// Webhook receives the updates Telegram pushes; the API server mounts it at
+// /api/telegram/webhook. Requests without the secret token are refused, so
+// fake updates can only come from someone who knows it. They are accepted
+// while the bot runs, also after it fell back to polling, which allows
+// feeding it updates locally; before that the handler answers 503.
+type Webhook struct {
+	url     string
+	secret  string
+	updates chan tgbotapi.Update
+	active  atomic.Bool
+}
+
+// NewWebhook prepares webhook mode for the public address rawURL, which must
+// reach /api/telegram/webhook. An empty secret is replaced with a random
+// one; Telegram learns it again on every start.
+func NewWebhook(rawURL, secret string) (*Webhook, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return nil, errors.New("webhook url must be an https URL")
+	}
+	if secret == "" {
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		secret = hex.EncodeToString(buf)
+	}
+	if !validWebhookSecret(secret) {
+		return nil, errors.New("webhook secret must be 1-256 characters of A-Z, a-z, 0-9, _ or -")
+	}
+	return &Webhook{url: u.String(), secret: secret, updates: make(chan tgbotapi.Update, webhookQueue)}, nil
+}
+
+func validWebhookSecret(secret string) bool {
+	if len(secret) == 0 || len(secret) > 256 {
+		return false
+	}
+	for _, r := range secret {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func (h *Webhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.active.Load() {
+		http.Error(w, "webhook is not active", http.StatusServiceUnavailable)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get(secretHeader)), []byte(h.secret)) != 1 {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	var update tgbotapi.Update
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&update); err != nil {
+		http.Error(w, "invalid update", http.StatusBadRequest)
+		return
+	}
+	// Telegram retries failed deliveries, so a full queue only delays them.
+	select {
+	case h.updates <- update:
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.Error(w, "busy", http.StatusServiceUnavailable)
+	}
+}
+
+// register points Telegram at the webhook. The library predates secret
+// tokens, hence the raw request.
+func (h *Webhook) register(api Client) error {
+	params := tgbotapi.Params{"url": h.url, "secret_token": h.secret}
+	if err := params.AddInterface("allowed_updates", []string{"message", "callback_query"}); err != nil {
+		return err
+	}
+	_, err := api.MakeRequest("setWebhook", params)
+	return err
+}
@@ -0,0 +1,425 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"litetask/internal/events"
+	"litetask/internal/store"
+)
+
+// Headers sent with every delivery. The signature covers
+// "<timestamp>.<body>" so receivers can reject replayed requests.
+const (
+	HeaderEvent     = "X-LiteTask-Event"
+	HeaderDelivery  = "X-LiteTask-Delivery"
+	HeaderTimestamp = "X-LiteTask-Timestamp"
+	HeaderSignature = "X-LiteTask-Signature"
+)
+
+const (
+	maxAttempts    = 8
+	baseBackoff    = 30 * time.Second
+	pollInterval   = 10 * time.Second
+	requestTimeout = 10 * time.Second
+	batchSize      = 20
+	eventBatch     = 100
+	maxErrorLength = 500
+)
+
+// EventTypes lists the events a webhook can subscribe to. Project creation
+// and deletion are left out: a webhook belongs to a project, so it cannot
+// exist before the project and is removed together with it.
+var EventTypes = []string{
+	events.TaskCreated,
+	events.TaskUpdated,
+	events.TaskDeleted,
+	events.CommentCreated,
+	events.CommentDeleted,
+	events.ProjectUpdated,
+}
+
+func Supported(eventType string) bool {
+	for _, t := range EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Payload is the JSON body of a delivery.
+type Payload struct {
+	Event         string    `json:"event"`
+	ProjectID     int64     `json:"projectId"`
+	PrevProjectID int64     `json:"prevProjectId,omitempty"`
+	TaskID        int64     `json:"taskId,omitempty"`
+	ActorID       int64     `json:"actorId,omitempty"`
+	Source        string    `json:"source"`
+	Data          any       `json:"data,omitempty"`
+	At            time.Time `json:"at"`
+}
+
+// Sign returns the signature header value for a delivery body.
+func Sign(secret, timestamp string, body []byte) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(timestamp))
+	h.Write([]byte("."))
+	h.Write(body)
+	return "sha256=" + base64.RawStdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// Dispatcher follows the task history, turns it into persisted deliveries
+// and sends them, retrying failures with exponential backoff. The history,
+// the position in it and the queue all live in the database, so events are
+// neither lost when the bus drops them nor across restarts; bus events only
+// wake the dispatcher up.
+type Dispatcher struct {
+	store  *store.Store
+	bus    *events.Bus
+	client *http.Client
+	wake   chan struct{}
+}
+
+func NewDispatcher(s *store.Store, bus *events.Bus) *Dispatcher {
+	return &Dispatcher{
+		store:  s,
+		bus:    bus,
+		client: &http.Client{Timeout: requestTimeout},
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// Run queues and sends deliveries until ctx is cancelled.
+func (d *Dispatcher) Run(ctx context.Context) {
+	sub, unsubscribe := d.bus.Subscribe()
+	defer unsubscribe()
+
+	cursor, err := d.store.WebhookCursor()
+	if err != nil {
+		log.Printf("webhooks: disabled: %v", err)
+		return
+	}
+	go d.deliverLoop(ctx)
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		cursor = d.enqueueEvents(cursor)
+		select {
+		case <-ctx.Done():
+			return
+		case _, ok := <-sub:
+			if !ok {
+				return
+			}
+		case <-ticker.C:
+		}
+	}
+}
+
+// enqueueEvents queues the deliveries for the task events recorded after
+// cursor and returns the new cursor. An event that cannot be loaded stops
+// the run and is tried again the next time.
+func (d *Dispatcher) enqueueEvents(cursor int64) int64 {
+	for {
+		batch, err := d.store.ListTaskEventsAfter(cursor, eventBatch)
+		if err != nil {
+			log.Printf("webhooks: failed to load task events: %v", err)
+			return cursor
+		}
+		next := cursor
+		var deliveries []store.NewWebhookDelivery
+		for _, te := range batch {
+			queued, err := d.deliveries(te)
+			if err != nil {
+				log.Printf("webhooks: failed to queue task event %d: %v", te.ID, err)
+				break
+			}
+			deliveries = append(deliveries, queued...)
+			next = te.ID
+		}
+		if next == cursor {
+			return cursor
+		}
+		if err := d.store.EnqueueWebhookDeliveries(next, deliveries); err != nil {
+			log.Printf("webhooks: failed to enqueue deliveries: %v", err)
+			return cursor
+		}
+		if len(deliveries) > 0 {
+			select {
+			case d.wake <- struct{}{}:
+			default:
+			}
+		}
+		if len(batch) < eventBatch || next != batch[len(batch)-1].ID {
+			return next
+		}
+		cursor = next
+	}
+}
+
+// movedTask is all the webhooks of the project a task moved out of learn
+// about it: that it left the project.
+type movedTask struct {
+	ID        int64 `json:"id"`
+	ProjectID int64 `json:"projectId"`
+	Moved     bool  `json:"moved"`
+}
+
+// deliveries returns what to queue for a task event.
+func (d *Dispatcher) deliveries(te store.TaskEvent) ([]store.NewWebhookDelivery, error) {
+	e, ok, err := d.event(te)
+	if err != nil || !ok {
+		return nil, err
+	}
+	deliveries, err := d.deliveriesFor(e)
+	if err != nil {
+		return nil, err
+	}
+	// A task moved to another project is reported to the webhooks of both,
+	// but the old project's only learn that it left, not what it looks like
+	// in a project they may have no access to.
+	if e.PrevProjectID != 0 && e.PrevProjectID != e.ProjectID {
+		moved := e
+		moved.ProjectID, moved.PrevProjectID = e.PrevProjectID, 0
+		moved.Data = movedTask{ID: e.TaskID, ProjectID: e.PrevProjectID, Moved: true}
+		more, err := d.deliveriesFor(moved)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, more...)
+	}
+	return deliveries, nil
+}
+
+// event turns a task event into the event the webhooks report, with the
+// task, comment or workflow as it is now. ok is false for events that are
+// not reported, such as changes to a task deleted since: its deletion is
+// reported on its own.
+func (d *Dispatcher) event(te store.TaskEvent) (e events.Event, ok bool, err error) {
+	e = events.Event{TaskID: te.TaskID, ActorID: te.ActorID, Source: te.Source, At: te.CreatedAt}
+	var data struct {
+		ProjectID int64     `json:"projectId"`
+		CommentID int64     `json:"commentId"`
+		Body      string    `json:"body"`
+		AuthorID  int64     `json:"authorId"`
+		CreatedAt time.Time `json:"createdAt"`
+	}
+	if err := json.Unmarshal(te.Data, &data); err != nil {
+		log.Printf("webhooks: invalid data in task event %d: %v", te.ID, err)
+		return e, false, nil
+	}
+
+	switch te.Type {
+	case store.EventTaskDeleted:
+		e.Type, e.ProjectID = events.TaskDeleted, data.ProjectID
+		return e, true, nil
+	case store.EventWorkflowChanged:
+		wf, err := d.store.GetWorkflow(data.ProjectID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return e, false, nil
+		}
+		if err != nil {
+			return e, false, err
+		}
+		e.Type, e.ProjectID, e.Data = events.ProjectUpdated, data.ProjectID, wf
+		return e, true, nil
+	}
+
+	t, err := d.store.GetTask(te.TaskID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return e, false, nil
+	}
+	if err != nil {
+		return e, false, err
+	}
+	e.ProjectID = t.ProjectID
+	switch te.Type {
+	case store.EventTaskCreated:
+		e.Type, e.Data = events.TaskCreated, t
+	case store.EventStatusChanged, store.EventDescriptionEdited, store.EventDatesChanged, store.EventAssigned, store.EventUnassigned:
+		e.Type, e.Data = events.TaskUpdated, t
+	case store.EventProjectMoved:
+		var moved struct {
+			From int64 `json:"from"`
+		}
+		if err := json.Unmarshal(te.Data, &moved); err != nil {
+			log.Printf("webhooks: invalid data in task event %d: %v", te.ID, err)
+			return e, false, nil
+		}
+		e.Type, e.PrevProjectID, e.Data = events.TaskUpdated, moved.From, t
+	case store.EventCommentAdded:
+		c, err := d.store.GetTaskComment(data.CommentID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return e, false, nil
+		}
+		if err != nil {
+			return e, false, err
+		}
+		e.Type, e.Data = events.CommentCreated, c
+	case store.EventCommentDeleted:
+		c := store.TaskComment{ID: data.CommentID, TaskID: te.TaskID, Body: data.Body, AuthorID: data.AuthorID, CreatedAt: data.CreatedAt}
+		if author, err := d.store.GetUserByID(data.AuthorID); err == nil {
+			c.AuthorEmail = author.Email
+		}
+		e.Type, e.Data = events.CommentDeleted, c
+	default:
+		return e, false, nil
+	}
+	return e, true, nil
+}
+
+// deliveriesFor returns the deliveries of e to the webhooks of its project.
+func (d *Dispatcher) deliveriesFor(e events.Event) ([]store.NewWebhookDelivery, error) {
+	hooks, err := d.store.ActiveWebhooksFor(e.ProjectID, e.Type)
+	if err != nil || len(hooks) == 0 {
+		return nil, err
+	}
+	body, err := json.Marshal(Payload{
+		Event:         e.Type,
+		ProjectID:     e.ProjectID,
+		PrevProjectID: e.PrevProjectID,
+		TaskID:        e.TaskID,
+		ActorID:       e.ActorID,
+		Source:        e.Source,
+		Data:          e.Data,
+		At:            e.At,
+	})
+	if err != nil {
+		log.Printf("webhooks: failed to encode %s: %v", e.Type, err)
+		return nil, nil
+	}
+	deliveries := make([]store.NewWebhookDelivery, len(hooks))
+	for i, hook := range hooks {
+		deliveries[i] = store.NewWebhookDelivery{WebhookID: hook.ID, EventType: e.Type, Payload: string(body)}
+	}
+	return deliveries, nil
+}
+
+func (d *Dispatcher) deliverLoop(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		d.deliverDue(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+func (d *Dispatcher) deliverDue(ctx context.Context) {
+	for ctx.Err() == nil {
+		due, err := d.store.DueWebhookDeliveries(time.Now(), batchSize)
+		if err != nil {
+			log.Printf("webhooks: failed to load pending deliveries: %v", err)
+			return
+		}
+		if len(due) == 0 {
+			return
+		}
+		hooks := make(map[int64]*store.Webhook)
+		for _, delivery := range due {
+			hook, ok := hooks[delivery.WebhookID]
+			if !ok {
+				h, err := d.store.GetWebhook(delivery.WebhookID)
+				if err != nil && !errors.Is(err, sql.ErrNoRows) {
+					log.Printf("webhooks: failed to load webhook %d: %v", delivery.WebhookID, err)
+					return
+				}
+				if err == nil {
+					hook = &h
+				}
+				hooks[delivery.WebhookID] = hook
+			}
+			if err := d.attempt(ctx, hook, delivery); err != nil {
+				if ctx.Err() == nil {
+					log.Printf("webhooks: failed to update delivery %d: %v", delivery.ID, err)
+				}
+				return
+			}
+		}
+	}
+}
+
+// attempt sends a delivery once and records the outcome.
+func (d *Dispatcher) attempt(ctx context.Context, hook *store.Webhook, delivery store.WebhookDelivery) error {
+	if hook == nil || !hook.Active {
+		return d.store.RecordWebhookAttempt(delivery.ID, false, 0, "webhook disabled", nil)
+	}
+
+	code, sendErr := d.send(ctx, *hook, delivery)
+	if sendErr == nil {
+		return d.store.RecordWebhookAttempt(delivery.ID, true, code, "", nil)
+	}
+	if ctx.Err() != nil {
+		// Shutting down: leave the delivery due for the next start.
+		return ctx.Err()
+	}
+
+	var next *time.Time
+	if attempts := delivery.Attempts + 1; attempts < maxAttempts {
+		at := time.Now().Add(backoff(attempts))
+		next = &at
+	}
+	return d.store.RecordWebhookAttempt(delivery.ID, false, code, truncate(sendErr.Error()), next)
+}
+
+func (d *Dispatcher) send(ctx context.Context, hook store.Webhook, delivery store.WebhookDelivery) (int, error) {
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "LiteTask-Webhook")
+	req.Header.Set(HeaderEvent, delivery.EventType)
+	req.Header.Set(HeaderDelivery, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Sign(hook.Secret, timestamp, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	snippet, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorLength))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg := strings.TrimSpace(string(snippet))
+		if msg == "" {
+			msg = resp.Status
+		}
+		return resp.StatusCode, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, msg)
+	}
+	return resp.StatusCode, nil
+}
+
+// backoff returns the delay before retry number attempt: 30s, 1m, 2m, ...
+func backoff(attempt int) time.Duration {
+	return baseBackoff << (attempt - 1)
+}
+
+func truncate(msg string) string {
+	if len(msg) <= maxErrorLength {
+		return msg
+	}
+	return msg[:maxErrorLength]
+}
@@ -0,0 +1,110 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"litetask/internal/events"
+	"litetask/internal/store"
+)
+
+func TestDispatcherQueuesTaskHistory(t *testing.T) {
+	s, err := store.Open(filepath.Join(t.TempDir(), "litetask.db"))
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	web := s.WithSource(events.SourceWeb)
+	u, err := s.CreateUser("ann@example.com", "", "password", "user", "", "")
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	other, err := s.CreateProject("Other")
+	if err != nil {
+		t.Fatalf("create project: %v", err)
+	}
+	// Events recorded before the dispatcher first runs are not replayed.
+	if _, err := web.InsertTask("Before", "", store.DefaultProjectID, u.ID, nil, nil); err != nil {
+		t.Fatalf("insert task: %v", err)
+	}
+	cursor, err := s.WebhookCursor()
+	if err != nil {
+		t.Fatalf("webhook cursor: %v", err)
+	}
+	oldHook, err := s.CreateWebhook(store.DefaultProjectID, "http://old.example.com", "secret", nil, true)
+	if err != nil {
+		t.Fatalf("create webhook: %v", err)
+	}
+	newHook, err := s.CreateWebhook(other.ID, "http://new.example.com", "secret", nil, true)
+	if err != nil {
+		t.Fatalf("create webhook: %v", err)
+	}
+
+	// No bus: nothing but the history tells the dispatcher about changes.
+	d := NewDispatcher(s, nil)
+	task, err := web.InsertTask("Plan", "secret plans", store.DefaultProjectID, u.ID, nil, nil)
+	if err != nil {
+		t.Fatalf("insert task: %v", err)
+	}
+	cursor = d.enqueueEvents(cursor)
+	if _, err := web.MoveTask(task.ID, other.ID, u.ID); err != nil {
+		t.Fatalf("move task: %v", err)
+	}
+	d.enqueueEvents(cursor)
+
+	due, err := s.DueWebhookDeliveries(time.Now().Add(time.Minute), 10)
+	if err != nil {
+		t.Fatalf("due deliveries: %v", err)
+	}
+	want := []struct {
+		webhookID int64
+		event     string
+		projectID int64
+		stub      bool
+	}{
+		{oldHook.ID, events.TaskCreated, store.DefaultProjectID, false},
+		{newHook.ID, events.TaskUpdated, other.ID, false},
+		{oldHook.ID, events.TaskUpdated, store.DefaultProjectID, true},
+	}
+	if len(due) != len(want) {
+		t.Fatalf("queued %d deliveries, want %d", len(due), len(want))
+	}
+	for i, w := range want {
+		var p struct {
+			Payload
+			Data map[string]any `json:"data"`
+		}
+		if err := json.Unmarshal([]byte(due[i].Payload), &p); err != nil {
+			t.Fatalf("delivery %d: %v", i, err)
+		}
+		if due[i].WebhookID != w.webhookID || p.Event != w.event || p.ProjectID != w.projectID || p.Source != events.SourceWeb {
+			t.Errorf("delivery %d: webhook %d, %s in project %d from %q, want webhook %d, %s in project %d from web",
+				i, due[i].WebhookID, p.Event, p.ProjectID, p.Source, w.webhookID, w.event, w.projectID)
+		}
+		if _, hasTitle := p.Data["title"]; hasTitle == w.stub || (w.stub && p.Data["moved"] != true) {
+			t.Errorf("delivery %d: data = %v, stub %v", i, p.Data, w.stub)
+		}
+	}
+
+	// A restarted dispatcher goes on after the queued events.
+	cursor, err = s.WebhookCursor()
+	if err != nil {
+		t.Fatalf("webhook cursor: %v", err)
+	}
+	if err := web.DeleteTask(task.ID, u.ID); err != nil {
+		t.Fatalf("delete task: %v", err)
+	}
+	NewDispatcher(s, nil).enqueueEvents(cursor)
+	due, err = s.DueWebhookDeliveries(time.Now().Add(time.Minute), 10)
+	if err != nil {
+		t.Fatalf("due deliveries: %v", err)
+	}
+	if len(due) != len(want)+1 {
+		t.Fatalf("queued %d deliveries, want %d", len(due), len(want)+1)
+	}
+	if last := due[len(due)-1]; last.WebhookID != newHook.ID || last.EventType != events.TaskDeleted {
+		t.Errorf("last delivery: %s to webhook %d, want %s to webhook %d", last.EventType, last.WebhookID, events.TaskDeleted, newHook.ID)
+	}
+}
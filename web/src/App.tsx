@@ -1,11 +1,17 @@
-import { Card, Empty, Layout, Spin, message } from "antd";
+import { Card, Empty, Layout, Modal, Spin, message } from "antd";
 import axios from "axios";
 import { useCallback, useEffect, useMemo, useState } from "react";
 import "./App.css";
 import api from "./api";
-import { AUTO_REFRESH_INTERVAL_STORAGE_KEY } from "./constants";
-import AuthCard from "./components/auth/AuthCard";
+import {
+  AUTO_REFRESH_INTERVAL_STORAGE_KEY,
+  emptyEmailPrefs,
+  emptyNotificationPrefs,
+} from "./constants";
+import AuthCard, { type AuthMode } from "./components/auth/AuthCard";
+import { RecoveryCodeList } from "./components/shared/TwoFactorSetup";
 import PasswordModal from "./components/admin/PasswordModal";
+import SecurityCard from "./components/admin/SecurityCard";
 import UserForm from "./components/admin/UserForm";
 import UserInfoModal from "./components/admin/UserInfoModal";
 import UserTable from "./components/admin/UserTable";
@@ -14,19 +20,49 @@ import MobileNav from "./components/layout/MobileNav";
 import ProjectManagerCard from "./components/projects/ProjectManagerCard";
 import ProjectTabs from "./components/projects/ProjectTabs";
 import ProfileModal from "./components/profile/ProfileModal";
+import TwoFactorModal from "./components/profile/TwoFactorModal";
 import QuickAddPage from "./components/quick/QuickAddPage";
 import Board from "./components/tasks/Board";
 import CreateTaskModal from "./components/tasks/CreateTaskModal";
 import TaskDetailCard from "./components/tasks/TaskDetailCard";
 import type {
+  AuthConfig,
   AutoRefreshIntervalMs,
+  EmailPrefs,
+  NotificationPrefs,
   Project,
   StatusKey,
   Task,
+  TaskAttachment,
   TaskComment,
+  TwoFactorChallenge,
+  TwoFactorSetup,
   User,
+  VerificationPending,
 } from "./types";
 
+// ssoErrorText explains the "?sso_error=" reasons of a failed single sign-on.
+function ssoErrorText(reason: string) {
+  switch (reason) {
+    case "blocked":
+      return "Аккаунт заблокирован";
+    case "no_email":
+      return "Провайдер не сообщил email, войти не получится";
+    case "email_taken":
+      return "Этот email уже занят другим аккаунтом";
+    case "denied":
+      return "Вход отменён";
+    case "expired":
+      return "Вход занял слишком много времени, попробуй ещё раз";
+    case "unavailable":
+      return "Провайдер входа недоступен, попробуй позже";
+    case "too_many_logins":
+      return "Слишком много попыток входа, попробуй позже";
+    default:
+      return "Не удалось войти через единый вход";
+  }
+}
+
 function App() {
   const [tasks, setTasks] = useState<Task[]>([]);
   const [projects, setProjects] = useState<Project[]>([]);
@@ -52,9 +88,26 @@ function App() {
   );
   const [deletingProject, setDeletingProject] = useState(false);
   const [user, setUser] = useState<User | null>(null);
-  const [authMode, setAuthMode] = useState<"login" | "register">("login");
+  // Token from a "?reset=" link in the password reset email.
+  const [resetToken] = useState(
+    () => new URLSearchParams(window.location.search).get("reset") ?? "",
+  );
+  const [authMode, setAuthMode] = useState<AuthMode>(
+    resetToken ? "reset" : "login",
+  );
   const [authLoading, setAuthLoading] = useState(false);
   const [authError, setAuthError] = useState("");
+  const [authNotice, setAuthNotice] = useState("");
+  const [authConfig, setAuthConfig] = useState<AuthConfig>({
+    passwordLogin: true,
+    registration: true,
+    sso: null,
+  });
+  // Login to resend the verification email to after "email not verified".
+  const [unverifiedLogin, setUnverifiedLogin] = useState("");
+  // Second login step after the password was accepted.
+  const [loginChallenge, setLoginChallenge] = useState("");
+  const [loginSetup, setLoginSetup] = useState<TwoFactorSetup | null>(null);
   const [mobileNavOpen, setMobileNavOpen] = useState(false);
   const [activePage, setActivePage] = useState<"board" | "settings" | "quick">(
     "quick",
@@ -69,17 +122,35 @@ function App() {
     null,
   );
   const [creatingUser, setCreatingUser] = useState(false);
+  const [requireAdminTwoFactor, setRequireAdminTwoFactor] = useState(false);
+  const [savingSecurity, setSavingSecurity] = useState(false);
   const [selectedTaskId, setSelectedTaskId] = useState<number | null>(null);
+  // Task to open once its project has loaded, from a "?project=&task=" link
+  // such as the "Открыть" button in Telegram.
+  const [linkedTask, setLinkedTask] = useState<{
+    projectId: number;
+    taskId: number;
+  } | null>(() => {
+    const params = new URLSearchParams(window.location.search);
+    const projectId = Number(params.get("project"));
+    const taskId = Number(params.get("task"));
+    return projectId > 0 && taskId > 0 ? { projectId, taskId } : null;
+  });
   const [taskModalOpen, setTaskModalOpen] = useState(false);
   const [projectTaskCounts, setProjectTaskCounts] = useState<
     Record<number, number>
   >({});
   const [profileModalOpen, setProfileModalOpen] = useState(false);
+  const [twoFactorModalOpen, setTwoFactorModalOpen] = useState(false);
   const [profilePassword, setProfilePassword] = useState("");
   const [profileTelegram, setProfileTelegram] = useState("");
   const [profileFirstName, setProfileFirstName] = useState("");
   const [profileLastName, setProfileLastName] = useState("");
   const [profileUsername, setProfileUsername] = useState("");
+  const [profileNotifications, setProfileNotifications] =
+    useState<NotificationPrefs>(emptyNotificationPrefs);
+  const [profileEmailNotifications, setProfileEmailNotifications] =
+    useState<EmailPrefs>(emptyEmailPrefs);
   const [profileSaving, setProfileSaving] = useState(false);
   const [editingUserInfo, setEditingUserInfo] = useState<User | null>(null);
   const [editingUserFirstName, setEditingUserFirstName] = useState("");
@@ -97,27 +168,108 @@ function App() {
     }
   };
 
+  const handleAuthModeChange = (mode: AuthMode) => {
+    setAuthMode(mode);
+    setAuthError("");
+    setAuthNotice("");
+    setUnverifiedLogin("");
+    setLoginChallenge("");
+    setLoginSetup(null);
+  };
+
+  // startTwoFactor moves a login that needs a second factor to the code
+  // step, setting up the authenticator first when an admin has none yet.
+  const startTwoFactor = useCallback(
+    async ({ challenge, twoFactor }: TwoFactorChallenge) => {
+      setLoginChallenge(challenge);
+      if (twoFactor === "enroll") {
+        const setup = await api.post<TwoFactorSetup>("/auth/2fa/enroll", {
+          challenge,
+        });
+        setLoginSetup(setup.data);
+        setAuthNotice(
+          "Администраторам нужно входить с кодом из приложения. Настрой его сейчас.",
+        );
+      }
+      setAuthMode("twofactor");
+    },
+    [],
+  );
+
   const handleAuth = async (
     email: string,
     password: string,
-    mode: "login" | "register",
+    mode: AuthMode,
     username?: string,
     firstName?: string,
     lastName?: string,
+    code?: string,
   ) => {
     setAuthLoading(true);
     setAuthError("");
+    setAuthNotice("");
+    setUnverifiedLogin("");
     try {
+      if (mode === "forgot") {
+        await api.post("/auth/forgot", { login: email });
+        setAuthNotice(
+          "Если такой аккаунт есть, мы отправили на его почту ссылку для сброса пароля",
+        );
+        return;
+      }
+      if (mode === "twofactor") {
+        const response = await api.post<
+          User & { recoveryCodes?: string[] }
+        >("/auth/2fa", { challenge: loginChallenge, code: code?.trim() });
+        const { recoveryCodes, ...signedIn } = response.data;
+        setLoginChallenge("");
+        setLoginSetup(null);
+        setAuthMode("login");
+        setUser(signedIn);
+        if (recoveryCodes) {
+          Modal.info({
+            title: "Вход по коду включён",
+            content: <RecoveryCodeList codes={recoveryCodes} />,
+            width: 480,
+          });
+        } else {
+          message.success("Вход выполнен");
+        }
+        void loadProjects();
+        void loadTasks();
+        return;
+      }
+      if (mode === "reset") {
+        await api.post("/auth/reset", { token: resetToken, password });
+        window.history.replaceState(null, "", window.location.pathname);
+        setAuthMode("login");
+        setAuthNotice("Пароль изменён, войди с новым паролем");
+        return;
+      }
       const response =
         mode === "login"
-          ? await api.post<User>("/auth/login", { login: email, password })
-          : await api.post<User>("/auth/register", {
+          ? await api.post<User | TwoFactorChallenge>("/auth/login", {
+              login: email,
+              password,
+            })
+          : await api.post<User | VerificationPending>("/auth/register", {
               email,
               username: username?.trim() ?? "",
               password,
               firstName: firstName?.trim() ?? "",
               lastName: lastName?.trim() ?? "",
             });
+      if ("challenge" in response.data) {
+        await startTwoFactor(response.data);
+        return;
+      }
+      if ("verificationRequired" in response.data) {
+        setAuthMode("login");
+        setAuthNotice(
+          `Мы отправили письмо на ${response.data.email}. Перейди по ссылке из него, чтобы подтвердить адрес и войти.`,
+        );
+        return;
+      }
       setUser(response.data);
       message.success(
         mode === "login" ? "Вход выполнен" : "Регистрация успешна",
@@ -127,8 +279,27 @@ function App() {
     } catch (error) {
       console.error(error);
       if (axios.isAxiosError(error)) {
-        if (error.response?.status === 403 && mode === "register") {
+        if (mode === "twofactor" && error.response?.status === 401) {
+          if (String(error.response.data).trim() === "invalid code") {
+            setAuthError("Неверный код");
+          } else {
+            setLoginChallenge("");
+            setLoginSetup(null);
+            setAuthMode("login");
+            setAuthError("Время на ввод кода истекло, войди заново");
+          }
+        } else if (error.response?.status === 429) {
+          setAuthError("Слишком много попыток входа, попробуй позже");
+        } else if (error.response?.status === 403 && mode === "register") {
           setAuthError("Регистрация отключена");
+        } else if (
+          error.response?.status === 403 &&
+          String(error.response.data).trim() === "email not verified"
+        ) {
+          setAuthError("Адрес не подтверждён, проверь почту");
+          setUnverifiedLogin(email);
+        } else if (error.response?.status === 503 && mode === "forgot") {
+          setAuthError("Сброс пароля не настроен, обратись к администратору");
         } else if (error.response?.status === 401) {
           setAuthError("Неверные учетные данные");
         } else if (error.response?.data) {
@@ -148,6 +319,18 @@ function App() {
     }
   };
 
+  const handleResendVerification = async () => {
+    try {
+      await api.post("/auth/verify/resend", { login: unverifiedLogin });
+      setAuthError("");
+      setUnverifiedLogin("");
+      setAuthNotice("Письмо отправлено, проверь почту");
+    } catch (error) {
+      console.error(error);
+      setAuthError("Не удалось отправить письмо");
+    }
+  };
+
   const handleLogout = async () => {
     try {
       await api.post("/auth/logout");
@@ -269,6 +452,61 @@ function App() {
     void fetchMe();
   }, []);
 
+  useEffect(() => {
+    api
+      .get<AuthConfig>("/auth/config")
+      .then((response) => setAuthConfig(response.data))
+      .catch((error) => console.error(error));
+  }, []);
+
+  // Single sign-on comes back with "?sso_error=" when it did not work out.
+  useEffect(() => {
+    const reason = new URLSearchParams(window.location.search).get(
+      "sso_error",
+    );
+    if (!reason) {
+      return;
+    }
+    window.history.replaceState(null, "", window.location.pathname);
+    setAuthError(ssoErrorText(reason));
+  }, []);
+
+  // Single sign-on comes back with "?sso_challenge=" when the account needs
+  // a second factor; the login then goes on as after a password.
+  useEffect(() => {
+    const params = new URLSearchParams(window.location.search);
+    const challenge = params.get("sso_challenge");
+    if (!challenge) {
+      return;
+    }
+    window.history.replaceState(null, "", window.location.pathname);
+    const twoFactor =
+      params.get("two_factor") === "enroll" ? "enroll" : "verify";
+    startTwoFactor({ challenge, twoFactor }).catch((error) => {
+      console.error(error);
+      setLoginChallenge("");
+      setLoginSetup(null);
+      setAuthMode("login");
+      setAuthError("Не удалось войти через единый вход");
+    });
+  }, [startTwoFactor]);
+
+  // Confirm the address from a "?verify=" link in the verification email.
+  useEffect(() => {
+    const token = new URLSearchParams(window.location.search).get("verify");
+    if (!token) {
+      return;
+    }
+    window.history.replaceState(null, "", window.location.pathname);
+    api
+      .post("/auth/verify", { token })
+      .then(() => setAuthNotice("Адрес подтверждён, теперь можно войти"))
+      .catch((error) => {
+        console.error(error);
+        setAuthError("Ссылка недействительна или устарела");
+      });
+  }, []);
+
   useEffect(() => {
     const saved = localStorage.getItem(AUTO_REFRESH_INTERVAL_STORAGE_KEY);
     if (!saved) {
@@ -304,6 +542,31 @@ function App() {
     void loadTasks();
   }, [loadTasks, selectedProject, user]);
 
+  useEffect(() => {
+    if (!linkedTask || projects.length === 0) {
+      return;
+    }
+    if (!projects.some((p) => p.id === linkedTask.projectId)) {
+      setLinkedTask(null);
+      return;
+    }
+    setActivePage("board");
+    setSelectedProject(linkedTask.projectId);
+  }, [linkedTask, projects]);
+
+  useEffect(() => {
+    if (
+      !linkedTask ||
+      selectedProject !== linkedTask.projectId ||
+      !tasks.some((task) => task.id === linkedTask.taskId)
+    ) {
+      return;
+    }
+    setLinkedTask(null);
+    window.history.replaceState(null, "", window.location.pathname);
+    openTaskDetails(linkedTask.taskId);
+  }, [linkedTask, selectedProject, tasks]);
+
   useEffect(() => {
     if (autoRefreshIntervalMs === null) {
       return;
@@ -317,6 +580,36 @@ function App() {
     return () => window.clearInterval(intervalId);
   }, [activePage, autoRefreshIntervalMs, loadTasks, selectedProject, user]);
 
+  useEffect(() => {
+    if (activePage !== "board" || !user || !selectedProject) {
+      return;
+    }
+    const base = import.meta.env.VITE_API_URL || "/api";
+    const source = new EventSource(
+      `${base}/events?projectId=${selectedProject}`,
+      { withCredentials: true },
+    );
+    let timeoutId: number | undefined;
+    const reload = () => {
+      window.clearTimeout(timeoutId);
+      timeoutId = window.setTimeout(() => {
+        void loadTasks(selectedProject, { silent: true });
+      }, 300);
+    };
+    [
+      "task.created",
+      "task.updated",
+      "task.deleted",
+      "comment.created",
+      "comment.deleted",
+      "project.updated",
+    ].forEach((type) => source.addEventListener(type, reload));
+    return () => {
+      window.clearTimeout(timeoutId);
+      source.close();
+    };
+  }, [activePage, loadTasks, selectedProject, user]);
+
   useEffect(() => {
     const handler = (e: KeyboardEvent) => {
       if (activePage !== "board" || !selectedProject || !user) {
@@ -446,6 +739,23 @@ function App() {
     }
   };
 
+  const loadTaskAttachments = async (taskId: number) => {
+    try {
+      const response = await api.get<TaskAttachment[]>(
+        `/tasks/${taskId}/attachments`,
+      );
+      setTasks((prev) =>
+        prev.map((task) =>
+          task.id === taskId
+            ? { ...task, attachments: response.data ?? [] }
+            : task,
+        ),
+      );
+    } catch (error) {
+      console.error(error);
+    }
+  };
+
   const openTaskDetails = (taskId: number) => {
     setSelectedTaskId(taskId);
     const currentTask = tasks.find((task) => task.id === taskId);
@@ -453,6 +763,7 @@ function App() {
     setCommentDraft("");
     setEditingId(null);
     void loadTaskComments(taskId);
+    void loadTaskAttachments(taskId);
   };
 
   const closeTaskDetails = () => {
@@ -550,10 +861,14 @@ function App() {
       firstName?: string;
       lastName?: string;
       username?: string;
+      notifications?: NotificationPrefs;
+      emailNotifications?: EmailPrefs;
     } = {
       telegram: profileTelegram,
       firstName: profileFirstName,
       lastName: profileLastName,
+      notifications: profileNotifications,
+      emailNotifications: profileEmailNotifications,
     };
     if (!user.username?.trim() && profileUsername.trim()) {
       payload.username = profileUsername.trim();
@@ -574,6 +889,12 @@ function App() {
       setProfileFirstName(response.data.firstName ?? "");
       setProfileLastName(response.data.lastName ?? "");
       setProfileUsername(response.data.username ?? "");
+      setProfileNotifications(
+        response.data.notifications ?? emptyNotificationPrefs,
+      );
+      setProfileEmailNotifications(
+        response.data.emailNotifications ?? emptyEmailPrefs,
+      );
       message.success("Профиль обновлен");
       setProfileModalOpen(false);
     } catch (error) {
@@ -592,6 +913,43 @@ function App() {
     }
   };
 
+  const handleLogoutOthers = async () => {
+    try {
+      await api.delete("/profile/sessions");
+      message.success("Остальные сеансы завершены");
+    } catch (error) {
+      console.error(error);
+      message.error("Не удалось завершить сеансы");
+    }
+  };
+
+  const handleTelegramLink = async () => {
+    try {
+      const response = await api.post<{ code: string; command: string }>(
+        "/profile/telegram/link",
+      );
+      Modal.info({
+        title: "Привязка Telegram",
+        content: `Отправьте боту команду ${response.data.command} в течение 10 минут.`,
+      });
+    } catch (error) {
+      console.error(error);
+      message.error("Не удалось получить код");
+    }
+  };
+
+  const handleTelegramUnlink = async () => {
+    if (!user) return;
+    try {
+      await api.delete("/profile/telegram");
+      setUser({ ...user, telegramLinked: false });
+      message.success("Telegram отвязан");
+    } catch (error) {
+      console.error(error);
+      message.error("Не удалось отвязать Telegram");
+    }
+  };
+
   const openUserInfoModal = (target: User) => {
     setEditingUserInfo(target);
     setEditingUserFirstName(target.firstName ?? "");
@@ -764,6 +1122,54 @@ function App() {
     }
   }, [activePage, loadUsers, user]);
 
+  useEffect(() => {
+    if (activePage !== "settings" || user?.role !== "admin") {
+      return;
+    }
+    api
+      .get<{ requireAdminTwoFactor: boolean }>("/admin/security")
+      .then((response) =>
+        setRequireAdminTwoFactor(response.data.requireAdminTwoFactor),
+      )
+      .catch((error) => console.error(error));
+  }, [activePage, user?.role]);
+
+  const handleRequireAdminTwoFactorChange = async (value: boolean) => {
+    setSavingSecurity(true);
+    try {
+      await api.put("/admin/security", { requireAdminTwoFactor: value });
+      setRequireAdminTwoFactor(value);
+      message.success("Настройки сохранены");
+    } catch (error) {
+      console.error(error);
+      if (axios.isAxiosError(error) && error.response?.status === 409) {
+        message.error("Сначала включи вход по коду себе в профиле");
+      } else {
+        message.error("Не удалось сохранить настройки");
+      }
+    } finally {
+      setSavingSecurity(false);
+    }
+  };
+
+  const handleResetTwoFactor = async (target: User) => {
+    try {
+      await api.delete(`/users/${target.id}/2fa`);
+      setUsers((prev) =>
+        prev.map((u) =>
+          u.id === target.id ? { ...u, twoFactorEnabled: false } : u,
+        ),
+      );
+      if (target.id === user?.id) {
+        setUser({ ...user, twoFactorEnabled: false });
+      }
+      message.success("Вход по коду сброшен");
+    } catch (error) {
+      console.error(error);
+      message.error("Не удалось сбросить вход по коду");
+    }
+  };
+
   const handleUserRoleChange = async (userId: number, role: User["role"]) => {
     setUpdatingUserId(userId);
     try {
@@ -899,10 +1305,16 @@ function App() {
         <Header brandHref="/" />
         <Layout.Content className="content">
           <AuthCard
+            authConfig={authConfig}
             authMode={authMode}
             authError={authError}
+            authNotice={authNotice}
             authLoading={authLoading}
-            onAuthModeChange={setAuthMode}
+            twoFactorSetup={loginSetup}
+            onAuthModeChange={handleAuthModeChange}
+            onResendVerification={
+              unverifiedLogin ? handleResendVerification : undefined
+            }
             onSubmit={(values, mode) =>
               handleAuth(
                 values.email,
@@ -911,6 +1323,7 @@ function App() {
                 values.username,
                 values.firstName,
                 values.lastName,
+                values.code,
               )
             }
           />
@@ -942,6 +1355,19 @@ function App() {
           setProfileFirstName(user.firstName ?? "");
           setProfileLastName(user.lastName ?? "");
           setProfileUsername(user.username ?? "");
+          setProfileNotifications(user.notifications ?? emptyNotificationPrefs);
+          setProfileEmailNotifications(
+            user.emailNotifications ?? emptyEmailPrefs,
+          );
+          // Email preferences are only part of the full profile.
+          api
+            .get<User>("/profile")
+            .then((response) =>
+              setProfileEmailNotifications(
+                response.data.emailNotifications ?? emptyEmailPrefs,
+              ),
+            )
+            .catch((error) => console.error(error));
           setProfilePassword("");
           setProfileModalOpen(true);
           setMobileNavOpen(false);
@@ -997,8 +1423,16 @@ function App() {
                 }
                 onOpenUserInfo={openUserInfoModal}
                 onOpenPassword={openPasswordModal}
+                onResetTwoFactor={(target) => void handleResetTwoFactor(target)}
               />
             </Card>
+            <SecurityCard
+              requireAdminTwoFactor={requireAdminTwoFactor}
+              saving={savingSecurity}
+              onRequireAdminTwoFactorChange={(value) =>
+                void handleRequireAdminTwoFactorChange(value)
+              }
+            />
           </>
         ) : (
           <>
@@ -1054,14 +1488,29 @@ function App() {
         profileUsername={profileUsername}
         profileTelegram={profileTelegram}
         profilePassword={profilePassword}
+        profileNotifications={profileNotifications}
+        profileEmailNotifications={profileEmailNotifications}
         onFirstNameChange={setProfileFirstName}
         onLastNameChange={setProfileLastName}
         onUsernameChange={setProfileUsername}
         onTelegramChange={setProfileTelegram}
         onPasswordChange={setProfilePassword}
+        onNotificationsChange={setProfileNotifications}
+        onEmailNotificationsChange={setProfileEmailNotifications}
         onSave={() => void handleUpdateProfile()}
+        onLogoutOthers={() => void handleLogoutOthers()}
+        onTwoFactor={() => setTwoFactorModalOpen(true)}
+        onTelegramLink={() => void handleTelegramLink()}
+        onTelegramUnlink={() => void handleTelegramUnlink()}
         onClose={() => setProfileModalOpen(false)}
       />
+      <TwoFactorModal
+        open={twoFactorModalOpen}
+        onChange={(enabled) =>
+          setUser({ ...user, twoFactorEnabled: enabled })
+        }
+        onClose={() => setTwoFactorModalOpen(false)}
+      />
       <UserInfoModal
         user={editingUserInfo}
         firstName={editingUserFirstName}
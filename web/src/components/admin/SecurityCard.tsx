@@ -0,0 +1,34 @@
+import { Card, Switch } from "antd";
+
+type SecurityCardProps = {
+  requireAdminTwoFactor: boolean;
+  saving: boolean;
+  onRequireAdminTwoFactorChange: (value: boolean) => void;
+};
+
+function SecurityCard({
+  requireAdminTwoFactor,
+  saving,
+  onRequireAdminTwoFactorChange,
+}: SecurityCardProps) {
+  return (
+    <Card className="create-card" title="Безопасность">
+      <div className="security-row">
+        <div>
+          <div>Вход по коду для администраторов</div>
+          <div className="muted-text">
+            Администраторы без кода выйдут из всех сеансов и настроят его при
+            следующем входе. Сначала включи вход по коду себе в профиле.
+          </div>
+        </div>
+        <Switch
+          checked={requireAdminTwoFactor}
+          loading={saving}
+          onChange={onRequireAdminTwoFactorChange}
+        />
+      </div>
+    </Card>
+  );
+}
+
+export default SecurityCard;
@@ -1,4 +1,4 @@
-import { Button, Select, Space, Table, Tag } from "antd";
+import { Button, Popconfirm, Select, Space, Table, Tag } from "antd";
 
 import type { Project, User } from "../../types";
 
@@ -14,6 +14,7 @@ type UserTableProps = {
   onUserProjectsChange: (userId: number, projectIds: number[]) => void;
   onOpenUserInfo: (user: User) => void;
   onOpenPassword: (user: User) => void;
+  onResetTwoFactor: (user: User) => void;
 };
 
 function UserTable({
@@ -28,6 +29,7 @@ function UserTable({
   onUserProjectsChange,
   onOpenUserInfo,
   onOpenPassword,
+  onResetTwoFactor,
 }: UserTableProps) {
   return (
     <>
@@ -145,6 +147,14 @@ function UserTable({
                 <Button size="small" onClick={() => onOpenPassword(record)}>
                   Сменить пароль
                 </Button>
+                {record.twoFactorEnabled && (
+                  <Popconfirm
+                    title="Сбросить вход по коду?"
+                    onConfirm={() => onResetTwoFactor(record)}
+                  >
+                    <Button size="small">Сбросить 2FA</Button>
+                  </Popconfirm>
+                )}
               </Space>
             ),
           },
@@ -1,6 +1,11 @@
-import { Button, Card, Form, Input, Tabs } from "antd";
+import { Button, Card, Divider, Form, Input, Tabs } from "antd";
 
-type AuthMode = "login" | "register";
+import type { AuthConfig, TwoFactorSetup as Setup } from "../../types";
+import TwoFactorSetup from "../shared/TwoFactorSetup";
+
+// "forgot" asks for a password reset link and "reset" sets the new password
+// from one; "twofactor" is the code step after the password.
+export type AuthMode = "login" | "register" | "forgot" | "reset" | "twofactor";
 
 type AuthFormValues = {
   email: string;
@@ -8,33 +13,160 @@ type AuthFormValues = {
   username?: string;
   firstName?: string;
   lastName?: string;
+  code?: string;
 };
 
 type AuthCardProps = {
+  authConfig: AuthConfig;
   authMode: AuthMode;
   authError: string;
+  authNotice: string;
   authLoading: boolean;
+  // Secret to set up when an admin must enroll during login.
+  twoFactorSetup: Setup | null;
   onAuthModeChange: (mode: AuthMode) => void;
   onSubmit: (values: AuthFormValues, mode: AuthMode) => void;
+  // Set when the login failed for an unconfirmed address.
+  onResendVerification?: () => void;
 };
 
 function AuthCard({
+  authConfig,
   authMode,
   authError,
+  authNotice,
   authLoading,
+  twoFactorSetup,
   onAuthModeChange,
   onSubmit,
+  onResendVerification,
 }: AuthCardProps) {
+  if (authMode === "twofactor") {
+    return (
+      <Card
+        className="auth-card"
+        title={twoFactorSetup ? "Настройка входа по коду" : "Код подтверждения"}
+      >
+        {twoFactorSetup && <TwoFactorSetup setup={twoFactorSetup} />}
+        <Form
+          layout="vertical"
+          onFinish={(values) => onSubmit(values, authMode)}
+        >
+          <Form.Item
+            name="code"
+            label="Код"
+            extra={
+              twoFactorSetup
+                ? undefined
+                : "Код из приложения-аутентификатора или код восстановления"
+            }
+            rules={[{ required: true, message: "Введите код" }]}
+          >
+            <Input
+              autoFocus
+              autoComplete="one-time-code"
+              autoCapitalize="none"
+              autoCorrect="off"
+              placeholder="123456"
+            />
+          </Form.Item>
+          {authNotice && <div className="auth-notice">{authNotice}</div>}
+          {authError && <div className="auth-error">{authError}</div>}
+          <Button type="primary" htmlType="submit" block loading={authLoading}>
+            Войти
+          </Button>
+          <Button type="link" block onClick={() => onAuthModeChange("login")}>
+            Назад ко входу
+          </Button>
+        </Form>
+      </Card>
+    );
+  }
+
+  if (authMode === "forgot" || authMode === "reset") {
+    return (
+      <Card
+        className="auth-card"
+        title={
+          authMode === "forgot" ? "Восстановление пароля" : "Новый пароль"
+        }
+      >
+        <Form
+          key={authMode}
+          layout="vertical"
+          onFinish={(values) => onSubmit(values, authMode)}
+        >
+          {authMode === "forgot" ? (
+            <Form.Item
+              name="email"
+              label="Email или юзернейм"
+              rules={[
+                { required: true, message: "Введите email или юзернейм" },
+              ]}
+            >
+              <Input
+                placeholder="you@example.com или юзернейм"
+                autoCapitalize="none"
+                autoCorrect="off"
+              />
+            </Form.Item>
+          ) : (
+            <Form.Item
+              name="password"
+              label="Новый пароль"
+              rules={[
+                { required: true },
+                { min: 6, message: "Минимум 6 символов" },
+              ]}
+            >
+              <Input.Password placeholder="••••••" />
+            </Form.Item>
+          )}
+          {authNotice && <div className="auth-notice">{authNotice}</div>}
+          {authError && <div className="auth-error">{authError}</div>}
+          <Button type="primary" htmlType="submit" block loading={authLoading}>
+            {authMode === "forgot" ? "Отправить ссылку" : "Сохранить пароль"}
+          </Button>
+          <Button type="link" block onClick={() => onAuthModeChange("login")}>
+            Назад ко входу
+          </Button>
+        </Form>
+      </Card>
+    );
+  }
+
+  const ssoButton = authConfig.sso && (
+    <Button block href="/api/auth/oidc/login">
+      Войти через {authConfig.sso.name}
+    </Button>
+  );
+
+  if (!authConfig.passwordLogin) {
+    return (
+      <Card className="auth-card" title="Вход">
+        {authError && <div className="auth-error">{authError}</div>}
+        {ssoButton}
+      </Card>
+    );
+  }
+
   return (
-    <Card className="auth-card" title="Войдите или зарегистрируйтесь">
-      <Tabs
-        activeKey={authMode}
-        onChange={(key) => onAuthModeChange(key as AuthMode)}
-        items={[
-          { key: "login", label: "Вход" },
-          { key: "register", label: "Регистрация" },
-        ]}
-      />
+    <Card
+      className="auth-card"
+      title={
+        authConfig.registration ? "Войдите или зарегистрируйтесь" : "Вход"
+      }
+    >
+      {authConfig.registration && (
+        <Tabs
+          activeKey={authMode}
+          onChange={(key) => onAuthModeChange(key as AuthMode)}
+          items={[
+            { key: "login", label: "Вход" },
+            { key: "register", label: "Регистрация" },
+          ]}
+        />
+      )}
       <Form
         layout="vertical"
         onFinish={(values) => onSubmit(values, authMode)}
@@ -105,11 +237,32 @@ function AuthCard({
         >
           <Input.Password placeholder="••••••" />
         </Form.Item>
+        {authNotice && <div className="auth-notice">{authNotice}</div>}
         {authError && <div className="auth-error">{authError}</div>}
+        {onResendVerification && (
+          <Button
+            type="link"
+            className="auth-link"
+            onClick={onResendVerification}
+          >
+            Отправить письмо ещё раз
+          </Button>
+        )}
         <Button type="primary" htmlType="submit" block loading={authLoading}>
           {authMode === "login" ? "Войти" : "Зарегистрироваться"}
         </Button>
+        {authMode === "login" && (
+          <Button type="link" block onClick={() => onAuthModeChange("forgot")}>
+            Забыли пароль?
+          </Button>
+        )}
       </Form>
+      {authMode === "login" && ssoButton && (
+        <>
+          <Divider plain>или</Divider>
+          {ssoButton}
+        </>
+      )}
     </Card>
   );
 }
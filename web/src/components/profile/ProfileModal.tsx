@@ -1,6 +1,15 @@
-import { Divider, Form, Input, Modal, Typography } from "antd";
+import {
+  Button,
+  Checkbox,
+  Divider,
+  Form,
+  Input,
+  Modal,
+  Space,
+  Typography,
+} from "antd";
 
-import type { User } from "../../types";
+import type { EmailPrefs, NotificationPrefs, User } from "../../types";
 
 type ProfileModalProps = {
   open: boolean;
@@ -11,12 +20,20 @@ type ProfileModalProps = {
   profileUsername: string;
   profileTelegram: string;
   profilePassword: string;
+  profileNotifications: NotificationPrefs;
+  profileEmailNotifications: EmailPrefs;
   onFirstNameChange: (value: string) => void;
   onLastNameChange: (value: string) => void;
   onUsernameChange: (value: string) => void;
   onTelegramChange: (value: string) => void;
   onPasswordChange: (value: string) => void;
+  onNotificationsChange: (value: NotificationPrefs) => void;
+  onEmailNotificationsChange: (value: EmailPrefs) => void;
   onSave: () => void;
+  onLogoutOthers: () => void;
+  onTwoFactor: () => void;
+  onTelegramLink: () => void;
+  onTelegramUnlink: () => void;
   onClose: () => void;
 };
 
@@ -29,12 +46,20 @@ function ProfileModal({
   profileUsername,
   profileTelegram,
   profilePassword,
+  profileNotifications,
+  profileEmailNotifications,
   onFirstNameChange,
   onLastNameChange,
   onUsernameChange,
   onTelegramChange,
   onPasswordChange,
+  onNotificationsChange,
+  onEmailNotificationsChange,
   onSave,
+  onLogoutOthers,
+  onTwoFactor,
+  onTelegramLink,
+  onTelegramUnlink,
   onClose,
 }: ProfileModalProps) {
   return (
@@ -92,6 +117,100 @@ function ProfileModal({
             onChange={(e) => onTelegramChange(e.target.value)}
           />
         </Form.Item>
+        <Form.Item
+          label="Бот"
+          extra={
+            user.telegramLinked
+              ? "Аккаунт Telegram привязан, команды бота выполняются от вашего имени."
+              : "Получите код и отправьте боту /link <код>."
+          }
+        >
+          {user.telegramLinked ? (
+            <Button onClick={onTelegramUnlink}>Отвязать Telegram</Button>
+          ) : (
+            <Button onClick={onTelegramLink}>Привязать Telegram</Button>
+          )}
+        </Form.Item>
+        <Form.Item
+          label="Уведомления"
+          extra="Бот пишет в личные сообщения, если аккаунт Telegram привязан."
+        >
+          <Space direction="vertical" size={4}>
+            <Checkbox
+              checked={profileNotifications.assigned}
+              onChange={(e) =>
+                onNotificationsChange({
+                  ...profileNotifications,
+                  assigned: e.target.checked,
+                })
+              }
+            >
+              Меня назначили на задачу
+            </Checkbox>
+            <Checkbox
+              checked={profileNotifications.comments}
+              onChange={(e) =>
+                onNotificationsChange({
+                  ...profileNotifications,
+                  comments: e.target.checked,
+                })
+              }
+            >
+              Комментарии к моим задачам
+            </Checkbox>
+            <Checkbox
+              checked={profileNotifications.statusChanges}
+              onChange={(e) =>
+                onNotificationsChange({
+                  ...profileNotifications,
+                  statusChanges: e.target.checked,
+                })
+              }
+            >
+              Смена статуса моих задач
+            </Checkbox>
+          </Space>
+        </Form.Item>
+        <Form.Item
+          label="Письма"
+          extra={`Письма приходят на ${user.email}, если на сервере настроена почта.`}
+        >
+          <Space direction="vertical" size={4}>
+            <Checkbox
+              checked={profileEmailNotifications.assigned}
+              onChange={(e) =>
+                onEmailNotificationsChange({
+                  ...profileEmailNotifications,
+                  assigned: e.target.checked,
+                })
+              }
+            >
+              Меня назначили на задачу
+            </Checkbox>
+            <Checkbox
+              checked={profileEmailNotifications.comments}
+              onChange={(e) =>
+                onEmailNotificationsChange({
+                  ...profileEmailNotifications,
+                  comments: e.target.checked,
+                })
+              }
+            >
+              Комментарии к моим задачам
+            </Checkbox>
+            <Checkbox
+              checked={profileEmailNotifications.mentions}
+              onChange={(e) =>
+                onEmailNotificationsChange({
+                  ...profileEmailNotifications,
+                  mentions: e.target.checked,
+                })
+              }
+            >
+              Упоминания @юзернейма в комментариях
+            </Checkbox>
+          </Space>
+        </Form.Item>
         <Divider style={{ margin: "12px 0" }} />
         <Typography.Text type="secondary" style={{ display: "block" }}>
           Смена пароля
@@ -106,6 +225,25 @@ function ProfileModal({
             onChange={(e) => onPasswordChange(e.target.value)}
           />
         </Form.Item>
+        <Form.Item
+          label="Вход по коду"
+          extra={
+            user.twoFactorEnabled
+              ? "Включён: после пароля нужен код из приложения."
+              : "Отключён: для входа достаточно пароля."
+          }
+        >
+          <Button onClick={onTwoFactor}>
+            {user.twoFactorEnabled ? "Управлять" : "Включить"}
+          </Button>
+        </Form.Item>
+        <Divider style={{ margin: "12px 0" }} />
+        <Form.Item
+          label="Сеансы"
+          extra="Смена пароля тоже завершает все остальные сеансы."
+        >
+          <Button onClick={onLogoutOthers}>Выйти на других устройствах</Button>
+        </Form.Item>
       </Form>
     </Modal>
   );
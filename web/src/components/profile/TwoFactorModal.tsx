@@ -0,0 +1,207 @@
+import { Button, Input, Modal, Space, Spin, Typography, message } from "antd";
+import axios from "axios";
+import { useEffect, useState } from "react";
+
+import api from "../../api";
+import type { TwoFactorSetup as Setup } from "../../types";
+import TwoFactorSetup, { RecoveryCodeList } from "../shared/TwoFactorSetup";
+
+type TwoFactorState = {
+  enabled: boolean;
+  recoveryCodesLeft: number;
+  required: boolean;
+};
+
+type TwoFactorModalProps = {
+  open: boolean;
+  onChange: (enabled: boolean) => void;
+  onClose: () => void;
+};
+
+function errorText(error: unknown, fallback: string) {
+  if (axios.isAxiosError(error) && error.response?.data) {
+    const text = String(error.response.data).trim();
+    return text === "invalid code" ? "Неверный код" : text;
+  }
+  return fallback;
+}
+
+// TwoFactorModal turns the caller's login with a TOTP code on and off and
+// replaces the recovery codes.
+function TwoFactorModal({ open, onChange, onClose }: TwoFactorModalProps) {
+  const [state, setState] = useState<TwoFactorState | null>(null);
+  const [setup, setSetup] = useState<Setup | null>(null);
+  const [recoveryCodes, setRecoveryCodes] = useState<string[] | null>(null);
+  const [code, setCode] = useState("");
+  const [busy, setBusy] = useState(false);
+
+  useEffect(() => {
+    if (!open) {
+      return;
+    }
+    setState(null);
+    setSetup(null);
+    setRecoveryCodes(null);
+    setCode("");
+    api
+      .get<TwoFactorState>("/profile/2fa")
+      .then((response) => setState(response.data))
+      .catch((error) => {
+        console.error(error);
+        message.error("Не удалось загрузить настройки входа");
+      });
+  }, [open]);
+
+  const run = async (action: () => Promise<void>, fallback: string) => {
+    setBusy(true);
+    try {
+      await action();
+    } catch (error) {
+      console.error(error);
+      message.error(errorText(error, fallback));
+    } finally {
+      setBusy(false);
+    }
+  };
+
+  const handleStart = () =>
+    run(async () => {
+      const response = await api.post<Setup>("/profile/2fa");
+      setSetup(response.data);
+    }, "Не удалось начать настройку");
+
+  const handleEnable = () =>
+    run(async () => {
+      const response = await api.put<{ recoveryCodes: string[] }>(
+        "/profile/2fa",
+        { code: code.trim() },
+      );
+      setSetup(null);
+      setCode("");
+      setRecoveryCodes(response.data.recoveryCodes);
+      setState((prev) =>
+        prev
+          ? {
+              ...prev,
+              enabled: true,
+              recoveryCodesLeft: response.data.recoveryCodes.length,
+            }
+          : prev,
+      );
+      onChange(true);
+    }, "Не удалось включить вход по коду");
+
+  const handleRegenerate = () =>
+    run(async () => {
+      const response = await api.post<{ recoveryCodes: string[] }>(
+        "/profile/2fa/recovery",
+        { code: code.trim() },
+      );
+      setCode("");
+      setRecoveryCodes(response.data.recoveryCodes);
+      setState((prev) =>
+        prev
+          ? { ...prev, recoveryCodesLeft: response.data.recoveryCodes.length }
+          : prev,
+      );
+    }, "Не удалось создать коды");
+
+  const handleDisable = () =>
+    run(async () => {
+      await api.delete("/profile/2fa", { data: { code: code.trim() } });
+      setCode("");
+      setState((prev) =>
+        prev ? { ...prev, enabled: false, recoveryCodesLeft: 0 } : prev,
+      );
+      onChange(false);
+      message.success("Вход по коду отключён");
+    }, "Не удалось отключить вход по коду");
+
+  const codeInput = (
+    <Input
+      value={code}
+      onChange={(e) => setCode(e.target.value)}
+      autoComplete="one-time-code"
+      placeholder="Код из приложения"
+      style={{ maxWidth: 200 }}
+    />
+  );
+
+  return (
+    <Modal
+      title="Вход по коду"
+      open={open}
+      onCancel={onClose}
+      footer={<Button onClick={onClose}>Закрыть</Button>}
+    >
+      {!state ? (
+        <Spin />
+      ) : recoveryCodes ? (
+        <RecoveryCodeList codes={recoveryCodes} />
+      ) : state.enabled ? (
+        <Space direction="vertical" style={{ width: "100%" }}>
+          <Typography.Paragraph>
+            Вход по коду включён. Осталось кодов восстановления:{" "}
+            {state.recoveryCodesLeft}.
+          </Typography.Paragraph>
+          <Typography.Paragraph type="secondary">
+            Чтобы получить новые коды восстановления или отключить вход по
+            коду, введи текущий код из приложения.
+          </Typography.Paragraph>
+          {codeInput}
+          <Space wrap>
+            <Button
+              onClick={() => void handleRegenerate()}
+              loading={busy}
+              disabled={!code.trim()}
+            >
+              Новые коды восстановления
+            </Button>
+            <Button
+              danger
+              onClick={() => void handleDisable()}
+              loading={busy}
+              disabled={!code.trim() || state.required}
+            >
+              Отключить
+            </Button>
+          </Space>
+          {state.required && (
+            <Typography.Text type="secondary">
+              Администраторам вход по коду обязателен.
+            </Typography.Text>
+          )}
+        </Space>
+      ) : setup ? (
+        <Space direction="vertical" style={{ width: "100%" }}>
+          <TwoFactorSetup setup={setup} />
+          {codeInput}
+          <Button
+            type="primary"
+            onClick={() => void handleEnable()}
+            loading={busy}
+            disabled={!code.trim()}
+          >
+            Включить
+          </Button>
+        </Space>
+      ) : (
+        <Space direction="vertical">
+          <Typography.Paragraph>
+            После пароля вход будет спрашивать код из приложения на телефоне,
+            так что одного пароля для входа станет мало.
+          </Typography.Paragraph>
+          <Button
+            type="primary"
+            onClick={() => void handleStart()}
+            loading={busy}
+          >
+            Настроить
+          </Button>
+        </Space>
+      )}
+    </Modal>
+  );
+}
+
+export default TwoFactorModal;
@@ -0,0 +1,49 @@
+import { Typography } from "antd";
+
+import type { TwoFactorSetup as Setup } from "../../types";
+
+type TwoFactorSetupProps = {
+  setup: Setup;
+};
+
+// TwoFactorSetup shows the secret for an authenticator app: the otpauth://
+// link opens the app on a phone, and the key can be typed in by hand.
+function TwoFactorSetup({ setup }: TwoFactorSetupProps) {
+  return (
+    <div>
+      <Typography.Paragraph>
+        Добавь аккаунт в приложение-аутентификатор (Google Authenticator,
+        Aegis, 1Password и т.п.): открой{" "}
+        <a href={setup.uri}>ссылку</a> на телефоне или введи ключ вручную.
+      </Typography.Paragraph>
+      <Typography.Paragraph>
+        <Typography.Text code copyable>
+          {setup.secret}
+        </Typography.Text>
+      </Typography.Paragraph>
+      <Typography.Paragraph type="secondary">
+        Затем введи шестизначный код из приложения.
+      </Typography.Paragraph>
+    </div>
+  );
+}
+
+type RecoveryCodeListProps = {
+  codes: string[];
+};
+
+export function RecoveryCodeList({ codes }: RecoveryCodeListProps) {
+  return (
+    <>
+      <Typography.Paragraph>
+        Сохрани коды восстановления. Каждый подходит один раз вместо кода из
+        приложения, если телефон потеряется. Больше они показаны не будут.
+      </Typography.Paragraph>
+      <Typography.Paragraph copyable={{ text: codes.join("\n") }}>
+        <pre className="recovery-codes">{codes.join("\n")}</pre>
+      </Typography.Paragraph>
+    </>
+  );
+}
+
+export default TwoFactorSetup;
@@ -3,12 +3,18 @@ import {
   DeleteOutlined,
   HighlightOutlined,
   LoadingOutlined,
+  PaperClipOutlined,
 } from "@ant-design/icons";
 import { Button, Card, Input, Popconfirm, Select, Space, Tag } from "antd";
 
+import api from "../../api";
 import { statusMeta, statusOrder } from "../../constants";
 import type { StatusKey, Task, User } from "../../types";
-import { formatAuthor, formatDate } from "../../utils/formatters";
+import {
+  formatAuthor,
+  formatDate,
+  formatSize,
+} from "../../utils/formatters";
 
 type TaskDetailCardProps = {
   task: Task;
@@ -153,6 +159,26 @@ function TaskDetailCard({
           )}
         </div>
 
+        {task.attachments && task.attachments.length > 0 && (
+          <div className="detail-section">
+            <div className="comments-header">
+              <span className="meta-label">Вложения</span>
+              <Tag color="default">{task.attachments.length}</Tag>
+            </div>
+            <Space direction="vertical" size={4}>
+              {task.attachments.map((attachment) => (
+                <a
+                  key={attachment.id}
+                  href={`${api.defaults.baseURL}/tasks/${task.id}/attachments/${attachment.id}`}
+                >
+                  <PaperClipOutlined /> {attachment.fileName} (
+                  {formatSize(attachment.size)})
+                </a>
+              ))}
+            </Space>
+          </div>
+        )}
+
         <div className="detail-section">
           <div className="comments-header">
             <span className="meta-label">Комментарии</span>
@@ -5,7 +5,7 @@ import {
 } from "@ant-design/icons";
 import { type ReactNode } from "react";
 
-import type { StatusKey } from "./types";
+import type { EmailPrefs, NotificationPrefs, StatusKey } from "./types";
 
 export const statusOrder: StatusKey[] = ["new", "in_progress", "done"];
 
@@ -30,3 +30,15 @@ export const columnDescriptions: Record<StatusKey, string> = {
 
 export const AUTO_REFRESH_INTERVAL_STORAGE_KEY =
   "litetask:autoRefreshIntervalMs";
+
+export const emptyNotificationPrefs: NotificationPrefs = {
+  assigned: false,
+  comments: false,
+  statusChanges: false,
+};
+
+export const emptyEmailPrefs: EmailPrefs = {
+  assigned: false,
+  comments: false,
+  mentions: false,
+};
@@ -9,6 +9,24 @@ export type TaskComment = {
   createdAt: string;
 };
 
+export type TaskAttachment = {
+  id: number;
+  taskId: number;
+  commentId?: number | null;
+  fileName: string;
+  contentType: string;
+  size: number;
+  createdAt: string;
+};
+
+export type TaskAssignee = {
+  id: number;
+  email: string;
+  username?: string;
+  firstName?: string;
+  lastName?: string;
+};
+
 export type Task = {
   id: number;
   title: string;
@@ -20,13 +38,30 @@ export type Task = {
   authorEmail?: string;
   authorFirstName?: string;
   authorLastName?: string;
+  startDate?: string | null;
+  dueDate?: string | null;
+  overdue?: boolean;
+  assignees?: TaskAssignee[];
   comments?: TaskComment[];
+  attachments?: TaskAttachment[];
 };
 
+export type ProjectRole = "viewer" | "member" | "maintainer";
+
 export type Project = {
   id: number;
   name: string;
   createdAt: string;
+  role?: ProjectRole;
+};
+
+export type ProjectMember = {
+  userId: number;
+  email: string;
+  username?: string;
+  firstName?: string;
+  lastName?: string;
+  role: ProjectRole;
 };
 
 export type User = {
@@ -38,6 +73,49 @@ export type User = {
   lastName?: string | null;
   projectIds?: number[] | null;
   telegram?: string | null;
+  telegramLinked?: boolean;
+  notifications?: NotificationPrefs;
+  emailNotifications?: EmailPrefs;
+  emailVerified?: boolean;
+  twoFactorEnabled?: boolean;
+};
+
+export type NotificationPrefs = {
+  assigned: boolean;
+  comments: boolean;
+  statusChanges: boolean;
+};
+
+export type EmailPrefs = {
+  assigned: boolean;
+  comments: boolean;
+  mentions: boolean;
+};
+
+// Answer to a registration that must confirm its email before signing in.
+export type VerificationPending = {
+  verificationRequired: true;
+  email: string;
+};
+
+// Ways to sign in that the server offers; sso is set when single sign-on
+// with an OpenID Connect provider is on.
+export type AuthConfig = {
+  passwordLogin: boolean;
+  registration: boolean;
+  sso: { name: string } | null;
+};
+
+// Answer to a password login that needs a code: "verify" asks for it,
+// "enroll" makes an admin set up two-factor authentication first.
+export type TwoFactorChallenge = {
+  twoFactor: "verify" | "enroll";
+  challenge: string;
+};
+
+export type TwoFactorSetup = {
+  secret: string;
+  uri: string;
 };
 
 export type AutoRefreshIntervalMs = 5000 | 30000 | 60000 | 300000;
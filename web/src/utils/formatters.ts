@@ -24,3 +24,13 @@ export const formatAuthor = (author: AuthorInfo) => {
   }
   return author.authorEmail || "Не указан";
 };
+
+export const formatSize = (bytes: number) => {
+  if (bytes < 1024) {
+    return `${bytes} Б`;
+  }
+  if (bytes < 1024 * 1024) {
+    return `${Math.round(bytes / 1024)} КБ`;
+  }
+  return `${(bytes / (1024 * 1024)).toFixed(1)} МБ`;
+};